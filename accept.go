@@ -0,0 +1,99 @@
+package subfilter
+
+import (
+	"strconv"
+	"strings"
+)
+
+// acceptRange is a media range of an Accept header with its quality.
+type acceptRange struct {
+	typ, subtype string
+	q            float64
+}
+
+// parseAccept returns the media ranges of an Accept header value. Malformed
+// ranges are dropped.
+func parseAccept(v string) []acceptRange {
+	var ranges []acceptRange
+
+	for _, part := range strings.Split(v, ",") {
+		fields := strings.Split(part, ";")
+
+		slash := strings.Split(strings.ToLower(strings.TrimSpace(fields[0])), "/")
+		if len(slash) != 2 || slash[0] == "" || slash[1] == "" || slash[0] == "*" && slash[1] != "*" {
+			continue
+		}
+
+		ar := acceptRange{typ: slash[0], subtype: slash[1], q: 1}
+
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if len(param) < 2 || !strings.EqualFold(param[:2], "q=") {
+				continue
+			}
+
+			q, err := strconv.ParseFloat(param[2:], 64)
+			if err != nil || q < 0 || q > 1 {
+				q = 0
+			}
+
+			ar.q = q
+		}
+
+		ranges = append(ranges, ar)
+	}
+
+	return ranges
+}
+
+// specificity returns how precisely the range matches the media type typ/
+// subtype: 2 for an exact match, 1 for a type/* range, 0 for */* and -1 when
+// it does not match.
+func (ar acceptRange) specificity(typ, subtype string) int {
+	switch {
+	case ar.typ == "*":
+		return 0
+	case ar.typ != typ:
+		return -1
+	case ar.subtype == "*":
+		return 1
+	case ar.subtype == subtype:
+		return 2
+	default:
+		return -1
+	}
+}
+
+// acceptsAny reports whether the Accept header value accept accepts any of
+// the media types, each taking the quality of the most specific range
+// matching it so that "text/html;q=0, */*" refuses text/html. In strict
+// mode, wildcard ranges match nothing.
+func acceptsAny(accept string, mediaTypes []string, strict bool) bool {
+	ranges := parseAccept(accept)
+
+	for _, mt := range mediaTypes {
+		slash := strings.SplitN(strings.ToLower(strings.TrimSpace(mt)), "/", 2)
+		if len(slash) != 2 {
+			continue
+		}
+
+		best, q := -1, 0.0
+
+		for _, ar := range ranges {
+			spec := ar.specificity(slash[0], slash[1])
+			if strict && spec < 2 {
+				continue
+			}
+
+			if spec > best {
+				best, q = spec, ar.q
+			}
+		}
+
+		if q > 0 {
+			return true
+		}
+	}
+
+	return false
+}
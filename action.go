@@ -0,0 +1,74 @@
+package subfilter
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+const (
+	actionReplace      = "replace"
+	actionDeleteLine   = "deleteline"
+	actionInsertBefore = "insertbefore"
+	actionInsertAfter  = "insertafter"
+)
+
+// parseAction returns the normalized action of f, rejecting unknown actions
+// and options the action cannot use.
+func parseAction(f Filter, typ string) (string, error) {
+	action := strings.ToLower(f.Action)
+
+	switch action {
+	case "", actionReplace:
+		return actionReplace, nil
+	case actionDeleteLine:
+		if f.HashReplacement != nil || f.Lookup != nil || f.Transforms || typ == filterTypeTemplate || typ == filterTypeCSSURL {
+			return "", fmt.Errorf("action %q only supports regex and glob filters without replacement options", f.Action)
+		}
+
+		return action, nil
+	case actionInsertBefore, actionInsertAfter:
+		if typ == filterTypeCSSURL {
+			return "", fmt.Errorf("action %q does not support %s filters", f.Action, typ)
+		}
+
+		return action, nil
+	default:
+		return "", fmt.Errorf("unknown action %q: must be replace, deleteLine, insertBefore or insertAfter", f.Action)
+	}
+}
+
+// deleteLines removes from b every line holding at least one of matches,
+// together with its terminator. A match spanning several lines removes all of
+// them.
+func deleteLines(b []byte, matches [][]int) []byte {
+	out := make([]byte, 0, len(b))
+	last := 0
+
+	for _, m := range matches {
+		if m[0] < last {
+			continue
+		}
+
+		start, end := lineSpan(b, m)
+		out = append(out, b[last:start]...)
+		last = end
+	}
+
+	return append(out, b[last:]...)
+}
+
+// lineSpan returns the offsets of the lines of b holding the match m, along
+// with the terminator of the last one.
+func lineSpan(b []byte, m []int) (int, int) {
+	start := bytes.LastIndexByte(b[:m[0]], '\n') + 1
+
+	end := len(b)
+	if m[1] > m[0] && b[m[1]-1] == '\n' {
+		end = m[1]
+	} else if i := bytes.IndexByte(b[m[1]:], '\n'); i >= 0 {
+		end = m[1] + i + 1
+	}
+
+	return start, end
+}
@@ -0,0 +1,173 @@
+package subfilter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestDeleteLineAction(t *testing.T) {
+	tests := []struct {
+		desc       string
+		filter     Filter
+		resBody    string
+		expResBody string
+	}{
+		{
+			desc:       "should delete matching lines with their terminator",
+			filter:     Filter{Regex: "debug", Action: "deleteLine"},
+			resBody:    "a\ndebug: x\nb\n",
+			expResBody: "a\nb\n",
+		},
+		{
+			desc:       "should delete CRLF terminated lines",
+			filter:     Filter{Regex: "debug", Action: "deleteLine"},
+			resBody:    "a\r\ndebug: x\r\nb\r\n",
+			expResBody: "a\r\nb\r\n",
+		},
+		{
+			desc:       "should delete a matching last line without terminator",
+			filter:     Filter{Regex: "debug", Action: "deleteLine"},
+			resBody:    "a\nb\ndebug",
+			expResBody: "a\nb\n",
+		},
+		{
+			desc:       "should delete a line once when it holds several matches",
+			filter:     Filter{Regex: "x", Action: "deleteLine"},
+			resBody:    "a\nx x x\nb",
+			expResBody: "a\nb",
+		},
+		{
+			desc:       "should delete every line a match spans",
+			filter:     Filter{Regex: `(?s)begin.*?end`, Action: "deleteLine"},
+			resBody:    "a\n<begin\nmid\nend>\nb\n",
+			expResBody: "a\nb\n",
+		},
+		{
+			desc:       "should delete lines matched by a glob",
+			filter:     Filter{Type: "glob", Regex: "*.internal.corp", Action: "deleteLine"},
+			resBody:    "keep\nhost api.internal.corp\n",
+			expResBody: "keep\n",
+		},
+		{
+			desc:       "should replace by default",
+			filter:     Filter{Regex: "debug", Replacement: "info", Action: "Replace"},
+			resBody:    "a\ndebug\n",
+			expResBody: "a\ninfo\n",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			got := serveTransform(t, test.filter, test.resBody)
+			if got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+		})
+	}
+}
+
+func TestDeleteLineActionGzip(t *testing.T) {
+	config := CreateConfig()
+	config.Filters = []Filter{{Regex: "secret", Action: "deleteLine"}}
+
+	next := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		_, _ = w.Write(gzipString(t, "a\nsecret\nb\n"))
+	}
+
+	handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if cl := recorder.Header().Get("Content-Length"); cl != "" && cl != strconv.Itoa(recorder.Body.Len()) {
+		t.Errorf("got Content-Length %s for a %d byte body", cl, recorder.Body.Len())
+	}
+
+	if got := gunzipString(t, recorder.Body.Bytes()); got != "a\nb\n" {
+		t.Errorf("got body %q, want %q", got, "a\nb\n")
+	}
+}
+
+func TestInsertActions(t *testing.T) {
+	tests := []struct {
+		desc       string
+		filter     Filter
+		resBody    string
+		expResBody string
+	}{
+		{
+			desc:       "should insert after the match",
+			filter:     Filter{Regex: "<head>", Replacement: `<link rel="stylesheet" href="/x.css">`, Action: "insertAfter"},
+			resBody:    "<html><head><title>t</title></head></html>",
+			expResBody: `<html><head><link rel="stylesheet" href="/x.css"><title>t</title></head></html>`,
+		},
+		{
+			desc:       "should insert before every match",
+			filter:     Filter{Regex: "<iframe", Replacement: "<!-- embedded -->", Action: "insertBefore"},
+			resBody:    `<iframe src="a"></iframe><p></p><iframe src="b"></iframe>`,
+			expResBody: `<!-- embedded --><iframe src="a"></iframe><p></p><!-- embedded --><iframe src="b"></iframe>`,
+		},
+		{
+			desc:       "should expand capture groups in the inserted text",
+			filter:     Filter{Regex: `<iframe src="([^"]*)"`, Replacement: "<!-- $1 -->", Action: "insertBefore"},
+			resBody:    `<iframe src="a"></iframe><iframe src="b"></iframe>`,
+			expResBody: `<!-- a --><iframe src="a"></iframe><!-- b --><iframe src="b"></iframe>`,
+		},
+		{
+			desc:       "should expand named groups after the match",
+			filter:     Filter{Regex: `id=(?P<id>\d+)`, Replacement: " data-id=${id}", Action: "insertAfter"},
+			resBody:    "<a id=1><b id=2>",
+			expResBody: "<a id=1 data-id=1><b id=2 data-id=2>",
+		},
+		{
+			desc:       "should expand transforms in the inserted text",
+			filter:     Filter{Regex: "<li>", Replacement: "${n}. ", Action: "insertAfter", Transforms: true},
+			resBody:    "<li>a<li>b",
+			expResBody: "<li>1. a<li>2. b",
+		},
+		{
+			desc:       "should insert before the first maxMatches matches only",
+			filter:     Filter{Regex: "</head>", Replacement: "<script></script>", Action: "insertBefore", MaxMatches: 1},
+			resBody:    "<head></head><pre></head></pre>",
+			expResBody: "<head><script></script></head><pre></head></pre>",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			got := serveTransform(t, test.filter, test.resBody)
+			if got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+		})
+	}
+}
+
+func TestActionInvalid(t *testing.T) {
+	for _, f := range []Filter{
+		{Regex: "x", Action: "drop"},
+		{Regex: "x", Action: "deleteLine", Transforms: true},
+		{Regex: "x", Action: "deleteLine", HashReplacement: &HashReplacement{Salt: "s"}},
+		{Type: "template", Regex: "x", Action: "deleteLine"},
+		{Type: "css-url", Regex: "x", Action: "deleteLine"},
+		{Type: "css-url", Regex: "x", Action: "insertAfter"},
+		{Regex: "x", Action: "insertAfter", MaxMatches: -1},
+		{Type: "range", Start: "<a>", End: "</a>", MaxMatches: 1},
+	} {
+		config := CreateConfig()
+		config.Filters = []Filter{f}
+
+		if _, err := New(context.Background(), nil, config, "subfilter"); err == nil {
+			t.Errorf("expected error for filter %+v", f)
+		}
+	}
+}
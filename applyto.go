@@ -0,0 +1,115 @@
+package subfilter
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const (
+	applyToBody    = "body"
+	applyToHeaders = "headers"
+)
+
+// targets is where a filter applies: to the body, to response headers, or
+// both.
+type targets struct {
+	skipBody bool
+	headers  bool
+	// names, when set, are the canonical names of the headers to filter;
+	// otherwise every header but the framing ones is.
+	names []string
+}
+
+func compileTargets(f Filter) (targets, error) {
+	if len(f.ApplyTo) == 0 {
+		if len(f.Headers) > 0 {
+			return targets{}, errors.New("headers requires applyTo to include headers")
+		}
+
+		return targets{}, nil
+	}
+
+	t := targets{skipBody: true}
+
+	for _, v := range f.ApplyTo {
+		switch strings.ToLower(v) {
+		case applyToBody:
+			t.skipBody = false
+		case applyToHeaders:
+			t.headers = true
+		default:
+			return targets{}, fmt.Errorf("invalid applyTo %q: must be %q or %q", v, applyToBody, applyToHeaders)
+		}
+	}
+
+	if !t.headers && len(f.Headers) > 0 {
+		return targets{}, errors.New("headers requires applyTo to include headers")
+	}
+
+	for _, name := range f.Headers {
+		name = http.CanonicalHeaderKey(name)
+
+		switch {
+		case name == "":
+			return targets{}, errors.New("empty header name")
+		case framingHeaders[name]:
+			return targets{}, fmt.Errorf("%s is managed by the middleware", name)
+		}
+
+		t.names = append(t.names, name)
+	}
+
+	return t, nil
+}
+
+// rejectHeaderTargets returns an error for the first filter applying to
+// headers: only the filters of responses, whose headers are sent once the
+// body was filtered, can.
+func rejectHeaderTargets(filters []filter) error {
+	for i := range filters {
+		if filters[i].targets.headers {
+			return &FilterError{Index: i, Err: errors.New("applyTo headers is only supported by response filters")}
+		}
+	}
+
+	return nil
+}
+
+// filterHeaders applies the filters targeting headers to every value of the
+// headers of h they target, and reports whether any value changed.
+func filterHeaders(filters []filter, h http.Header, sc *scope) bool {
+	changed := false
+
+	for i := range filters {
+		f := &filters[i]
+		if !f.targets.headers {
+			continue
+		}
+
+		names := f.targets.names
+		if names == nil {
+			for name := range h {
+				if !framingHeaders[name] {
+					names = append(names, name)
+				}
+			}
+
+			sort.Strings(names)
+		}
+
+		for _, name := range names {
+			values := h[name]
+
+			for j, v := range values {
+				if values[j] = string(f.apply([]byte(v), sc)); values[j] != v {
+					changed = true
+				}
+			}
+		}
+	}
+
+	return changed
+}
@@ -0,0 +1,163 @@
+package subfilter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestApplyTo(t *testing.T) {
+	tests := []struct {
+		desc        string
+		applyTo     []string
+		headers     []string
+		expResBody  string
+		expLocation string
+		expLink     string
+	}{
+		{
+			desc:        "should apply to the body by default",
+			expResBody:  `<a href="https://public.example.com/next">next</a>`,
+			expLocation: "https://internal.corp/next",
+			expLink:     "<https://internal.corp/style.css>; rel=preload",
+		},
+		{
+			desc:        "should apply to the body and every header",
+			applyTo:     []string{"body", "headers"},
+			expResBody:  `<a href="https://public.example.com/next">next</a>`,
+			expLocation: "https://public.example.com/next",
+			expLink:     "<https://public.example.com/style.css>; rel=preload",
+		},
+		{
+			desc:        "should apply to the listed headers only",
+			applyTo:     []string{"Headers"},
+			headers:     []string{"location"},
+			expResBody:  `<a href="https://internal.corp/next">next</a>`,
+			expLocation: "https://public.example.com/next",
+			expLink:     "<https://internal.corp/style.css>; rel=preload",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.Filters = []Filter{{
+				Regex:       `internal\.corp`,
+				Replacement: "public.example.com",
+				ApplyTo:     test.applyTo,
+				Headers:     test.headers,
+			}}
+
+			next := func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Location", "https://internal.corp/next")
+				w.Header().Set("Link", "<https://internal.corp/style.css>; rel=preload")
+				w.WriteHeader(http.StatusFound)
+				_, _ = w.Write([]byte(`<a href="https://internal.corp/next">next</a>`))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if recorder.Code != http.StatusFound {
+				t.Errorf("got status %d, want %d", recorder.Code, http.StatusFound)
+			}
+
+			if got := recorder.Body.String(); got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+
+			if got := recorder.Header().Get("Location"); got != test.expLocation {
+				t.Errorf("got Location %q, want %q", got, test.expLocation)
+			}
+
+			if got := recorder.Header().Get("Link"); got != test.expLink {
+				t.Errorf("got Link %q, want %q", got, test.expLink)
+			}
+		})
+	}
+}
+
+func TestApplyToRepeatedHeaders(t *testing.T) {
+	config := CreateConfig()
+	config.Filters = []Filter{{
+		Regex:       `internal\.corp`,
+		Replacement: "public.example.com",
+		ApplyTo:     []string{"headers"},
+	}}
+
+	next := func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Add("Set-Cookie", "a=1; Domain=internal.corp")
+		w.Header().Add("Set-Cookie", "b=2; Path=/")
+		w.Header().Add("Set-Cookie", "c=3; Domain=internal.corp")
+		w.Header().Add("Link", "<https://internal.corp/a.css>; rel=preload")
+		w.Header().Add("Link", "<https://internal.corp/b.js>; rel=preload")
+		_, _ = w.Write([]byte("foo"))
+	}
+
+	handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	expected := map[string][]string{
+		"Set-Cookie": {"a=1; Domain=public.example.com", "b=2; Path=/", "c=3; Domain=public.example.com"},
+		"Link":       {"<https://public.example.com/a.css>; rel=preload", "<https://public.example.com/b.js>; rel=preload"},
+	}
+
+	for name, values := range expected {
+		got := recorder.Header().Values(name)
+		if strings.Join(got, "|") != strings.Join(values, "|") {
+			t.Errorf("got %s %q, want %q", name, got, values)
+		}
+	}
+}
+
+func TestApplyToConfig(t *testing.T) {
+	headers := Filter{Regex: "foo", ApplyTo: []string{"headers"}}
+
+	tests := []struct {
+		desc   string
+		config *Config
+	}{
+		{
+			desc:   "should reject unknown targets",
+			config: &Config{Filters: []Filter{{Regex: "foo", ApplyTo: []string{"trailers"}}}},
+		},
+		{
+			desc:   "should reject headers without the headers target",
+			config: &Config{Filters: []Filter{{Regex: "foo", Headers: []string{"Location"}}}},
+		},
+		{
+			desc:   "should reject framing headers",
+			config: &Config{Filters: []Filter{{Regex: "foo", ApplyTo: []string{"headers"}, Headers: []string{"content-length"}}}},
+		},
+		{
+			desc:   "should reject request filters",
+			config: &Config{Filters: []Filter{{Regex: "foo"}}, RequestFilters: []Filter{headers}},
+		},
+		{
+			desc:   "should reject query filters",
+			config: &Config{Filters: []Filter{{Regex: "foo"}}, QueryFilters: []Filter{headers}},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			if _, err := New(context.Background(), nil, test.config, "subfilter"); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
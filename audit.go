@@ -0,0 +1,132 @@
+package subfilter
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	defaultAuditMaxBytes = 64 << 10
+
+	// auditQueueSize is the number of entries waiting to be written before
+	// new ones are dropped.
+	auditQueueSize = 64
+)
+
+// auditEntry is the JSON line written for an audited response.
+type auditEntry struct {
+	Time     string `json:"time"`
+	Method   string `json:"method"`
+	Host     string `json:"host"`
+	URI      string `json:"uri"`
+	Status   int    `json:"status"`
+	Modified bool   `json:"modified"`
+	// Original and Filtered are the decoded bodies, cut to the size cap, in
+	// which case Truncated is set.
+	Original  string `json:"original"`
+	Filtered  string `json:"filtered"`
+	Truncated bool   `json:"truncated,omitempty"`
+}
+
+// auditor writes a copy of a sample of the filtered bodies to a sink. Entries
+// are queued and written by a single goroutine, so that a slow sink never
+// delays a response; they are dropped when the queue is full.
+type auditor struct {
+	percent  float64
+	maxBytes int
+	entries  chan auditEntry
+	// warnOnce limits the warning about dropped entries to one log line.
+	warnOnce sync.Once
+}
+
+func (s *SubFilter) setupAudit(config *Config) error {
+	if config.AuditFile == "" {
+		return nil
+	}
+
+	percent := config.AuditSamplePercent
+	if percent == 0 {
+		percent = 100
+	} else if percent < 0 || percent > 100 {
+		return fmt.Errorf("auditSamplePercent %v is not between 0 and 100", percent)
+	}
+
+	maxBytes := config.AuditMaxBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultAuditMaxBytes
+	}
+
+	f, err := os.OpenFile(config.AuditFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("unable to open auditFile: %w", err)
+	}
+
+	s.auditor = newAuditor(f, percent, maxBytes)
+
+	return nil
+}
+
+// newAuditor starts an auditor writing to w.
+func newAuditor(w io.Writer, percent float64, maxBytes int) *auditor {
+	a := &auditor{percent: percent, maxBytes: maxBytes, entries: make(chan auditEntry, auditQueueSize)}
+
+	go func() {
+		enc := json.NewEncoder(w)
+
+		for e := range a.entries {
+			if err := enc.Encode(e); err != nil {
+				log.Printf("unable to write audit entry: %v", err)
+			}
+		}
+	}()
+
+	return a
+}
+
+// record queues an entry for the response to r, if it is sampled. A nil
+// *auditor records nothing.
+func (a *auditor) record(r *http.Request, status int, original, filtered []byte, modified bool) {
+	if a == nil || a.percent < 100 && !sampleRandom(a.percent) {
+		return
+	}
+
+	e := auditEntry{
+		Time:     time.Now().UTC().Format(time.RFC3339),
+		Method:   r.Method,
+		Host:     r.Host,
+		URI:      r.URL.RequestURI(),
+		Status:   status,
+		Modified: modified,
+	}
+
+	e.Original, e.Truncated = a.cut(original)
+
+	var truncated bool
+
+	e.Filtered, truncated = a.cut(filtered)
+	e.Truncated = e.Truncated || truncated
+
+	select {
+	case a.entries <- e:
+	default:
+		a.warnOnce.Do(func() {
+			log.Printf("audit queue full, dropping entries")
+		})
+	}
+}
+
+// cut returns b as a string of at most maxBytes bytes, and whether it was
+// cut.
+func (a *auditor) cut(b []byte) (string, bool) {
+	if len(b) > a.maxBytes {
+		return string(b[:a.maxBytes]), true
+	}
+
+	return string(b), false
+}
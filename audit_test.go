@@ -0,0 +1,154 @@
+package subfilter
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAuditFile(t *testing.T) {
+	tests := []struct {
+		desc       string
+		percent    float64
+		maxBytes   int
+		expEntries int
+		expEntry   auditEntry
+	}{
+		{
+			desc:       "should audit every response by default",
+			expEntries: 2,
+			expEntry: auditEntry{
+				Method:   http.MethodGet,
+				Host:     "example.com",
+				URI:      "/page?x=1",
+				Status:   http.StatusOK,
+				Modified: true,
+				Original: "foo is the new bar",
+				Filtered: "bar is the new bar",
+			},
+		},
+		{
+			desc:       "should cut the bodies to the size cap",
+			percent:    100,
+			maxBytes:   3,
+			expEntries: 2,
+			expEntry: auditEntry{
+				Method:    http.MethodGet,
+				Host:      "example.com",
+				URI:       "/page?x=1",
+				Status:    http.StatusOK,
+				Modified:  true,
+				Original:  "foo",
+				Filtered:  "bar",
+				Truncated: true,
+			},
+		},
+		{
+			desc:    "should not audit responses sampled out",
+			percent: 1e-9,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "audit.log")
+
+			config := CreateConfig()
+			config.Filters = []Filter{{Regex: "foo", Replacement: "bar"}}
+			config.AuditFile = path
+			config.AuditSamplePercent = test.percent
+			config.AuditMaxBytes = test.maxBytes
+
+			next := func(w http.ResponseWriter, _ *http.Request) {
+				_, _ = w.Write([]byte("foo is the new bar"))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i := 0; i < 2; i++ {
+				recorder := httptest.NewRecorder()
+				handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://example.com/page?x=1", nil))
+
+				if got := recorder.Body.String(); got != "bar is the new bar" {
+					t.Fatalf("got body %q, want %q", got, "bar is the new bar")
+				}
+			}
+
+			entries := readAuditEntries(t, path, test.expEntries)
+			if len(entries) != test.expEntries {
+				t.Fatalf("got %d audit entries, want %d", len(entries), test.expEntries)
+			}
+
+			for _, e := range entries {
+				if _, err := time.Parse(time.RFC3339, e.Time); err != nil {
+					t.Errorf("got invalid time %q", e.Time)
+				}
+
+				e.Time = ""
+				if e != test.expEntry {
+					t.Errorf("got entry %+v, want %+v", e, test.expEntry)
+				}
+			}
+		})
+	}
+}
+
+// readAuditEntries reads the entries of the audit file at path, waiting up to
+// a second for the background writer to have written exp of them, or a tenth
+// of a second when none are expected.
+func readAuditEntries(t *testing.T, path string, exp int) []auditEntry {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	if exp == 0 {
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	for {
+		var entries []auditEntry
+
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var e auditEntry
+			if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+				t.Fatal(err)
+			}
+
+			entries = append(entries, e)
+		}
+
+		_ = f.Close()
+
+		if len(entries) >= exp || time.Now().After(deadline) {
+			return entries
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAuditConfig(t *testing.T) {
+	config := CreateConfig()
+	config.Filters = []Filter{{Regex: "foo", Replacement: "bar"}}
+	config.AuditFile = filepath.Join(t.TempDir(), "audit.log")
+	config.AuditSamplePercent = 101
+
+	if _, err := New(context.Background(), http.NotFoundHandler(), config, "subfilter"); err == nil {
+		t.Error("expected an error for an invalid auditSamplePercent")
+	}
+}
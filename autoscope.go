@@ -0,0 +1,190 @@
+package subfilter
+
+import "bytes"
+
+// autoScoped reports whether the filter is one of those configured without
+// a scope of their own, which AutoScope restricts by Content-Type. Filters
+// for which a scope makes no sense, such as range or deleteLine ones, and the
+// built-in ones, such as those of HostMap, apply to the whole body.
+func (f *filter) autoScoped() bool {
+	return f.def != nil && f.regex != nil && f.cssURL == nil && f.dataURI == nil && f.within == nil &&
+		f.head == nil && f.rng == nil && f.bytes == nil && f.csv == nil && f.yaml == nil &&
+		f.action != actionDeleteLine
+}
+
+// applyAutoScope runs the filter over the part of b that its Content-Type
+// makes safe to rewrite: the URLs of the url() tokens of stylesheets, the
+// string literals of scripts and the text nodes of HTML documents. Bodies of
+// other types are filtered whole.
+func (f *filter) applyAutoScope(b []byte, sc *scope) []byte {
+	sc.scoping = true
+	defer func() { sc.scoping = false }()
+
+	switch {
+	case mediaType(sc.contentType) == "text/css":
+		urls := filter{regex: cssURLRegex, cssURL: f}
+
+		return urls.apply(b, sc)
+	case isJavaScriptContentType(sc.contentType):
+		return filterJSStrings(b, func(s []byte) []byte { return f.apply(s, sc) })
+	case isHTMLContentType(sc.contentType):
+		text := withinTags{all: true, inner: f}
+
+		return text.apply(b, sc)
+	default:
+		return f.apply(b, sc)
+	}
+}
+
+// filterJSStrings applies fn to the content of the string literals of the
+// script b, escapes included, and to the text of its template literals,
+// leaving their ${} substitutions, comments and code untouched. Literals
+// that fn would terminate early, with their quote or a line break, are kept
+// as they were. Regular expression literals are not told apart from code.
+func filterJSStrings(b []byte, fn func([]byte) []byte) []byte {
+	out := make([]byte, 0, len(b))
+
+	for i := 0; i < len(b); {
+		switch {
+		case bytes.HasPrefix(b[i:], []byte("//")):
+			end := bytes.IndexByte(b[i:], '\n')
+			if end < 0 {
+				end = len(b) - i
+			}
+
+			out = append(out, b[i:i+end]...)
+			i += end
+		case bytes.HasPrefix(b[i:], []byte("/*")):
+			end := bytes.Index(b[i+2:], []byte("*/"))
+			if end < 0 {
+				end = len(b) - i - 2
+			} else {
+				end += 2
+			}
+
+			out = append(out, b[i:i+2+end]...)
+			i += 2 + end
+		case b[i] == '"' || b[i] == '\'' || b[i] == '`':
+			n := jsLiteralLen(b[i:])
+			out = append(out, filterJSLiteral(b[i:i+n], fn)...)
+			i += n
+		default:
+			out = append(out, b[i])
+			i++
+		}
+	}
+
+	return out
+}
+
+// jsLiteralLen returns the length of the string or template literal at the
+// start of b, quotes included.
+func jsLiteralLen(b []byte) int {
+	quote := b[0]
+	depth := 0
+
+	for i := 1; i < len(b); i++ {
+		switch {
+		case b[i] == '\\':
+			i++
+		case depth > 0 && b[i] == '{':
+			depth++
+		case depth > 0 && b[i] == '}':
+			depth--
+		case depth > 0:
+		case quote == '`' && b[i] == '$' && i+1 < len(b) && b[i+1] == '{':
+			depth++
+			i++
+		case b[i] == quote:
+			return i + 1
+		case quote != '`' && b[i] == '\n':
+			// Unterminated string literal.
+			return i
+		}
+	}
+
+	return len(b)
+}
+
+// filterJSLiteral applies fn to the text of the literal l, unless the result
+// would not fit in it.
+func filterJSLiteral(l []byte, fn func([]byte) []byte) []byte {
+	quote := l[0]
+
+	end := len(l)
+	if end > 1 && l[end-1] == quote {
+		end--
+	}
+
+	if quote != '`' {
+		text := fn(l[1:end])
+		if !jsLiteralSafe(text, quote) {
+			return l
+		}
+
+		return append(append([]byte{quote}, text...), l[end:]...)
+	}
+
+	out := []byte{quote}
+	depth := 0
+	start := 1
+
+	for i := 1; i < end; i++ {
+		switch {
+		case l[i] == '\\':
+			i++
+		case depth > 0 && l[i] == '{':
+			depth++
+		case depth > 0 && l[i] == '}':
+			if depth--; depth == 0 {
+				out = append(out, l[start:i+1]...)
+				start = i + 1
+			}
+		case depth == 0 && l[i] == '$' && i+1 < end && l[i+1] == '{':
+			text := fn(l[start:i])
+			if !jsLiteralSafe(text, quote) {
+				return l
+			}
+
+			out = append(out, text...)
+			start = i
+			depth++
+			i++
+		}
+	}
+
+	if depth > 0 {
+		out = append(out, l[start:end]...)
+	} else {
+		text := fn(l[start:end])
+		if !jsLiteralSafe(text, quote) {
+			return l
+		}
+
+		out = append(out, text...)
+	}
+
+	return append(out, l[end:]...)
+}
+
+// jsLiteralSafe reports whether text can be the content of a literal quoted
+// by quote: it holds no unescaped quote nor, but in template literals, line
+// break, and does not end with a lone backslash escaping the closing quote.
+func jsLiteralSafe(text []byte, quote byte) bool {
+	for i := 0; i < len(text); i++ {
+		switch {
+		case text[i] == '\\':
+			if i++; i == len(text) {
+				return false
+			}
+		case text[i] == quote:
+			return false
+		case quote == '`' && text[i] == '$' && i+1 < len(text) && text[i+1] == '{':
+			return false
+		case quote != '`' && (text[i] == '\n' || text[i] == '\r'):
+			return false
+		}
+	}
+
+	return true
+}
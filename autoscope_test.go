@@ -0,0 +1,101 @@
+package subfilter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAutoScope(t *testing.T) {
+	tests := []struct {
+		desc        string
+		autoScope   bool
+		contentType string
+		filters     []Filter
+		resBody     string
+		expResBody  string
+	}{
+		{
+			desc:        "should only rewrite the url() URLs of CSS",
+			autoScope:   true,
+			contentType: "text/css",
+			filters:     []Filter{{Regex: "old", Replacement: "new"}},
+			resBody:     `.old { background: url("/old.png") } /* old */`,
+			expResBody:  `.old { background: url("/new.png") } /* old */`,
+		},
+		{
+			desc:        "should only rewrite the string literals of JavaScript",
+			autoScope:   true,
+			contentType: "application/javascript",
+			filters:     []Filter{{Regex: "old", Replacement: "new"}},
+			resBody:     "var old = 'old', t = `old ${old} old`; // old\n/* old */ f(\"old\");",
+			expResBody:  "var old = 'new', t = `new ${old} new`; // old\n/* old */ f(\"new\");",
+		},
+		{
+			desc:        "should keep JavaScript literals a replacement would break",
+			autoScope:   true,
+			contentType: "text/javascript",
+			filters:     []Filter{{Regex: "old", Replacement: `"`}},
+			resBody:     `f("old", 'old')`,
+			expResBody:  `f("old", '"')`,
+		},
+		{
+			desc:        "should only rewrite the text nodes of HTML",
+			autoScope:   true,
+			contentType: "text/html; charset=utf-8",
+			filters:     []Filter{{Regex: "old", Replacement: "new"}},
+			resBody:     `<a href="/old" class="old">old</a><script>old</script>`,
+			expResBody:  `<a href="/old" class="old">new</a><script>old</script>`,
+		},
+		{
+			desc:        "should filter other content types whole",
+			autoScope:   true,
+			contentType: "text/plain",
+			filters:     []Filter{{Regex: "old", Replacement: "new"}},
+			resBody:     `url(old) "old" <b>old</b>`,
+			expResBody:  `url(new) "new" <b>new</b>`,
+		},
+		{
+			desc:        "should keep the scope of filters that have one",
+			autoScope:   true,
+			contentType: "text/html",
+			filters:     []Filter{{Regex: "old", Replacement: "new", WithinTags: []string{"a"}, WithinTagsAttributes: true}},
+			resBody:     `<a href="/old">old</a>`,
+			expResBody:  `<a href="/new">new</a>`,
+		},
+		{
+			desc:        "should filter the whole body unless enabled",
+			contentType: "text/html",
+			filters:     []Filter{{Regex: "old", Replacement: "new"}},
+			resBody:     `<a href="/old">old</a>`,
+			expResBody:  `<a href="/new">new</a>`,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.Filters = test.filters
+			config.AutoScope = test.autoScope
+
+			next := func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Type", test.contentType)
+				_, _ = w.Write([]byte(test.resBody))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if got := recorder.Body.String(); got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+		})
+	}
+}
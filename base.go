@@ -0,0 +1,74 @@
+package subfilter
+
+import (
+	"bytes"
+	"html"
+	"regexp"
+)
+
+var (
+	baseTagRegex  = regexp.MustCompile(`(?i)<base\b[^>]*>`)
+	baseHrefRegex = regexp.MustCompile(`(?i)\bhref\s*=\s*(?:"[^"]*"|'[^']*'|[^\s>]*)`)
+	headTagRegex  = regexp.MustCompile(`(?i)<head\b[^>]*>`)
+	htmlTagRegex  = regexp.MustCompile(`(?i)<html\b[^>]*>`)
+	doctypeRegex  = regexp.MustCompile(`(?i)^\s*<!doctype[^>]*>`)
+)
+
+// isHTMLContentType reports whether contentType describes an HTML document.
+func isHTMLContentType(contentType string) bool {
+	mt := mediaType(contentType)
+
+	return mt == "text/html" || mt == "application/xhtml+xml"
+}
+
+// injectBaseHref points the <base> tag of the HTML document b at href. An
+// existing <base> tag has its href set; otherwise one is inserted at the start
+// of <head>, creating the head if needed.
+func (s *SubFilter) injectBaseHref(b []byte) []byte {
+	attr := []byte(`href="` + html.EscapeString(s.baseHref) + `"`)
+
+	if loc := baseTagRegex.FindIndex(b); loc != nil {
+		tag := b[loc[0]:loc[1]]
+
+		if baseHrefRegex.Match(tag) {
+			tag = baseHrefRegex.ReplaceAllLiteral(tag, attr)
+		} else {
+			tag = append(append([]byte("<base "), attr...), tag[len("<base"):]...)
+		}
+
+		return splice(b, loc[0], loc[1], tag)
+	}
+
+	base := append(append([]byte("<base "), attr...), '>')
+
+	if loc := headTagRegex.FindIndex(b); loc != nil {
+		return splice(b, loc[1], loc[1], base)
+	}
+
+	head := append(append([]byte("<head>"), base...), "</head>"...)
+
+	if loc := htmlTagRegex.FindIndex(b); loc != nil {
+		return splice(b, loc[1], loc[1], head)
+	}
+
+	// Without <html> either, the base goes after the doctype, never before it
+	// as that would put browsers in quirks mode.
+	at := 0
+	if loc := doctypeRegex.FindIndex(b); loc != nil {
+		at = loc[1]
+	}
+
+	return splice(b, at, at, head)
+}
+
+// splice returns b with b[start:end] replaced by insert.
+func splice(b []byte, start, end int, insert []byte) []byte {
+	var out bytes.Buffer
+
+	out.Grow(len(b) - (end - start) + len(insert))
+	out.Write(b[:start])
+	out.Write(insert)
+	out.Write(b[end:])
+
+	return out.Bytes()
+}
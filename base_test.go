@@ -0,0 +1,79 @@
+package subfilter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBaseHref(t *testing.T) {
+	tests := []struct {
+		desc        string
+		contentType string
+		resBody     string
+		expResBody  string
+	}{
+		{
+			desc:        "should insert a base at the start of the head",
+			contentType: "text/html; charset=utf-8",
+			resBody:     `<html><head lang="en"><title>x</title></head></html>`,
+			expResBody:  `<html><head lang="en"><base href="/app/"><title>x</title></head></html>`,
+		},
+		{
+			desc:        "should update an existing base",
+			contentType: "text/html",
+			resBody:     `<head><BASE target="_top" HREF='/'></head>`,
+			expResBody:  `<head><BASE target="_top" href="/app/"></head>`,
+		},
+		{
+			desc:        "should add an href to a base without one",
+			contentType: "text/html",
+			resBody:     `<head><base target="_top"></head>`,
+			expResBody:  `<head><base href="/app/" target="_top"></head>`,
+		},
+		{
+			desc:        "should create the head when missing",
+			contentType: "text/html",
+			resBody:     `<!DOCTYPE html><html lang="en"><body>x</body></html>`,
+			expResBody:  `<!DOCTYPE html><html lang="en"><head><base href="/app/"></head><body>x</body></html>`,
+		},
+		{
+			desc:        "should insert after the doctype of a fragment",
+			contentType: "text/html",
+			resBody:     `<!DOCTYPE html><p>x</p>`,
+			expResBody:  `<!DOCTYPE html><head><base href="/app/"></head><p>x</p>`,
+		},
+		{
+			desc:        "should leave other content types alone",
+			contentType: "text/plain",
+			resBody:     `<head></head>`,
+			expResBody:  `<head></head>`,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.BaseHref = "/app/"
+
+			next := func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", test.contentType)
+				_, _ = w.Write([]byte(test.resBody))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if got := recorder.Body.String(); got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+		})
+	}
+}
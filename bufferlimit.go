@@ -0,0 +1,126 @@
+package subfilter
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	bufferLimitPassthrough   = "passthrough"
+	bufferLimitRewritePrefix = "rewriteprefix"
+)
+
+// SizeGuard skips filtering responses larger than MaxSize bytes. Those
+// declaring a larger Content-Length are passed through without being
+// buffered; the others are buffered up to MaxSize, and handled as OnExceed
+// says once their body crosses it: "passthrough" or "rewritePrefix".
+type SizeGuard struct {
+	MaxSize  int64  `json:"maxSize,omitempty"`
+	OnExceed string `json:"onExceed,omitempty"`
+}
+
+func (s *SubFilter) setupBufferLimit(config *Config) error {
+	maxName, policyName := "maxBufferSize", "onBufferLimit"
+	maxSize, onExceed := config.MaxBufferSize, config.OnBufferLimit
+
+	if config.SizeGuard != nil {
+		if config.MaxBufferSize != 0 || config.OnBufferLimit != "" {
+			return errors.New("sizeGuard cannot be combined with maxBufferSize nor onBufferLimit")
+		}
+
+		maxName, policyName = "sizeGuard.maxSize", "sizeGuard.onExceed"
+		maxSize, onExceed = config.SizeGuard.MaxSize, config.SizeGuard.OnExceed
+	}
+
+	if maxSize < 0 {
+		return fmt.Errorf("invalid %s %d: must not be negative", maxName, maxSize)
+	}
+
+	s.maxBufferSize = maxSize
+
+	switch policy := strings.ToLower(onExceed); policy {
+	case "":
+		s.onBufferLimit = bufferLimitPassthrough
+	case bufferLimitPassthrough, bufferLimitRewritePrefix:
+		s.onBufferLimit = policy
+	default:
+		return fmt.Errorf("invalid %s %q: must be %q or %q", policyName, onExceed, bufferLimitPassthrough, "rewritePrefix")
+	}
+
+	return nil
+}
+
+// exceedsBufferLimit reports whether the Content-Length of a response
+// declares a body larger than maxBufferSize.
+func (s *SubFilter) exceedsBufferLimit(header http.Header) bool {
+	if s.maxBufferSize == 0 {
+		return false
+	}
+
+	n, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+
+	return err == nil && n > s.maxBufferSize
+}
+
+// overflowBuffer is called when writing b would grow the buffered body of rw
+// past maxBufferSize. The buffered prefix is sent, filtered first with the
+// rewritePrefix policy, followed by b, and the rest of the response is
+// streamed untouched. Matches straddling the end of the prefix are missed.
+func (s *SubFilter) overflowBuffer(rw *responseWriter, r *http.Request, b []byte) (int, error) {
+	atomic.AddUint64(&s.stats.BufferLimited, 1)
+
+	prefix := rw.buffer.Bytes()
+
+	// A compressed or multipart prefix cannot be filtered on its own.
+	if s.onBufferLimit == bufferLimitRewritePrefix && rw.gzipLayers == 0 && !bytes.HasPrefix(prefix, gzipMagic) &&
+		rw.partFilters == nil {
+		atomic.AddUint64(&s.stats.Filtered, 1)
+
+		sc := s.newScope(r)
+		sc.lang = s.requestLanguage(r)
+		sc.header = rw.Header()
+
+		filtered := s.filterBody(rw.filters, prefix, rw.Header().Get("Content-Type"), sc)
+		if !bytes.Equal(filtered, prefix) {
+			atomic.AddUint64(&s.stats.Modified, 1)
+
+			rw.Header().Set(processedHeader, s.name)
+			// Digests would have to cover the tail, which is not known yet.
+			rw.Header().Del("Digest")
+			rw.Header().Del("Content-Digest")
+			rw.Header().Del("Repr-Digest")
+			s.updateLastModified(rw.Header())
+
+			if s.stripAcceptRanges {
+				rw.Header().Del("Accept-Ranges")
+			}
+		}
+
+		prefix = filtered
+	}
+
+	rw.passthrough = true
+	rw.buffer = nil
+
+	rw.applyHeaderEdits()
+	// The final length is not known until the upstream is done.
+	s.preserveOriginalLength(rw.Header())
+	rw.Header().Del("Content-Length")
+	rw.ResponseWriter.WriteHeader(rw.statusCode())
+
+	if _, err := rw.ResponseWriter.Write(prefix); err != nil {
+		return 0, fmt.Errorf("could not write buffered prefix: %w", err)
+	}
+
+	n, err := rw.ResponseWriter.Write(b)
+	if err != nil {
+		return n, fmt.Errorf("could not write response: %w", err)
+	}
+
+	return n, nil
+}
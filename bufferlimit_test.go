@@ -0,0 +1,200 @@
+package subfilter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestBufferLimit(t *testing.T) {
+	head := "<head><title>foo</title></head>"
+	tail := strings.Repeat("<p>foo</p>", 10)
+
+	tests := []struct {
+		desc          string
+		policy        string
+		contentLength int
+		chunks        []string
+		expResBody    string
+		expLength     string
+		expLimited    uint64
+	}{
+		{
+			desc:       "should filter bodies within the limit",
+			chunks:     []string{head},
+			expResBody: "<head><title>bar</title></head>",
+		},
+		{
+			desc:       "should pass the prefix through untouched by default",
+			chunks:     []string{head, tail},
+			expResBody: head + tail,
+			expLimited: 1,
+		},
+		{
+			desc:       "should pass the prefix through untouched",
+			policy:     "passthrough",
+			chunks:     []string{head, tail},
+			expResBody: head + tail,
+			expLimited: 1,
+		},
+		{
+			desc:       "should rewrite the prefix and stream the rest",
+			policy:     "rewritePrefix",
+			chunks:     []string{head, tail},
+			expResBody: "<head><title>bar</title></head>" + tail,
+			expLimited: 1,
+		},
+		{
+			desc:          "should drop a Content-Length undercounting the body",
+			policy:        "rewritePrefix",
+			contentLength: len(head),
+			chunks:        []string{head, tail},
+			expResBody:    "<head><title>bar</title></head>" + tail,
+			expLimited:    1,
+		},
+		{
+			desc:          "should pass declared oversized bodies through",
+			policy:        "rewritePrefix",
+			contentLength: len(head + tail),
+			chunks:        []string{head, tail},
+			expResBody:    head + tail,
+			expLength:     strconv.Itoa(len(head + tail)),
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.Filters = []Filter{{Regex: "foo", Replacement: "bar"}}
+			config.MaxBufferSize = 48
+			config.OnBufferLimit = test.policy
+
+			next := func(w http.ResponseWriter, _ *http.Request) {
+				if test.contentLength > 0 {
+					w.Header().Set("Content-Length", strconv.Itoa(test.contentLength))
+				}
+
+				for _, chunk := range test.chunks {
+					_, _ = w.Write([]byte(chunk))
+				}
+			}
+
+			sf, err := NewSubFilter(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			sf.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if got := recorder.Body.String(); got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+
+			if got := recorder.Header().Get("Content-Length"); got != test.expLength {
+				t.Errorf("got Content-Length %q, want %q", got, test.expLength)
+			}
+
+			if got := sf.Stats().BufferLimited; got != test.expLimited {
+				t.Errorf("got %d buffer-limited responses, want %d", got, test.expLimited)
+			}
+		})
+	}
+}
+
+func TestBufferLimitConfig(t *testing.T) {
+	for _, config := range []*Config{
+		{MaxBufferSize: -1},
+		{MaxBufferSize: 1024, OnBufferLimit: "truncate"},
+	} {
+		config.Filters = []Filter{{Regex: "foo"}}
+
+		if _, err := New(context.Background(), nil, config, "subfilter"); err == nil {
+			t.Errorf("expected error for maxBufferSize %d and onBufferLimit %q", config.MaxBufferSize, config.OnBufferLimit)
+		}
+	}
+}
+
+func TestSizeGuard(t *testing.T) {
+	body := strings.Repeat("<p>foo</p>", 10)
+
+	tests := []struct {
+		desc          string
+		contentLength bool
+		maxSize       int64
+		expResBody    string
+		expLimited    uint64
+	}{
+		{
+			desc:          "should pass bodies declaring a larger length through",
+			contentLength: true,
+			maxSize:       64,
+			expResBody:    body,
+		},
+		{
+			desc:       "should filter bodies of unknown length under the limit",
+			maxSize:    1024,
+			expResBody: strings.Repeat("<p>bar</p>", 10),
+		},
+		{
+			desc:       "should pass bodies of unknown length over the limit through",
+			maxSize:    64,
+			expResBody: body,
+			expLimited: 1,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.Filters = []Filter{{Regex: "foo", Replacement: "bar"}}
+			config.SizeGuard = &SizeGuard{MaxSize: test.maxSize}
+
+			next := func(w http.ResponseWriter, _ *http.Request) {
+				if test.contentLength {
+					w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+				}
+
+				for i := 0; i < len(body); i += 10 {
+					_, _ = w.Write([]byte(body[i : i+10]))
+				}
+			}
+
+			sf, err := NewSubFilter(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			sf.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if got := recorder.Body.String(); got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+
+			if got := sf.Stats().BufferLimited; got != test.expLimited {
+				t.Errorf("got %d buffer-limited responses, want %d", got, test.expLimited)
+			}
+		})
+	}
+}
+
+func TestSizeGuardConfig(t *testing.T) {
+	for i, config := range []*Config{
+		{SizeGuard: &SizeGuard{MaxSize: -1}},
+		{SizeGuard: &SizeGuard{MaxSize: 1024, OnExceed: "truncate"}},
+		{SizeGuard: &SizeGuard{MaxSize: 1024}, MaxBufferSize: 1024},
+		{SizeGuard: &SizeGuard{MaxSize: 1024}, OnBufferLimit: "passthrough"},
+	} {
+		config.Filters = []Filter{{Regex: "foo"}}
+
+		if _, err := New(context.Background(), nil, config, "subfilter"); err == nil {
+			t.Errorf("config %d: expected error", i)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package subfilter
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+func validateBumpParam(args []string) error {
+	if args[0] == "" || strings.ContainsAny(args[0], "&=#?") {
+		return fmt.Errorf("invalid parameter name %q", args[0])
+	}
+
+	return nil
+}
+
+// bumpParamTransform implements ${bumpparam:name[:group]}: the URL captured
+// by the group (the whole match by default) with its numeric query parameter
+// name incremented, as in "app.js?v=3" to "app.js?v=4", or added as name=1
+// when missing. The other parameters, their order and the fragment are kept;
+// "&amp;" separators, as in HTML attributes, are understood. URLs whose
+// parameter is not a number are kept as they are.
+func bumpParamTransform(dst []byte, args []string, re *regexp.Regexp, src []byte, match []int, _ *scope) []byte {
+	ref := "0"
+	if len(args) > 1 {
+		ref = args[1]
+	}
+
+	value := string(group(re, src, match, ref))
+
+	return append(dst, bumpParam(value, args[0])...)
+}
+
+// bumpParam returns rawURL with its query parameter name incremented or set
+// to 1.
+func bumpParam(rawURL, name string) string {
+	fragment := ""
+	if i := strings.IndexByte(rawURL, '#'); i >= 0 {
+		rawURL, fragment = rawURL[:i], rawURL[i:]
+	}
+
+	query := ""
+	if i := strings.IndexByte(rawURL, '?'); i >= 0 {
+		rawURL, query = rawURL[:i], rawURL[i+1:]
+	}
+
+	sep := "&"
+	if strings.Contains(query, "&amp;") {
+		sep = "&amp;"
+	}
+
+	params := strings.Split(query, sep)
+
+	for i, param := range params {
+		key, val := param, ""
+		if j := strings.IndexByte(param, '='); j >= 0 {
+			key, val = param[:j], param[j+1:]
+		}
+
+		if k, err := url.QueryUnescape(key); err != nil || k != name {
+			continue
+		}
+
+		n, err := strconv.ParseUint(val, 10, 64)
+		if err != nil {
+			return rawURL + "?" + query + fragment
+		}
+
+		params[i] = key + "=" + strconv.FormatUint(n+1, 10)
+
+		return rawURL + "?" + strings.Join(params, sep) + fragment
+	}
+
+	param := url.QueryEscape(name) + "=1"
+	if query != "" {
+		param = query + sep + param
+	}
+
+	return rawURL + "?" + param + fragment
+}
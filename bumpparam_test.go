@@ -0,0 +1,74 @@
+package subfilter
+
+import "testing"
+
+func TestBumpParamTransform(t *testing.T) {
+	tests := []struct {
+		desc        string
+		regex       string
+		replacement string
+		resBody     string
+		expResBody  string
+	}{
+		{
+			desc:        "should bump the parameter",
+			regex:       `/app\.js[^"]*`,
+			replacement: "${bumpparam:v}",
+			resBody:     `<script src="/app.js?v=3">`,
+			expResBody:  `<script src="/app.js?v=4">`,
+		},
+		{
+			desc:        "should add the parameter when absent",
+			regex:       `/app\.js[^"]*`,
+			replacement: "${bumpparam:v}",
+			resBody:     `<script src="/app.js">`,
+			expResBody:  `<script src="/app.js?v=1">`,
+		},
+		{
+			desc:        "should add the parameter after the others",
+			regex:       `/app\.js[^"]*`,
+			replacement: "${bumpparam:v}",
+			resBody:     `<script src="/app.js?b=2&a=1#top">`,
+			expResBody:  `<script src="/app.js?b=2&a=1&v=1#top">`,
+		},
+		{
+			desc:        "should keep the other parameters in order",
+			regex:       `/app\.js[^"]*`,
+			replacement: "${bumpparam:v}",
+			resBody:     `<script src="/app.js?z=1&amp;v=9&amp;a=x%20y">`,
+			expResBody:  `<script src="/app.js?z=1&amp;v=10&amp;a=x%20y">`,
+		},
+		{
+			desc:        "should bump a named group",
+			regex:       `href="(?P<url>[^"]+)"`,
+			replacement: `href="${bumpparam:rev:url}"`,
+			resBody:     `<link href="style.css?rev=41">`,
+			expResBody:  `<link href="style.css?rev=42">`,
+		},
+		{
+			desc:        "should keep a parameter that is not a number",
+			regex:       `/app\.js[^"]*`,
+			replacement: "${bumpparam:v}",
+			resBody:     `<script src="/app.js?v=abc">`,
+			expResBody:  `<script src="/app.js?v=abc">`,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			got := serveTransform(t, Filter{Regex: test.regex, Replacement: test.replacement, Transforms: true}, test.resBody)
+			if got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+		})
+	}
+}
+
+func TestBumpParamTransformInvalid(t *testing.T) {
+	for _, replacement := range []string{"${bumpparam}", "${bumpparam:}", "${bumpparam:v=1}", "${bumpparam:v:1:2}"} {
+		if _, err := compileFilters([]Filter{{Regex: "x", Replacement: replacement, Transforms: true}}); err == nil {
+			t.Errorf("replacement %q: expected error", replacement)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package subfilter
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// bytesFilter replaces every occurrence of a fixed byte sequence, for binary
+// bodies that regexes and text handling have no business with.
+type bytesFilter struct {
+	old, new []byte
+	// def is the definition the filter was compiled from.
+	def *Filter
+}
+
+func compileBytes(f Filter) (*bytesFilter, error) {
+	switch {
+	case f.Regex == "":
+		return nil, errors.New("bytes filters require regex")
+	case f.Preset != "" || f.ReplacementFile != "" || f.HashReplacement != nil || f.Lookup != nil || f.Transforms ||
+		len(f.Replacements) > 0 || f.MaxCaptureLen > 0:
+		return nil, errors.New("bytes filters only support a literal replacement")
+	}
+
+	old, err := hex.DecodeString(f.Regex)
+	if err != nil {
+		return nil, fmt.Errorf("invalid hex regex %q: %w", f.Regex, err)
+	}
+
+	replacement, err := hex.DecodeString(f.Replacement)
+	if err != nil {
+		return nil, fmt.Errorf("invalid hex replacement %q: %w", f.Replacement, err)
+	}
+
+	return &bytesFilter{old: old, new: replacement}, nil
+}
+
+// apply replaces every non-overlapping occurrence of the sequence in b.
+func (bf *bytesFilter) apply(b []byte, sc *scope) []byte {
+	i := bytes.Index(b, bf.old)
+	if i < 0 {
+		return b
+	}
+
+	out := make([]byte, 0, len(b)+len(bf.new)-len(bf.old))
+	last := 0
+
+	for i >= 0 {
+		start := last + i
+		end := start + len(bf.old)
+
+		sc.countMatch(bf.def)
+		sc.reportMatch(bf.def, b, start, end)
+
+		out = append(out, b[last:start]...)
+		out = append(out, bf.new...)
+		last = end
+		i = bytes.Index(b[last:], bf.old)
+	}
+
+	return append(out, b[last:]...)
+}
@@ -0,0 +1,92 @@
+package subfilter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestBytesFilter(t *testing.T) {
+	firmware := []byte{0x7f, 'E', 'L', 'F', 0x0a, 0x00, 0x00, 0x01, 0xff, 0x0a, 0x00, 0x00, 0x01}
+
+	tests := []struct {
+		desc       string
+		filter     Filter
+		expResBody []byte
+	}{
+		{
+			desc:       "should swap a sequence of the same length",
+			filter:     Filter{Type: "bytes", Regex: "0a000001", Replacement: "C0A80001"},
+			expResBody: []byte{0x7f, 'E', 'L', 'F', 0xc0, 0xa8, 0x00, 0x01, 0xff, 0xc0, 0xa8, 0x00, 0x01},
+		},
+		{
+			desc:       "should swap a sequence of another length",
+			filter:     Filter{Type: "bytes", Regex: "ff0a000001", Replacement: "00"},
+			expResBody: []byte{0x7f, 'E', 'L', 'F', 0x0a, 0x00, 0x00, 0x01, 0x00},
+		},
+		{
+			desc:       "should delete a sequence",
+			filter:     Filter{Type: "bytes", Regex: "7f454c46"},
+			expResBody: firmware[4:],
+		},
+		{
+			desc:       "should not use regex semantics",
+			filter:     Filter{Type: "bytes", Regex: "2e2a", Replacement: "00"},
+			expResBody: firmware,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.ContentTypeFilters = map[string][]Filter{"application/octet-stream": {test.filter}}
+
+			next := func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Type", "application/octet-stream")
+				w.Header().Set("Content-Length", strconv.Itoa(len(firmware)))
+				_, _ = w.Write(firmware)
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/firmware.bin", nil))
+
+			if got := recorder.Body.Bytes(); string(got) != string(test.expResBody) {
+				t.Errorf("got body %x, want %x", got, test.expResBody)
+			}
+
+			if cl := recorder.Header().Get("Content-Length"); cl != "" {
+				t.Errorf("got stale Content-Length %s", cl)
+			}
+		})
+	}
+}
+
+func TestBytesFilterConfig(t *testing.T) {
+	tests := []struct {
+		desc   string
+		filter Filter
+	}{
+		{desc: "should reject odd-length sequences", filter: Filter{Type: "bytes", Regex: "0a0", Replacement: "00"}},
+		{desc: "should reject non-hex replacements", filter: Filter{Type: "bytes", Regex: "0a", Replacement: "zz"}},
+		{desc: "should reject empty sequences", filter: Filter{Type: "bytes", Replacement: "00"}},
+		{desc: "should reject transforms", filter: Filter{Type: "bytes", Regex: "0a", Transforms: true}},
+		{desc: "should reject actions", filter: Filter{Type: "bytes", Regex: "0a", Action: "deleteLine"}},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			if _, err := compileFilters([]Filter{test.filter}); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package subfilter
+
+import (
+	"net/http"
+	"strings"
+)
+
+const directiveNoTransform = "no-transform"
+
+// hasCacheDirective reports whether the Cache-Control header of h carries the
+// given directive, ignoring case and directive arguments.
+func hasCacheDirective(h http.Header, directive string) bool {
+	for _, v := range h.Values("Cache-Control") {
+		for _, d := range strings.Split(v, ",") {
+			name := strings.TrimSpace(strings.SplitN(d, "=", 2)[0])
+			if strings.EqualFold(name, directive) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,157 @@
+package subfilter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHonorNoTransform(t *testing.T) {
+	tests := []struct {
+		desc          string
+		honor         bool
+		reqDirectives string
+		resDirectives string
+		expResBody    string
+	}{
+		{
+			desc:          "should skip when the request has no-transform",
+			honor:         true,
+			reqDirectives: "max-age=0, No-Transform",
+			expResBody:    "foo is the new bar",
+		},
+		{
+			desc:          "should skip when the response has no-transform",
+			honor:         true,
+			resDirectives: "public, no-transform, max-age=60",
+			expResBody:    "foo is the new bar",
+		},
+		{
+			desc:          "should filter without no-transform",
+			honor:         true,
+			reqDirectives: "no-cache",
+			resDirectives: "public",
+			expResBody:    "bar is the new bar",
+		},
+		{
+			desc:          "should ignore no-transform when disabled",
+			reqDirectives: "no-transform",
+			resDirectives: "no-transform",
+			expResBody:    "bar is the new bar",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.HonorNoTransform = test.honor
+			config.Filters = []Filter{{Regex: "foo", Replacement: "bar"}}
+
+			next := func(w http.ResponseWriter, r *http.Request) {
+				if test.resDirectives != "" {
+					w.Header().Set("Cache-Control", test.resDirectives)
+				}
+
+				_, _ = w.Write([]byte("foo is the new bar"))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if test.reqDirectives != "" {
+				req.Header.Set("Cache-Control", test.reqDirectives)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+
+			if got := recorder.Body.String(); got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+		})
+	}
+}
+
+func TestCacheControlOnRewrite(t *testing.T) {
+	tests := []struct {
+		desc       string
+		filter     Filter
+		expResBody string
+		expCache   string
+		expExpires bool
+	}{
+		{
+			desc:       "should keep the caching headers of a static rewrite",
+			filter:     Filter{Regex: "HOST", Replacement: "example.com"},
+			expResBody: "see example.com",
+			expCache:   "public, max-age=86400",
+			expExpires: true,
+		},
+		{
+			desc:       "should keep the caching headers of a template not using the request",
+			filter:     Filter{Type: "template", Regex: "HOST", Replacement: "{{lower .Match}}"},
+			expResBody: "see host",
+			expCache:   "public, max-age=86400",
+			expExpires: true,
+		},
+		{
+			desc:       "should replace the caching headers of a rewrite using the request",
+			filter:     Filter{Type: "template", Regex: "HOST", Replacement: "{{.Request.Host}}"},
+			expResBody: "see app.example.com",
+			expCache:   "private, no-store",
+		},
+		{
+			desc:       "should detect the request used in a with block",
+			filter:     Filter{Type: "template", Regex: "HOST", Replacement: "{{with .Request}}{{.Host}}{{end}}"},
+			expResBody: "see app.example.com",
+			expCache:   "private, no-store",
+		},
+		{
+			desc:       "should keep the caching headers when nothing matched",
+			filter:     Filter{Type: "template", Regex: "NOPE", Replacement: "{{.Request.Host}}"},
+			expResBody: "see HOST",
+			expCache:   "public, max-age=86400",
+			expExpires: true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.Filters = []Filter{test.filter}
+			config.CacheControlOnRewrite = "private, no-store"
+
+			next := func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Cache-Control", "public, max-age=86400")
+				w.Header().Set("Expires", "Thu, 01 Jan 2099 00:00:00 GMT")
+				_, _ = w.Write([]byte("see HOST"))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://app.example.com/", nil))
+
+			if got := recorder.Body.String(); got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+
+			if got := recorder.Header().Get("Cache-Control"); got != test.expCache {
+				t.Errorf("got Cache-Control %q, want %q", got, test.expCache)
+			}
+
+			if got := recorder.Header().Get("Expires") != ""; got != test.expExpires {
+				t.Errorf("got Expires %t, want %t", got, test.expExpires)
+			}
+		})
+	}
+}
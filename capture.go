@@ -0,0 +1,34 @@
+package subfilter
+
+import (
+	"log"
+	"sync"
+)
+
+// captureLimit skips matches with a capture group longer than max bytes, so
+// that a runaway greedy group is not duplicated into the body.
+type captureLimit struct {
+	max int
+	// warnOnce limits the warning to one log line per filter.
+	warnOnce sync.Once
+}
+
+// allow reports whether every capture group of the match m is within the
+// limit. A nil *captureLimit allows everything.
+func (c *captureLimit) allow(m []int) bool {
+	if c == nil {
+		return true
+	}
+
+	for i := 2; i+1 < len(m); i += 2 {
+		if m[i] >= 0 && m[i+1]-m[i] > c.max {
+			c.warnOnce.Do(func() {
+				log.Printf("skipping match with a %d byte capture group, over maxCaptureLen %d", m[i+1]-m[i], c.max)
+			})
+
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,123 @@
+package subfilter
+
+import "sort"
+
+// edit is a match of one of the filters applied independently: the span of
+// the body it rewrites, from the match m of filter f.
+type edit struct {
+	start, end int
+	m          []int
+	f          *filter
+}
+
+// independent reports whether f can be applied independently of the other
+// filters, its edits merged with theirs: only plain regex filters can, and
+// not those whose maxExpansionRatio needs their whole output.
+func (f *filter) independent() bool {
+	return f.regex != nil && f.cssURL == nil && f.dataURI == nil && f.within == nil && f.rng == nil && f.bytes == nil &&
+		f.csv == nil && f.yaml == nil && f.head == nil && f.onError == "" && !f.firstChunk && f.maxExpansion == 0
+}
+
+// applyIndependent applies every filter to b as the upstream sent it rather
+// than to the output of the previous ones, and merges their edits. An edit
+// overlapping one of an earlier filter is dropped and counted as a conflict.
+// The filters that cannot be applied this way then run in order over the
+// result.
+func applyIndependent(filters []filter, b []byte, sc *scope) []byte {
+	var (
+		edits []edit
+		rest  []*filter
+		ok    bool
+	)
+
+	for i := range filters {
+		f := &filters[i]
+
+		switch {
+		case f.targets.skipBody || !f.rollout.include(sc) || !f.window.active(sc):
+			continue
+		case !f.independent() || sc.autoScope && f.autoScoped():
+			rest = append(rest, f)
+
+			continue
+		}
+
+		// deleted is the end of the lines the filter deletes so far.
+		deleted := 0
+
+		for _, m := range f.actedMatches(b, sc) {
+			e := edit{start: m[0], end: m[1], m: m, f: f}
+
+			if f.action == actionDeleteLine {
+				if m[0] < deleted {
+					// The line is already deleted by the same filter.
+					continue
+				}
+
+				e.start, e.end = lineSpan(b, m)
+				deleted = e.end
+			}
+
+			if edits, ok = insertEdit(edits, e); !ok {
+				sc.conflicts++
+			}
+		}
+	}
+
+	out := make([]byte, 0, len(b))
+	last := 0
+
+	for _, e := range edits {
+		sc.countMatch(e.f.def)
+
+		out = append(out, b[last:e.start]...)
+		n := len(out)
+
+		if e.f.action != actionDeleteLine {
+			out = e.f.act(out, b, e.m, sc)
+		}
+
+		if e.f.action != actionDeleteLine && e.f.unchanged(out[n:], b, e.m) {
+			sc.discountMatch(e.f.def)
+		} else {
+			sc.markApplied(e.f)
+			sc.reportMatch(e.f.def, b, e.m[0], e.m[1])
+		}
+
+		last = e.end
+	}
+
+	b = append(out, b[last:]...)
+
+	for _, f := range rest {
+		matches := sc.matches
+		if b = sc.timeFilter(f, b); sc.matches > matches {
+			sc.markApplied(f)
+		}
+	}
+
+	return b
+}
+
+// insertEdit inserts e into edits, which are ordered by offset and do not
+// overlap, unless it overlaps one of them. Edits of the same offset are kept
+// in the order they were inserted.
+func insertEdit(edits []edit, e edit) ([]edit, bool) {
+	i := sort.Search(len(edits), func(i int) bool { return edits[i].start > e.start })
+
+	if i > 0 && overlap(edits[i-1], e) || i < len(edits) && overlap(edits[i], e) {
+		return edits, false
+	}
+
+	edits = append(edits, edit{})
+	copy(edits[i+1:], edits[i:])
+	edits[i] = e
+
+	return edits, true
+}
+
+// overlap reports whether the spans of a and b overlap. An empty span only
+// overlaps the spans it is strictly inside of.
+func overlap(a, b edit) bool {
+	return a.start < b.end && b.start < a.end
+}
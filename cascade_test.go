@@ -0,0 +1,111 @@
+package subfilter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCascadeFilters(t *testing.T) {
+	tests := []struct {
+		desc       string
+		cascade    bool
+		filters    []Filter
+		resBody    string
+		expResBody string
+	}{
+		{
+			desc:       "should let filters match what earlier ones wrote by default",
+			cascade:    true,
+			filters:    []Filter{{Regex: "foo", Replacement: "bar"}, {Regex: "bar", Replacement: "baz"}},
+			resBody:    "foo bar",
+			expResBody: "baz baz",
+		},
+		{
+			desc:       "should match every filter against the upstream body",
+			filters:    []Filter{{Regex: "foo", Replacement: "bar"}, {Regex: "bar", Replacement: "baz"}},
+			resBody:    "foo bar",
+			expResBody: "bar baz",
+		},
+		{
+			desc:       "should swap values",
+			filters:    []Filter{{Regex: "left", Replacement: "right"}, {Regex: "right", Replacement: "left"}},
+			resBody:    "left right",
+			expResBody: "right left",
+		},
+		{
+			desc: "should drop replacements overlapping those of earlier filters",
+			filters: []Filter{
+				{Regex: "bc", Replacement: "X"},
+				{Regex: "abcd", Replacement: "Y"},
+				{Regex: "d", Replacement: "Z"},
+			},
+			resBody:    "abcd",
+			expResBody: "aXZ",
+		},
+		{
+			desc: "should merge insertions and deleted lines",
+			filters: []Filter{
+				{Regex: "<b>", Replacement: "<i>", Action: "insertBefore"},
+				{Regex: "debug", Action: "deleteLine"},
+			},
+			resBody:    "<b>a</b>\ndebug debug\n<b>c</b>",
+			expResBody: "<i><b>a</b>\n<i><b>c</b>",
+		},
+		{
+			desc: "should run other filter types over the result",
+			filters: []Filter{
+				{Type: "range", Start: "<x>", End: "</x>", Replacement: "bar"},
+				{Regex: "foo", Replacement: "<x>foo</x>"},
+			},
+			resBody:    "foo",
+			expResBody: "<x>bar</x>",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.Filters = test.filters
+			config.IndependentFilters = !test.cascade
+
+			next := func(w http.ResponseWriter, _ *http.Request) {
+				_, _ = w.Write([]byte(test.resBody))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if got := recorder.Body.String(); got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+		})
+	}
+}
+
+func TestCascadeFiltersZeroConfig(t *testing.T) {
+	config := &Config{Filters: []Filter{{Regex: "foo", Replacement: "bar"}, {Regex: "bar", Replacement: "baz"}}}
+
+	next := func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("foo"))
+	}
+
+	handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if got := recorder.Body.String(); got != "baz" {
+		t.Errorf("got body %q, want %q", got, "baz")
+	}
+}
@@ -0,0 +1,73 @@
+package subfilter
+
+import (
+	"bytes"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	caseLower = iota + 1
+	caseUpper
+	caseTitle
+)
+
+// casePattern returns the case of the letters of match: all lower, all
+// upper, or title case with only the first letter upper. It returns zero for
+// mixed cases and matches without letters. A single upper letter is title
+// case.
+func casePattern(match []byte) int {
+	upper, lower, letters := 0, 0, 0
+	firstUpper := false
+
+	for _, r := range string(match) {
+		switch {
+		case unicode.IsUpper(r):
+			firstUpper = firstUpper || letters == 0
+			upper++
+		case unicode.IsLower(r):
+			lower++
+		default:
+			continue
+		}
+
+		letters++
+	}
+
+	switch {
+	case letters == 0:
+		return 0
+	case upper == 0:
+		return caseLower
+	case firstUpper && upper == 1:
+		return caseTitle
+	case lower == 0:
+		return caseUpper
+	default:
+		return 0
+	}
+}
+
+// matchCase returns replacement in the case of match, or as it is when the
+// case of match is mixed.
+func matchCase(match, replacement []byte) []byte {
+	switch casePattern(match) {
+	case caseLower:
+		return bytes.ToLower(replacement)
+	case caseUpper:
+		return bytes.ToUpper(replacement)
+	case caseTitle:
+		out := bytes.ToLower(replacement)
+
+		for i, r := range string(out) {
+			if unicode.IsLetter(r) {
+				return append(append(append([]byte(nil), out[:i]...), string(unicode.ToUpper(r))...),
+					out[i+utf8.RuneLen(r):]...)
+			}
+		}
+
+		return out
+	default:
+		return replacement
+	}
+}
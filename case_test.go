@@ -0,0 +1,85 @@
+package subfilter
+
+import "testing"
+
+func TestPreserveCase(t *testing.T) {
+	tests := []struct {
+		desc        string
+		regex       string
+		replacement string
+		resBody     string
+		expResBody  string
+	}{
+		{
+			desc:        "should keep lower case matches lower",
+			regex:       `(?i)color`,
+			replacement: "Colour",
+			resBody:     "a color",
+			expResBody:  "a colour",
+		},
+		{
+			desc:        "should upper case the replacement of upper case matches",
+			regex:       `(?i)color`,
+			replacement: "colour",
+			resBody:     "COLOR!",
+			expResBody:  "COLOUR!",
+		},
+		{
+			desc:        "should title case the replacement of title case matches",
+			regex:       `(?i)color`,
+			replacement: "colour",
+			resBody:     "Color me",
+			expResBody:  "Colour me",
+		},
+		{
+			desc:        "should follow each match",
+			regex:       `(?i)color`,
+			replacement: "colour",
+			resBody:     "color Color COLOR",
+			expResBody:  "colour Colour COLOUR",
+		},
+		{
+			desc:        "should title case past leading punctuation",
+			regex:       `(?i)-color`,
+			replacement: "-colour",
+			resBody:     "x-Color",
+			expResBody:  "x-Colour",
+		},
+		{
+			desc:        "should leave replacements of mixed case matches alone",
+			regex:       `(?i)color`,
+			replacement: "colour",
+			resBody:     "CoLoR",
+			expResBody:  "colour",
+		},
+		{
+			desc:        "should apply to expanded groups",
+			regex:       `(?i)(gr)ey`,
+			replacement: "${1}ay",
+			resBody:     "Grey GREY",
+			expResBody:  "Gray GRAY",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			f := Filter{Regex: test.regex, Replacement: test.replacement, PreserveCase: true}
+			if got := serveTransform(t, f, test.resBody); got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+		})
+	}
+}
+
+func TestPreserveCaseConfig(t *testing.T) {
+	for i, f := range []Filter{
+		{Type: "range", Start: "a", End: "b", PreserveCase: true},
+		{Regex: "foo", Mask: "full", PreserveCase: true},
+		{Regex: "foo", Action: "deleteLine", PreserveCase: true},
+	} {
+		if _, err := compileFilters([]Filter{f}); err == nil {
+			t.Errorf("filter %d: expected error", i)
+		}
+	}
+}
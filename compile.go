@@ -0,0 +1,79 @@
+package subfilter
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FilterError reports why the filter at Index of a list could not be
+// compiled.
+type FilterError struct {
+	Index int
+	Err   error
+}
+
+func (e *FilterError) Error() string {
+	return fmt.Sprintf("filter %d: %v", e.Index, e.Err)
+}
+
+func (e *FilterError) Unwrap() error {
+	return e.Err
+}
+
+// FilterErrors lists the errors of every invalid filter of a list, in order.
+type FilterErrors []*FilterError
+
+func (e FilterErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+
+	return strings.Join(msgs, "; ")
+}
+
+// CompiledFilter is a filter ready to be applied.
+type CompiledFilter struct {
+	f filter
+}
+
+// Apply returns b with the filter applied, as it would be to a response body
+// outside of any request.
+func (c CompiledFilter) Apply(b []byte) []byte {
+	return c.f.apply(b, nil)
+}
+
+// CompileFilters compiles filters the way the middleware does, without
+// constructing it, so that configuration files can be linted. Unlike New, it
+// does not stop at the first invalid filter: the error, if any, is a
+// FilterErrors listing every one of them.
+func CompileFilters(filters []Filter) ([]CompiledFilter, error) {
+	compiled := make([]CompiledFilter, 0, len(filters))
+
+	var errs FilterErrors
+
+	for i, f := range filters {
+		c, err := compileFilter(i, f)
+		if err != nil {
+			errs = append(errs, &FilterError{Index: i, Err: err})
+
+			continue
+		}
+
+		compiled = append(compiled, CompiledFilter{f: c})
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	return compiled, nil
+}
+
+// ApplyCount is like Apply and also returns the number of matches the filter
+// acted upon.
+func (c CompiledFilter) ApplyCount(b []byte) ([]byte, int) {
+	sc := &scope{}
+
+	return c.f.apply(b, sc), sc.matches
+}
@@ -0,0 +1,72 @@
+package subfilter
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCompileFilters(t *testing.T) {
+	compiled, err := CompileFilters([]Filter{
+		{Regex: "foo", Replacement: "bar"},
+		{Type: "glob", Regex: "*.corp", Replacement: "example.com"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(compiled) != 2 {
+		t.Fatalf("got %d compiled filters, want 2", len(compiled))
+	}
+
+	b := []byte("foo at a.corp")
+	for _, c := range compiled {
+		b = c.Apply(b)
+	}
+
+	if got := string(b); got != "bar at example.com" {
+		t.Errorf("got %q, want %q", got, "bar at example.com")
+	}
+}
+
+func TestCompileFiltersErrors(t *testing.T) {
+	_, err := CompileFilters([]Filter{
+		{Regex: "foo", Replacement: "bar"},
+		{Regex: "(unclosed"},
+		{Regex: "ok"},
+		{Type: "wildcard", Regex: "*"},
+		{Regex: "x", Transforms: true, Replacement: "${nope:1}"},
+	})
+
+	var errs FilterErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("got error %v, want FilterErrors", err)
+	}
+
+	expected := []struct {
+		index int
+		msg   string
+	}{
+		{1, `error compiling regex "(unclosed"`},
+		{3, `unknown type "wildcard"`},
+		{4, `unknown transform "nope"`},
+	}
+
+	if len(errs) != len(expected) {
+		t.Fatalf("got %d errors (%v), want %d", len(errs), err, len(expected))
+	}
+
+	for i, exp := range expected {
+		if errs[i].Index != exp.index {
+			t.Errorf("got error %d for filter %d, want filter %d", i, errs[i].Index, exp.index)
+		}
+
+		if !strings.Contains(errs[i].Error(), exp.msg) {
+			t.Errorf("got error %q, want it to contain %q", errs[i].Error(), exp.msg)
+		}
+	}
+
+	if !strings.HasPrefix(errs[0].Error(), "filter 1: ") {
+		t.Errorf("got error %q, want it prefixed with the filter index", errs[0].Error())
+	}
+}
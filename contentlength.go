@@ -0,0 +1,76 @@
+package subfilter
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	lengthMismatchLog       = "log"
+	lengthMismatchTrustBody = "trust-body"
+	lengthMismatchFail      = "fail"
+
+	originalLengthHeader = "X-Original-Content-Length"
+)
+
+func (s *SubFilter) setupLengthMismatch(config *Config) error {
+	switch policy := strings.ToLower(config.OnContentLengthMismatch); policy {
+	case "":
+		s.onLengthMismatch = lengthMismatchLog
+	case lengthMismatchLog, lengthMismatchTrustBody, lengthMismatchFail:
+		s.onLengthMismatch = policy
+	default:
+		return fmt.Errorf("invalid onContentLengthMismatch %q: must be %q, %q or %q", config.OnContentLengthMismatch,
+			lengthMismatchLog, lengthMismatchTrustBody, lengthMismatchFail)
+	}
+
+	return nil
+}
+
+// checkContentLength compares the bytes buffered from the upstream, still
+// encoded as the declared length describes them, with its Content-Length.
+// It reports whether filtering may proceed; when it may not, the response has
+// already been sent. Responses to HEAD requests and 1xx, 204 and 304 ones
+// have no body, whatever length they declare.
+func (s *SubFilter) checkContentLength(rw *responseWriter, r *http.Request) bool {
+	declared := rw.Header().Get("Content-Length")
+	if declared == "" || hasNoBody(r, rw.statusCode()) {
+		return true
+	}
+
+	actual := int64(rw.buffer.Len())
+
+	expected, err := strconv.ParseInt(declared, 10, 64)
+	if err == nil && expected == actual {
+		return true
+	}
+
+	if s.onLengthMismatch != lengthMismatchTrustBody {
+		s.logResponse(logLevelWarn, r, "response to %s declares a Content-Length of %q bytes but has %d", r.URL.Path, declared, actual)
+	}
+
+	if s.onLengthMismatch == lengthMismatchFail {
+		writeStatus(rw, http.StatusBadGateway)
+
+		return false
+	}
+
+	return true
+}
+
+// hasNoBody reports whether the response with status to r has no body: that of
+// a HEAD request, or a 1xx, 204 or 304 one.
+func hasNoBody(r *http.Request, status int) bool {
+	return r.Method == http.MethodHead || status < http.StatusOK || status == http.StatusNoContent ||
+		status == http.StatusNotModified
+}
+
+// preserveOriginalLength copies the upstream Content-Length of h, if any, to
+// originalLengthHeader when configured to, before it is dropped or replaced.
+func (s *SubFilter) preserveOriginalLength(h http.Header) {
+	if v := h.Get("Content-Length"); s.keepOriginalLength && v != "" {
+		h.Set(originalLengthHeader, v)
+	}
+}
@@ -0,0 +1,238 @@
+package subfilter
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestOnContentLengthMismatch(t *testing.T) {
+	compressed := gzipString(t, "foo")
+
+	tests := []struct {
+		desc       string
+		policy     string
+		delta      int
+		expStatus  int
+		expResBody string
+	}{
+		{desc: "should filter matching lengths", policy: "fail", expStatus: http.StatusOK, expResBody: "bar"},
+		{desc: "should log and filter over-delivery by default", delta: -5, expStatus: http.StatusOK, expResBody: "bar"},
+		{desc: "should log and filter under-delivery", policy: "log", delta: 5, expStatus: http.StatusOK, expResBody: "bar"},
+		{desc: "should trust over-delivered bodies", policy: "trust-body", delta: -5, expStatus: http.StatusOK, expResBody: "bar"},
+		{desc: "should trust under-delivered bodies", policy: "trust-body", delta: 5, expStatus: http.StatusOK, expResBody: "bar"},
+		{desc: "should fail over-delivery", policy: "fail", delta: -5, expStatus: http.StatusBadGateway, expResBody: "Bad Gateway\n"},
+		{desc: "should fail under-delivery", policy: "fail", delta: 5, expStatus: http.StatusBadGateway, expResBody: "Bad Gateway\n"},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.Filters = []Filter{{Regex: "foo", Replacement: "bar"}}
+			config.OnContentLengthMismatch = test.policy
+
+			next := func(w http.ResponseWriter, _ *http.Request) {
+				// The declared length is that of the gzip stream, not of the
+				// decoded body.
+				w.Header().Set("Content-Encoding", "gzip")
+				w.Header().Set("Content-Length", strconv.Itoa(len(compressed)+test.delta))
+				_, _ = w.Write(compressed)
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+
+			if recorder.Code != test.expStatus {
+				t.Errorf("got status %d, want %d", recorder.Code, test.expStatus)
+			}
+
+			got := recorder.Body.String()
+			if recorder.Code == http.StatusOK {
+				got = gunzipString(t, recorder.Body.Bytes())
+			}
+
+			if got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+		})
+	}
+}
+
+func TestOnContentLengthMismatchWithoutBody(t *testing.T) {
+	tests := []struct {
+		desc   string
+		method string
+		status int
+	}{
+		{desc: "should accept the length of responses to HEAD requests", method: http.MethodHead, status: http.StatusOK},
+		{desc: "should accept the length of 204 responses", method: http.MethodGet, status: http.StatusNoContent},
+		{desc: "should accept the length of 304 responses", method: http.MethodGet, status: http.StatusNotModified},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			var logs bytes.Buffer
+
+			log.SetOutput(&logs)
+			defer log.SetOutput(os.Stderr)
+
+			config := CreateConfig()
+			config.Filters = []Filter{{Regex: "foo", Replacement: "bar"}}
+			config.OnContentLengthMismatch = "fail"
+
+			next := func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Length", "42")
+				w.WriteHeader(test.status)
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(test.method, "/", nil))
+
+			if recorder.Code != test.status {
+				t.Errorf("got status %d, want %d", recorder.Code, test.status)
+			}
+
+			if bytes.Contains(logs.Bytes(), []byte("Content-Length")) {
+				t.Errorf("got logs %q, want no length mismatch", logs.String())
+			}
+		})
+	}
+}
+
+func TestOnContentLengthMismatchInvalid(t *testing.T) {
+	config := CreateConfig()
+	config.OnContentLengthMismatch = "ignore"
+
+	if _, err := New(context.Background(), nil, config, "subfilter"); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestPreserveOriginalLengthHeader(t *testing.T) {
+	tests := []struct {
+		desc             string
+		preserve         bool
+		setLength        bool
+		stream           bool
+		declare          bool
+		expOriginal      string
+		expContentLength string
+	}{
+		{desc: "should not add the header by default", declare: true},
+		{desc: "should keep the original length", preserve: true, declare: true, expOriginal: "12"},
+		{
+			desc: "should keep the original length along with the new one", preserve: true, setLength: true,
+			declare: true, expOriginal: "12", expContentLength: "9",
+		},
+		{desc: "should keep the original length of streamed bodies", preserve: true, stream: true, declare: true, expOriginal: "12"},
+		{desc: "should not add the header without upstream length", preserve: true},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.Filters = []Filter{{Regex: "hello", Replacement: "hi"}}
+			config.PreserveOriginalLengthHeader = test.preserve
+			config.SetContentLength = test.setLength
+			config.Stream = test.stream
+
+			next := func(w http.ResponseWriter, _ *http.Request) {
+				if test.declare {
+					w.Header().Set("Content-Length", "12")
+				}
+
+				_, _ = w.Write([]byte("hello, world"))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if got := recorder.Body.String(); got != "hi, world" {
+				t.Errorf("got body %q, want %q", got, "hi, world")
+			}
+
+			if got := recorder.Header().Get("X-Original-Content-Length"); got != test.expOriginal {
+				t.Errorf("got X-Original-Content-Length %q, want %q", got, test.expOriginal)
+			}
+
+			if got := recorder.Header().Get("Content-Length"); got != test.expContentLength {
+				t.Errorf("got Content-Length %q, want %q", got, test.expContentLength)
+			}
+		})
+	}
+}
+
+func TestTransferEncodingOnOutput(t *testing.T) {
+	tests := []struct {
+		desc             string
+		setLength        bool
+		stream           bool
+		expTE            string
+		expContentLength string
+	}{
+		{desc: "should drop a chunked coding contradicting the new length", setLength: true, expContentLength: "9"},
+		{desc: "should keep the coding of buffered bodies without a length", expTE: "chunked"},
+		{desc: "should keep the coding of streamed bodies", stream: true, expTE: "chunked"},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.Filters = []Filter{{Regex: "hello", Replacement: "hi"}}
+			config.SetContentLength = test.setLength
+			config.Stream = test.stream
+
+			next := func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Transfer-Encoding", "chunked")
+				_, _ = w.Write([]byte("hello, world"))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if got := recorder.Body.String(); got != "hi, world" {
+				t.Errorf("got body %q, want %q", got, "hi, world")
+			}
+
+			if got := recorder.Header().Get("Transfer-Encoding"); got != test.expTE {
+				t.Errorf("got Transfer-Encoding %q, want %q", got, test.expTE)
+			}
+
+			if got := recorder.Header().Get("Content-Length"); got != test.expContentLength {
+				t.Errorf("got Content-Length %q, want %q", got, test.expContentLength)
+			}
+		})
+	}
+}
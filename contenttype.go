@@ -0,0 +1,78 @@
+package subfilter
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// contentTypeGroup holds the filters configured for one ContentTypeFilters
+// key.
+type contentTypeGroup struct {
+	pattern string
+	filters []filter
+}
+
+// compileContentTypeGroups compiles ContentTypeFilters, ordered from the most
+// specific key to the least specific one: exact media types, then "type/*"
+// wildcards, then "*/*". Keys of equal specificity are ordered alphabetically.
+func compileContentTypeGroups(groups map[string][]Filter) ([]contentTypeGroup, error) {
+	compiled := make([]contentTypeGroup, 0, len(groups))
+
+	for key, defs := range groups {
+		pattern := strings.ToLower(strings.TrimSpace(key))
+		if !strings.Contains(pattern, "/") && pattern != "*" {
+			return nil, fmt.Errorf("contentTypeFilters: invalid media type %q", key)
+		}
+
+		filters, err := compileFilters(defs)
+		if err != nil {
+			return nil, fmt.Errorf("contentTypeFilters %q: %w", key, err)
+		}
+
+		compiled = append(compiled, contentTypeGroup{pattern: pattern, filters: filters})
+	}
+
+	sort.Slice(compiled, func(i, j int) bool {
+		a, b := compiled[i], compiled[j]
+
+		if sa, sb := mediaTypeSpecificity(a.pattern), mediaTypeSpecificity(b.pattern); sa != sb {
+			return sa < sb
+		}
+
+		return a.pattern < b.pattern
+	})
+
+	return compiled, nil
+}
+
+// contentTypeFilters returns the filters of every group matching contentType.
+func contentTypeFilters(groups []contentTypeGroup, contentType string) []filter {
+	if len(groups) == 0 {
+		return nil
+	}
+
+	mt := mediaType(contentType)
+	if mt == "" {
+		return nil
+	}
+
+	var filters []filter
+
+	for _, g := range groups {
+		if mediaTypeMatches(g.pattern, mt) {
+			filters = append(filters, g.filters...)
+		}
+	}
+
+	return filters
+}
+
+func countContentTypeFilters(groups []contentTypeGroup) int {
+	n := 0
+	for _, g := range groups {
+		n += len(g.filters)
+	}
+
+	return n
+}
@@ -0,0 +1,105 @@
+package subfilter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestContentTypeFilters(t *testing.T) {
+	groups := map[string][]Filter{
+		"text/html":        {{Regex: "link", Replacement: "<a>"}},
+		"text/*":           {{Regex: "<a>|link", Replacement: "text link"}},
+		"application/json": {{Regex: "link", Replacement: `"url"`}},
+	}
+
+	tests := []struct {
+		desc        string
+		mode        string
+		contentType string
+		expResBody  string
+	}{
+		{
+			desc:        "should apply the HTML group after the top-level filters",
+			contentType: "text/html; charset=utf-8",
+			expResBody:  "new text link",
+		},
+		{
+			desc:        "should apply the JSON group",
+			contentType: "Application/JSON",
+			expResBody:  `new "url"`,
+		},
+		{
+			desc:        "should apply a wildcard group",
+			contentType: "text/css",
+			expResBody:  "new text link",
+		},
+		{
+			desc:        "should apply the exact group before the wildcard group",
+			mode:        "replace",
+			contentType: "text/html",
+			expResBody:  "old text link",
+		},
+		{
+			desc:        "should only apply the top-level filters without a matching group",
+			contentType: "image/png",
+			expResBody:  "new link",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.Filters = []Filter{{Regex: "old", Replacement: "new"}}
+			config.ContentTypeFilters = groups
+			config.ContentTypeFiltersMode = test.mode
+
+			next := func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", test.contentType)
+				_, _ = w.Write([]byte("old link"))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if got := recorder.Body.String(); got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+		})
+	}
+}
+
+func TestMediaTypeMatches(t *testing.T) {
+	tests := []struct {
+		pattern string
+		mt      string
+		exp     bool
+	}{
+		{pattern: "text/html", mt: "text/html", exp: true},
+		{pattern: " Text/HTML ", mt: "text/html", exp: true},
+		{pattern: "text/html", mt: "text/plain", exp: false},
+		{pattern: "text/*", mt: "text/css", exp: true},
+		{pattern: "text/*", mt: "application/text", exp: false},
+		{pattern: "*/*", mt: "image/png", exp: true},
+		{pattern: "text/*", mt: "text/html", exp: true},
+		{pattern: "*+xml", mt: "application/atom+xml", exp: true},
+		{pattern: "*+xml", mt: "image/svg+xml", exp: true},
+		{pattern: "*+xml", mt: "application/xml", exp: false},
+		{pattern: "application/*+json", mt: "application/problem+json", exp: true},
+		{pattern: "application/*+json", mt: "text/vnd.api+json", exp: false},
+		{pattern: "*/html", mt: "text/html", exp: true},
+	}
+
+	for _, test := range tests {
+		if got := mediaTypeMatches(test.pattern, test.mt); got != test.exp {
+			t.Errorf("mediaTypeMatches(%q, %q) = %v, want %v", test.pattern, test.mt, got, test.exp)
+		}
+	}
+}
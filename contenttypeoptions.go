@@ -0,0 +1,81 @@
+package subfilter
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	contentTypeOptionsKeep    = "keep"
+	contentTypeOptionsNosniff = "nosniff"
+	contentTypeOptionsRemove  = "remove"
+
+	// sniffLen is the number of leading bytes of a body browsers look at to
+	// sniff its type.
+	sniffLen = 512
+)
+
+func (s *SubFilter) setupContentTypeOptions(config *Config) error {
+	switch mode := strings.ToLower(config.ContentTypeOptions); mode {
+	case "", contentTypeOptionsKeep:
+	case contentTypeOptionsNosniff, contentTypeOptionsRemove:
+		s.contentTypeOptions = mode
+	default:
+		return fmt.Errorf("invalid contentTypeOptions %q: must be %q, %q or %q", config.ContentTypeOptions,
+			contentTypeOptionsKeep, contentTypeOptionsNosniff, contentTypeOptionsRemove)
+	}
+
+	s.nosniffLeading = config.NosniffOnLeadingChange
+
+	return nil
+}
+
+// fixContentTypeOptions adjusts the X-Content-Type-Options header of h as
+// configured by mode when its Content-Type is no longer the upstream one.
+func fixContentTypeOptions(h http.Header, mode, upstreamType string) {
+	if mode == "" || h.Get("Content-Type") == upstreamType {
+		return
+	}
+
+	if mode == contentTypeOptionsNosniff {
+		h.Set("X-Content-Type-Options", "nosniff")
+	} else {
+		h.Del("X-Content-Type-Options")
+	}
+}
+
+// guardSniffing sets X-Content-Type-Options: nosniff in h when filtering the
+// body original into b changed its start, up to the end of its first word or
+// tag name, which browsers sniff the type of a body from, so that the type
+// they settle on cannot change with it. Edits further in, such as a rewritten
+// hostname in <head>, leave the header alone: nosniff makes browsers refuse
+// scripts and stylesheets served with the wrong type.
+func (s *SubFilter) guardSniffing(h http.Header, original, b []byte) {
+	if !s.nosniffLeading {
+		return
+	}
+
+	if !bytes.Equal(leadingToken(original), leadingToken(b)) {
+		h.Set("X-Content-Type-Options", "nosniff")
+	}
+}
+
+// leadingToken returns the leading whitespace of b along with the word or tag
+// name following it, up to and including the whitespace or '>' ending it,
+// looking no further than the bytes browsers sniff.
+func leadingToken(b []byte) []byte {
+	if len(b) > sniffLen {
+		b = b[:sniffLen]
+	}
+
+	start := len(b) - len(bytes.TrimLeft(b, " \t\n\f\r"))
+
+	end := bytes.IndexAny(b[start:], " \t\n\f\r>")
+	if end < 0 {
+		return b
+	}
+
+	return b[:start+end+1]
+}
@@ -0,0 +1,202 @@
+package subfilter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestContentTypeOptions(t *testing.T) {
+	tests := []struct {
+		desc        string
+		mode        string
+		addHeaders  map[string]string
+		errorPage   bool
+		upstream    string
+		expOptions  string
+		expResType  string
+		expResBody  string
+		expNewError bool
+	}{
+		{
+			desc:       "should keep the upstream header by default",
+			addHeaders: map[string]string{"Content-Type": "text/plain"},
+			upstream:   "nosniff",
+			expOptions: "nosniff",
+			expResType: "text/plain",
+			expResBody: "bar",
+		},
+		{
+			desc:       "should set nosniff when the content type changes",
+			mode:       "nosniff",
+			addHeaders: map[string]string{"Content-Type": "text/plain"},
+			expOptions: "nosniff",
+			expResType: "text/plain",
+			expResBody: "bar",
+		},
+		{
+			desc:       "should remove the header when the content type changes",
+			mode:       "remove",
+			addHeaders: map[string]string{"Content-Type": "text/plain"},
+			upstream:   "nosniff",
+			expResType: "text/plain",
+			expResBody: "bar",
+		},
+		{
+			desc:       "should leave the header alone when the content type is kept",
+			mode:       "remove",
+			addHeaders: map[string]string{"X-Frame-Options": "DENY"},
+			upstream:   "nosniff",
+			expOptions: "nosniff",
+			expResType: "text/html",
+			expResBody: "bar",
+		},
+		{
+			desc:       "should set nosniff on error pages of another type",
+			mode:       "nosniff",
+			errorPage:  true,
+			expOptions: "nosniff",
+			expResType: "text/plain",
+			expResBody: "oops",
+		},
+		{
+			desc:        "should reject unknown modes",
+			mode:        "sniff",
+			expNewError: true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.Filters = []Filter{{Regex: "foo", Replacement: "bar"}}
+			config.ContentTypeOptions = test.mode
+			config.AddHeaders = test.addHeaders
+
+			status := http.StatusOK
+			if test.errorPage {
+				status = http.StatusInternalServerError
+				config.ErrorPage = &ErrorPage{StatusCodes: []string{"5xx"}, Body: "oops", ContentType: "text/plain"}
+			}
+
+			next := func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Type", "text/html")
+				if test.upstream != "" {
+					w.Header().Set("X-Content-Type-Options", test.upstream)
+				}
+				w.WriteHeader(status)
+				_, _ = w.Write([]byte("foo"))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if test.expNewError {
+				if err == nil {
+					t.Error("expected an error")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if got := recorder.Body.String(); got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+
+			if got := recorder.Header().Get("Content-Type"); got != test.expResType {
+				t.Errorf("got Content-Type %q, want %q", got, test.expResType)
+			}
+
+			if got := recorder.Header().Get("X-Content-Type-Options"); got != test.expOptions {
+				t.Errorf("got X-Content-Type-Options %q, want %q", got, test.expOptions)
+			}
+		})
+	}
+}
+
+func TestNosniffOnLeadingChange(t *testing.T) {
+	page := "\n<html><head><link href=\"https://old.example.com/app.css\"></head><body>" + strings.Repeat("x", sniffLen) +
+		"footer</body></html>"
+
+	tests := []struct {
+		desc       string
+		filter     Filter
+		enabled    bool
+		stream     bool
+		expOptions string
+	}{
+		{
+			desc:       "should set nosniff on a leading insert",
+			filter:     Filter{Regex: "<html>", Replacement: "<!-- banner -->", Action: "insertBefore"},
+			enabled:    true,
+			expOptions: "nosniff",
+		},
+		{
+			desc:       "should set nosniff on a leading insert of a streamed body",
+			filter:     Filter{Regex: "<html>", Replacement: "<!-- banner -->", Action: "insertBefore"},
+			enabled:    true,
+			stream:     true,
+			expOptions: "nosniff",
+		},
+		{
+			desc:    "should not set nosniff when the sniffed bytes are left alone",
+			filter:  Filter{Regex: "footer", Replacement: "<p>footer</p>", Action: "insertAfter"},
+			enabled: true,
+		},
+		{
+			desc:    "should not set nosniff on a replacement in the head",
+			filter:  Filter{Regex: "old.example.com", Replacement: "new.example.com"},
+			enabled: true,
+		},
+		{
+			desc:       "should set nosniff when the leading whitespace changes",
+			filter:     Filter{Regex: `^\s+`, Replacement: ""},
+			enabled:    true,
+			expOptions: "nosniff",
+		},
+		{
+			desc:    "should not set nosniff when nothing matches",
+			filter:  Filter{Regex: "<nav>", Replacement: "<!-- banner -->", Action: "insertBefore"},
+			enabled: true,
+		},
+		{
+			desc:   "should not set nosniff by default",
+			filter: Filter{Regex: "<html>", Replacement: "<!-- banner -->", Action: "insertBefore"},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.Filters = []Filter{test.filter}
+			config.NosniffOnLeadingChange = test.enabled
+			config.Stream = test.stream
+
+			next := func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Type", "text/html")
+				_, _ = w.Write([]byte(page))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if got := recorder.Header().Get("X-Content-Type-Options"); got != test.expOptions {
+				t.Errorf("got X-Content-Type-Options %q, want %q", got, test.expOptions)
+			}
+		})
+	}
+}
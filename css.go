@@ -0,0 +1,25 @@
+package subfilter
+
+import "regexp"
+
+// cssURLRegex matches a CSS url() token. The URL is captured by the group
+// matching its form: double-quoted, single-quoted or unquoted.
+var cssURLRegex = regexp.MustCompile(`(?i)\burl\(\s*(?:"([^"]*)"|'([^']*)'|([^"'()\s]*))\s*\)`)
+
+// expandCSSURL appends the url() token matched by m in src to dst, with the
+// URL it contains rewritten by inner. Quotes and whitespace are kept.
+func expandCSSURL(dst []byte, inner *filter, src []byte, m []int, sc *scope) []byte {
+	for g := 1; g <= 3; g++ {
+		start, end := m[2*g], m[2*g+1]
+		if start < 0 {
+			continue
+		}
+
+		dst = append(dst, src[m[0]:start]...)
+		dst = append(dst, inner.apply(src[start:end], sc)...)
+
+		return append(dst, src[end:m[1]]...)
+	}
+
+	return append(dst, src[m[0]:m[1]]...)
+}
@@ -0,0 +1,43 @@
+package subfilter
+
+import "testing"
+
+func TestCSSURLFilters(t *testing.T) {
+	tests := []struct {
+		desc       string
+		resBody    string
+		expResBody string
+	}{
+		{
+			desc:       "should rewrite an unquoted URL in a style block",
+			resBody:    `<style>.a { background: url(/old.png) } .b::after { content: "/old.png" }</style>`,
+			expResBody: `<style>.a { background: url(/new.png) } .b::after { content: "/old.png" }</style>`,
+		},
+		{
+			desc:       "should rewrite a quoted URL in a style attribute",
+			resBody:    `<div style="background-image: url( '/old.png' )"><img src="/old.png"></div>`,
+			expResBody: `<div style="background-image: url( '/new.png' )"><img src="/old.png"></div>`,
+		},
+		{
+			desc:       "should rewrite a double-quoted URL",
+			resBody:    `@import URL("/old.png");`,
+			expResBody: `@import URL("/new.png");`,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			got := serveTransform(t, Filter{Type: "css-url", Regex: `^/old\.png$`, Replacement: "/new.png"}, test.resBody)
+			if got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+		})
+	}
+}
+
+func TestCSSURLFilterConfig(t *testing.T) {
+	if _, err := compileFilters([]Filter{{Type: "css-url", Regex: "x", Every: 2}}); err == nil {
+		t.Error("expected an error for every")
+	}
+}
@@ -0,0 +1,109 @@
+package subfilter
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+// csvFilter applies a filter to one column of the data rows of a CSV body,
+// whose first record is the header.
+type csvFilter struct {
+	// column is the header of the column, or index its 0-based position when
+	// column is empty.
+	column string
+	index  int
+	comma  rune
+	inner  *filter
+}
+
+func compileCSV(f Filter, inner *filter) (*csvFilter, error) {
+	switch {
+	case f.Column == "" && f.ColumnIndex <= 0:
+		return nil, errors.New("csv filters require column or a positive columnIndex")
+	case f.Column != "" && f.ColumnIndex != 0:
+		return nil, errors.New("column and columnIndex are mutually exclusive")
+	}
+
+	cf := &csvFilter{column: f.Column, index: f.ColumnIndex - 1, comma: ',', inner: inner}
+
+	if f.Delimiter != "" {
+		r, size := utf8.DecodeRuneInString(f.Delimiter)
+		if size != len(f.Delimiter) || r == utf8.RuneError || r == '"' || r == '\r' || r == '\n' {
+			return nil, fmt.Errorf("invalid delimiter %q: must be a single character other than a quote or newline", f.Delimiter)
+		}
+
+		cf.comma = r
+	}
+
+	return cf, nil
+}
+
+// apply filters the field of the column in every data row of b. Bodies that
+// are not valid CSV, or have no such column, are left untouched, as are
+// bodies in which no field changed, so that their quoting is kept as is.
+func (cf *csvFilter) apply(b []byte, sc *scope) []byte {
+	r := csv.NewReader(bytes.NewReader(b))
+	r.Comma = cf.comma
+	r.FieldsPerRecord = -1
+
+	records, err := r.ReadAll()
+	if err != nil || len(records) < 2 {
+		return b
+	}
+
+	col := cf.index
+
+	if cf.column != "" {
+		col = -1
+
+		for i, name := range records[0] {
+			if name == cf.column {
+				col = i
+
+				break
+			}
+		}
+
+		if col < 0 {
+			return b
+		}
+	}
+
+	changed := false
+
+	for _, record := range records[1:] {
+		if col >= len(record) {
+			continue
+		}
+
+		field := []byte(record[col])
+		if filtered := cf.inner.apply(field, sc); !bytes.Equal(filtered, field) {
+			record[col] = string(filtered)
+			changed = true
+		}
+	}
+
+	if !changed {
+		return b
+	}
+
+	var out bytes.Buffer
+
+	w := csv.NewWriter(&out)
+	w.Comma = cf.comma
+	w.UseCRLF = bytes.Contains(b, []byte("\r\n"))
+
+	if err := w.WriteAll(records); err != nil {
+		return b
+	}
+
+	if !bytes.HasSuffix(b, []byte("\n")) {
+		// Keep the missing final line terminator missing.
+		return bytes.TrimRight(out.Bytes(), "\r\n")
+	}
+
+	return out.Bytes()
+}
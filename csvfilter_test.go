@@ -0,0 +1,88 @@
+package subfilter
+
+import (
+	"testing"
+)
+
+func TestCSVFilter(t *testing.T) {
+	tests := []struct {
+		desc       string
+		filter     Filter
+		resBody    string
+		expResBody string
+	}{
+		{
+			desc:       "should rewrite the named column only",
+			filter:     Filter{Type: "csv", Column: "email", Regex: `^[^@]+`, Replacement: "redacted"},
+			resBody:    "name,email,note\nAnn,ann@example.com,ann@example.com\n",
+			expResBody: "name,email,note\nAnn,redacted@example.com,ann@example.com\n",
+		},
+		{
+			desc:       "should handle quoted fields with commas and newlines",
+			filter:     Filter{Type: "csv", Column: "email", Regex: `.+`, Replacement: "x"},
+			resBody:    "name,email,address\n\"Doe, Jane\",jane@example.com,\"1 Main St,\nSpringfield\"\nBob,bob@example.com,-\n",
+			expResBody: "name,email,address\n\"Doe, Jane\",x,\"1 Main St,\nSpringfield\"\nBob,x,-\n",
+		},
+		{
+			desc:       "should quote replacements where required",
+			filter:     Filter{Type: "csv", ColumnIndex: 2, Regex: `.+`, Replacement: `a, "b"`},
+			resBody:    "id,value\n1,foo\n",
+			expResBody: "id,value\n1,\"a, \"\"b\"\"\"\n",
+		},
+		{
+			desc:       "should use the delimiter",
+			filter:     Filter{Type: "csv", Column: "email", Delimiter: "\t", Regex: `.+`, Replacement: "x,y"},
+			resBody:    "name\temail\r\nAnn\tann@example.com\r\n",
+			expResBody: "name\temail\r\nAnn\tx,y\r\n",
+		},
+		{
+			desc:       "should skip files without the column",
+			filter:     Filter{Type: "csv", Column: "email", Regex: `.+`, Replacement: "x"},
+			resBody:    "name,mail\nAnn,ann@example.com\n",
+			expResBody: "name,mail\nAnn,ann@example.com\n",
+		},
+		{
+			desc:       "should skip bodies that are not CSV",
+			filter:     Filter{Type: "csv", ColumnIndex: 1, Regex: `.+`, Replacement: "x"},
+			resBody:    "a,b\n\"unterminated,c\n",
+			expResBody: "a,b\n\"unterminated,c\n",
+		},
+		{
+			desc:       "should keep a missing final newline missing",
+			filter:     Filter{Type: "csv", ColumnIndex: 1, Regex: `foo`, Replacement: "bar"},
+			resBody:    "a\nfoo",
+			expResBody: "a\nbar",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			if got := serveTransform(t, test.filter, test.resBody); got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+		})
+	}
+}
+
+func TestCSVFilterConfig(t *testing.T) {
+	tests := []struct {
+		desc   string
+		filter Filter
+	}{
+		{desc: "should require a column", filter: Filter{Type: "csv", Regex: "foo"}},
+		{desc: "should reject both column and columnIndex", filter: Filter{Type: "csv", Column: "a", ColumnIndex: 1, Regex: "foo"}},
+		{desc: "should reject long delimiters", filter: Filter{Type: "csv", Column: "a", Delimiter: "::", Regex: "foo"}},
+		{desc: "should reject quote delimiters", filter: Filter{Type: "csv", Column: "a", Delimiter: `"`, Regex: "foo"}},
+		{desc: "should reject deleteLine", filter: Filter{Type: "csv", Column: "a", Regex: "foo", Action: "deleteLine"}},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			if _, err := compileFilters([]Filter{test.filter}); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package subfilter
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"regexp"
+)
+
+// defaultMaxDataURISize is the largest decoded payload data-uri filters
+// rewrite by default.
+const defaultMaxDataURISize = 1 << 20
+
+// dataURIRegex matches a base64 data URI, capturing its payload.
+var dataURIRegex = regexp.MustCompile(`(?i:\bdata:[a-z0-9.+-]*/?[a-z0-9.+-]*(?:;[a-z0-9.+-]+=[^;,\s"'()]*)*;base64,)` +
+	`([A-Za-z0-9+/]+={0,2})`)
+
+// dataURIFilter applies its inner filter to the decoded payloads of base64
+// data URIs.
+type dataURIFilter struct {
+	inner *filter
+	// maxSize is the largest decoded payload rewritten; larger ones are kept.
+	maxSize int
+}
+
+func compileDataURI(f Filter, inner *filter) (*dataURIFilter, error) {
+	if f.MaxDataURISize < 0 {
+		return nil, fmt.Errorf("invalid maxDataURISize %d", f.MaxDataURISize)
+	}
+
+	du := &dataURIFilter{inner: inner, maxSize: f.MaxDataURISize}
+	if du.maxSize == 0 {
+		du.maxSize = defaultMaxDataURISize
+	}
+
+	return du, nil
+}
+
+// expand appends the data URI matched by m in src to dst, with its payload,
+// captured by the first group, decoded, rewritten by the inner filter and
+// encoded again. Payloads that are too large, are not valid base64 or are
+// left unchanged keep their original encoding.
+func (du *dataURIFilter) expand(dst, src []byte, m []int, sc *scope) []byte {
+	start, end := m[2], m[3]
+	payload := src[start:end]
+
+	if base64.StdEncoding.DecodedLen(len(payload)) > du.maxSize {
+		return append(dst, src[m[0]:m[1]]...)
+	}
+
+	decoded := make([]byte, base64.StdEncoding.DecodedLen(len(payload)))
+
+	n, err := base64.StdEncoding.Decode(decoded, payload)
+	if err != nil {
+		return append(dst, src[m[0]:m[1]]...)
+	}
+
+	filtered := du.inner.apply(decoded[:n], sc)
+	if bytes.Equal(filtered, decoded[:n]) {
+		return append(dst, src[m[0]:m[1]]...)
+	}
+
+	encoded := make([]byte, base64.StdEncoding.EncodedLen(len(filtered)))
+	base64.StdEncoding.Encode(encoded, filtered)
+
+	dst = append(dst, src[m[0]:start]...)
+	dst = append(dst, encoded...)
+
+	return append(dst, src[end:m[1]]...)
+}
@@ -0,0 +1,89 @@
+package subfilter
+
+import (
+	"encoding/base64"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestDataURIFilters(t *testing.T) {
+	svg := `<svg xmlns="http://www.w3.org/2000/svg"><image href="http://old.example.com/a.png"/></svg>`
+	encode := func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }
+
+	tests := []struct {
+		desc       string
+		maxSize    int
+		resBody    string
+		expResBody string
+	}{
+		{
+			desc:       "should rewrite the payload of an image data URI",
+			resBody:    `<img src="data:image/svg+xml;base64,` + encode(svg) + `">`,
+			expResBody: `<img src="data:image/svg+xml;base64,` + encode(strings.Replace(svg, "old", "new", 1)) + `">`,
+		},
+		{
+			desc:       "should keep media type parameters",
+			resBody:    `url(data:image/svg+xml;charset=utf-8;base64,` + encode(svg) + `)`,
+			expResBody: `url(data:image/svg+xml;charset=utf-8;base64,` + encode(strings.Replace(svg, "old", "new", 1)) + `)`,
+		},
+		{
+			desc:       "should leave payloads without match alone",
+			resBody:    `data:text/plain;base64,` + encode("nothing"),
+			expResBody: `data:text/plain;base64,` + encode("nothing"),
+		},
+		{
+			desc:       "should leave the text outside data URIs alone",
+			resBody:    `old.example.com data:text/plain;base64,` + encode("old"),
+			expResBody: `old.example.com data:text/plain;base64,` + encode("new"),
+		},
+		{
+			desc:       "should leave data URIs that are not base64 alone",
+			resBody:    `data:text/plain,old`,
+			expResBody: `data:text/plain,old`,
+		},
+		{
+			desc:       "should leave invalid base64 alone",
+			resBody:    `data:text/plain;base64,b2xk=A`,
+			expResBody: `data:text/plain;base64,b2xk=A`,
+		},
+		{
+			desc:       "should leave payloads over the size limit alone",
+			maxSize:    16,
+			resBody:    `data:image/svg+xml;base64,` + encode(svg),
+			expResBody: `data:image/svg+xml;base64,` + encode(svg),
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			f := Filter{Type: "data-uri", Regex: "old", Replacement: "new", MaxDataURISize: test.maxSize}
+			got := serveTransform(t, f, test.resBody)
+			if got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+
+			if got == test.resBody {
+				return
+			}
+
+			for _, m := range regexp.MustCompile(`;base64,([^"')\s]*)`).FindAllStringSubmatch(got, -1) {
+				if _, err := base64.StdEncoding.DecodeString(m[1]); err != nil {
+					t.Errorf("got invalid base64 payload %q: %v", m[1], err)
+				}
+			}
+		})
+	}
+}
+
+func TestDataURIFiltersConfig(t *testing.T) {
+	for i, f := range []Filter{
+		{Type: "data-uri", Regex: "old", MaxDataURISize: -1},
+		{Regex: "old", MaxDataURISize: 10},
+	} {
+		if _, err := compileFilters([]Filter{f}); err == nil {
+			t.Errorf("filter %d: expected error", i)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package subfilter
+
+import "crypto/sha256"
+
+// insert appends the replacement for the match m of src to dst, as inserted
+// by insertBefore and insertAfter filters. When the scope dedupes inserts,
+// a replacement identical to one already inserted in the body is dropped.
+func (f *filter) insert(dst, src []byte, m []int, sc *scope) []byte {
+	if sc == nil || sc.inserted == nil {
+		return f.replace(dst, src, m, sc)
+	}
+
+	start := len(dst)
+	dst = f.replace(dst, src, m, sc)
+
+	sum := sha256.Sum256(dst[start:])
+	if sc.inserted[sum] {
+		return dst[:start]
+	}
+
+	sc.inserted[sum] = true
+
+	return dst
+}
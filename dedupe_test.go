@@ -0,0 +1,86 @@
+package subfilter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDedupeInserts(t *testing.T) {
+	const script = `<script src="/analytics.js"></script>`
+
+	tests := []struct {
+		desc       string
+		dedupe     bool
+		cascade    bool
+		filters    []Filter
+		expResBody string
+	}{
+		{
+			desc:    "should insert identical content once",
+			dedupe:  true,
+			cascade: true,
+			filters: []Filter{
+				{Regex: "<head>", Replacement: script, Action: "insertAfter"},
+				{Regex: "</head>", Replacement: script, Action: "insertBefore"},
+			},
+			expResBody: "<head>" + script + "<title>x</title></head>",
+		},
+		{
+			desc:    "should insert identical content once without cascading",
+			dedupe:  true,
+			cascade: false,
+			filters: []Filter{
+				{Regex: "<head>", Replacement: script, Action: "insertAfter"},
+				{Regex: "</head>", Replacement: script, Action: "insertBefore"},
+			},
+			expResBody: "<head>" + script + "<title>x</title></head>",
+		},
+		{
+			desc:    "should insert different content",
+			dedupe:  true,
+			cascade: true,
+			filters: []Filter{
+				{Regex: "<head>", Replacement: script, Action: "insertAfter"},
+				{Regex: "</head>", Replacement: "<style></style>", Action: "insertBefore"},
+			},
+			expResBody: "<head>" + script + "<title>x</title><style></style></head>",
+		},
+		{
+			desc:    "should insert duplicates unless enabled",
+			cascade: true,
+			filters: []Filter{
+				{Regex: "<head>", Replacement: script, Action: "insertAfter"},
+				{Regex: "</head>", Replacement: script, Action: "insertBefore"},
+			},
+			expResBody: "<head>" + script + "<title>x</title>" + script + "</head>",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.Filters = test.filters
+			config.DedupeInserts = test.dedupe
+			config.IndependentFilters = !test.cascade
+
+			next := func(w http.ResponseWriter, _ *http.Request) {
+				_, _ = w.Write([]byte("<head><title>x</title></head>"))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if got := recorder.Body.String(); got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package subfilter
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	digestModeStrip     = "strip"
+	digestModeRecompute = "recompute"
+)
+
+func (s *SubFilter) setupDigest(config *Config) error {
+	mode := strings.ToLower(config.DigestMode)
+
+	switch mode {
+	case "", digestModeStrip, digestModeRecompute:
+		s.digestMode = mode
+	default:
+		return fmt.Errorf("invalid digestMode %q: must be %q or %q", config.DigestMode, digestModeStrip, digestModeRecompute)
+	}
+
+	switch algorithm := strings.ToLower(config.EmitContentDigest); algorithm {
+	case "", "sha-256", "sha-512":
+		s.contentDigest = algorithm
+	default:
+		return fmt.Errorf("invalid emitContentDigest %q: must be sha-256 or sha-512", config.EmitContentDigest)
+	}
+
+	if config.EmitReprDigest && s.contentDigest == "" {
+		return errors.New("emitReprDigest requires emitContentDigest")
+	}
+
+	s.reprDigest = config.EmitReprDigest
+
+	return nil
+}
+
+// emitContentDigest sets the RFC 9530 Content-Digest header of a rewritten
+// response to the digest of its final body b, as sent, and Repr-Digest if
+// enabled to the digest of repr, the decoded representation, replacing the
+// upstream values.
+func (s *SubFilter) emitContentDigest(h http.Header, b, repr []byte) {
+	if s.contentDigest == "" {
+		return
+	}
+
+	h.Set("Content-Digest", s.contentDigest+"=:"+base64.StdEncoding.EncodeToString(digestSum(s.contentDigest, b))+":")
+
+	if s.reprDigest {
+		h.Set("Repr-Digest", s.contentDigest+"=:"+base64.StdEncoding.EncodeToString(digestSum(s.contentDigest, repr))+":")
+	}
+}
+
+// digestSum returns the sha-256 or sha-512 digest of b.
+func digestSum(algorithm string, b []byte) []byte {
+	if algorithm == "sha-512" {
+		d := sha512.Sum512(b)
+
+		return d[:]
+	}
+
+	d := sha256.Sum256(b)
+
+	return d[:]
+}
+
+// updateDigest brings an upstream RFC 3230 Digest header in line with the
+// rewritten body b. Recomputing uses SHA-512 when the upstream offered it and
+// SHA-256 otherwise.
+func (s *SubFilter) updateDigest(h http.Header, b []byte) {
+	if s.digestMode == "" || h.Get("Digest") == "" {
+		return
+	}
+
+	if s.digestMode == digestModeStrip {
+		h.Del("Digest")
+
+		return
+	}
+
+	algorithm := "sha-256"
+
+	for _, v := range h.Values("Digest") {
+		for _, d := range strings.Split(v, ",") {
+			if strings.HasPrefix(strings.ToLower(strings.TrimSpace(d)), "sha-512=") {
+				algorithm = "sha-512"
+			}
+		}
+	}
+
+	h.Set("Digest", algorithm+"="+base64.StdEncoding.EncodeToString(digestSum(algorithm, b)))
+}
+
+// updateReencodedDigest brings the upstream digests of a body left alone but
+// sent with other bytes than the upstream ones, decoded for a client refusing
+// gzip for instance, in line with b, those bytes, and repr, the decoded
+// body. They are recomputed when configured to, and dropped otherwise, as
+// they no longer hold.
+func (s *SubFilter) updateReencodedDigest(h http.Header, b, repr []byte) {
+	if s.digestMode == digestModeRecompute {
+		s.updateDigest(h, b)
+	} else {
+		h.Del("Digest")
+	}
+
+	if s.contentDigest == "" {
+		h.Del("Content-Digest")
+		h.Del("Repr-Digest")
+
+		return
+	}
+
+	s.emitContentDigest(h, b, repr)
+
+	if !s.reprDigest {
+		h.Del("Repr-Digest")
+	}
+}
@@ -0,0 +1,255 @@
+package subfilter
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDigestMode(t *testing.T) {
+	sum := sha256.Sum256([]byte("bar is the new bar"))
+	recomputed := "sha-256=" + base64.StdEncoding.EncodeToString(sum[:])
+
+	tests := []struct {
+		desc      string
+		mode      string
+		gzip      bool
+		identity  bool
+		resBody   string
+		expDigest string
+	}{
+		{
+			desc:      "should keep the digest by default",
+			resBody:   "foo is the new bar",
+			expDigest: "sha-256=upstream",
+		},
+		{
+			desc:      "should strip the digest of a modified body",
+			mode:      "strip",
+			resBody:   "foo is the new bar",
+			expDigest: "",
+		},
+		{
+			desc:      "should recompute the digest of a modified body",
+			mode:      "recompute",
+			resBody:   "foo is the new bar",
+			expDigest: recomputed,
+		},
+		{
+			desc:      "should not touch the digest of an unmodified body",
+			mode:      "strip",
+			resBody:   "nothing to see here",
+			expDigest: "sha-256=upstream",
+		},
+		{
+			desc:      "should send an unmodified gzip body as is along with its digest",
+			gzip:      true,
+			resBody:   strings.Repeat("nothing to see here ", 100),
+			expDigest: "sha-256=upstream",
+		},
+		{
+			desc:     "should drop the digest of an unmodified gzip body sent decoded",
+			gzip:     true,
+			identity: true,
+			resBody:  strings.Repeat("nothing to see here ", 100),
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.DigestMode = test.mode
+			config.Filters = []Filter{{Regex: "foo", Replacement: "bar"}}
+
+			body := []byte(test.resBody)
+			if test.gzip {
+				// Compressed unlike the middleware would, for a re-encoding to
+				// change the bytes.
+				var buf bytes.Buffer
+
+				zw, _ := gzip.NewWriterLevel(&buf, gzip.HuffmanOnly)
+				_, _ = zw.Write(body)
+				_ = zw.Close()
+				body = buf.Bytes()
+			}
+
+			next := func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Digest", "sha-256=upstream")
+
+				if test.gzip {
+					w.Header().Set("Content-Encoding", "gzip")
+				}
+
+				_, _ = w.Write(body)
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if test.identity {
+				req.Header.Set("Accept-Encoding", "identity")
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+
+			if got := recorder.Header().Get("Digest"); got != test.expDigest {
+				t.Errorf("got digest %q, want %q", got, test.expDigest)
+			}
+
+			if test.gzip && !test.identity && !bytes.Equal(recorder.Body.Bytes(), body) {
+				t.Errorf("got body %x, want the upstream bytes %x", recorder.Body.Bytes(), body)
+			}
+		})
+	}
+}
+
+func TestDigestModeInvalid(t *testing.T) {
+	config := CreateConfig()
+	config.DigestMode = "md5"
+	config.Filters = []Filter{{Regex: "foo", Replacement: "bar"}}
+
+	if _, err := New(context.Background(), nil, config, "subfilter"); err == nil {
+		t.Fatal("expected error on invalid digest mode")
+	}
+
+	config.DigestMode = ""
+	config.EmitContentDigest = "md5"
+
+	if _, err := New(context.Background(), nil, config, "subfilter"); err == nil {
+		t.Fatal("expected error on invalid content digest algorithm")
+	}
+
+	config.EmitContentDigest = ""
+	config.EmitReprDigest = true
+
+	if _, err := New(context.Background(), nil, config, "subfilter"); err == nil {
+		t.Fatal("expected error on emitReprDigest without emitContentDigest")
+	}
+}
+
+func TestEmitContentDigest(t *testing.T) {
+	digest := func(s string) string {
+		sum := sha256.Sum256([]byte(s))
+
+		return "sha-256=:" + base64.StdEncoding.EncodeToString(sum[:]) + ":"
+	}
+
+	tests := []struct {
+		desc      string
+		algorithm string
+		repr      bool
+		gzip      bool
+		identity  bool
+		resBody   string
+		expDigest string
+		expRepr   string
+	}{
+		{
+			desc:      "should emit the sha-256 digest of a modified identity body",
+			algorithm: "sha-256",
+			resBody:   "foo is the new bar",
+			expDigest: "sha-256=:dUvdFdgDya88dtBtIy10lXW2gEd0H95qPqVa7U8TGZQ=:",
+		},
+		{
+			desc:      "should emit the sha-512 digest and Repr-Digest",
+			algorithm: "SHA-512",
+			repr:      true,
+			resBody:   "foo is the new bar",
+			expDigest: "sha-512=:SrA5Xnd0flqPj5pCyWJhWkpBarzb4ONse7P2Rln4lSw0lRZFuQB33xJOzmEuqUY17KSTJS7tUSatjW95BGgVOw==:",
+			expRepr:   "sha-512=:SrA5Xnd0flqPj5pCyWJhWkpBarzb4ONse7P2Rln4lSw0lRZFuQB33xJOzmEuqUY17KSTJS7tUSatjW95BGgVOw==:",
+		},
+		{
+			desc:      "should digest the re-encoded gzip body",
+			algorithm: "sha-256",
+			gzip:      true,
+			resBody:   "foo is the new bar",
+		},
+		{
+			desc:      "should digest the decoded representation of a gzip body",
+			algorithm: "sha-256",
+			repr:      true,
+			gzip:      true,
+			resBody:   "foo is the new bar",
+			expRepr:   digest("bar is the new bar"),
+		},
+		{
+			desc:      "should recompute the digest of an unmodified gzip body sent decoded",
+			algorithm: "sha-256",
+			gzip:      true,
+			identity:  true,
+			resBody:   "nothing to see here",
+			expDigest: digest("nothing to see here"),
+		},
+		{
+			desc:      "should leave the upstream digest of an unmodified body",
+			algorithm: "sha-256",
+			resBody:   "nothing to see here",
+			expDigest: "sha-256=:upstream:",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.EmitContentDigest = test.algorithm
+			config.EmitReprDigest = test.repr
+			config.Filters = []Filter{{Regex: "foo", Replacement: "bar"}}
+
+			next := func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Digest", "sha-256=:upstream:")
+
+				if test.gzip {
+					w.Header().Set("Content-Encoding", "gzip")
+					_, _ = w.Write(gzipString(t, test.resBody))
+
+					return
+				}
+
+				_, _ = w.Write([]byte(test.resBody))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if test.identity {
+				req.Header.Set("Accept-Encoding", "identity")
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+
+			expDigest := test.expDigest
+			if test.gzip && !test.identity {
+				if got := gunzipString(t, recorder.Body.Bytes()); got != "bar is the new bar" {
+					t.Fatalf("got body %q, want %q", got, "bar is the new bar")
+				}
+
+				sum := sha256.Sum256(recorder.Body.Bytes())
+				expDigest = "sha-256=:" + base64.StdEncoding.EncodeToString(sum[:]) + ":"
+			}
+
+			if got := recorder.Header().Get("Content-Digest"); got != expDigest {
+				t.Errorf("got Content-Digest %q, want %q", got, expDigest)
+			}
+
+			if got := recorder.Header().Get("Repr-Digest"); got != test.expRepr {
+				t.Errorf("got Repr-Digest %q, want %q", got, test.expRepr)
+			}
+		})
+	}
+}
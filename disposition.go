@@ -0,0 +1,24 @@
+package subfilter
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// isAttachment reports whether the Content-Disposition header of h marks the
+// response as a download. Unparsable values are checked by their first token,
+// so a malformed filename parameter does not get a download filtered.
+func isAttachment(h http.Header) bool {
+	v := h.Get("Content-Disposition")
+	if v == "" {
+		return false
+	}
+
+	disposition, _, err := mime.ParseMediaType(v)
+	if err != nil {
+		disposition = strings.TrimSpace(strings.SplitN(v, ";", 2)[0])
+	}
+
+	return strings.EqualFold(disposition, "attachment")
+}
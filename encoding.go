@@ -0,0 +1,453 @@
+package subfilter
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+const (
+	unknownEncodingSkip     = "skip"
+	unknownEncodingWarn     = "warn"
+	unknownEncodingIdentity = "identity"
+)
+
+// errDecodedTooLarge reports a gzip body decoding to more bytes than allowed.
+var errDecodedTooLarge = errors.New("decoded body too large")
+
+// defaultReadBufferSize matches the buffer io.Copy uses.
+const defaultReadBufferSize = 32 << 10
+
+// maxWarnedEncodings bounds the number of distinct unknown encodings
+// remembered for onUnknownEncoding = "warn".
+const maxWarnedEncodings = 64
+
+// knownEncodings are registered content codings that cannot be decoded. They
+// are always passed through, whatever onUnknownEncoding says.
+var knownEncodings = map[string]bool{
+	"br":           true,
+	"compress":     true,
+	"deflate":      true,
+	"exi":          true,
+	"pack200-gzip": true,
+	"x-compress":   true,
+	"zstd":         true,
+}
+
+// warnOnce remembers which values were already logged. Once full it starts
+// over, so memory stays bounded at the cost of the odd repeated line.
+type warnOnce struct {
+	mu   sync.Mutex
+	seen map[string]bool
+}
+
+func (w *warnOnce) first(v string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.seen[v] {
+		return false
+	}
+
+	if w.seen == nil || len(w.seen) >= maxWarnedEncodings {
+		w.seen = make(map[string]bool)
+	}
+
+	w.seen[v] = true
+
+	return true
+}
+
+func (s *SubFilter) setupEncoding(config *Config) error {
+	s.readBufferSize = config.ReadBufferSize
+	if s.readBufferSize <= 0 {
+		s.readBufferSize = defaultReadBufferSize
+	}
+
+	for _, ce := range config.PassthroughEncodings {
+		if s.passthroughEncodings == nil {
+			s.passthroughEncodings = make(map[string]bool)
+		}
+
+		s.passthroughEncodings[strings.ToLower(strings.TrimSpace(ce))] = true
+	}
+
+	switch mode := strings.ToLower(config.OnUnknownEncoding); mode {
+	case "", unknownEncodingSkip:
+		s.onUnknownEncoding = unknownEncodingSkip
+	case unknownEncodingWarn, unknownEncodingIdentity:
+		s.onUnknownEncoding = mode
+	default:
+		return fmt.Errorf("invalid onUnknownEncoding %q: must be %q, %q or %q",
+			config.OnUnknownEncoding, unknownEncodingSkip, unknownEncodingWarn, unknownEncodingIdentity)
+	}
+
+	return nil
+}
+
+// acceptEncoding reports whether a response with the Content-Encoding of h can
+// be filtered: one with no coding but identity, or a single one that is gzip
+// or one onUnknownEncoding lets through. Lists of several codings are passed
+// through, as only one layer of gzip is decoded.
+func (s *SubFilter) acceptEncoding(h http.Header, r *http.Request) bool {
+	codings := contentCodings(h)
+
+	for _, coding := range codings {
+		if s.passthroughEncodings[coding] {
+			return false
+		}
+	}
+
+	switch {
+	case len(codings) == 0:
+		return true
+	case len(codings) > 1:
+		return false
+	case codings[0] == contentEncodingGzip || codings[0] == "x-gzip":
+		return true
+	case knownEncodings[codings[0]]:
+		return false
+	}
+
+	ce := strings.TrimSpace(h.Get("Content-Encoding"))
+
+	switch s.onUnknownEncoding {
+	case unknownEncodingIdentity:
+		return true
+	case unknownEncodingWarn:
+		if s.warnedEncodings.first(ce) {
+			s.logResponse(logLevelWarn, r, "not filtering %s: unknown Content-Encoding %q", r.URL.Path, ce)
+		}
+	}
+
+	return false
+}
+
+// acceptsGzip reports whether the Accept-Encoding header of r allows a gzip
+// response. Requests without the header accept any coding.
+func acceptsGzip(r *http.Request) bool {
+	values := r.Header.Values("Accept-Encoding")
+	if len(values) == 0 {
+		return true
+	}
+
+	gzipQ, anyQ := -1.0, -1.0
+
+	for _, v := range values {
+		for _, part := range strings.Split(v, ",") {
+			fields := strings.Split(part, ";")
+			q := 1.0
+
+			for _, param := range fields[1:] {
+				param = strings.TrimSpace(param)
+				if len(param) < 2 || !strings.EqualFold(param[:2], "q=") {
+					continue
+				}
+
+				var err error
+				if q, err = strconv.ParseFloat(param[2:], 64); err != nil {
+					q = 0
+				}
+			}
+
+			switch coding := strings.ToLower(strings.TrimSpace(fields[0])); coding {
+			case contentEncodingGzip, "x-gzip":
+				gzipQ = q
+			case "*":
+				anyQ = q
+			}
+		}
+	}
+
+	if gzipQ >= 0 {
+		return gzipQ > 0
+	}
+
+	return anyQ > 0
+}
+
+// gzipMagic starts every gzip stream.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// gzipMediaTypes are the media types of gzip files, whose magic bytes are
+// their payload rather than a sign of their encoding.
+var gzipMediaTypes = []string{"application/gzip", "application/x-gzip"}
+
+// sniffsEncoding reports whether the encoding of the body described by h is
+// to be told from its first bytes.
+func (s *SubFilter) sniffsEncoding(h http.Header) bool {
+	return s.sniffEncoding && !matchMediaType(gzipMediaTypes, h.Get("Content-Type"))
+}
+
+// gzipLayers returns how many layers of gzip the response body described by h
+// is wrapped in: one for Content-Encoding: gzip and one for a gzip entry in
+// Transfer-Encoding, which some servers use in its place.
+func gzipLayers(h http.Header) int {
+	n := 0
+
+	if codings := contentCodings(h); len(codings) == 1 && (codings[0] == contentEncodingGzip || codings[0] == "x-gzip") {
+		n++
+	}
+
+	for _, coding := range transferCodings(h) {
+		if coding == contentEncodingGzip || coding == "x-gzip" {
+			n++
+		}
+	}
+
+	return n
+}
+
+// contentCodings returns the lowercased codings listed in the Content-Encoding
+// header of h, but for identity, which stands for none.
+func contentCodings(h http.Header) []string {
+	var codings []string
+
+	for _, v := range h.Values("Content-Encoding") {
+		for _, coding := range strings.Split(v, ",") {
+			if coding = strings.ToLower(strings.TrimSpace(coding)); coding != "" && coding != "identity" {
+				codings = append(codings, coding)
+			}
+		}
+	}
+
+	return codings
+}
+
+// transferCodings returns the lowercased codings listed in the Transfer-Encoding
+// header of h.
+func transferCodings(h http.Header) []string {
+	var codings []string
+
+	for _, v := range h.Values("Transfer-Encoding") {
+		for _, coding := range strings.Split(v, ",") {
+			if coding = strings.ToLower(strings.TrimSpace(coding)); coding != "" {
+				codings = append(codings, coding)
+			}
+		}
+	}
+
+	return codings
+}
+
+// supportedTransferCodings reports whether every coding in the Transfer-Encoding
+// header of h can be undone before filtering.
+func supportedTransferCodings(h http.Header) bool {
+	for _, coding := range transferCodings(h) {
+		switch coding {
+		case "chunked", "identity", contentEncodingGzip, "x-gzip":
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// gzipStream is a decoded gzip body.
+type gzipStream struct {
+	decoded []byte
+	// trailing holds the bytes found after the gzip stream.
+	trailing []byte
+	// header is the header of its first member.
+	header gzip.Header
+}
+
+// gzipDecodeLayers strips up to layers gzip layers from b. A layer is only
+// decoded while b still looks like a gzip stream, so a body that net/http
+// already decoded without dropping the header is not decoded twice. The
+// trailing bytes and header returned are those of the outermost layer.
+func gzipDecodeLayers(b []byte, layers, bufSize int) (gzipStream, error) {
+	outer := gzipStream{decoded: b}
+
+	for i := 0; i < layers && bytes.HasPrefix(b, gzipMagic); i++ {
+		stream, err := gzipDecodeStream(b, bufSize)
+		if err != nil {
+			return gzipStream{}, err
+		}
+
+		if i == 0 {
+			outer = stream
+		}
+
+		b = stream.decoded
+	}
+
+	outer.decoded = b
+
+	return outer, nil
+}
+
+// decodeBody returns the decoded body buffered by rw. Unless sniffing is
+// disabled, the gzip magic bytes take precedence over the headers: a gzip body
+// labeled as anything else is decoded, and a body labeled gzip without being
+// compressed is taken as is. Either way the body is then sent back unencoded,
+// so clients never receive a mislabeled body. Empty bodies, and those of gzip
+// files, are taken at their word.
+func (s *SubFilter) decodeBody(rw *responseWriter, r *http.Request) ([]byte, error) {
+	b := rw.buffer.Bytes()
+	layers := rw.gzipLayers
+
+	if s.sniffsEncoding(rw.Header()) && len(b) > 0 && !hasNoBody(r, rw.statusCode()) {
+		switch gzipped := bytes.HasPrefix(b, gzipMagic); {
+		case gzipped && layers == 0:
+			s.logResponse(logLevelWarn, r, "response to %s is gzip-compressed but not labeled as such", r.URL.Path)
+
+			layers = 1
+			rw.gzipLayers = 0
+		case !gzipped && layers > 0:
+			s.logResponse(logLevelWarn, r, "response to %s is labeled gzip but not compressed", r.URL.Path)
+
+			rw.Header().Del("Content-Encoding")
+			rw.Header().Del("Transfer-Encoding")
+			rw.gzipLayers = 0
+
+			return b, nil
+		}
+	}
+
+	stream, err := gzipDecodeLayers(b, layers, s.readBufferSize)
+	if err != nil {
+		return nil, err
+	}
+
+	if n := len(stream.trailing); n > 0 {
+		s.logResponse(logLevelWarn, r, "response to %s has %d bytes after its gzip stream", r.URL.Path, n)
+
+		if s.preserveTrailingBytes {
+			rw.trailing = stream.trailing
+		}
+	}
+
+	if s.preserveGzipHeader && layers > 0 {
+		rw.gzipHeader = &stream.header
+	}
+
+	return stream.decoded, nil
+}
+
+// gzipEncode compresses b.
+func gzipEncode(b []byte) ([]byte, error) {
+	return gzipEncodeHeader(b, nil)
+}
+
+// gzipEncodeHeader compresses b, carrying over the name, comment, extra field,
+// modification time and OS of header when set. Names and comments gzip cannot
+// hold are dropped rather than failing the response.
+func gzipEncodeHeader(b []byte, header *gzip.Header) ([]byte, error) {
+	var buf bytes.Buffer
+
+	gz := gzip.NewWriter(&buf)
+	if header != nil {
+		gz.Header = *header
+		gz.Name = gzipHeaderString(header.Name)
+		gz.Comment = gzipHeaderString(header.Comment)
+	}
+
+	if _, err := gz.Write(b); err != nil {
+		return nil, fmt.Errorf("unable to write gzipped content: %w", err)
+	}
+
+	if err := gz.Close(); err != nil {
+		return nil, fmt.Errorf("unable to close gzip writer: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// gzipHeaderString returns s if it can be written as a Latin-1, NUL-terminated
+// gzip header field, or "". The gzip reader decodes the raw bytes of these
+// fields as Latin-1, so names that are not valid UTF-8 on the wire round-trip.
+func gzipHeaderString(s string) string {
+	for _, r := range s {
+		if r == 0 || r > unicode.MaxLatin1 {
+			return ""
+		}
+	}
+
+	return s
+}
+
+// gzipDecode decompresses b, reading through buffers of bufSize bytes. Bytes
+// following the gzip stream are ignored.
+func gzipDecode(b []byte, bufSize int) ([]byte, error) {
+	return gzipDecodeLimit(b, bufSize, 0)
+}
+
+// gzipDecodeLimit is gzipDecode failing with errDecodedTooLarge once more
+// than limit bytes were decoded, unless limit is 0, so that a small body
+// cannot inflate to an unbounded size.
+func gzipDecodeLimit(b []byte, bufSize int, limit int64) ([]byte, error) {
+	stream, err := gzipDecodeStreamLimit(b, bufSize, limit)
+
+	return stream.decoded, err
+}
+
+// gzipDecodeStream decompresses the gzip stream at the start of b, which may
+// hold several members, and keeps the bytes following it, which some upstreams
+// append after the last trailer.
+func gzipDecodeStream(b []byte, bufSize int) (gzipStream, error) {
+	return gzipDecodeStreamLimit(b, bufSize, 0)
+}
+
+// gzipDecodeStreamLimit is gzipDecodeStream decoding at most limit bytes,
+// unless limit is 0.
+func gzipDecodeStreamLimit(b []byte, bufSize int, limit int64) (gzipStream, error) {
+	src := bytes.NewReader(b)
+	in := bufio.NewReaderSize(src, bufSize)
+
+	gr, err := gzip.NewReader(in)
+	if err != nil {
+		return gzipStream{}, fmt.Errorf("unable to create gzip reader: %w", err)
+	}
+
+	stream := gzipStream{header: gr.Header}
+
+	for {
+		gr.Multistream(false)
+
+		r := io.Reader(gr)
+		if limit > 0 {
+			r = io.LimitReader(gr, limit-int64(len(stream.decoded))+1)
+		}
+
+		member, err := readAll(r, bufSize)
+		if err != nil {
+			return gzipStream{}, fmt.Errorf("unable to read gzipped content: %w", err)
+		}
+
+		stream.decoded = append(stream.decoded, member...)
+
+		if limit > 0 && int64(len(stream.decoded)) > limit {
+			return gzipStream{}, errDecodedTooLarge
+		}
+
+		rest := b[len(b)-src.Len()-in.Buffered():]
+		if !bytes.HasPrefix(rest, gzipMagic) {
+			stream.trailing = rest
+
+			return stream, nil
+		}
+
+		if err := gr.Reset(in); err != nil {
+			return gzipStream{}, fmt.Errorf("unable to create gzip reader: %w", err)
+		}
+	}
+}
+
+// readAll reads r to the end, bufSize bytes at a time.
+func readAll(r io.Reader, bufSize int) ([]byte, error) {
+	var out bytes.Buffer
+
+	_, err := io.CopyBuffer(&out, r, make([]byte, bufSize))
+
+	return out.Bytes(), err
+}
@@ -0,0 +1,838 @@
+package subfilter
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTransferEncodingGzip(t *testing.T) {
+	tests := []struct {
+		desc             string
+		contentEncoding  string
+		transferEncoding string
+		resBody          func(t *testing.T) []byte
+		expGzipped       bool
+		expResBody       string
+	}{
+		{
+			desc:            "should decode and re-encode gzip in Content-Encoding",
+			contentEncoding: "gzip",
+			resBody:         func(t *testing.T) []byte { return gzipString(t, "foo") },
+			expGzipped:      true,
+			expResBody:      "bar",
+		},
+		{
+			desc:             "should decode gzip in Transfer-Encoding and send it back as Content-Encoding",
+			transferEncoding: "gzip, chunked",
+			resBody:          func(t *testing.T) []byte { return gzipString(t, "foo") },
+			expGzipped:       true,
+			expResBody:       "bar",
+		},
+		{
+			desc:             "should decode gzip in both headers once each",
+			contentEncoding:  "gzip",
+			transferEncoding: "gzip",
+			resBody:          func(t *testing.T) []byte { return gzipString(t, string(gzipString(t, "foo"))) },
+			expGzipped:       true,
+			expResBody:       "bar",
+		},
+		{
+			desc:             "should not decode a body that was already decoded",
+			transferEncoding: "gzip",
+			resBody:          func(t *testing.T) []byte { return []byte("foo") },
+			expResBody:       "bar",
+		},
+		{
+			desc:             "should pass through an unsupported transfer coding",
+			transferEncoding: "deflate",
+			resBody:          func(t *testing.T) []byte { return []byte("foo") },
+			expResBody:       "foo",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.Filters = []Filter{{Regex: "foo", Replacement: "bar"}}
+
+			next := func(w http.ResponseWriter, r *http.Request) {
+				if test.contentEncoding != "" {
+					w.Header().Set("Content-Encoding", test.contentEncoding)
+				}
+
+				w.Header().Set("Transfer-Encoding", test.transferEncoding)
+				_, _ = w.Write(test.resBody(t))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			got := recorder.Body.String()
+
+			if test.expGzipped {
+				if ce := recorder.Header().Get("Content-Encoding"); ce != "gzip" {
+					t.Fatalf("got Content-Encoding %q, want gzip", ce)
+				}
+
+				if te := recorder.Header().Get("Transfer-Encoding"); te != "" {
+					t.Errorf("got Transfer-Encoding %q, want none", te)
+				}
+
+				got = gunzipString(t, recorder.Body.Bytes())
+			}
+
+			if got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+		})
+	}
+}
+
+func TestOnUnknownEncoding(t *testing.T) {
+	tests := []struct {
+		desc       string
+		mode       string
+		expResBody string
+		expLogs    int
+	}{
+		{
+			desc:       "should pass through by default",
+			expResBody: "foo",
+		},
+		{
+			desc:       "should pass through and log each encoding once",
+			mode:       "warn",
+			expResBody: "foo",
+			expLogs:    1,
+		},
+		{
+			desc:       "should filter the body as unencoded",
+			mode:       "identity",
+			expResBody: "bar",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			var logs bytes.Buffer
+
+			log.SetOutput(&logs)
+			defer log.SetOutput(os.Stderr)
+
+			config := CreateConfig()
+			config.Filters = []Filter{{Regex: "foo", Replacement: "bar"}}
+			config.OnUnknownEncoding = test.mode
+
+			next := func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Encoding", "UTF-8")
+				_, _ = w.Write([]byte("foo"))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i := 0; i < 3; i++ {
+				recorder := httptest.NewRecorder()
+				handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+				if got := recorder.Body.String(); got != test.expResBody {
+					t.Errorf("got body %q, want %q", got, test.expResBody)
+				}
+			}
+
+			if got := strings.Count(logs.String(), `"UTF-8"`); got != test.expLogs {
+				t.Errorf("got %d log lines about the encoding, want %d: %s", got, test.expLogs, logs.String())
+			}
+		})
+	}
+}
+
+func TestOnUnknownEncodingKnown(t *testing.T) {
+	config := CreateConfig()
+	config.Filters = []Filter{{Regex: "foo", Replacement: "bar"}}
+	config.OnUnknownEncoding = "identity"
+
+	next := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "br")
+		_, _ = w.Write([]byte("foo"))
+	}
+
+	handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if got := recorder.Body.String(); got != "foo" {
+		t.Errorf("got body %q, want a brotli body passed through", got)
+	}
+}
+
+func TestContentEncodingList(t *testing.T) {
+	tests := []struct {
+		desc     string
+		encoding string
+		gzipped  bool
+		expBody  string
+	}{
+		{desc: "should pass several codings through", encoding: "gzip, br", gzipped: true, expBody: "foo"},
+		{desc: "should pass unknown codings of a list through", encoding: "UTF-8, UTF-8", expBody: "foo"},
+		{desc: "should filter identity as unencoded", encoding: "identity", expBody: "bar"},
+		{desc: "should decode gzip listed along with identity", encoding: "identity, gzip", gzipped: true, expBody: "bar"},
+		{desc: "should decode x-gzip like gzip", encoding: "x-gzip", gzipped: true, expBody: "bar"},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.Filters = []Filter{{Regex: "foo", Replacement: "bar"}}
+			config.OnUnknownEncoding = "identity"
+
+			body := []byte("foo")
+			if test.gzipped {
+				body = gzipString(t, "foo")
+			}
+
+			next := func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Encoding", test.encoding)
+				_, _ = w.Write(body)
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if test.expBody == "foo" {
+				if !bytes.Equal(recorder.Body.Bytes(), body) {
+					t.Errorf("got body %x, want the upstream bytes %x", recorder.Body.Bytes(), body)
+				}
+
+				return
+			}
+
+			got := recorder.Body.String()
+			if test.gzipped {
+				got = gunzipString(t, recorder.Body.Bytes())
+			}
+
+			if got != test.expBody {
+				t.Errorf("got body %q, want %q", got, test.expBody)
+			}
+		})
+	}
+}
+
+func TestPassthroughEncodings(t *testing.T) {
+	compressed := gzipString(t, "foo")
+
+	tests := []struct {
+		desc      string
+		encodings []string
+		expBody   string
+	}{
+		{desc: "should filter gzip responses by default", expBody: "bar"},
+		{desc: "should pass listed encodings through", encodings: []string{"br", "GZIP"}, expBody: "foo"},
+		{desc: "should filter encodings that are not listed", encodings: []string{"br"}, expBody: "bar"},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.Filters = []Filter{{Regex: "foo", Replacement: "bar"}}
+			config.PassthroughEncodings = test.encodings
+
+			next := func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Encoding", "gzip")
+				_, _ = w.Write(compressed)
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if test.expBody == "foo" && !bytes.Equal(recorder.Body.Bytes(), compressed) {
+				t.Errorf("got body %x, want the upstream bytes %x", recorder.Body.Bytes(), compressed)
+			}
+
+			if got := gunzipString(t, recorder.Body.Bytes()); got != test.expBody {
+				t.Errorf("got body %q, want %q", got, test.expBody)
+			}
+		})
+	}
+}
+
+func TestPreserveEncodingCasing(t *testing.T) {
+	tests := []struct {
+		desc     string
+		preserve bool
+		expCE    string
+	}{
+		{desc: "should send lowercase gzip by default", expCE: "gzip"},
+		{desc: "should echo the upstream casing", preserve: true, expCE: "GZIP"},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.Filters = []Filter{{Regex: "foo", Replacement: "bar"}}
+			config.PreserveEncodingCasing = test.preserve
+
+			next := func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Encoding", "GZIP")
+				_, _ = w.Write(gzipString(t, "foo"))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if got := recorder.Header().Get("Content-Encoding"); got != test.expCE {
+				t.Errorf("got Content-Encoding %q, want %q", got, test.expCE)
+			}
+
+			if got := gunzipString(t, recorder.Body.Bytes()); got != "bar" {
+				t.Errorf("got body %q, want %q", got, "bar")
+			}
+		})
+	}
+}
+
+func TestEncodingSniffing(t *testing.T) {
+	tests := []struct {
+		desc            string
+		disable         bool
+		method          string
+		status          int
+		contentType     string
+		contentEncoding string
+		resBody         func(t *testing.T) []byte
+		expEncoding     string
+		expResBody      string
+		expWarning      bool
+	}{
+		{
+			desc:            "should filter a plain body labeled gzip and send it unlabeled",
+			contentEncoding: "gzip",
+			resBody:         func(t *testing.T) []byte { return []byte("foo") },
+			expResBody:      "bar",
+			expWarning:      true,
+		},
+		{
+			desc:       "should decode an unlabeled gzip body and send it plain",
+			resBody:    func(t *testing.T) []byte { return gzipString(t, "foo") },
+			expResBody: "bar",
+			expWarning: true,
+		},
+		{
+			desc:            "should trust the header when disabled",
+			disable:         true,
+			contentEncoding: "gzip",
+			resBody:         func(t *testing.T) []byte { return []byte("foo") },
+			expEncoding:     "gzip",
+			expResBody:      "bar",
+		},
+		{
+			desc:            "should keep the gzip label of the response to a HEAD request",
+			method:          http.MethodHead,
+			contentEncoding: "gzip",
+			resBody:         func(t *testing.T) []byte { return nil },
+			expEncoding:     "gzip",
+		},
+		{
+			desc:            "should keep the gzip label of a 304 response",
+			status:          http.StatusNotModified,
+			contentEncoding: "gzip",
+			resBody:         func(t *testing.T) []byte { return nil },
+			expEncoding:     "gzip",
+		},
+		{
+			desc:            "should keep the gzip label of an empty body",
+			contentEncoding: "gzip",
+			resBody:         func(t *testing.T) []byte { return nil },
+			expEncoding:     "gzip",
+		},
+		{
+			desc:        "should not decode a gzip file",
+			contentType: "application/gzip",
+			resBody:     func(t *testing.T) []byte { return gzipString(t, "baz") },
+			expResBody:  string(gzipString(t, "baz")),
+		},
+		{
+			desc:        "should not decode an x-gzip file",
+			contentType: "application/x-gzip",
+			resBody:     func(t *testing.T) []byte { return gzipString(t, "baz") },
+			expResBody:  string(gzipString(t, "baz")),
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			var logs bytes.Buffer
+
+			log.SetOutput(&logs)
+			defer log.SetOutput(os.Stderr)
+
+			config := CreateConfig()
+			config.Filters = []Filter{{Regex: "foo", Replacement: "bar"}}
+			config.DisableEncodingSniffing = test.disable
+
+			next := func(w http.ResponseWriter, r *http.Request) {
+				if test.contentType != "" {
+					w.Header().Set("Content-Type", test.contentType)
+				}
+
+				w.Header().Set("Content-Encoding", test.contentEncoding)
+
+				if test.status != 0 {
+					w.WriteHeader(test.status)
+				}
+
+				_, _ = w.Write(test.resBody(t))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			method := test.method
+			if method == "" {
+				method = http.MethodGet
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(method, "/", nil))
+
+			ce := recorder.Header().Get("Content-Encoding")
+			if ce != test.expEncoding {
+				t.Errorf("got Content-Encoding %q, want %q", ce, test.expEncoding)
+			}
+
+			got := recorder.Body.String()
+			if ce == "gzip" && recorder.Body.Len() > 0 {
+				got = gunzipString(t, recorder.Body.Bytes())
+			}
+
+			if got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+
+			if warned := strings.Contains(logs.String(), "labeled"); warned != test.expWarning {
+				t.Errorf("got warning %t, want %t: %s", warned, test.expWarning, logs.String())
+			}
+		})
+	}
+}
+
+func TestGzipTrailingBytes(t *testing.T) {
+	junk := "\r\n\x00junk"
+
+	tests := []struct {
+		desc       string
+		preserve   bool
+		resBody    func(t *testing.T) []byte
+		expResBody string
+		expJunk    bool
+	}{
+		{
+			desc:       "should drop the bytes after the gzip stream",
+			resBody:    func(t *testing.T) []byte { return append(gzipString(t, "foo"), junk...) },
+			expResBody: "bar",
+		},
+		{
+			desc:       "should preserve the bytes after the gzip stream",
+			preserve:   true,
+			resBody:    func(t *testing.T) []byte { return append(gzipString(t, "foo"), junk...) },
+			expResBody: "bar",
+			expJunk:    true,
+		},
+		{
+			desc: "should decode every member of a multi-member stream",
+			resBody: func(t *testing.T) []byte {
+				return append(append(gzipString(t, "foo "), gzipString(t, "foo")...), junk...)
+			},
+			expResBody: "bar bar",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.Filters = []Filter{{Regex: "foo", Replacement: "bar"}}
+			config.PreserveTrailingBytes = test.preserve
+
+			next := func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Encoding", "gzip")
+				_, _ = w.Write(test.resBody(t))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			b := recorder.Body.Bytes()
+
+			if hasJunk := bytes.HasSuffix(b, []byte(junk)); hasJunk != test.expJunk {
+				t.Fatalf("got trailing bytes %t, want %t", hasJunk, test.expJunk)
+			}
+
+			if test.expJunk {
+				b = b[:len(b)-len(junk)]
+			}
+
+			if got := gunzipString(t, b); got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+		})
+	}
+}
+
+func TestPreserveGzipHeader(t *testing.T) {
+	modTime := time.Unix(1700000000, 0)
+
+	tests := []struct {
+		desc    string
+		disable bool
+		modTime time.Time
+		expName string
+	}{
+		{
+			desc:    "should preserve the header by default",
+			modTime: modTime,
+			expName: "café.html",
+		},
+		{
+			desc:    "should preserve a zero modification time",
+			expName: "café.html",
+		},
+		{
+			desc:    "should drop the header",
+			disable: true,
+			modTime: modTime,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.Filters = []Filter{{Regex: "foo", Replacement: "bar"}}
+
+			if test.disable {
+				config.PreserveGzipHeader = false
+			}
+
+			next := func(w http.ResponseWriter, r *http.Request) {
+				var buf bytes.Buffer
+
+				gw := gzip.NewWriter(&buf)
+				// Written as Latin-1, which is not valid UTF-8.
+				gw.Name = "café.html"
+				gw.Comment = "built by ci"
+				gw.Extra = []byte("AB\x02\x00ok")
+				gw.ModTime = test.modTime
+				gw.OS = 3
+
+				_, _ = gw.Write([]byte("foo"))
+				_ = gw.Close()
+
+				w.Header().Set("Content-Encoding", "gzip")
+				_, _ = w.Write(buf.Bytes())
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if test.expName != "" && !bytes.Contains(recorder.Body.Bytes(), []byte("caf\xe9.html\x00")) {
+				t.Errorf("got %q, want the raw Latin-1 name", recorder.Body.Bytes())
+			}
+
+			gr, err := gzip.NewReader(recorder.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			b, err := ioutil.ReadAll(gr)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(b) != "bar" {
+				t.Errorf("got body %q, want %q", b, "bar")
+			}
+
+			expName, expComment, expExtra, expModTime, expOS := "", "", "", time.Time{}, byte(255)
+			if test.expName != "" {
+				expName, expComment, expExtra, expModTime, expOS = test.expName, "built by ci", "AB\x02\x00ok", test.modTime, 3
+			}
+
+			if gr.Name != expName || gr.Comment != expComment || string(gr.Extra) != expExtra {
+				t.Errorf("got header name %q, comment %q, extra %q, want %q, %q, %q",
+					gr.Name, gr.Comment, gr.Extra, expName, expComment, expExtra)
+			}
+
+			if !gr.ModTime.Equal(expModTime) || gr.OS != expOS {
+				t.Errorf("got modification time %v and OS %d, want %v and %d", gr.ModTime, gr.OS, expModTime, expOS)
+			}
+		})
+	}
+}
+
+func TestGzipEncodeHeaderInvalidStrings(t *testing.T) {
+	b, err := gzipEncodeHeader([]byte("foo"), &gzip.Header{Name: "日本.html", Comment: "a\x00b", OS: 255})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gr, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if gr.Name != "" || gr.Comment != "" {
+		t.Errorf("got name %q and comment %q, want them dropped", gr.Name, gr.Comment)
+	}
+}
+
+func TestReadBufferSize(t *testing.T) {
+	body := strings.Repeat("foo bar baz\n", 10000)
+	expected := strings.Repeat("FOO bar baz\n", 10000)
+
+	for _, size := range []int{0, 1, 512, 64 << 10} {
+		size := size
+		t.Run(strconv.Itoa(size), func(t *testing.T) {
+			config := CreateConfig()
+			config.Filters = []Filter{{Regex: "foo", Replacement: "FOO"}}
+			config.RequestFilters = config.Filters
+			config.ReadBufferSize = size
+
+			var seen string
+
+			next := func(w http.ResponseWriter, r *http.Request) {
+				b, _ := ioutil.ReadAll(r.Body)
+				seen = string(b)
+
+				w.Header().Set("Content-Encoding", "gzip")
+				_, _ = w.Write(gzipString(t, body))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+
+			if seen != expected {
+				t.Errorf("got a request body of %d bytes, want %d filtered bytes", len(seen), len(expected))
+			}
+
+			if got := gunzipString(t, recorder.Body.Bytes()); got != expected {
+				t.Errorf("got a response body of %d bytes, want %d filtered bytes", len(got), len(expected))
+			}
+		})
+	}
+}
+
+func BenchmarkGzipDecode(b *testing.B) {
+	var buf bytes.Buffer
+
+	gw := gzip.NewWriter(&buf)
+	_, _ = gw.Write(bytes.Repeat([]byte("<p>foo is the new bar</p>\n"), 1<<16))
+	_ = gw.Close()
+
+	for _, size := range []int{512, 4 << 10, 32 << 10, 256 << 10} {
+		size := size
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			b.ReportAllocs()
+
+			for i := 0; i < b.N; i++ {
+				if _, err := gzipDecode(buf.Bytes(), size); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestVaryAcceptEncoding(t *testing.T) {
+	tests := []struct {
+		desc           string
+		gzip           bool
+		vary           string
+		acceptEncoding string
+		expVary        []string
+		expGzip        bool
+	}{
+		{
+			desc:    "should add Vary to re-encoded responses",
+			gzip:    true,
+			expVary: []string{"Accept-Encoding"},
+			expGzip: true,
+		},
+		{
+			desc:    "should append to an existing Vary",
+			gzip:    true,
+			vary:    "Cookie, Origin",
+			expVary: []string{"Cookie, Origin, Accept-Encoding"},
+			expGzip: true,
+		},
+		{
+			desc:    "should not duplicate Accept-Encoding",
+			gzip:    true,
+			vary:    "accept-encoding, Cookie",
+			expVary: []string{"accept-encoding, Cookie"},
+			expGzip: true,
+		},
+		{
+			desc:    "should leave Vary: * alone",
+			gzip:    true,
+			vary:    "*",
+			expVary: []string{"*"},
+			expGzip: true,
+		},
+		{
+			desc:           "should send unencoded bodies to clients refusing gzip",
+			gzip:           true,
+			acceptEncoding: "br, gzip;q=0",
+			expVary:        []string{"Accept-Encoding"},
+		},
+		{
+			desc:    "should not add Vary to unencoded responses",
+			vary:    "Cookie",
+			expVary: []string{"Cookie"},
+		},
+		{
+			desc: "should not create Vary for unencoded responses",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.Filters = []Filter{{Regex: "foo", Replacement: "bar"}}
+
+			next := func(w http.ResponseWriter, _ *http.Request) {
+				if test.vary != "" {
+					w.Header().Set("Vary", test.vary)
+				}
+
+				if !test.gzip {
+					_, _ = w.Write([]byte("foo"))
+
+					return
+				}
+
+				w.Header().Set("Content-Encoding", "gzip")
+				_, _ = w.Write(gzipString(t, "foo"))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if test.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", test.acceptEncoding)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+
+			if got := recorder.Header().Values("Vary"); strings.Join(got, "|") != strings.Join(test.expVary, "|") {
+				t.Errorf("got Vary %q, want %q", got, test.expVary)
+			}
+
+			body := recorder.Body.String()
+			if test.expGzip {
+				body = gunzipString(t, recorder.Body.Bytes())
+			}
+
+			if body != "bar" {
+				t.Errorf("got body %q, want %q", body, "bar")
+			}
+
+			if got := recorder.Header().Get("Content-Encoding") != ""; got != test.expGzip {
+				t.Errorf("got Content-Encoding %t, want %t", got, test.expGzip)
+			}
+		})
+	}
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	for value, expected := range map[string]bool{
+		"":                    true,
+		"gzip, deflate, br":   true,
+		"GZIP;q=0.5":          true,
+		"*":                   true,
+		"identity":            false,
+		"br, gzip;q=0":        false,
+		"*;q=0":               false,
+		"gzip;q=0, *":         false,
+		"deflate, *;q=0.1":    true,
+		"x-gzip":              true,
+		"gzip;q=invalid, br":  false,
+		"identity;q=1, *;q=0": false,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if value != "" {
+			req.Header.Set("Accept-Encoding", value)
+		}
+
+		if got := acceptsGzip(req); got != expected {
+			t.Errorf("Accept-Encoding %q: got %t, want %t", value, got, expected)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package subfilter
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+const defaultErrorPageContentType = "text/html; charset=utf-8"
+
+// ErrorPage replaces the whole body of upstream responses with a matching
+// status, such as stack traces behind a 500.
+type ErrorPage struct {
+	// StatusCodes are status codes ("500"), classes ("5xx") or inclusive
+	// ranges ("500-599").
+	StatusCodes []string `json:"statusCodes,omitempty"`
+	// Body is the page, or BodyFile the path of a file holding it, read at
+	// startup.
+	Body     string `json:"body,omitempty"`
+	BodyFile string `json:"bodyFile,omitempty"`
+	// ContentType defaults to "text/html; charset=utf-8".
+	ContentType string `json:"contentType,omitempty"`
+	// ReplaceStatus, when set, replaces the upstream status code.
+	ReplaceStatus int `json:"replaceStatus,omitempty"`
+	// LogBytes logs the first LogBytes bytes of each replaced upstream body.
+	LogBytes int `json:"logBytes,omitempty"`
+}
+
+type errorPage struct {
+	statusCodes   []statusRange
+	body          []byte
+	contentType   string
+	replaceStatus int
+	logBytes      int
+}
+
+// replacedHeaders are the upstream headers that describe the replaced body or
+// let caches and clients reuse it.
+var replacedHeaders = []string{
+	"Accept-Ranges",
+	"Age",
+	"Cache-Control",
+	"Content-Disposition",
+	"Content-Encoding",
+	"Content-Language",
+	"Content-Location",
+	"Content-Md5",
+	"Content-Range",
+	"Digest",
+	"Etag",
+	"Expires",
+	"Last-Modified",
+	"Transfer-Encoding",
+}
+
+func (s *SubFilter) setupErrorPage(config *Config) error {
+	c := config.ErrorPage
+	if c == nil {
+		return nil
+	}
+
+	ep := &errorPage{
+		body:          []byte(c.Body),
+		contentType:   c.ContentType,
+		replaceStatus: c.ReplaceStatus,
+		logBytes:      c.LogBytes,
+	}
+
+	switch {
+	case len(c.StatusCodes) == 0:
+		return errors.New("errorPage: statusCodes is required")
+	case c.Body != "" && c.BodyFile != "":
+		return errors.New("errorPage: body and bodyFile are mutually exclusive")
+	case c.ReplaceStatus != 0 && (c.ReplaceStatus < 100 || c.ReplaceStatus > 599):
+		return fmt.Errorf("errorPage: invalid replaceStatus %d", c.ReplaceStatus)
+	}
+
+	for _, p := range c.StatusCodes {
+		sr, err := parseStatusPattern(p)
+		if err != nil {
+			return fmt.Errorf("errorPage: %w", err)
+		}
+
+		ep.statusCodes = append(ep.statusCodes, sr)
+	}
+
+	if c.BodyFile != "" {
+		b, err := ioutil.ReadFile(c.BodyFile)
+		if err != nil {
+			return fmt.Errorf("errorPage: %w", err)
+		}
+
+		ep.body = b
+	}
+
+	if ep.contentType == "" {
+		ep.contentType = defaultErrorPageContentType
+	}
+
+	s.errorPage = ep
+
+	return nil
+}
+
+// match reports whether responses with status get the error page. A nil
+// *errorPage matches nothing.
+func (ep *errorPage) match(status int) bool {
+	if ep == nil {
+		return false
+	}
+
+	for _, sr := range ep.statusCodes {
+		if sr.contains(status) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// writeErrorPage sends the error page in place of the upstream response buffered by
+// rw, which only holds its first logBytes bytes.
+func (s *SubFilter) writeErrorPage(rw *responseWriter, r *http.Request) {
+	ep := s.errorPage
+
+	if ep.logBytes > 0 {
+		s.logResponse(logLevelWarn, r, "replacing %d response to %s, upstream body started with %q",
+			rw.statusCode(), r.URL.Path, rw.buffer.Bytes())
+	}
+
+	h := rw.Header()
+	for _, name := range replacedHeaders {
+		h.Del(name)
+	}
+
+	for _, name := range rw.vary {
+		addVary(h, name)
+	}
+
+	rw.headerEdits.apply(h)
+
+	h.Set("Content-Type", ep.contentType)
+	fixContentTypeOptions(h, rw.contentTypeOptions, rw.upstreamType)
+	h.Set("Content-Length", strconv.Itoa(len(ep.body)))
+
+	status := rw.statusCode()
+	if ep.replaceStatus != 0 {
+		status = ep.replaceStatus
+	}
+
+	rw.ResponseWriter.WriteHeader(status)
+
+	if _, err := rw.ResponseWriter.Write(ep.body); err != nil {
+		log.Printf("unable to write response: %v", err)
+	}
+}
@@ -0,0 +1,135 @@
+package subfilter
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestErrorPage(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "502.html")
+	if err := ioutil.WriteFile(file, []byte("<h1>Bad gateway</h1>"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		desc          string
+		errorPage     ErrorPage
+		status        int
+		expStatus     int
+		expResBody    string
+		expType       string
+		expCacheValue string
+		replaced      bool
+	}{
+		{
+			desc:       "should replace a 500 with the inline body",
+			errorPage:  ErrorPage{StatusCodes: []string{"500"}, Body: "<h1>Oops</h1>"},
+			status:     http.StatusInternalServerError,
+			expStatus:  http.StatusInternalServerError,
+			expResBody: "<h1>Oops</h1>",
+			expType:    "text/html; charset=utf-8",
+			replaced:   true,
+		},
+		{
+			desc:       "should replace a 502 with the file body and the configured type",
+			errorPage:  ErrorPage{StatusCodes: []string{"5xx"}, BodyFile: file, ContentType: "text/html"},
+			status:     http.StatusBadGateway,
+			expStatus:  http.StatusBadGateway,
+			expResBody: "<h1>Bad gateway</h1>",
+			expType:    "text/html",
+			replaced:   true,
+		},
+		{
+			desc:       "should replace the status when configured",
+			errorPage:  ErrorPage{StatusCodes: []string{"500-599"}, Body: "down", ContentType: "text/plain", ReplaceStatus: 503},
+			status:     http.StatusInternalServerError,
+			expStatus:  http.StatusServiceUnavailable,
+			expResBody: "down",
+			expType:    "text/plain",
+			replaced:   true,
+		},
+		{
+			desc:          "should leave a 200 alone",
+			errorPage:     ErrorPage{StatusCodes: []string{"5xx"}, Body: "<h1>Oops</h1>"},
+			status:        http.StatusOK,
+			expStatus:     http.StatusOK,
+			expResBody:    "java.lang.NullPointerException",
+			expType:       "text/plain",
+			expCacheValue: "public, max-age=60",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.ErrorPage = &test.errorPage
+			config.ErrorPage.LogBytes = 8
+
+			next := func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/plain")
+				w.Header().Set("Cache-Control", "public, max-age=60")
+				w.Header().Set("ETag", `"v1"`)
+				w.WriteHeader(test.status)
+				_, _ = w.Write([]byte("java.lang.NullPointerException"))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if recorder.Code != test.expStatus {
+				t.Errorf("got status %d, want %d", recorder.Code, test.expStatus)
+			}
+
+			if got := recorder.Body.String(); got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+
+			if got := recorder.Header().Get("Content-Type"); got != test.expType {
+				t.Errorf("got Content-Type %q, want %q", got, test.expType)
+			}
+
+			if got := recorder.Header().Get("Cache-Control"); got != test.expCacheValue {
+				t.Errorf("got Cache-Control %q, want %q", got, test.expCacheValue)
+			}
+
+			if test.replaced {
+				if got := recorder.Header().Get("Content-Length"); got != strconv.Itoa(len(test.expResBody)) {
+					t.Errorf("got Content-Length %q, want %d", got, len(test.expResBody))
+				}
+
+				if got := recorder.Header().Get("ETag"); got != "" {
+					t.Errorf("got ETag %q, want none", got)
+				}
+			}
+		})
+	}
+}
+
+func TestErrorPageInvalid(t *testing.T) {
+	for _, ep := range []ErrorPage{
+		{Body: "x"},
+		{StatusCodes: []string{"5xy"}, Body: "x"},
+		{StatusCodes: []string{"500"}, Body: "x", BodyFile: "/x"},
+		{StatusCodes: []string{"500"}, BodyFile: "/does/not/exist"},
+		{StatusCodes: []string{"500"}, Body: "x", ReplaceStatus: 42},
+	} {
+		ep := ep
+		config := CreateConfig()
+		config.ErrorPage = &ep
+
+		if _, err := New(context.Background(), nil, config, "subfilter"); err == nil {
+			t.Errorf("expected error for error page %+v", ep)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package subfilter
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// ReplacementEvent reports that a filter acted upon matches of a response,
+// published on the channel registered with SetEvents.
+type ReplacementEvent struct {
+	// Filter names the filter by its regex, preset or range.
+	Filter string
+	// Replacements is the number of matches the filter acted upon.
+	Replacements int
+	Path         string
+}
+
+// SetEvents registers ch to receive a ReplacementEvent for every filter that
+// matched in the body of a response, once it went through the filters. Events
+// are sent without blocking and dropped when ch is full, so ch should be
+// buffered and drained promptly. A nil ch stops the events.
+func (s *SubFilter) SetEvents(ch chan<- ReplacementEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.events = ch
+}
+
+// eventChannel returns the channel registered with SetEvents, if any.
+func (s *SubFilter) eventChannel() chan<- ReplacementEvent {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.events
+}
+
+// publishEvents sends the events of the filters that matched according to
+// sc to the channel registered with SetEvents, counting those dropped.
+func (s *SubFilter) publishEvents(r *http.Request, filters []filter, sc *scope) {
+	ch := s.eventChannel()
+	if ch == nil {
+		return
+	}
+
+	for i := range filters {
+		def := filters[i].def
+		if def == nil || sc.traced[def] == 0 {
+			continue
+		}
+
+		select {
+		case ch <- ReplacementEvent{Filter: filterName(*def), Replacements: sc.traced[def], Path: r.URL.Path}:
+		default:
+			atomic.AddUint64(&s.stats.DroppedEvents, 1)
+		}
+	}
+}
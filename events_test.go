@@ -0,0 +1,65 @@
+package subfilter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestSetEvents(t *testing.T) {
+	config := CreateConfig()
+	config.Filters = []Filter{
+		{Regex: "foo", Replacement: "bar"},
+		{Regex: "baz", Replacement: "qux"},
+	}
+
+	next := func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.URL.Query().Get("body")))
+	}
+
+	sf, err := NewSubFilter(context.Background(), http.HandlerFunc(next), config, "subfilter")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serve := func(target string) {
+		sf.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	}
+
+	serve("/before?body=foo")
+
+	events := make(chan ReplacementEvent, 3)
+	sf.SetEvents(events)
+
+	serve("/a?body=foo+foo+baz")
+	serve("/b?body=nothing")
+	serve("/c?body=baz")
+	// The channel is full by now: this event is dropped.
+	serve("/d?body=foo")
+
+	sf.SetEvents(nil)
+	serve("/after?body=foo")
+
+	close(events)
+
+	var got []ReplacementEvent
+	for ev := range events {
+		got = append(got, ev)
+	}
+
+	want := []ReplacementEvent{
+		{Filter: "foo", Replacements: 2, Path: "/a"},
+		{Filter: "baz", Replacements: 1, Path: "/a"},
+		{Filter: "baz", Replacements: 1, Path: "/c"},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got events %+v, want %+v", got, want)
+	}
+
+	if dropped := sf.Stats().DroppedEvents; dropped != 1 {
+		t.Errorf("got %d dropped events, want 1", dropped)
+	}
+}
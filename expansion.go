@@ -0,0 +1,16 @@
+package subfilter
+
+import "log"
+
+// expanded reports whether out, the output of the filter over b, grew past
+// the expansion ratio of the filter, in which case it is logged.
+func (f *filter) expanded(b, out []byte) bool {
+	if f.maxExpansion == 0 || len(b) == 0 || float64(len(out)) <= f.maxExpansion*float64(len(b)) {
+		return false
+	}
+
+	log.Printf("filter %s would expand a %d-byte body to %d bytes, over its maxExpansionRatio of %g: "+
+		"keeping the body unchanged", filterLabel(*f.def), len(b), len(out), f.maxExpansion)
+
+	return true
+}
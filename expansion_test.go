@@ -0,0 +1,92 @@
+package subfilter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMaxExpansionRatio(t *testing.T) {
+	tests := []struct {
+		desc       string
+		filter     Filter
+		resBody    string
+		expResBody string
+	}{
+		{
+			desc:       "should keep the body when the ratio is exceeded",
+			filter:     Filter{Regex: "x", Replacement: "xxxxxxxxxx", MaxExpansionRatio: 2},
+			resBody:    "axbxc",
+			expResBody: "axbxc",
+		},
+		{
+			desc:       "should apply replacements within the ratio",
+			filter:     Filter{Regex: "x", Replacement: "yy", MaxExpansionRatio: 2},
+			resBody:    "axbxc",
+			expResBody: "ayybyyc",
+		},
+		{
+			desc:       "should not limit shrinking replacements",
+			filter:     Filter{Regex: "x+", MaxExpansionRatio: 1},
+			resBody:    "axxxb",
+			expResBody: "ab",
+		},
+		{
+			desc:       "should not limit the filter without a ratio",
+			filter:     Filter{Regex: "x", Replacement: "xxxxxxxxxx"},
+			resBody:    "x",
+			expResBody: "xxxxxxxxxx",
+		},
+		{
+			desc:       "should measure the ratio of a scoped filter within its scope",
+			filter:     Filter{Regex: "b", Replacement: "bbbbbbbbbb", MaxExpansionRatio: 1.5, WithinTags: []string{"p"}},
+			resBody:    "<p>b</p>",
+			expResBody: "<p>b</p>",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			if got := serveTransform(t, test.filter, test.resBody); got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+		})
+	}
+
+	for _, f := range []Filter{
+		{Regex: "x", MaxExpansionRatio: 0.5},
+		{Regex: "x", MaxExpansionRatio: -1},
+		{Type: "range", Start: "a", End: "b", MaxExpansionRatio: 2},
+	} {
+		if _, err := compileFilters([]Filter{f}); err == nil {
+			t.Errorf("expected error for %+v", f)
+		}
+	}
+}
+
+func TestMaxExpansionRatioIndependent(t *testing.T) {
+	config := CreateConfig()
+	config.IndependentFilters = true
+	config.Filters = []Filter{
+		{Regex: "x", Replacement: "xxxxxxxxxx", MaxExpansionRatio: 2},
+		{Regex: "a", Replacement: "A"},
+	}
+
+	next := func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("axbxcxdxexfxgxh"))
+	}
+
+	handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if got, want := recorder.Body.String(), "Axbxcxdxexfxgxh"; got != want {
+		t.Errorf("got body %q, want %q", got, want)
+	}
+}
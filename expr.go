@@ -0,0 +1,248 @@
+package subfilter
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+)
+
+// exprNode is a node of an arithmetic expression of ${expr:...}.
+type exprNode struct {
+	// op is '+', '-', '*' or '/' for binary nodes, 'n' for negation, 0 for
+	// numbers and '$' for capture group references.
+	op          byte
+	left, right *exprNode
+	num         float64
+	ref         string
+}
+
+var errDivisionByZero = errors.New("division by zero")
+
+// exprParser parses expressions made of numbers, $group references, the +,
+// -, * and / operators and parentheses.
+type exprParser struct {
+	s   string
+	pos int
+}
+
+// parseExpr compiles s, returning an error for anything but the tiny
+// arithmetic grammar.
+func parseExpr(s string) (*exprNode, error) {
+	p := &exprParser{s: s}
+
+	n, err := p.sum()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.skipSpaces(); p.pos < len(p.s) {
+		return nil, fmt.Errorf("unexpected %q at offset %d", p.s[p.pos], p.pos)
+	}
+
+	return n, nil
+}
+
+func (p *exprParser) skipSpaces() {
+	for p.pos < len(p.s) && p.s[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+// next returns the next non-space byte without consuming it, or 0 at the end.
+func (p *exprParser) next() byte {
+	if p.skipSpaces(); p.pos < len(p.s) {
+		return p.s[p.pos]
+	}
+
+	return 0
+}
+
+func (p *exprParser) sum() (*exprNode, error) {
+	n, err := p.product()
+	if err != nil {
+		return nil, err
+	}
+
+	for op := p.next(); op == '+' || op == '-'; op = p.next() {
+		p.pos++
+
+		right, err := p.product()
+		if err != nil {
+			return nil, err
+		}
+
+		n = &exprNode{op: op, left: n, right: right}
+	}
+
+	return n, nil
+}
+
+func (p *exprParser) product() (*exprNode, error) {
+	n, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+
+	for op := p.next(); op == '*' || op == '/'; op = p.next() {
+		p.pos++
+
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+
+		n = &exprNode{op: op, left: n, right: right}
+	}
+
+	return n, nil
+}
+
+func (p *exprParser) unary() (*exprNode, error) {
+	switch c := p.next(); {
+	case c == '-':
+		p.pos++
+
+		n, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+
+		return &exprNode{op: 'n', left: n}, nil
+	case c == '(':
+		p.pos++
+
+		n, err := p.sum()
+		if err != nil {
+			return nil, err
+		}
+
+		if p.next() != ')' {
+			return nil, fmt.Errorf("missing ) at offset %d", p.pos)
+		}
+
+		p.pos++
+
+		return n, nil
+	case c == '$':
+		p.pos++
+		start := p.pos
+
+		for p.pos < len(p.s) && isRefByte(p.s[p.pos]) {
+			p.pos++
+		}
+
+		if p.pos == start {
+			return nil, fmt.Errorf("missing group after $ at offset %d", start)
+		}
+
+		return &exprNode{op: '$', ref: p.s[start:p.pos]}, nil
+	case c >= '0' && c <= '9' || c == '.':
+		start := p.pos
+
+		for p.pos < len(p.s) && (p.s[p.pos] >= '0' && p.s[p.pos] <= '9' || p.s[p.pos] == '.') {
+			p.pos++
+		}
+
+		num, err := strconv.ParseFloat(p.s[start:p.pos], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", p.s[start:p.pos])
+		}
+
+		return &exprNode{num: num}, nil
+	case c == 0:
+		return nil, errors.New("unexpected end of expression")
+	default:
+		return nil, fmt.Errorf("unexpected %q at offset %d", c, p.pos)
+	}
+}
+
+func isRefByte(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// eval computes the expression, resolving group references with value.
+func (n *exprNode) eval(value func(ref string) (float64, error)) (float64, error) {
+	switch n.op {
+	case 0:
+		return n.num, nil
+	case '$':
+		return value(n.ref)
+	case 'n':
+		v, err := n.left.eval(value)
+
+		return -v, err
+	}
+
+	left, err := n.left.eval(value)
+	if err != nil {
+		return 0, err
+	}
+
+	right, err := n.right.eval(value)
+	if err != nil {
+		return 0, err
+	}
+
+	switch n.op {
+	case '+':
+		return left + right, nil
+	case '-':
+		return left - right, nil
+	case '*':
+		return left * right, nil
+	default:
+		if right == 0 {
+			return 0, errDivisionByZero
+		}
+
+		return left / right, nil
+	}
+}
+
+// firstRef returns the first group reference of the expression, or "".
+func (n *exprNode) firstRef() string {
+	if n == nil {
+		return ""
+	}
+
+	if n.op == '$' {
+		return n.ref
+	}
+
+	if ref := n.left.firstRef(); ref != "" {
+		return ref
+	}
+
+	return n.right.firstRef()
+}
+
+// compileExpr parses the expression of ${expr:expression} once, when the
+// filter is compiled, for exprTransform to evaluate it at every match.
+func compileExpr(args []string) (transformFunc, error) {
+	n, err := parseExpr(args[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %w", args[0], err)
+	}
+
+	return exprTransform(n), nil
+}
+
+// exprTransform implements ${expr:expression}, n being the parsed expression:
+// the result of an arithmetic expression over numbers and capture groups,
+// such as $1*2. Expressions that cannot be computed, because a group is not a
+// number or on division by zero, expand to the text of their first group
+// reference instead.
+func exprTransform(n *exprNode) transformFunc {
+	return func(dst []byte, _ []string, re *regexp.Regexp, src []byte, match []int, _ *scope) []byte {
+		v, err := n.eval(func(ref string) (float64, error) {
+			return strconv.ParseFloat(string(group(re, src, match, ref)), 64)
+		})
+		if err != nil || math.IsInf(v, 0) || math.IsNaN(v) {
+			return append(dst, group(re, src, match, n.firstRef())...)
+		}
+
+		return strconv.AppendFloat(dst, v, 'f', -1, 64)
+	}
+}
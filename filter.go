@@ -0,0 +1,904 @@
+package subfilter
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+)
+
+const (
+	filterTypeRegex    = "regex"
+	filterTypeGlob     = "glob"
+	filterTypeTemplate = "template"
+	filterTypeCSSURL   = "css-url"
+	filterTypeDataURI  = "data-uri"
+	filterTypeRange    = "range"
+	filterTypeBytes    = "bytes"
+	filterTypeCSV      = "csv"
+	filterTypeYAML     = "yaml"
+)
+
+// Filter holds one Filter definition.
+type Filter struct {
+	// Type is "regex" (the default), "glob", in which case Regex holds a glob
+	// and Replacement is used literally, "template", in which case
+	// Replacement is a text/template executed for every match, "css-url",
+	// which only applies the filter to the URLs of CSS url() tokens,
+	// "data-uri", which applies it to the decoded payloads of base64 data
+	// URIs, "range", which replaces the regions delimited by Start and End,
+	// "bytes", in which case Regex and Replacement are hex-encoded byte
+	// sequences, "csv", which only applies the filter to one column of a CSV
+	// body, or "yaml", which only applies it to the scalar values at Path of
+	// YAML documents.
+	Type        string `json:"type,omitempty"`
+	Regex       string `json:"regex,omitempty"`
+	Preset      string `json:"preset,omitempty"`
+	Replacement string `json:"replacement,omitempty"`
+	// ReplacementFile is a file whose contents, read once when the middleware
+	// is created, are used literally as the replacement instead.
+	ReplacementFile string           `json:"replacementFile,omitempty"`
+	HashReplacement *HashReplacement `json:"hashReplacement,omitempty"`
+	// Mask replaces every match with a masked form of it instead: "full"
+	// masks every character with '*', "partial" keeps some of the match, as
+	// in a***@***.com for an email address or ***-***-4567 for a phone
+	// number, and "hash" is the unsalted hashReplacement.
+	Mask string `json:"mask,omitempty"`
+	// Format reformats the JSON or HTML fragment of every match, its first
+	// capture group or else the whole match, instead of replacing it:
+	// "pretty" indents it and "minify" strips its insignificant whitespace.
+	// Fragments are parsed as JSON in JSON
+	// documents and as HTML in HTML ones; matches of other documents, and
+	// those that do not parse, are kept.
+	Format string `json:"format,omitempty"`
+	// PreserveCase writes the replacement in the case of each match: lower,
+	// upper or title case, so that "colour" replaces "Color" with "Colour".
+	// Replacements of matches in mixed case are left as they are.
+	PreserveCase bool `json:"preserveCase,omitempty"`
+	// Replacements holds one replacement per capture group: each match is
+	// replaced with the entry of the first group that took part in it, or
+	// with Replacement when none did.
+	Replacements []string `json:"replacements,omitempty"`
+	// Action is "replace" (the default), "deleteLine", which removes every
+	// line holding a match, terminator included, instead, or "insertBefore"
+	// and "insertAfter", which keep the match and insert the expanded
+	// replacement next to it.
+	Action string `json:"action,omitempty"`
+	// Lookup fetches the replacement of every match from an external service,
+	// falling back to Replacement, or the match itself, when that fails.
+	Lookup *Lookup `json:"lookup,omitempty"`
+	// Transforms enables ${name:args} transform tokens in Replacement.
+	Transforms bool `json:"transforms,omitempty"`
+	// MaxCaptureLen, when positive, skips the matches in which a capture
+	// group spans more than MaxCaptureLen bytes.
+	MaxCaptureLen int `json:"maxCaptureLen,omitempty"`
+	// MaxExpansionRatio, when set, drops the replacements of the filter in a
+	// body, logging it, when they would make it more than MaxExpansionRatio
+	// times as large, as a guard against runaway replacements.
+	MaxExpansionRatio float64 `json:"maxExpansionRatio,omitempty"`
+	// When gates the filter on the headers of the response, such as its
+	// Content-Type along with a custom flag.
+	When *HeaderPredicate `json:"when,omitempty"`
+	// Every, when above 1, only acts upon every Every-th match of a body:
+	// with 2, the second, fourth, sixth and so on.
+	Every int `json:"every,omitempty"`
+	// MaxMatches, when set, only acts upon the first MaxMatches matches of a
+	// response, so that 1 inserts a snippet once. It holds across the pieces
+	// of streamed bodies.
+	MaxMatches int `json:"maxMatches,omitempty"`
+	// OnError says what happens when a template replacement fails: "skip",
+	// the default, keeps the failing matches unchanged, "passthrough" leaves
+	// the body as the filter found it, and "abort" answers with a 502.
+	OnError string `json:"onError,omitempty"`
+	// MaxDataURISize caps, in bytes, the decoded payloads data-uri filters
+	// rewrite; larger ones are left untouched.
+	MaxDataURISize int `json:"maxDataURISize,omitempty"`
+	// Start and End are the regexes delimiting the regions of range filters.
+	// Inclusive replaces the markers along with the region, and
+	// ReplaceUnterminated replaces a region without End up to the end of the
+	// body instead of leaving it untouched.
+	Start               string `json:"start,omitempty"`
+	End                 string `json:"end,omitempty"`
+	Inclusive           bool   `json:"inclusive,omitempty"`
+	ReplaceUnterminated bool   `json:"replaceUnterminated,omitempty"`
+	// SamplePercent, when between 0 and 100 exclusive, applies the filter to
+	// that percentage of the requests only, drawn once per request, or from a
+	// hash of the SampleKeyHeader request header when it is present.
+	SamplePercent   float64 `json:"samplePercent,omitempty"`
+	SampleKeyHeader string  `json:"sampleKeyHeader,omitempty"`
+	// ActiveFrom and ActiveTo, as in "09:00" and "17:30", only apply the
+	// filter from ActiveFrom to ActiveTo each day, in the ActiveTimeZone
+	// location (UTC by default). A window ending before it starts spans
+	// midnight.
+	ActiveFrom     string `json:"activeFrom,omitempty"`
+	ActiveTo       string `json:"activeTo,omitempty"`
+	ActiveTimeZone string `json:"activeTimeZone,omitempty"`
+	// Column is the header of the column csv filters apply to, and
+	// ColumnIndex its 1-based position instead. The first record is the
+	// header and is never filtered. Delimiter separates the fields, a comma
+	// by default.
+	Column      string `json:"column,omitempty"`
+	ColumnIndex int    `json:"columnIndex,omitempty"`
+	Delimiter   string `json:"delimiter,omitempty"`
+	// Path selects the values of yaml filters by their keys in dot notation,
+	// "*" standing for any element of a sequence, as in
+	// "spec.containers.*.image".
+	Path string `json:"path,omitempty"`
+	// WithinTags restricts the filter to the text inside the HTML elements
+	// matching one of its selectors, a tag name optionally followed by
+	// .class and #id parts, as in "td.hostname". WithinTagsAttributes also
+	// applies it to the attribute values of these elements and of the
+	// elements they contain. Responses that are not HTML skip the filter.
+	WithinTags           []string `json:"withinTags,omitempty"`
+	WithinTagsAttributes bool     `json:"withinTagsAttributes,omitempty"`
+	// TextNodesOnly restricts the filter to the text of HTML responses,
+	// leaving tags, attributes, comments, scripts and styles untouched.
+	TextNodesOnly bool `json:"textNodesOnly,omitempty"`
+	// HeadOnly restricts the filter to the head section of HTML responses,
+	// markup included, for meta and title rewrites. A document without a head
+	// start tag has its head implied up to <body>.
+	HeadOnly bool `json:"headOnly,omitempty"`
+	// FirstChunkOnly restricts the filter to the bytes of the first write of
+	// the upstream when the response is streamed, such as the shell of an
+	// HTML page. Buffered responses are filtered as a single chunk.
+	FirstChunkOnly bool `json:"firstChunkOnly,omitempty"`
+	// ApplyTo lists where the filter applies, "body" (the default) and
+	// "headers", in which case it rewrites every value of the response
+	// Headers, or of every header but the framing ones when they are not set.
+	ApplyTo []string `json:"applyTo,omitempty"`
+	Headers []string `json:"headers,omitempty"`
+}
+
+type filter struct {
+	regex       *regexp.Regexp
+	replacement []byte
+	// replacements, when set, holds the replacement of each capture group.
+	replacements [][]byte
+	hash         *hasher
+	lookup       *lookup
+	// mask, when set, is the mode matches are masked with.
+	mask string
+	// format, when set, is the mode matched fragments are reformatted with.
+	format string
+	// preserveCase writes replacements in the case of their match.
+	preserveCase bool
+	// hosts, when set, maps the lowercased match to its replacement.
+	hosts    map[string]string
+	template *replacementTemplate
+	// literal disables capture group expansion in replacement.
+	literal    bool
+	goTemplate *goTemplate
+	// cssURL, when set, is applied to the URL of every url() token matched
+	// by regex.
+	cssURL *filter
+	// dataURI, when set, is applied to the payload of every data URI matched
+	// by regex.
+	dataURI *dataURIFilter
+	// rng, when set, replaces ranges instead of matches of regex.
+	rng *rangeFilter
+	// bytes, when set, replaces a byte sequence instead of matches of regex.
+	bytes *bytesFilter
+	// csv, when set, applies its inner filter to a column of a CSV body.
+	csv *csvFilter
+	// yaml, when set, applies its inner filter to values of YAML documents.
+	yaml *yamlFilter
+	// within, when set, applies its inner filter inside some HTML elements.
+	within *withinTags
+	// head, when set, applies its inner filter to the head of HTML bodies.
+	head *headOnly
+	// firstChunk restricts the filter to the first write of streamed bodies.
+	firstChunk bool
+	// action is what happens to matches: they are replaced, their lines
+	// deleted, or the replacement inserted before or after them.
+	action string
+	// captureLimit, when set, rejects matches with oversized capture groups.
+	captureLimit *captureLimit
+	// every, when above 1, only keeps every every-th accepted match.
+	every int
+	// maxMatches, when set, is the number of matches of a response acted
+	// upon, counted in the scope.
+	maxMatches int
+	// maxExpansion, when set, is the largest ratio of the output of the
+	// filter to its input.
+	maxExpansion float64
+	// onError, when set, is the passthrough or abort policy for the
+	// replacements that fail to render.
+	onError string
+	// accept, when set, rejects matches based on their surroundings.
+	accept func(src []byte, start, end int) bool
+	// when, when set, restricts the filter to the responses it holds for.
+	when *headerPredicate
+	// rollout, when set, applies the filter to a sample of the requests.
+	rollout *rollout
+	// window, when set, applies the filter at some times of day only.
+	window *activeWindow
+	// targets says whether the filter applies to the body and headers.
+	targets targets
+	// def is the definition the filter was compiled from.
+	def *Filter
+}
+
+// apply runs the action of the filter on every match in b.
+func (f *filter) apply(b []byte, sc *scope) []byte {
+	if !f.rollout.include(sc) || !f.window.active(sc) {
+		return b
+	}
+
+	if f.firstChunk && sc != nil && sc.streamed && sc.firstChunkLeft < len(b) {
+		return f.applyFirstChunk(b, sc)
+	}
+
+	if sc != nil && sc.autoScope && !sc.scoping && f.autoScoped() {
+		return f.applyAutoScope(b, sc)
+	}
+
+	if f.within != nil {
+		return f.within.apply(b, sc)
+	}
+
+	if f.head != nil {
+		return f.head.apply(b, sc)
+	}
+
+	if f.rng != nil {
+		return f.rng.apply(b, sc)
+	}
+
+	if f.bytes != nil {
+		return f.bytes.apply(b, sc)
+	}
+
+	if f.csv != nil {
+		return f.csv.apply(b, sc)
+	}
+
+	if f.yaml != nil {
+		return f.yaml.apply(b, sc)
+	}
+
+	matches := f.actedMatches(b, sc)
+	if matches == nil {
+		return b
+	}
+
+	if f.action == actionDeleteLine {
+		for _, m := range matches {
+			sc.countMatch(f.def)
+			sc.reportMatch(f.def, b, m[0], m[1])
+		}
+
+		return deleteLines(b, matches)
+	}
+
+	out := make([]byte, 0, len(b))
+	last := 0
+	counted := 0
+
+	if sc != nil {
+		counted = sc.matches
+	}
+
+	// applied holds the matches replaced, reported once the filter is sure
+	// to keep its output.
+	var applied [][]int
+
+	for _, m := range matches {
+		counts := f.cssURL == nil && f.dataURI == nil
+		if counts {
+			sc.countMatch(f.def)
+		}
+
+		out = append(out, b[last:m[0]]...)
+		n := len(out)
+
+		if out = f.act(out, b, m, sc); counts && f.unchanged(out[n:], b, m) {
+			sc.discountMatch(f.def)
+		} else if counts {
+			applied = append(applied, m)
+		}
+
+		last = m[1]
+	}
+
+	out = append(out, b[last:]...)
+
+	if err := sc.takeFailure(); err != nil {
+		out = f.failed(b, out, err, sc, counted)
+	} else if f.expanded(b, out) {
+		if sc != nil {
+			sc.matches = counted
+		}
+
+		out = b
+	}
+
+	if sc != nil && sc.matches > counted {
+		for _, m := range applied {
+			sc.reportMatch(f.def, b, m[0], m[1])
+		}
+	}
+
+	return out
+}
+
+// actedMatches returns the matches of the regex of f in b to act upon: those
+// accepted, every f.every-th one of them, up to the limit of f.
+func (f *filter) actedMatches(b []byte, sc *scope) [][]int {
+	matches := f.regex.FindAllSubmatchIndex(b, -1)
+	if matches == nil {
+		return nil
+	}
+
+	// n counts the accepted matches, of which only every f.every-th one is
+	// acted upon, and at most left of them.
+	n := 0
+	left := sc.matchesLeft(f)
+	acted := matches[:0]
+
+	for _, m := range matches {
+		if left == 0 {
+			break
+		}
+
+		if !f.acceptMatch(b, m) {
+			continue
+		}
+
+		if n++; f.every > 1 && n%f.every != 0 {
+			continue
+		}
+
+		sc.useMatch(f)
+		left--
+		acted = append(acted, m)
+	}
+
+	if len(acted) == 0 {
+		return nil
+	}
+
+	return acted
+}
+
+// act appends what the match m of src becomes to dst: its replacement, or
+// the match along with the replacement inserted next to it.
+func (f *filter) act(dst, src []byte, m []int, sc *scope) []byte {
+	switch f.action {
+	case actionInsertBefore:
+		return append(f.insert(dst, src, m, sc), src[m[0]:m[1]]...)
+	case actionInsertAfter:
+		return f.insert(append(dst, src[m[0]:m[1]]...), src, m, sc)
+	default:
+		return f.replace(dst, src, m, sc)
+	}
+}
+
+// unchanged reports whether acting upon the match m of src produced done,
+// the match itself: a replacement identical to it, as transforms may yield,
+// is no replacement at all.
+func (f *filter) unchanged(done, src []byte, m []int) bool {
+	return f.action != actionInsertBefore && f.action != actionInsertAfter && bytes.Equal(done, src[m[0]:m[1]])
+}
+
+// replace appends the replacement of the match m of src to dst, in the case
+// of the match when f preserves it.
+func (f *filter) replace(dst, src []byte, m []int, sc *scope) []byte {
+	if !f.preserveCase {
+		return f.expand(dst, src, m, sc)
+	}
+
+	return append(dst, matchCase(src[m[0]:m[1]], f.expand(nil, src, m, sc))...)
+}
+
+// acceptMatch reports whether the match m of b should be acted upon.
+func (f *filter) acceptMatch(b []byte, m []int) bool {
+	if f.accept != nil && !f.accept(b, m[0], m[1]) {
+		return false
+	}
+
+	return f.captureLimit.allow(m)
+}
+
+// expand appends the replacement for the match m of src to dst.
+func (f *filter) expand(dst, src []byte, m []int, sc *scope) []byte {
+	if f.cssURL != nil {
+		return expandCSSURL(dst, f.cssURL, src, m, sc)
+	}
+
+	if f.dataURI != nil {
+		return f.dataURI.expand(dst, src, m, sc)
+	}
+
+	if f.hash != nil {
+		return append(dst, f.hash.replace(src[m[0]:m[1]])...)
+	}
+
+	if f.mask != "" {
+		return append(dst, maskMatch(f.mask, src[m[0]:m[1]])...)
+	}
+
+	if f.format != "" {
+		contentType := ""
+		if sc != nil {
+			contentType = sc.contentType
+		}
+
+		// The first capture group, when there is one, holds the fragment.
+		start, end := m[0], m[1]
+		if len(m) > 3 && m[2] >= 0 {
+			start, end = m[2], m[3]
+		}
+
+		dst = append(dst, src[m[0]:start]...)
+		dst = append(dst, formatFragment(f.format, contentType, src[start:end])...)
+
+		return append(dst, src[end:m[1]]...)
+	}
+
+	if f.hosts != nil {
+		return append(dst, f.hosts[strings.ToLower(string(src[m[0]:m[1]]))]...)
+	}
+
+	if f.lookup != nil {
+		if v, ok := f.lookup.resolve(sc.context(), string(src[m[0]:m[1]])); ok {
+			return append(dst, v...)
+		}
+
+		if len(f.replacement) == 0 {
+			return append(dst, src[m[0]:m[1]]...)
+		}
+	}
+
+	if f.template != nil {
+		return f.template.expand(dst, f.regex, src, m, sc)
+	}
+
+	if f.literal {
+		return append(dst, f.replacement...)
+	}
+
+	if f.goTemplate != nil {
+		return f.goTemplate.expand(dst, f.regex, src, m, sc)
+	}
+
+	return f.regex.Expand(dst, f.branchReplacement(m), src, m)
+}
+
+// branchReplacement returns the replacement for the match m: the one of the
+// first capture group that matched, if any, or the default one.
+func (f *filter) branchReplacement(m []int) []byte {
+	for i, r := range f.replacements {
+		if m[2*i+2] >= 0 {
+			return r
+		}
+	}
+
+	return f.replacement
+}
+
+// compileReplacements checks that the replacements of f fit regex and returns
+// them.
+func compileReplacements(f Filter, typ string, regex *regexp.Regexp) ([][]byte, error) {
+	if len(f.Replacements) == 0 {
+		return nil, nil
+	}
+
+	switch {
+	case typ != filterTypeRegex:
+		return nil, fmt.Errorf("%s filters do not support replacements", typ)
+	case f.HashReplacement != nil || f.Lookup != nil || f.Transforms:
+		return nil, errors.New("replacements cannot be combined with hashReplacement, lookup or transforms")
+	case len(f.Replacements) > regex.NumSubexp():
+		return nil, fmt.Errorf("%d replacements for %d capture groups", len(f.Replacements), regex.NumSubexp())
+	}
+
+	replacements := make([][]byte, len(f.Replacements))
+	for i, r := range f.Replacements {
+		replacements[i] = []byte(r)
+	}
+
+	return replacements, nil
+}
+
+// compileFilters compiles the filter definitions. Any invalid filter is an
+// error, so that a broken configuration is refused rather than half applied.
+func compileFilters(defs []Filter) ([]filter, error) {
+	filters := make([]filter, 0, len(defs))
+
+	for i, f := range defs {
+		compiled, err := compileFilter(i, f)
+		if err != nil {
+			return nil, &FilterError{Index: i, Err: err}
+		}
+
+		filters = append(filters, compiled)
+	}
+
+	return filters, nil
+}
+
+// compileFilter compiles the definition of the i-th filter.
+func compileFilter(i int, f Filter) (filter, error) {
+	if f.ReplacementFile != "" {
+		if err := loadReplacementFile(&f); err != nil {
+			return filter{}, err
+		}
+	}
+
+	pattern := f.Regex
+
+	var accept func([]byte, int, int) bool
+
+	typ, err := filterType(f)
+	if err != nil {
+		return filter{}, err
+	}
+
+	if err := checkOptions(f, typ); err != nil {
+		return filter{}, err
+	}
+
+	ro, err := compileRollout(f)
+	if err != nil {
+		return filter{}, err
+	}
+
+	window, err := compileActiveWindow(f)
+	if err != nil {
+		return filter{}, err
+	}
+
+	tg, err := compileTargets(f)
+	if err != nil {
+		return filter{}, err
+	}
+
+	when, err := compileHeaderPredicate(f.When)
+	if err != nil {
+		return filter{}, err
+	}
+
+	if typ == filterTypeRange {
+		rf, err := compileRange(f)
+		if err != nil {
+			return filter{}, err
+		}
+
+		rf.def = &f
+
+		return filter{rng: rf, rollout: ro, window: window, targets: tg, def: &f}, nil
+	}
+
+	if typ == filterTypeBytes {
+		bf, err := compileBytes(f)
+		if err != nil {
+			return filter{}, err
+		}
+
+		bf.def = &f
+
+		return filter{bytes: bf, rollout: ro, window: window, targets: tg, def: &f}, nil
+	}
+
+	if typ == filterTypeGlob {
+		pattern = globRegex(f.Regex)
+	}
+
+	if f.Preset != "" {
+		p, err := lookupPreset(f)
+		if err != nil {
+			return filter{}, err
+		}
+
+		pattern, accept = p.pattern, p.accept
+	}
+
+	regex, err := regexp.Compile(pattern)
+	if err != nil {
+		return filter{}, fmt.Errorf("error compiling regex %q: %w", pattern, err)
+	}
+
+	newFilter := filter{
+		def:          &f,
+		regex:        regex,
+		replacement:  []byte(f.Replacement),
+		literal:      typ == filterTypeGlob || f.ReplacementFile != "",
+		accept:       accept,
+		preserveCase: f.PreserveCase,
+	}
+
+	if f.MaxCaptureLen > 0 {
+		newFilter.captureLimit = &captureLimit{max: f.MaxCaptureLen}
+	}
+
+	if f.Every < 0 {
+		return filter{}, fmt.Errorf("invalid every %d", f.Every)
+	}
+
+	newFilter.every = f.Every
+
+	if f.MaxMatches < 0 {
+		return filter{}, fmt.Errorf("invalid maxMatches %d", f.MaxMatches)
+	}
+
+	newFilter.maxMatches = f.MaxMatches
+
+	if f.MaxExpansionRatio != 0 && f.MaxExpansionRatio < 1 {
+		return filter{}, fmt.Errorf("invalid maxExpansionRatio %g: must be at least 1", f.MaxExpansionRatio)
+	}
+
+	newFilter.maxExpansion = f.MaxExpansionRatio
+
+	if newFilter.onError, err = parseOnError(f, typ); err != nil {
+		return filter{}, err
+	}
+
+	if newFilter.action, err = parseAction(f, typ); err != nil {
+		return filter{}, err
+	}
+
+	if newFilter.replacements, err = compileReplacements(f, typ, regex); err != nil {
+		return filter{}, err
+	}
+
+	if f.HashReplacement != nil {
+		newFilter.hash, err = newHasher(f.HashReplacement)
+		if err != nil {
+			return filter{}, err
+		}
+	}
+
+	if newFilter.mask, err = compileMask(f, &newFilter); err != nil {
+		return filter{}, err
+	}
+
+	if newFilter.format, err = compileFormat(f); err != nil {
+		return filter{}, err
+	}
+
+	if f.Lookup != nil {
+		if f.HashReplacement != nil {
+			return filter{}, errors.New("lookup and hashReplacement are mutually exclusive")
+		}
+
+		newFilter.lookup, err = newLookup(f.Lookup)
+		if err != nil {
+			return filter{}, err
+		}
+	}
+
+	if typ == filterTypeTemplate {
+		newFilter.goTemplate, err = parseGoTemplate(i, f.Replacement)
+		if err != nil {
+			return filter{}, err
+		}
+
+		newFilter.goTemplate.reportErrors = newFilter.onError != ""
+	}
+
+	if f.Transforms {
+		newFilter.template, err = parseReplacementTemplate(f.Replacement)
+		if err != nil {
+			return filter{}, err
+		}
+	}
+
+	if typ == filterTypeCSSURL {
+		inner := newFilter
+		newFilter = filter{regex: cssURLRegex, cssURL: &inner, def: &f}
+	}
+
+	if typ == filterTypeDataURI {
+		inner := newFilter
+
+		du, err := compileDataURI(f, &inner)
+		if err != nil {
+			return filter{}, err
+		}
+
+		newFilter = filter{regex: dataURIRegex, dataURI: du, def: &f}
+	}
+
+	if typ == filterTypeCSV {
+		inner := newFilter
+
+		cf, err := compileCSV(f, &inner)
+		if err != nil {
+			return filter{}, err
+		}
+
+		newFilter = filter{csv: cf, def: &f}
+	}
+
+	if typ == filterTypeYAML {
+		inner := newFilter
+
+		yf, err := compileYAML(f, &inner)
+		if err != nil {
+			return filter{}, err
+		}
+
+		newFilter = filter{yaml: yf, def: &f}
+	}
+
+	if len(f.WithinTags) > 0 || f.TextNodesOnly {
+		inner := newFilter
+
+		wt, err := compileWithinTags(f, &inner)
+		if err != nil {
+			return filter{}, err
+		}
+
+		newFilter = filter{within: wt, def: &f}
+	}
+
+	if f.HeadOnly {
+		inner := newFilter
+		newFilter = filter{head: &headOnly{inner: &inner}, def: &f}
+	}
+
+	newFilter.firstChunk = f.FirstChunkOnly
+	newFilter.rollout = ro
+	newFilter.targets = tg
+	newFilter.when = when
+	newFilter.window = window
+
+	return newFilter, nil
+}
+
+// loadReplacementFile sets the replacement of f to the contents of its
+// ReplacementFile.
+func loadReplacementFile(f *Filter) error {
+	switch {
+	case f.Replacement != "":
+		return errors.New("replacementFile and replacement are mutually exclusive")
+	case f.Transforms || len(f.Replacements) > 0 || strings.EqualFold(f.Type, filterTypeTemplate):
+		return errors.New("replacementFile cannot be combined with transforms, replacements or the template type")
+	}
+
+	b, err := ioutil.ReadFile(f.ReplacementFile)
+	if err != nil {
+		return fmt.Errorf("error reading replacementFile: %w", err)
+	}
+
+	f.Replacement = string(b)
+
+	return nil
+}
+
+// filterType returns the normalized type of f, rejecting unknown types and
+// options that only make sense for plain regexps.
+func filterType(f Filter) (string, error) {
+	typ := strings.ToLower(f.Type)
+
+	switch typ {
+	case "", filterTypeRegex:
+		return filterTypeRegex, nil
+	case filterTypeCSSURL:
+		if f.Every > 1 {
+			return "", fmt.Errorf("%s filters do not support every", typ)
+		}
+
+		return typ, nil
+	case filterTypeDataURI:
+		return typ, nil
+	case filterTypeCSV, filterTypeYAML:
+		switch {
+		case strings.EqualFold(f.Action, actionDeleteLine):
+			return "", fmt.Errorf("%s filters do not support action %q", typ, f.Action)
+		case f.Every > 1:
+			return "", fmt.Errorf("%s filters do not support every", typ)
+		}
+
+		return typ, nil
+	case filterTypeRange, filterTypeBytes:
+		if action := strings.ToLower(f.Action); action != "" && action != actionReplace {
+			return "", fmt.Errorf("%s filters do not support action %q", typ, f.Action)
+		}
+
+		if f.Every > 1 {
+			return "", fmt.Errorf("%s filters do not support every", typ)
+		}
+
+		if len(f.WithinTags) > 0 || f.TextNodesOnly {
+			return "", fmt.Errorf("%s filters do not support withinTags nor textNodesOnly", typ)
+		}
+
+		return typ, nil
+	case filterTypeGlob, filterTypeTemplate:
+		switch {
+		case f.Transforms:
+			return "", fmt.Errorf("%s filters do not support transforms", typ)
+		case f.HashReplacement != nil:
+			return "", fmt.Errorf("%s filters do not support hashReplacement", typ)
+		case typ == filterTypeGlob && f.Preset != "":
+			return "", fmt.Errorf("%s filters do not support presets", typ)
+		}
+
+		return typ, nil
+	default:
+		return "", fmt.Errorf("unknown type %q: must be %q, %q, %q, %q, %q, %q, %q, %q or %q", f.Type, filterTypeRegex,
+			filterTypeGlob, filterTypeTemplate, filterTypeCSSURL, filterTypeDataURI, filterTypeRange, filterTypeBytes,
+			filterTypeCSV, filterTypeYAML)
+	}
+}
+
+// checkOptions rejects the options of f that would be silently ignored: those
+// of other filter types, and replacements a replacement option overrides.
+func checkOptions(f Filter, typ string) error {
+	switch {
+	case typ != filterTypeRange && (f.Start != "" || f.End != "" || f.Inclusive || f.ReplaceUnterminated):
+		return fmt.Errorf("start, end, inclusive and replaceUnterminated require type %q", filterTypeRange)
+	case typ != filterTypeCSV && (f.Column != "" || f.ColumnIndex != 0 || f.Delimiter != ""):
+		return fmt.Errorf("column, columnIndex and delimiter require type %q", filterTypeCSV)
+	case typ != filterTypeYAML && f.Path != "":
+		return fmt.Errorf("path requires type %q", filterTypeYAML)
+	case typ != filterTypeDataURI && f.MaxDataURISize != 0:
+		return fmt.Errorf("maxDataURISize requires type %q", filterTypeDataURI)
+	case f.WithinTagsAttributes && len(f.WithinTags) == 0:
+		return errors.New("withinTagsAttributes requires withinTags")
+	case f.HeadOnly && typ != filterTypeRegex && typ != filterTypeGlob && typ != filterTypeTemplate &&
+		typ != filterTypeCSSURL && typ != filterTypeDataURI:
+		return fmt.Errorf("%s filters do not support headOnly", typ)
+	case f.HeadOnly && (len(f.WithinTags) > 0 || f.TextNodesOnly):
+		return errors.New("headOnly cannot be combined with withinTags nor textNodesOnly")
+	case f.TextNodesOnly && len(f.WithinTags) > 0:
+		return errors.New("textNodesOnly cannot be combined with withinTags, which only filters text already")
+	case (typ == filterTypeRange || typ == filterTypeBytes) && f.MaxCaptureLen > 0:
+		return fmt.Errorf("%s filters do not support maxCaptureLen", typ)
+	case (typ == filterTypeRange || typ == filterTypeBytes) && f.MaxMatches > 0:
+		return fmt.Errorf("%s filters do not support maxMatches", typ)
+	case (typ == filterTypeRange || typ == filterTypeBytes) && f.MaxExpansionRatio != 0:
+		return fmt.Errorf("%s filters do not support maxExpansionRatio", typ)
+	case f.HashReplacement != nil && (f.Replacement != "" || f.Transforms):
+		return errors.New("hashReplacement cannot be combined with replacement or transforms")
+	case f.Mask != "" && typ != filterTypeRegex && typ != filterTypeGlob && typ != filterTypeCSV &&
+		typ != filterTypeYAML:
+		return fmt.Errorf("%s filters do not support mask", typ)
+	case f.Mask != "" && (f.Replacement != "" || len(f.Replacements) > 0 || f.HashReplacement != nil ||
+		f.Lookup != nil || f.Transforms):
+		return errors.New("mask cannot be combined with replacement, replacements, hashReplacement, lookup or transforms")
+	case f.Format != "" && typ != filterTypeRegex && typ != filterTypeGlob:
+		return fmt.Errorf("%s filters do not support format", typ)
+	case f.Format != "" && (f.Replacement != "" || len(f.Replacements) > 0 || f.HashReplacement != nil ||
+		f.Mask != "" || f.Lookup != nil || f.Transforms || f.PreserveCase):
+		return errors.New("format cannot be combined with replacement, replacements, hashReplacement, mask, lookup, " +
+			"transforms nor preserveCase")
+	case f.PreserveCase && (typ == filterTypeRange || typ == filterTypeBytes || f.Mask != "" ||
+		f.HashReplacement != nil || strings.EqualFold(f.Action, actionDeleteLine)):
+		return errors.New("preserveCase is not supported by range, bytes, mask, hashReplacement nor deleteLine filters")
+	case strings.EqualFold(f.Action, actionDeleteLine) && (f.Replacement != "" || len(f.Replacements) > 0):
+		return fmt.Errorf("action %q cannot be combined with replacement or replacements", f.Action)
+	}
+
+	return nil
+}
+
+// applyFilters runs every filter over b in order, or over b independently
+// of each other when the scope says so.
+func applyFilters(filters []filter, b []byte, sc *scope) []byte {
+	if sc != nil && sc.independent {
+		return applyIndependent(filters, b, sc)
+	}
+
+	for i := range filters {
+		if filters[i].targets.skipBody {
+			continue
+		}
+
+		if sc == nil {
+			b = filters[i].apply(b, nil)
+
+			continue
+		}
+
+		matches := sc.matches
+		if b = sc.timeFilter(&filters[i], b); sc.matches > matches {
+			sc.markApplied(&filters[i])
+		}
+	}
+
+	return b
+}
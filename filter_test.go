@@ -0,0 +1,347 @@
+package subfilter
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReplacements(t *testing.T) {
+	tests := []struct {
+		desc       string
+		filter     Filter
+		resBody    string
+		expResBody string
+	}{
+		{
+			desc:       "should replace each branch with its replacement",
+			filter:     Filter{Regex: "(foo)|(bar)", Replacements: []string{"X", "Y"}},
+			resBody:    "foo bar baz foo",
+			expResBody: "X Y baz X",
+		},
+		{
+			desc:       "should expand capture groups in the chosen replacement",
+			filter:     Filter{Regex: `(http)://(\S+)|(ftp)://(\S+)`, Replacements: []string{"https://$2", "", "sftp://$4"}},
+			resBody:    "http://a ftp://b",
+			expResBody: "https://a sftp://b",
+		},
+		{
+			desc:       "should fall back to replacement when no listed group matched",
+			filter:     Filter{Regex: "(foo)|bar", Replacement: "Z", Replacements: []string{"X"}},
+			resBody:    "foo bar",
+			expResBody: "X Z",
+		},
+		{
+			desc:       "should use the first group that matched",
+			filter:     Filter{Regex: "((foo))", Replacements: []string{"outer", "inner"}},
+			resBody:    "foo",
+			expResBody: "outer",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			got := serveTransform(t, test.filter, test.resBody)
+			if got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+		})
+	}
+}
+
+func TestReplacementsInvalid(t *testing.T) {
+	for _, f := range []Filter{
+		{Regex: "(foo)", Replacements: []string{"X", "Y"}},
+		{Type: "glob", Regex: "foo", Replacements: []string{"X"}},
+		{Regex: "(foo)", Replacements: []string{"X"}, Transforms: true},
+		{Regex: "(foo)", Replacements: []string{"X"}, HashReplacement: &HashReplacement{Salt: "s"}},
+	} {
+		config := CreateConfig()
+		config.Filters = []Filter{f}
+
+		if _, err := New(context.Background(), nil, config, "subfilter"); err == nil {
+			t.Errorf("expected error for filter %+v", f)
+		}
+	}
+}
+
+func TestConflictingOptions(t *testing.T) {
+	tests := []struct {
+		desc   string
+		filter Filter
+		expErr string
+	}{
+		{
+			desc:   "should reject range options on other types",
+			filter: Filter{Regex: "foo", End: "bar"},
+			expErr: `start, end, inclusive and replaceUnterminated require type "range"`,
+		},
+		{
+			desc:   "should reject csv options on other types",
+			filter: Filter{Regex: "foo", Delimiter: ";"},
+			expErr: `column, columnIndex and delimiter require type "csv"`,
+		},
+		{
+			desc:   "should reject a path on other types",
+			filter: Filter{Type: "csv", Column: "a", Regex: "foo", Path: "a.b"},
+			expErr: `path requires type "yaml"`,
+		},
+		{
+			desc:   "should reject withinTagsAttributes without withinTags",
+			filter: Filter{Regex: "foo", WithinTagsAttributes: true},
+			expErr: "withinTagsAttributes requires withinTags",
+		},
+		{
+			desc:   "should reject maxCaptureLen on bytes filters",
+			filter: Filter{Type: "bytes", Regex: "0a", MaxCaptureLen: 3},
+			expErr: "bytes filters do not support maxCaptureLen",
+		},
+		{
+			desc:   "should reject a replacement overridden by hashReplacement",
+			filter: Filter{Regex: "foo", Replacement: "bar", HashReplacement: &HashReplacement{Salt: "s"}},
+			expErr: "hashReplacement cannot be combined with replacement or transforms",
+		},
+		{
+			desc:   "should reject transforms overridden by hashReplacement",
+			filter: Filter{Regex: "foo", Transforms: true, HashReplacement: &HashReplacement{Salt: "s"}},
+			expErr: "hashReplacement cannot be combined with replacement or transforms",
+		},
+		{
+			desc:   "should reject a replacement of deleted lines",
+			filter: Filter{Regex: "foo", Replacement: "bar", Action: "deleteLine"},
+			expErr: `action "deleteLine" cannot be combined with replacement or replacements`,
+		},
+		{
+			desc:   "should reject replacements of deleted lines",
+			filter: Filter{Regex: "(foo)", Replacements: []string{"bar"}, Action: "deleteLine"},
+			expErr: `action "deleteLine" cannot be combined with replacement or replacements`,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			_, err := compileFilters([]Filter{test.filter})
+			if err == nil || !strings.Contains(err.Error(), test.expErr) {
+				t.Errorf("got error %v, want %q", err, test.expErr)
+			}
+		})
+	}
+}
+
+func TestMaxCaptureLen(t *testing.T) {
+	tests := []struct {
+		desc       string
+		filter     Filter
+		resBody    string
+		expResBody string
+	}{
+		{
+			desc:       "should skip a match with an oversized capture",
+			filter:     Filter{Regex: `<b>(.*)</b>`, Replacement: "<strong>$1</strong>", MaxCaptureLen: 5},
+			resBody:    "<b>a very long span</b>",
+			expResBody: "<b>a very long span</b>",
+		},
+		{
+			desc:       "should replace matches within the limit",
+			filter:     Filter{Regex: `<b>([^<]*)</b>`, Replacement: "<strong>$1</strong>", MaxCaptureLen: 5},
+			resBody:    "<b>short</b> <b>much longer</b>",
+			expResBody: "<strong>short</strong> <b>much longer</b>",
+		},
+		{
+			desc:       "should ignore groups that did not take part in the match",
+			filter:     Filter{Regex: `(x{10})|(y)`, Replacement: "z", MaxCaptureLen: 1},
+			resBody:    "y",
+			expResBody: "z",
+		},
+		{
+			desc:       "should skip lines with an oversized capture when deleting lines",
+			filter:     Filter{Regex: `debug: (.*)`, Action: "deleteLine", MaxCaptureLen: 3},
+			resBody:    "debug: ok\ndebug: too long\n",
+			expResBody: "debug: too long\n",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			got := serveTransform(t, test.filter, test.resBody)
+			if got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+		})
+	}
+}
+
+func TestEvery(t *testing.T) {
+	tests := []struct {
+		desc       string
+		filter     Filter
+		resBody    string
+		expResBody string
+	}{
+		{
+			desc:       "should replace every second match",
+			filter:     Filter{Regex: `x`, Replacement: "y", Every: 2},
+			resBody:    "x x x x x x",
+			expResBody: "x y x y x y",
+		},
+		{
+			desc:       "should replace every third match",
+			filter:     Filter{Regex: `\d`, Replacement: "#", Every: 3},
+			resBody:    "1 2 3 4 5 6",
+			expResBody: "1 2 # 4 5 #",
+		},
+		{
+			desc:       "should replace every match with 1",
+			filter:     Filter{Regex: `x`, Replacement: "y", Every: 1},
+			resBody:    "x x x",
+			expResBody: "y y y",
+		},
+		{
+			desc:       "should count the accepted matches only",
+			filter:     Filter{Regex: `<b>([^<]*)</b>`, Replacement: "[$1]", MaxCaptureLen: 1, Every: 2},
+			resBody:    "<b>a</b><b>long</b><b>b</b><b>c</b>",
+			expResBody: "<b>a</b><b>long</b>[b]<b>c</b>",
+		},
+		{
+			desc:       "should delete every second line",
+			filter:     Filter{Regex: `(?m)^row`, Action: "deleteLine", Every: 2},
+			resBody:    "row 1\nrow 2\nrow 3\nrow 4\n",
+			expResBody: "row 1\nrow 3\n",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			got := serveTransform(t, test.filter, test.resBody)
+			if got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+		})
+	}
+}
+
+func TestReplacementFile(t *testing.T) {
+	footer := filepath.Join(t.TempDir(), "footer.html")
+	if err := ioutil.WriteFile(footer, []byte("<footer>$1 &copy; Corp</footer>\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	got := serveTransform(t, Filter{Regex: "(</body>)", ReplacementFile: footer, Action: "insertBefore"}, "<body>foo</body>")
+	if exp := "<body>foo<footer>$1 &copy; Corp</footer>\n</body>"; got != exp {
+		t.Errorf("got body %q, want %q", got, exp)
+	}
+}
+
+func TestReplacementFileConfig(t *testing.T) {
+	footer := filepath.Join(t.TempDir(), "footer.html")
+	if err := ioutil.WriteFile(footer, []byte("<footer/>"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		desc   string
+		filter Filter
+	}{
+		{desc: "should reject a missing file", filter: Filter{Regex: "foo", ReplacementFile: footer + ".missing"}},
+		{desc: "should reject replacement", filter: Filter{Regex: "foo", Replacement: "bar", ReplacementFile: footer}},
+		{desc: "should reject transforms", filter: Filter{Regex: "foo", Transforms: true, ReplacementFile: footer}},
+		{desc: "should reject bytes filters", filter: Filter{Type: "bytes", Regex: "00", ReplacementFile: footer}},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			if _, err := compileFilters([]Filter{test.filter}); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestIdenticalReplacements(t *testing.T) {
+	tests := []struct {
+		desc            string
+		cascade         bool
+		resBody         string
+		expResBody      string
+		expModified     bool
+		expReplacements int
+		expWarning      string
+	}{
+		{
+			desc:       "should not count replacements identical to their match",
+			resBody:    "already lower",
+			expResBody: "already lower",
+		},
+		{
+			desc:       "should not count identical cascaded replacements",
+			cascade:    true,
+			resBody:    "already lower",
+			expResBody: "already lower",
+		},
+		{
+			desc:            "should only count the replacements changing the body",
+			resBody:         "Hello world",
+			expResBody:      "hello world",
+			expModified:     true,
+			expReplacements: 1,
+			expWarning:      `214 subfilter "Transformation Applied"`,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.Filters = []Filter{{Regex: "[A-Za-z]+", Replacement: "${lower:0}", Transforms: true}}
+			config.IndependentFilters = !test.cascade
+			config.AddTransformationWarning = true
+
+			var summaries []RewriteSummary
+
+			next := func(w http.ResponseWriter, _ *http.Request) {
+				_, _ = w.Write([]byte(test.resBody))
+			}
+
+			opts := Options{OnRewrite: func(summary RewriteSummary) { summaries = append(summaries, summary) }}
+
+			handler, err := NewWithOptions(context.Background(), http.HandlerFunc(next), config, "subfilter", opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if got := recorder.Body.String(); got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+
+			if got := recorder.Header().Get("Warning"); got != test.expWarning {
+				t.Errorf("got Warning %q, want %q", got, test.expWarning)
+			}
+
+			if len(summaries) != 1 {
+				t.Fatalf("got %d summaries, want 1", len(summaries))
+			}
+
+			if summaries[0].Modified != test.expModified || summaries[0].Replacements != test.expReplacements {
+				t.Errorf("got modified %v with %d replacements, want %v with %d", summaries[0].Modified,
+					summaries[0].Replacements, test.expModified, test.expReplacements)
+			}
+
+			stats := handler.Stats()
+			if modified := stats.Modified == 1; modified != test.expModified {
+				t.Errorf("got %d modified responses, want modified %v", stats.Modified, test.expModified)
+			}
+		})
+	}
+}
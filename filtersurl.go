@@ -0,0 +1,154 @@
+package subfilter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	filtersURLFail  = "fail"
+	filtersURLEmpty = "empty"
+
+	defaultFiltersURLTimeout = 5 * time.Second
+
+	// maxFiltersURLSize bounds the filter list read from FiltersURL.
+	maxFiltersURLSize = 1 << 20
+)
+
+// setupFiltersURL fetches the filters served at FiltersURL, which are
+// compiled after the top-level filters.
+func (s *SubFilter) setupFiltersURL(config *Config) error {
+	if config.FiltersURL == "" {
+		return nil
+	}
+
+	policy := strings.ToLower(config.OnFiltersURLError)
+	switch policy {
+	case "":
+		policy = filtersURLFail
+	case filtersURLFail, filtersURLEmpty:
+	default:
+		return fmt.Errorf("invalid onFiltersURLError %q: must be %q or %q", config.OnFiltersURLError, filtersURLFail, filtersURLEmpty)
+	}
+
+	timeout, err := parseDurationDefault(config.FiltersURLTimeout, defaultFiltersURLTimeout)
+	if err != nil {
+		return fmt.Errorf("invalid filtersURLTimeout: %w", err)
+	}
+
+	if config.FiltersURLRefresh != "" {
+		if s.filtersRefresh, err = parseDurationDefault(config.FiltersURLRefresh, 0); err != nil {
+			return fmt.Errorf("invalid filtersURLRefresh: %w", err)
+		}
+	}
+
+	s.filtersURL = config.FiltersURL
+	s.filtersClient = &http.Client{Timeout: timeout}
+
+	filters, err := s.fetchFilters(context.Background())
+	if err == nil {
+		_, err = compileFilters(filters)
+	}
+
+	switch {
+	case err == nil:
+		s.remoteFilters = filters
+	case policy == filtersURLFail:
+		return fmt.Errorf("unable to load filtersURL: %w", err)
+	default:
+		log.Printf("unable to load filtersURL, starting without its filters: %v", err)
+	}
+
+	return nil
+}
+
+// fetchFilters returns the JSON list of filters served at filtersURL.
+func (s *SubFilter) fetchFilters(ctx context.Context) ([]Filter, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.filtersURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Accept", "application/json")
+
+	res, err := s.filtersClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() { _ = res.Body.Close() }()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", res.StatusCode)
+	}
+
+	b, err := ioutil.ReadAll(io.LimitReader(res.Body, maxFiltersURLSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("could not read filters: %w", err)
+	}
+
+	if len(b) > maxFiltersURLSize {
+		return nil, fmt.Errorf("filters larger than %d bytes", maxFiltersURLSize)
+	}
+
+	var filters []Filter
+	if err := json.Unmarshal(b, &filters); err != nil {
+		return nil, fmt.Errorf("invalid filters: %w", err)
+	}
+
+	return filters, nil
+}
+
+// refreshFilters fetches the filters of filtersURL again every
+// filtersRefresh until ctx is done. Lists that cannot be fetched or compiled
+// are logged and the filters in use are kept.
+func (s *SubFilter) refreshFilters(ctx context.Context) {
+	ticker := time.NewTicker(s.filtersRefresh)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		filters, err := s.fetchFilters(ctx)
+		if err == nil {
+			err = s.setRemoteFilters(filters)
+		}
+
+		if err != nil && ctx.Err() == nil {
+			log.Printf("unable to refresh filtersURL, keeping the current filters: %v", err)
+		}
+	}
+}
+
+// setRemoteFilters replaces the filters fetched from filtersURL, keeping the
+// top-level ones in front of them.
+func (s *SubFilter) setRemoteFilters(filters []Filter) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	compiled, err := compileFilters(append(append([]Filter(nil), s.config.Filters...), filters...))
+	if err != nil {
+		return err
+	}
+
+	rules := append([]rule{{filters: compiled}}, s.rules[1:]...)
+	if err := s.checkFilterCount(rules); err != nil {
+		return err
+	}
+
+	s.rules = rules
+	s.remoteFilters = filters
+
+	return nil
+}
@@ -0,0 +1,169 @@
+package subfilter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func serveFilterList(t *testing.T, status int, list string) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(list))
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestFiltersURL(t *testing.T) {
+	tests := []struct {
+		desc       string
+		status     int
+		list       string
+		filters    []Filter
+		policy     string
+		expResBody string
+	}{
+		{
+			desc:       "should apply the fetched filters",
+			status:     http.StatusOK,
+			list:       `[{"regex": "foo", "replacement": "bar"}]`,
+			expResBody: "bar baz",
+		},
+		{
+			desc:       "should apply the fetched filters after the top-level ones",
+			status:     http.StatusOK,
+			list:       `[{"regex": "bar", "replacement": "qux"}]`,
+			filters:    []Filter{{Regex: "foo", Replacement: "bar"}},
+			expResBody: "qux baz",
+		},
+		{
+			desc:       "should start without the filters on errors",
+			status:     http.StatusInternalServerError,
+			list:       `[{"regex": "foo", "replacement": "bar"}]`,
+			filters:    []Filter{{Regex: "baz", Replacement: "qux"}},
+			policy:     "empty",
+			expResBody: "foo qux",
+		},
+		{
+			desc:       "should start without the filters on invalid lists",
+			status:     http.StatusOK,
+			list:       `[{"regex": "foo", "replacement": "bar"}`,
+			policy:     "empty",
+			expResBody: "foo baz",
+		},
+		{
+			desc:       "should start without the filters on invalid filters",
+			status:     http.StatusOK,
+			list:       `[{"regex": "(foo", "replacement": "bar"}]`,
+			policy:     "empty",
+			expResBody: "foo baz",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.Filters = test.filters
+			config.FiltersURL = serveFilterList(t, test.status, test.list).URL
+			config.OnFiltersURLError = test.policy
+
+			next := func(w http.ResponseWriter, _ *http.Request) {
+				_, _ = w.Write([]byte("foo baz"))
+			}
+
+			sf, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			sf.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if got := recorder.Body.String(); got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+		})
+	}
+}
+
+func TestFiltersURLRefresh(t *testing.T) {
+	var version int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if atomic.LoadInt32(&version) == 0 {
+			_, _ = w.Write([]byte(`[{"regex": "foo", "replacement": "bar"}]`))
+
+			return
+		}
+
+		_, _ = w.Write([]byte(`[{"regex": "foo", "replacement": "qux"}]`))
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	config := CreateConfig()
+	config.FiltersURL = server.URL
+	config.FiltersURLRefresh = "10ms"
+
+	next := func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("foo"))
+	}
+
+	sf, err := New(ctx, http.HandlerFunc(next), config, "subfilter")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serve := func() string {
+		recorder := httptest.NewRecorder()
+		sf.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		return recorder.Body.String()
+	}
+
+	if got := serve(); got != "bar" {
+		t.Fatalf("got body %q, want %q", got, "bar")
+	}
+
+	atomic.StoreInt32(&version, 1)
+
+	for deadline := time.Now().Add(5 * time.Second); serve() != "qux"; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("filters were not refreshed")
+		}
+	}
+}
+
+func TestFiltersURLConfig(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		_, _ = w.Write([]byte(`[{"regex": "foo"}]`))
+	}))
+	defer slow.Close()
+
+	broken := serveFilterList(t, http.StatusNotFound, "")
+	valid := serveFilterList(t, http.StatusOK, `[{"regex": "foo"}]`)
+
+	for i, config := range []*Config{
+		{FiltersURL: broken.URL},
+		{FiltersURL: broken.URL, OnFiltersURLError: "fail"},
+		{FiltersURL: slow.URL, FiltersURLTimeout: "10ms"},
+		{FiltersURL: valid.URL, OnFiltersURLError: "ignore"},
+		{FiltersURL: valid.URL, FiltersURLTimeout: "soon"},
+		{FiltersURL: valid.URL, FiltersURLRefresh: "-1m"},
+	} {
+		if _, err := New(context.Background(), nil, config, "subfilter"); err == nil {
+			t.Errorf("config %d: expected error", i)
+		}
+	}
+}
@@ -0,0 +1,13 @@
+package subfilter
+
+// applyFirstChunk runs the filter over the part of b that the upstream wrote
+// first, leaving the rest of b untouched. The part is measured in the body
+// as written, before the filters preceding this one changed its length.
+func (f *filter) applyFirstChunk(b []byte, sc *scope) []byte {
+	n := sc.firstChunkLeft
+	if n <= 0 {
+		return b
+	}
+
+	return splice(b, 0, n, f.apply(b[:n:n], sc))
+}
@@ -0,0 +1,80 @@
+package subfilter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFirstChunkOnly(t *testing.T) {
+	tests := []struct {
+		desc       string
+		stream     bool
+		overlap    int
+		filters    []Filter
+		chunks     []string
+		expResBody string
+	}{
+		{
+			desc:       "should only filter the first write",
+			stream:     true,
+			overlap:    2,
+			filters:    []Filter{{Regex: "foo", Replacement: "bar", FirstChunkOnly: true}},
+			chunks:     []string{"foo foo", " foo", " foo"},
+			expResBody: "bar bar foo foo",
+		},
+		{
+			desc:       "should only filter the first write when held back",
+			stream:     true,
+			filters:    []Filter{{Regex: "foo", Replacement: "bar", FirstChunkOnly: true}},
+			chunks:     []string{"foo", "foo foo"},
+			expResBody: "barfoo foo",
+		},
+		{
+			desc:    "should keep filtering every write with the other filters",
+			stream:  true,
+			overlap: 2,
+			filters: []Filter{
+				{Regex: "<head>", Replacement: `<head><meta charset="utf-8">`, FirstChunkOnly: true},
+				{Regex: "foo", Replacement: "bar"},
+			},
+			chunks:     []string{"<head>foo", "<head>foo"},
+			expResBody: `<head><meta charset="utf-8">bar<head>bar`,
+		},
+		{
+			desc:       "should filter all of a buffered body",
+			filters:    []Filter{{Regex: "foo", Replacement: "bar", FirstChunkOnly: true}},
+			chunks:     []string{"foo foo", " foo"},
+			expResBody: "bar bar bar",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.Filters = test.filters
+			config.Stream = test.stream
+			config.StreamOverlap = test.overlap
+
+			next := func(w http.ResponseWriter, _ *http.Request) {
+				for _, chunk := range test.chunks {
+					_, _ = w.Write([]byte(chunk))
+				}
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if got := recorder.Body.String(); got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package subfilter
+
+import (
+	"mime"
+	"net/http"
+	"path"
+)
+
+// mayFilter reports whether the response to r could be filtered, judging by
+// the request alone. The response Content-Type is not known yet, so the one
+// of the extension of the path, if any, stands for it in the content type
+// conditions and in the filterable types of the middleware; paths without a
+// known extension may match any of them.
+func (s *SubFilter) mayFilter(r *http.Request, rules []rule) bool {
+	guess := mime.TypeByExtension(path.Ext(r.URL.Path))
+	if guess != "" && !s.filterableType(guess) {
+		return false
+	}
+
+	if len(rules[0].filters) > 0 || len(s.statusGroups) > 0 || len(s.options.Transformers) > 0 {
+		return true
+	}
+
+	for _, rl := range rules[1:] {
+		if len(rl.filters) == 0 || !rl.conditions.matchRequest(r) {
+			continue
+		}
+
+		if guess == "" || len(rl.conditions.contentTypes) == 0 || matchMediaType(rl.conditions.contentTypes, guess) {
+			return true
+		}
+	}
+
+	return guess == "" && len(s.typeGroups) > 0 || len(contentTypeFilters(s.typeGroups, guess)) > 0
+}
+
+// forceFullResponse strips the Range headers of requests whose response may
+// be filtered, so that the upstream sends the whole body: filters cannot
+// rewrite a slice of it.
+func (s *SubFilter) forceFullResponse(r *http.Request, rules []rule) {
+	if s.forceFull && s.mayFilter(r, rules) {
+		r.Header.Del("Range")
+		r.Header.Del("If-Range")
+	}
+}
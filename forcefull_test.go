@@ -0,0 +1,127 @@
+package subfilter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestForceFullResponse(t *testing.T) {
+	htmlRule := []Rule{{
+		Conditions: Conditions{Paths: []string{"^/docs/"}, ContentTypes: []string{"text/html"}},
+		Filters:    []Filter{{Regex: "foo", Replacement: "bar"}},
+	}}
+
+	tests := []struct {
+		desc           string
+		force          bool
+		filters        []Filter
+		rules          []Rule
+		contentTypes   []string
+		path           string
+		contentType    string
+		expRange       bool
+		expAcceptRange bool
+	}{
+		{
+			desc:           "should keep ranges by default",
+			filters:        []Filter{{Regex: "foo", Replacement: "bar"}},
+			path:           "/",
+			contentType:    "text/html",
+			expRange:       true,
+			expAcceptRange: true,
+		},
+		{
+			desc:        "should strip ranges of filtered routes",
+			force:       true,
+			filters:     []Filter{{Regex: "foo", Replacement: "bar"}},
+			path:        "/",
+			contentType: "text/html",
+		},
+		{
+			desc:        "should strip ranges of paths matching a rule",
+			force:       true,
+			rules:       htmlRule,
+			path:        "/docs/index",
+			contentType: "text/html",
+		},
+		{
+			desc:           "should keep ranges of paths matching no rule",
+			force:          true,
+			rules:          htmlRule,
+			path:           "/videos/intro",
+			contentType:    "text/html",
+			expRange:       true,
+			expAcceptRange: true,
+		},
+		{
+			desc:           "should keep ranges of extensions of other content types",
+			force:          true,
+			rules:          htmlRule,
+			path:           "/docs/manual.pdf",
+			contentType:    "application/pdf",
+			expRange:       true,
+			expAcceptRange: true,
+		},
+		{
+			desc:           "should keep ranges of extensions of types not filterable",
+			force:          true,
+			filters:        []Filter{{Regex: "foo", Replacement: "bar"}},
+			contentTypes:   []string{"text/html"},
+			path:           "/videos/intro.mp4",
+			contentType:    "video/mp4",
+			expRange:       true,
+			expAcceptRange: true,
+		},
+		{
+			desc:           "should keep Accept-Ranges of responses not filtered",
+			force:          true,
+			rules:          htmlRule,
+			path:           "/docs/data",
+			contentType:    "application/octet-stream",
+			expAcceptRange: true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.Filters = test.filters
+			config.Rules = test.rules
+			config.ContentTypes = test.contentTypes
+			config.ForceFullResponse = test.force
+
+			var sawRange, sawIfRange bool
+
+			next := func(w http.ResponseWriter, r *http.Request) {
+				sawRange, sawIfRange = r.Header.Get("Range") != "", r.Header.Get("If-Range") != ""
+
+				w.Header().Set("Content-Type", test.contentType)
+				w.Header().Set("Accept-Ranges", "bytes")
+				_, _ = w.Write([]byte("foo"))
+			}
+
+			sf, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, test.path, nil)
+			req.Header.Set("Range", "bytes=0-1")
+			req.Header.Set("If-Range", `"v1"`)
+
+			recorder := httptest.NewRecorder()
+			sf.ServeHTTP(recorder, req)
+
+			if sawRange != test.expRange || sawIfRange != test.expRange {
+				t.Errorf("upstream got Range %t and If-Range %t, want %t", sawRange, sawIfRange, test.expRange)
+			}
+
+			if got := recorder.Header().Get("Accept-Ranges") != ""; got != test.expAcceptRange {
+				t.Errorf("got Accept-Ranges %t, want %t", got, test.expAcceptRange)
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package subfilter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	formatPretty = "pretty"
+	formatMinify = "minify"
+
+	// formatIndent is the indentation of one level of pretty-printed
+	// fragments.
+	formatIndent = "  "
+)
+
+// htmlVerbatimElements hold text whose whitespace matters, or which is not
+// HTML, and are kept as they are when formatting.
+var htmlVerbatimElements = map[string]bool{"pre": true, "script": true, "style": true, "textarea": true}
+
+// compileFormat returns the normalized format mode of f.
+func compileFormat(f Filter) (string, error) {
+	switch mode := strings.ToLower(f.Format); mode {
+	case "", formatPretty, formatMinify:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("invalid format %q: must be %q or %q", f.Format, formatPretty, formatMinify)
+	}
+}
+
+// isJSONContentType reports whether contentType describes a JSON document.
+func isJSONContentType(contentType string) bool {
+	mt := mediaType(contentType)
+
+	return mt == "application/json" || strings.HasSuffix(mt, "+json")
+}
+
+// formatFragment returns match reformatted with mode: parsed as JSON in JSON
+// documents, and as HTML in HTML ones, but for fragments starting with '{' or
+// '[', such as the content of <script type="application/json">, which are
+// JSON. The whitespace around the fragment is kept. Matches that do not
+// parse, and those of other documents, are kept whole.
+func formatFragment(mode, contentType string, match []byte) []byte {
+	fragment := bytes.TrimSpace(match)
+
+	var formatted []byte
+
+	switch {
+	case len(fragment) == 0:
+		return match
+	case isJSONContentType(contentType), isHTMLContentType(contentType) && (fragment[0] == '{' || fragment[0] == '['):
+		var ok bool
+		if formatted, ok = formatJSON(mode, fragment); !ok {
+			return match
+		}
+	case isHTMLContentType(contentType):
+		if htmlMalformed(fragment) {
+			return match
+		}
+
+		formatted = formatHTML(mode, fragment)
+	default:
+		return match
+	}
+
+	start := bytes.Index(match, fragment)
+	out := append([]byte(nil), match[:start]...)
+	out = append(out, formatted...)
+
+	return append(out, match[start+len(fragment):]...)
+}
+
+// formatJSON returns the JSON fragment indented or compacted, and whether it
+// is valid JSON.
+func formatJSON(mode string, fragment []byte) ([]byte, bool) {
+	var buf bytes.Buffer
+
+	var err error
+	if mode == formatPretty {
+		err = json.Indent(&buf, fragment, "", formatIndent)
+	} else {
+		err = json.Compact(&buf, fragment)
+	}
+
+	return buf.Bytes(), err == nil
+}
+
+// formatHTML returns the HTML fragment b pretty-printed, with every tag and
+// text node on a line of its own, indented by its depth, or minified, with
+// the whitespace of its text collapsed and that indenting its tags dropped.
+// Comments are kept, and so is the content of pre, script, style and
+// textarea elements.
+func formatHTML(mode string, b []byte) []byte {
+	out := make([]byte, 0, len(b))
+	depth := 0
+
+	line := func(token []byte) {
+		if len(out) > 0 {
+			out = append(out, '\n')
+		}
+
+		out = append(out, strings.Repeat(formatIndent, depth)...)
+		out = append(out, token...)
+	}
+
+	for len(b) > 0 {
+		i := bytes.IndexByte(b, '<')
+		if i < 0 {
+			i = len(b)
+		}
+
+		if text := b[:i]; mode == formatPretty {
+			if fields := bytes.Fields(text); len(fields) > 0 {
+				line(bytes.Join(fields, []byte(" ")))
+			}
+		} else {
+			out = append(out, minifyHTMLText(text)...)
+		}
+
+		b = b[i:]
+		if len(b) == 0 {
+			break
+		}
+
+		n := xmlMarkupLen(b)
+		tag := b[:n]
+		b = b[n:]
+
+		name, closing := htmlTagName(tag)
+
+		if closing && name != "" && !htmlVoidElements[name] && depth > 0 {
+			depth--
+		}
+
+		if mode == formatPretty {
+			line(tag)
+		} else {
+			out = append(out, tag...)
+		}
+
+		switch {
+		case name == "" || closing || htmlVoidElements[name] || bytes.HasSuffix(tag, []byte("/>")):
+		case htmlVerbatimElements[name]:
+			end := htmlRawTextEnd(b, name)
+			out = append(out, b[:end]...)
+			b = b[end:]
+
+			if len(b) > 0 {
+				n := xmlMarkupLen(b)
+				out = append(out, b[:n]...)
+				b = b[n:]
+			}
+		default:
+			depth++
+		}
+	}
+
+	return out
+}
+
+// minifyHTMLText collapses the runs of whitespace of the text node to a
+// single space, dropping the node whole when it only indents markup.
+func minifyHTMLText(text []byte) []byte {
+	if len(bytes.TrimSpace(text)) == 0 && bytes.ContainsAny(text, "\r\n") {
+		return nil
+	}
+
+	out := make([]byte, 0, len(text))
+	space := false
+
+	for _, c := range text {
+		switch c {
+		case ' ', '\t', '\n', '\r', '\f':
+			space = true
+		default:
+			if space {
+				out = append(out, ' ')
+				space = false
+			}
+
+			out = append(out, c)
+		}
+	}
+
+	if space {
+		out = append(out, ' ')
+	}
+
+	return out
+}
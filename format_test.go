@@ -0,0 +1,108 @@
+package subfilter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		desc        string
+		contentType string
+		filter      Filter
+		resBody     string
+		expResBody  string
+	}{
+		{
+			desc:        "should minify a matched JSON blob",
+			contentType: "application/json",
+			filter:      Filter{Regex: `(?s)\{\s*"config".*\}`, Format: "minify"},
+			resBody:     "{\n  \"config\": {\n    \"debug\": true,\n    \"tags\": [\"a\", \"b\"]\n  }\n}",
+			expResBody:  `{"config":{"debug":true,"tags":["a","b"]}}`,
+		},
+		{
+			desc:        "should pretty-print a JSON blob of an HTML document",
+			contentType: "text/html",
+			filter:      Filter{Regex: `(?s)<script type="application/json">(.*?)</script>`, Format: "pretty"},
+			resBody:     `<p>x</p><script type="application/json">{"a":1,"b":[true,null]}</script>`,
+			expResBody:  "<p>x</p><script type=\"application/json\">{\n  \"a\": 1,\n  \"b\": [\n    true,\n    null\n  ]\n}</script>",
+		},
+		{
+			desc:        "should pretty-print an HTML fragment",
+			contentType: "text/html; charset=utf-8",
+			filter:      Filter{Regex: `(?s)<nav>.*</nav>`, Format: "pretty"},
+			resBody:     `<body><nav><ul><li><a href="/">Home</a></li><li>About<br>us</li></ul></nav></body>`,
+			expResBody: "<body><nav>\n  <ul>\n    <li>\n      <a href=\"/\">\n        Home\n      </a>\n    </li>\n" +
+				"    <li>\n      About\n      <br>\n      us\n    </li>\n  </ul>\n</nav></body>",
+		},
+		{
+			desc:        "should minify an HTML fragment, keeping preformatted text",
+			contentType: "text/html",
+			filter:      Filter{Regex: `(?s)<main>.*</main>`, Format: "minify"},
+			resBody:     "<main>\n  <p>Hello,\n     world</p>\n  <pre>  a\n  b</pre>\n  <!-- note -->\n</main>",
+			expResBody:  "<main><p>Hello, world</p><pre>  a\n  b</pre><!-- note --></main>",
+		},
+		{
+			desc:        "should keep invalid JSON",
+			contentType: "application/json",
+			filter:      Filter{Regex: `(?s)\{.*\}`, Format: "minify"},
+			resBody:     `{"a": 1,, }`,
+			expResBody:  `{"a": 1,, }`,
+		},
+		{
+			desc:        "should keep the whitespace around the fragment",
+			contentType: "text/html",
+			filter:      Filter{Regex: `(?s)<script>(.*?)</script>`, Format: "minify"},
+			resBody:     "<script>\n[ 1, 2 ]\n</script>",
+			expResBody:  "<script>\n[1,2]\n</script>",
+		},
+		{
+			desc:        "should leave other content types alone",
+			contentType: "text/plain",
+			filter:      Filter{Regex: `(?s)\{.*\}`, Format: "minify"},
+			resBody:     `{ "a": 1 }`,
+			expResBody:  `{ "a": 1 }`,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.Filters = []Filter{test.filter}
+
+			next := func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Type", test.contentType)
+				_, _ = w.Write([]byte(test.resBody))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if got := recorder.Body.String(); got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+		})
+	}
+}
+
+func TestFormatConfig(t *testing.T) {
+	for _, f := range []Filter{
+		{Regex: "foo", Format: "beautify"},
+		{Regex: "foo", Format: "pretty", Replacement: "bar"},
+		{Regex: "foo", Format: "pretty", Mask: "full"},
+		{Regex: "foo", Format: "minify", Transforms: true},
+		{Type: "range", Start: "<a>", End: "</a>", Format: "minify"},
+	} {
+		if _, err := compileFilters([]Filter{f}); err == nil {
+			t.Errorf("expected an error for %+v", f)
+		}
+	}
+}
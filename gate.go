@@ -0,0 +1,162 @@
+package subfilter
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// requestGate decides, before the upstream handler is called, whether a
+// request is eligible for filtering at all. Ineligible requests are passed to
+// the next handler without filtering the response.
+type requestGate struct {
+	cookies          map[string]*regexp.Regexp
+	honorNoTransform bool
+	// authHeaders and authCookies, when any is present, mark the request as
+	// authenticated and exempt it from filtering.
+	authHeaders []string
+	authCookies []string
+	// accept, when set, lists the media types one of which the request must
+	// accept.
+	accept       []string
+	strictAccept bool
+	// userAgents, when set, are regexes one of which the request User-Agent
+	// must match.
+	userAgents []*regexp.Regexp
+	// vary lists the request headers the gate decides upon, which every
+	// response then varies with, filtered or not.
+	vary []string
+}
+
+func (s *SubFilter) setupGate(config *Config) error {
+	g := &requestGate{
+		honorNoTransform: config.HonorNoTransform,
+		accept:           config.Accept,
+		strictAccept:     config.StrictAccept,
+	}
+
+	for _, mt := range config.Accept {
+		if !strings.Contains(mt, "/") {
+			return fmt.Errorf("invalid accept media type %q", mt)
+		}
+	}
+
+	if len(g.accept) > 0 {
+		g.vary = append(g.vary, "Accept")
+	}
+
+	if config.SkipAuthenticated {
+		g.authHeaders = config.AuthHeaders
+		if len(g.authHeaders) == 0 {
+			g.authHeaders = []string{"Authorization"}
+		}
+
+		g.authCookies = config.AuthCookies
+	}
+
+	for _, p := range config.UserAgents {
+		regex, err := regexp.Compile(p)
+		if err != nil {
+			return fmt.Errorf("error compiling userAgents regex %q: %w", p, err)
+		}
+
+		g.userAgents = append(g.userAgents, regex)
+	}
+
+	if len(g.userAgents) > 0 {
+		g.vary = append(g.vary, "User-Agent")
+	}
+
+	for name, value := range config.Cookies {
+		regex, err := regexp.Compile(`^(?:` + value + `)$`)
+		if err != nil {
+			return fmt.Errorf("error compiling regex %q for cookie %q: %w", value, name, err)
+		}
+
+		if g.cookies == nil {
+			g.cookies = make(map[string]*regexp.Regexp)
+		}
+
+		g.cookies[name] = regex
+	}
+
+	if len(g.cookies) > 0 {
+		g.vary = append(g.vary, "Cookie")
+	}
+
+	s.gate = g
+
+	return nil
+}
+
+// allow reports whether the request may be filtered.
+func (g *requestGate) allow(r *http.Request) bool {
+	if g.honorNoTransform && hasCacheDirective(r.Header, directiveNoTransform) {
+		return false
+	}
+
+	if g.authenticated(r) {
+		return false
+	}
+
+	if !g.accepts(r) || !g.matchUserAgent(r) {
+		return false
+	}
+
+	for name, regex := range g.cookies {
+		c, err := r.Cookie(name)
+		if err != nil || !regex.MatchString(c.Value) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// authenticated reports whether r carries any of the authentication headers
+// or cookies.
+func (g *requestGate) authenticated(r *http.Request) bool {
+	for _, name := range g.authHeaders {
+		if r.Header.Get(name) != "" {
+			return true
+		}
+	}
+
+	for _, name := range g.authCookies {
+		if _, err := r.Cookie(name); err == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// accepts reports whether r accepts one of the media types filtered
+// responses are meant for. Requests without an Accept header accept them all.
+func (g *requestGate) accepts(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	if len(g.accept) == 0 || accept == "" {
+		return true
+	}
+
+	return acceptsAny(accept, g.accept, g.strictAccept)
+}
+
+// matchUserAgent reports whether the User-Agent of r matches one of the
+// configured regexes, if any.
+func (g *requestGate) matchUserAgent(r *http.Request) bool {
+	if len(g.userAgents) == 0 {
+		return true
+	}
+
+	ua := r.UserAgent()
+
+	for _, regex := range g.userAgents {
+		if regex.MatchString(ua) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,286 @@
+package subfilter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCookies(t *testing.T) {
+	tests := []struct {
+		desc       string
+		cookies    []*http.Cookie
+		expResBody string
+	}{
+		{
+			desc:       "should filter when every cookie matches",
+			cookies:    []*http.Cookie{{Name: "beta", Value: "on"}, {Name: "variant", Value: "b2"}},
+			expResBody: "bar is the new bar",
+		},
+		{
+			desc:       "should not filter when a cookie is missing",
+			cookies:    []*http.Cookie{{Name: "beta", Value: "on"}},
+			expResBody: "foo is the new bar",
+		},
+		{
+			desc:       "should not filter when a cookie does not match",
+			cookies:    []*http.Cookie{{Name: "beta", Value: "only"}, {Name: "variant", Value: "b2"}},
+			expResBody: "foo is the new bar",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.Cookies = map[string]string{"beta": "on", "variant": "b[0-9]+"}
+			config.Filters = []Filter{{Regex: "foo", Replacement: "bar"}}
+
+			next := func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte("foo is the new bar"))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			for _, c := range test.cookies {
+				req.AddCookie(c)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+
+			if got := recorder.Body.String(); got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+
+			if vary := recorder.Header().Get("Vary"); vary != "Cookie" {
+				t.Errorf("got Vary %q, want %q", vary, "Cookie")
+			}
+		})
+	}
+}
+
+func TestSkipAuthenticated(t *testing.T) {
+	tests := []struct {
+		desc       string
+		skip       bool
+		headers    []string
+		header     string
+		cookie     string
+		expResBody string
+	}{
+		{
+			desc:       "should filter authenticated requests by default",
+			header:     "Authorization",
+			expResBody: "bar",
+		},
+		{
+			desc:       "should skip requests with an Authorization header",
+			skip:       true,
+			header:     "Authorization",
+			expResBody: "foo",
+		},
+		{
+			desc:       "should skip requests with a listed cookie",
+			skip:       true,
+			cookie:     "session",
+			expResBody: "foo",
+		},
+		{
+			desc:       "should skip requests with a listed header",
+			skip:       true,
+			headers:    []string{"x-api-key"},
+			header:     "X-Api-Key",
+			expResBody: "foo",
+		},
+		{
+			desc:       "should only check the listed headers",
+			skip:       true,
+			headers:    []string{"X-Api-Key"},
+			header:     "Authorization",
+			expResBody: "bar",
+		},
+		{
+			desc:       "should filter anonymous requests",
+			skip:       true,
+			cookie:     "theme",
+			expResBody: "bar",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.Filters = []Filter{{Regex: "foo", Replacement: "bar"}}
+			config.SkipAuthenticated = test.skip
+			config.AuthHeaders = test.headers
+			config.AuthCookies = []string{"session"}
+
+			recorder := httptest.NewRecorder()
+
+			var wrapped bool
+
+			next := func(w http.ResponseWriter, r *http.Request) {
+				wrapped = w != http.ResponseWriter(recorder)
+				_, _ = w.Write([]byte("foo"))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if test.header != "" {
+				req.Header.Set(test.header, "secret")
+			}
+
+			if test.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: test.cookie, Value: "x"})
+			}
+
+			handler.ServeHTTP(recorder, req)
+
+			if got := recorder.Body.String(); got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+
+			if skipped := test.expResBody == "foo"; wrapped == skipped {
+				t.Errorf("got response writer wrapped %t, want %t", wrapped, !skipped)
+			}
+		})
+	}
+}
+
+func TestAccept(t *testing.T) {
+	tests := []struct {
+		desc       string
+		accept     string
+		strict     bool
+		expResBody string
+	}{
+		{desc: "should filter requests for HTML", accept: "text/html,application/xhtml+xml;q=0.9", expResBody: "bar"},
+		{desc: "should pass API requests through", accept: "application/json", expResBody: "foo"},
+		{desc: "should filter requests without an Accept header", expResBody: "bar"},
+		{desc: "should match type wildcards", accept: "text/*", expResBody: "bar"},
+		{desc: "should match the full wildcard", accept: "application/json, */*;q=0.1", expResBody: "bar"},
+		{desc: "should honor q=0 exclusions", accept: "text/html;q=0, */*", expResBody: "foo"},
+		{desc: "should ignore wildcards in strict mode", accept: "*/*", strict: true, expResBody: "foo"},
+		{desc: "should match exact types in strict mode", accept: "text/*, text/html;q=0.5", strict: true, expResBody: "bar"},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.Filters = []Filter{{Regex: "foo", Replacement: "bar"}}
+			config.Accept = []string{"text/html"}
+			config.StrictAccept = test.strict
+
+			next := func(w http.ResponseWriter, r *http.Request) {
+				// The upstream mislabels its response, which must not matter.
+				w.Header().Set("Content-Type", "text/html")
+				_, _ = w.Write([]byte("foo"))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if test.accept != "" {
+				req.Header.Set("Accept", test.accept)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+
+			if got := recorder.Body.String(); got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+
+			// Filtered or not, the response depends on the Accept header.
+			if vary := recorder.Header().Get("Vary"); vary != "Accept" {
+				t.Errorf("got Vary %q, want %q", vary, "Accept")
+			}
+		})
+	}
+}
+
+func TestUserAgents(t *testing.T) {
+	tests := []struct {
+		desc       string
+		userAgent  string
+		expResBody string
+	}{
+		{
+			desc:       "should filter a legacy user agent",
+			userAgent:  "Mozilla/4.0 (compatible; MSIE 8.0; Windows NT 6.1; Trident/4.0)",
+			expResBody: "<head><script src=/shim.js></script>",
+		},
+		{
+			desc:       "should filter another matching user agent",
+			userAgent:  "Mozilla/5.0 (Windows NT 10.0; Trident/7.0; rv:11.0) like Gecko",
+			expResBody: "<head><script src=/shim.js></script>",
+		},
+		{
+			desc:       "should pass a modern user agent through",
+			userAgent:  "Mozilla/5.0 (X11; Linux x86_64; rv:128.0) Gecko/20100101 Firefox/128.0",
+			expResBody: "<head>",
+		},
+		{
+			desc:       "should pass requests without a user agent through",
+			expResBody: "<head>",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.Filters = []Filter{{Regex: "<head>", Replacement: "<script src=/shim.js></script>", Action: "insertAfter"}}
+			config.UserAgents = []string{`MSIE [6-9]\.`, `Trident/`}
+
+			next := func(w http.ResponseWriter, _ *http.Request) {
+				_, _ = w.Write([]byte("<head>"))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("User-Agent", test.userAgent)
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+
+			if got := recorder.Body.String(); got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+
+			if vary := recorder.Header().Get("Vary"); vary != "User-Agent" {
+				t.Errorf("got Vary %q, want %q", vary, "User-Agent")
+			}
+		})
+	}
+}
+
+func TestUserAgentsConfig(t *testing.T) {
+	config := CreateConfig()
+	config.UserAgents = []string{"MSIE ("}
+
+	next := func(http.ResponseWriter, *http.Request) {}
+
+	if _, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter"); err == nil {
+		t.Error("expected an error for an invalid userAgents regex")
+	}
+}
@@ -0,0 +1,27 @@
+package subfilter
+
+import (
+	"regexp"
+	"strings"
+)
+
+// globRegex translates a glob into a regexp. "*" matches any run of
+// characters other than "/" and whitespace, "?" exactly one such character,
+// and everything else matches literally. Keeping "*" within a single word
+// stops it from spanning the whole document.
+func globRegex(glob string) string {
+	var b strings.Builder
+
+	for _, r := range glob {
+		switch r {
+		case '*':
+			b.WriteString(`[^/\s]*`)
+		case '?':
+			b.WriteString(`[^/\s]`)
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,70 @@
+package subfilter
+
+import (
+	"context"
+	"testing"
+)
+
+func TestGlobFilters(t *testing.T) {
+	tests := []struct {
+		desc        string
+		glob        string
+		replacement string
+		resBody     string
+		expResBody  string
+	}{
+		{
+			desc:        "should match a run of characters within a word",
+			glob:        "*.internal.corp",
+			replacement: "example.com",
+			resBody:     "see https://api.internal.corp/v1 and db.internal.corp",
+			expResBody:  "see https://example.com/v1 and example.com",
+		},
+		{
+			desc:        "should match a single character",
+			glob:        "v?.example",
+			replacement: "vX.example",
+			resBody:     "v1.example v10.example",
+			expResBody:  "vX.example v10.example",
+		},
+		{
+			desc:        "should treat regexp characters literally",
+			glob:        "c++ (gcc)",
+			replacement: "C++",
+			resBody:     "c++ (gcc) and cc (gcc)",
+			expResBody:  "C++ and cc (gcc)",
+		},
+		{
+			desc:        "should use the replacement literally",
+			glob:        "price",
+			replacement: "$1 ${x}",
+			resBody:     "price",
+			expResBody:  "$1 ${x}",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			got := serveTransform(t, Filter{Type: "glob", Regex: test.glob, Replacement: test.replacement}, test.resBody)
+			if got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+		})
+	}
+}
+
+func TestGlobFiltersInvalid(t *testing.T) {
+	for _, f := range []Filter{
+		{Type: "wildcard", Regex: "*"},
+		{Type: "glob", Regex: "*", Transforms: true},
+		{Type: "glob", Preset: "email"},
+	} {
+		config := CreateConfig()
+		config.Filters = []Filter{f}
+
+		if _, err := New(context.Background(), nil, config, "subfilter"); err == nil {
+			t.Errorf("expected error for filter %+v", f)
+		}
+	}
+}
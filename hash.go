@@ -0,0 +1,88 @@
+package subfilter
+
+import (
+	"crypto/sha1" // nolint:gosec // used for pseudonymization, not security
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"os"
+	"strings"
+)
+
+// HashReplacement replaces every match with Prefix followed by the hex digest
+// of Salt+match, so equal inputs always map to the same opaque token.
+type HashReplacement struct {
+	// Algorithm is one of sha256 (default), sha512 or sha1.
+	Algorithm string `json:"algorithm,omitempty"`
+	Salt      string `json:"salt,omitempty"`
+	// SaltEnv names an environment variable holding the salt, which keeps it
+	// out of the dynamic configuration. It must be set when SaltEnv is used.
+	SaltEnv string `json:"saltEnv,omitempty"`
+	Prefix  string `json:"prefix,omitempty"`
+	// Length truncates the hex digest to at most Length characters.
+	Length int `json:"length,omitempty"`
+}
+
+type hasher struct {
+	newHash func() hash.Hash
+	salt    []byte
+	prefix  []byte
+	length  int
+}
+
+func newHasher(config *HashReplacement) (*hasher, error) {
+	h := &hasher{
+		salt:   []byte(config.Salt),
+		prefix: []byte(config.Prefix),
+		length: config.Length,
+	}
+
+	switch strings.ToLower(config.Algorithm) {
+	case "", "sha256":
+		h.newHash = sha256.New
+	case "sha512":
+		h.newHash = sha512.New
+	case "sha1":
+		h.newHash = sha1.New
+	default:
+		return nil, fmt.Errorf("unsupported hash algorithm %q: must be sha256, sha512 or sha1", config.Algorithm)
+	}
+
+	if config.Length < 0 {
+		return nil, errors.New("hash length must not be negative")
+	}
+
+	if config.SaltEnv != "" {
+		if config.Salt != "" {
+			return nil, errors.New("salt and saltEnv are mutually exclusive")
+		}
+
+		salt, ok := os.LookupEnv(config.SaltEnv)
+		if !ok || salt == "" {
+			return nil, fmt.Errorf("salt environment variable %q is not set", config.SaltEnv)
+		}
+
+		h.salt = []byte(salt)
+	}
+
+	return h, nil
+}
+
+func (h *hasher) replace(match []byte) []byte {
+	d := h.newHash()
+	_, _ = d.Write(h.salt)
+	_, _ = d.Write(match)
+
+	sum := hex.EncodeToString(d.Sum(nil))
+	if h.length > 0 && h.length < len(sum) {
+		sum = sum[:h.length]
+	}
+
+	out := make([]byte, 0, len(h.prefix)+len(sum))
+	out = append(out, h.prefix...)
+
+	return append(out, sum...)
+}
@@ -0,0 +1,85 @@
+package subfilter
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func serveHashed(t *testing.T, hr *HashReplacement, body string) string {
+	t.Helper()
+
+	config := CreateConfig()
+	config.Filters = []Filter{{Regex: `[a-z]+@example\.com`, HashReplacement: hr}}
+
+	next := func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(body))
+	}
+
+	handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	return recorder.Body.String()
+}
+
+func TestHashReplacement(t *testing.T) {
+	hr := &HashReplacement{Salt: "pepper", Prefix: "user-", Length: 12}
+
+	sum := sha256.Sum256([]byte("pepperalice@example.com"))
+	want := "from user-" + hex.EncodeToString(sum[:])[:12]
+
+	first := serveHashed(t, hr, "from alice@example.com")
+	if first != want {
+		t.Errorf("got body %q, want %q", first, want)
+	}
+
+	if second := serveHashed(t, hr, "from alice@example.com"); second != first {
+		t.Errorf("got %q on second request, want %q", second, first)
+	}
+
+	if other := serveHashed(t, &HashReplacement{Salt: "salt", Prefix: "user-", Length: 12}, "from alice@example.com"); other == first {
+		t.Errorf("got identical token %q for different salts", other)
+	}
+
+	full := serveHashed(t, &HashReplacement{Algorithm: "sha512"}, "alice@example.com")
+	if len(full) != 128 {
+		t.Errorf("got untruncated sha512 digest of length %d, want 128", len(full))
+	}
+}
+
+func TestHashReplacementSaltEnv(t *testing.T) {
+	const env = "SUBFILTER_TEST_SALT"
+
+	config := CreateConfig()
+	config.Filters = []Filter{{Regex: "foo", HashReplacement: &HashReplacement{SaltEnv: env}}}
+
+	if err := os.Unsetenv(env); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := New(context.Background(), nil, config, "subfilter"); err == nil {
+		t.Fatal("expected error when the salt environment variable is not set")
+	}
+
+	if err := os.Setenv(env, "pepper"); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() { _ = os.Unsetenv(env) }()
+
+	fromEnv := serveHashed(t, &HashReplacement{SaltEnv: env}, "alice@example.com")
+	inline := serveHashed(t, &HashReplacement{Salt: "pepper"}, "alice@example.com")
+
+	if fromEnv != inline {
+		t.Errorf("got %q using saltEnv, want %q", fromEnv, inline)
+	}
+}
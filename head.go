@@ -0,0 +1,64 @@
+package subfilter
+
+import "regexp"
+
+var (
+	headEndRegex = regexp.MustCompile(`(?i)</head\s*>`)
+	bodyTagRegex = regexp.MustCompile(`(?i)<body\b`)
+)
+
+// headOnly restricts a filter to the head section of HTML documents.
+type headOnly struct {
+	inner *filter
+}
+
+// apply runs the inner filter over the head section of the HTML body b.
+// Bodies of other content types, and documents whose head cannot be told
+// apart from their body, are left untouched.
+func (h *headOnly) apply(b []byte, sc *scope) []byte {
+	if sc != nil && sc.contentType != "" && !isHTMLContentType(sc.contentType) {
+		return b
+	}
+
+	start, end, ok := headSection(b)
+	if !ok {
+		return b
+	}
+
+	return splice(b, start, end, h.inner.apply(b[start:end], sc))
+}
+
+// headSection returns the bounds of the head section of the HTML document b:
+// the content of its head element, which ends at </head> or, when the end tag
+// is omitted, at <body>. Without a head start tag, the head is implied from
+// the <html> tag, the doctype or the start of the document up to <body>, and
+// there is none without a <body> tag either.
+func headSection(b []byte) (int, int, bool) {
+	body := len(b)
+	if loc := bodyTagRegex.FindIndex(b); loc != nil {
+		body = loc[0]
+	}
+
+	start := 0
+
+	if loc := headTagRegex.FindIndex(b[:body]); loc != nil {
+		start = loc[1]
+	} else {
+		if body == len(b) {
+			return 0, 0, false
+		}
+
+		if loc := htmlTagRegex.FindIndex(b[:body]); loc != nil {
+			start = loc[1]
+		} else if loc := doctypeRegex.FindIndex(b[:body]); loc != nil {
+			start = loc[1]
+		}
+	}
+
+	end := body
+	if loc := headEndRegex.FindIndex(b[start:body]); loc != nil {
+		end = start + loc[0]
+	}
+
+	return start, end, true
+}
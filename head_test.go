@@ -0,0 +1,91 @@
+package subfilter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHeadOnly(t *testing.T) {
+	tests := []struct {
+		desc        string
+		contentType string
+		resBody     string
+		expResBody  string
+	}{
+		{
+			desc:       "should only replace in the head",
+			resBody:    `<html><head><meta name="x" content="old"><title>old</title></head><body>old</body></html>`,
+			expResBody: `<html><head><meta name="x" content="new"><title>new</title></head><body>old</body></html>`,
+		},
+		{
+			desc:       "should handle head tags with attributes and any case",
+			resBody:    `<HTML><HEAD profile="x">old</HEAD><BODY>old</BODY></HTML>`,
+			expResBody: `<HTML><HEAD profile="x">new</HEAD><BODY>old</BODY></HTML>`,
+		},
+		{
+			desc:       "should end an unclosed head at the body",
+			resBody:    `<head><title>old</title><body>old</body>`,
+			expResBody: `<head><title>new</title><body>old</body>`,
+		},
+		{
+			desc:       "should imply the head before the body",
+			resBody:    `<!DOCTYPE html><html><title>old</title><body><header>old</header></body></html>`,
+			expResBody: `<!DOCTYPE html><html><title>new</title><body><header>old</header></body></html>`,
+		},
+		{
+			desc:       "should leave documents without head nor body alone",
+			resBody:    `<p>old</p>`,
+			expResBody: `<p>old</p>`,
+		},
+		{
+			desc:        "should leave other content types alone",
+			contentType: "text/plain",
+			resBody:     `<head>old</head>`,
+			expResBody:  `<head>old</head>`,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.Filters = []Filter{{Regex: "old", Replacement: "new", HeadOnly: true}}
+
+			contentType := test.contentType
+			if contentType == "" {
+				contentType = "text/html; charset=utf-8"
+			}
+
+			next := func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Type", contentType)
+				_, _ = w.Write([]byte(test.resBody))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if got := recorder.Body.String(); got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+		})
+	}
+}
+
+func TestHeadOnlyConfig(t *testing.T) {
+	for i, f := range []Filter{
+		{Type: "range", Start: "a", End: "b", HeadOnly: true},
+		{Regex: "foo", WithinTags: []string{"title"}, HeadOnly: true},
+		{Regex: "foo", TextNodesOnly: true, HeadOnly: true},
+	} {
+		if _, err := compileFilters([]Filter{f}); err == nil {
+			t.Errorf("filter %d: expected error", i)
+		}
+	}
+}
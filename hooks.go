@@ -0,0 +1,153 @@
+package subfilter
+
+import (
+	"crypto/sha256"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Options holds the settings of a SubFilter that only library users can
+// provide, as they are Go values rather than configuration.
+type Options struct {
+	// OnMatch, when set, is called for every match a filter acts upon, once
+	// its replacement is kept.
+	OnMatch func(MatchInfo)
+	// OnRewrite, when set, is called once for every response whose body went
+	// through the filters.
+	OnRewrite func(RewriteSummary)
+	// Transformers run custom code on the decoded bodies of the filtered
+	// responses, before or after the filters as registered.
+	Transformers []RegisteredTransformer
+	// Resolver, when set, resolves the ${lookup:key} transform tokens of
+	// replacements, reporting whether it knows key. It is called for every
+	// match, so it should be fast.
+	Resolver func(key string) (string, bool)
+	// Tracer, when set, is called once for every response whose body went
+	// through the filters with the number of matches of each of them, for
+	// tests to assert which filters fired. Matches are only counted per
+	// filter when it is set, or when SetEvents registered a channel.
+	Tracer func(FilterTrace)
+	// Now, when set, stands for time.Now as the time at which bodies start
+	// being filtered, and of streamed flushes, for the ${now} and ${reltime}
+	// transforms and FlushInterval to be deterministic in tests.
+	Now func() time.Time
+}
+
+// MatchInfo describes a match reported to Options.OnMatch.
+type MatchInfo struct {
+	// Filter is the definition of the filter that matched.
+	Filter Filter
+	// Start and End are the offsets of the match in the body as the filter
+	// saw it, after the filters that ran before it.
+	Start, End int
+	Text       string
+}
+
+// RewriteSummary describes a filtered response reported to
+// Options.OnRewrite.
+type RewriteSummary struct {
+	Request  *http.Request
+	Status   int
+	Modified bool
+	// Replacements is the number of matches the filters acted upon.
+	Replacements int
+	// Duration is the time spent decoding and filtering the body.
+	Duration time.Duration
+	// FilterDurations, with Debug set, holds the time each filter spent on
+	// the body.
+	FilterDurations []FilterDuration
+}
+
+// FilterTrace describes the filters a response went through, reported to
+// Options.Tracer.
+type FilterTrace struct {
+	Request *http.Request
+	// Filters holds every configured filter selected for the response, in
+	// the order they ran, including those that did not match.
+	Filters []FilterMatches
+}
+
+// FilterMatches is the number of matches a filter acted upon in a body.
+type FilterMatches struct {
+	Filter  Filter
+	Matches int
+}
+
+// now returns the current time, as told by Options.Now when set.
+func (s *SubFilter) now() time.Time {
+	if s.options.Now != nil {
+		return s.options.Now()
+	}
+
+	return time.Now()
+}
+
+// newScope returns the scope of the filtering of a body for r.
+func (s *SubFilter) newScope(r *http.Request) *scope {
+	sc := &scope{req: r, tokenizerFallback: s.tokenizerFallback, independent: s.independent, clock: s.options.Now,
+		autoScope: s.autoScope, trustForwardedFor: s.trustForwardedFor}
+
+	if s.options.OnMatch != nil {
+		sc.onMatch = func(mi MatchInfo) {
+			s.callHook("OnMatch", func() { s.options.OnMatch(mi) })
+		}
+	}
+
+	if s.options.Resolver != nil {
+		sc.resolve = func(key string) (v string, ok bool) {
+			s.callHook("Resolver", func() { v, ok = s.options.Resolver(key) })
+
+			return v, ok
+		}
+	}
+
+	if s.options.Tracer != nil || s.eventChannel() != nil {
+		sc.traced = make(map[*Filter]int)
+	}
+
+	if s.dedupeInserts {
+		sc.inserted = make(map[[sha256.Size]byte]bool)
+	}
+
+	return sc
+}
+
+// reportRewrite calls the OnRewrite hook, if any.
+func (s *SubFilter) reportRewrite(summary RewriteSummary) {
+	if s.options.OnRewrite == nil {
+		return
+	}
+
+	s.callHook("OnRewrite", func() { s.options.OnRewrite(summary) })
+}
+
+// reportTrace calls the Tracer hook, if any, with the matches sc counted for
+// filters.
+func (s *SubFilter) reportTrace(r *http.Request, filters []filter, sc *scope) {
+	if s.options.Tracer == nil {
+		return
+	}
+
+	trace := FilterTrace{Request: r}
+
+	for i := range filters {
+		if def := filters[i].def; def != nil {
+			trace.Filters = append(trace.Filters, FilterMatches{Filter: *def, Matches: sc.traced[def]})
+		}
+	}
+
+	s.callHook("Tracer", func() { s.options.Tracer(trace) })
+}
+
+// callHook calls fn, recovering from its panics so that a broken hook cannot
+// break the response.
+func (s *SubFilter) callHook(hook string, fn func()) {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Printf("%s: recovered from a panic in the %s hook: %v", s.name, hook, err)
+		}
+	}()
+
+	fn()
+}
@@ -0,0 +1,311 @@
+package subfilter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestHooks(t *testing.T) {
+	config := CreateConfig()
+	config.Filters = []Filter{
+		{Regex: "foo", Replacement: "bar"},
+		{Regex: "new", Replacement: "old"},
+	}
+
+	next := func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte("foo is the new foo"))
+	}
+
+	type match struct {
+		regex      string
+		start, end int
+		text       string
+	}
+
+	var (
+		matches   []match
+		summaries []RewriteSummary
+	)
+
+	opts := Options{
+		OnMatch: func(mi MatchInfo) {
+			matches = append(matches, match{regex: mi.Filter.Regex, start: mi.Start, end: mi.End, text: mi.Text})
+		},
+		OnRewrite: func(summary RewriteSummary) {
+			summaries = append(summaries, summary)
+		},
+	}
+
+	sf, err := NewWithOptions(context.Background(), http.HandlerFunc(next), config, "subfilter", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	sf.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if got := recorder.Body.String(); got != "bar is the old bar" {
+		t.Errorf("got body %q, want %q", got, "bar is the old bar")
+	}
+
+	expMatches := []match{
+		{regex: "foo", start: 0, end: 3, text: "foo"},
+		{regex: "foo", start: 15, end: 18, text: "foo"},
+		{regex: "new", start: 11, end: 14, text: "new"},
+	}
+	if !reflect.DeepEqual(matches, expMatches) {
+		t.Errorf("got matches %+v, want %+v", matches, expMatches)
+	}
+
+	if len(summaries) != 1 {
+		t.Fatalf("got %d summaries, want 1", len(summaries))
+	}
+
+	summary := summaries[0]
+	if !summary.Modified || summary.Replacements != 3 || summary.Status != http.StatusCreated ||
+		summary.Request == nil || summary.Duration < 0 {
+		t.Errorf("got summary %+v", summary)
+	}
+}
+
+func TestHooksTakenBackMatches(t *testing.T) {
+	tests := []struct {
+		desc        string
+		filter      Filter
+		independent bool
+		resBody     string
+		expResBody  string
+		expTexts    []string
+	}{
+		{
+			desc:       "should only report the matches acted upon",
+			filter:     Filter{Regex: "foo[0-9]", Replacement: "bar", Every: 2, MaxMatches: 1},
+			resBody:    "foo1 foo2 foo3 foo4",
+			expResBody: "foo1 bar foo3 foo4",
+			expTexts:   []string{"foo2"},
+		},
+		{
+			desc:       "should not report matches replaced by themselves",
+			filter:     Filter{Regex: "foo|bar", Replacement: "bar"},
+			resBody:    "foo bar",
+			expResBody: "bar bar",
+			expTexts:   []string{"foo"},
+		},
+		{
+			desc:        "should not report matches replaced by themselves with independent filters",
+			filter:      Filter{Regex: "foo|bar", Replacement: "bar"},
+			independent: true,
+			resBody:     "foo bar",
+			expResBody:  "bar bar",
+			expTexts:    []string{"foo"},
+		},
+		{
+			desc:       "should not report the matches of a filter whose output was dropped",
+			filter:     Filter{Regex: "foo", Replacement: "foofoofoo", MaxExpansionRatio: 2},
+			resBody:    "foo",
+			expResBody: "foo",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.Filters = []Filter{test.filter}
+			config.IndependentFilters = test.independent
+
+			next := func(w http.ResponseWriter, _ *http.Request) {
+				_, _ = w.Write([]byte(test.resBody))
+			}
+
+			var texts []string
+
+			opts := Options{OnMatch: func(mi MatchInfo) { texts = append(texts, mi.Text) }}
+
+			sf, err := NewWithOptions(context.Background(), http.HandlerFunc(next), config, "subfilter", opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			sf.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if got := recorder.Body.String(); got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+
+			if !reflect.DeepEqual(texts, test.expTexts) {
+				t.Errorf("got matches %q, want %q", texts, test.expTexts)
+			}
+		})
+	}
+}
+
+func TestHooksPanic(t *testing.T) {
+	config := CreateConfig()
+	config.Filters = []Filter{{Regex: "foo", Replacement: "bar"}}
+
+	next := func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("foo"))
+	}
+
+	opts := Options{
+		OnMatch:   func(MatchInfo) { panic("match") },
+		OnRewrite: func(RewriteSummary) { panic("rewrite") },
+	}
+
+	sf, err := NewWithOptions(context.Background(), http.HandlerFunc(next), config, "subfilter", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	sf.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if got := recorder.Body.String(); got != "bar" {
+		t.Errorf("got body %q, want %q", got, "bar")
+	}
+}
+
+func TestResolver(t *testing.T) {
+	flags := map[string]string{"banner": "new", "theme": "dark"}
+
+	tests := []struct {
+		desc        string
+		replacement string
+		resolver    func(string) (string, bool)
+		expResBody  string
+	}{
+		{
+			desc:        "should replace keys the resolver knows",
+			replacement: "${lookup:banner}-${lookup:theme}",
+			expResBody:  "<new-dark>",
+		},
+		{
+			desc:        "should fall back to the default of unknown keys",
+			replacement: "${lookup:banner}-${lookup:layout:grid}",
+			expResBody:  "<new-grid>",
+		},
+		{
+			desc:        "should drop unknown keys without default",
+			replacement: "${lookup:banner}-${lookup:layout}",
+			expResBody:  "<new->",
+		},
+		{
+			desc:        "should fall back to the default when the resolver panics",
+			replacement: "${lookup:banner:old}",
+			resolver:    func(string) (string, bool) { panic("flags") },
+			expResBody:  "<old>",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.Filters = []Filter{{Regex: "FLAG", Replacement: test.replacement, Transforms: true}}
+
+			next := func(w http.ResponseWriter, _ *http.Request) {
+				_, _ = w.Write([]byte("<FLAG>"))
+			}
+
+			resolver := test.resolver
+			if resolver == nil {
+				resolver = func(key string) (string, bool) {
+					v, ok := flags[key]
+
+					return v, ok
+				}
+			}
+
+			sf, err := NewWithOptions(context.Background(), http.HandlerFunc(next), config, "subfilter",
+				Options{Resolver: resolver})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			sf.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if got := recorder.Body.String(); got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+		})
+	}
+}
+
+func TestTracer(t *testing.T) {
+	tests := []struct {
+		desc       string
+		stream     bool
+		resBody    string
+		expMatches map[string]int
+	}{
+		{
+			desc:       "should count the matches of every filter",
+			resBody:    "foo foo <b>new</b>",
+			expMatches: map[string]int{"foo": 2, "new": 1, "absent": 0},
+		},
+		{
+			desc:       "should report filters that did not match",
+			resBody:    "nothing here",
+			expMatches: map[string]int{"foo": 0, "new": 0, "absent": 0},
+		},
+		{
+			desc:       "should count the matches of streamed bodies",
+			stream:     true,
+			resBody:    "foo new",
+			expMatches: map[string]int{"foo": 1, "new": 1, "absent": 0},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.Stream = test.stream
+			config.Filters = []Filter{
+				{Regex: "foo", Replacement: "bar"},
+				{Regex: "new", Replacement: "old", WithinTags: []string{"b"}},
+				{Regex: "absent", Replacement: "x"},
+			}
+
+			if test.stream {
+				config.Filters[1].WithinTags = nil
+			}
+
+			next := func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Type", "text/html")
+				_, _ = w.Write([]byte(test.resBody))
+			}
+
+			var traces []FilterTrace
+
+			opts := Options{Tracer: func(trace FilterTrace) { traces = append(traces, trace) }}
+
+			sf, err := NewWithOptions(context.Background(), http.HandlerFunc(next), config, "subfilter", opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			sf.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if len(traces) != 1 {
+				t.Fatalf("got %d traces, want 1", len(traces))
+			}
+
+			got := make(map[string]int)
+			for _, fm := range traces[0].Filters {
+				got[fm.Filter.Regex] = fm.Matches
+			}
+
+			if !reflect.DeepEqual(got, test.expMatches) {
+				t.Errorf("got matches %v, want %v", got, test.expMatches)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package subfilter
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var hostnameRegex = regexp.MustCompile(`^(?i)[a-z0-9](?:[a-z0-9-]{0,61}[a-z0-9])?(?:\.[a-z0-9](?:[a-z0-9-]{0,61}[a-z0-9])?)*$`)
+
+func (s *SubFilter) setupHostMap(config *Config) error {
+	if len(config.HostMap) == 0 {
+		return nil
+	}
+
+	hosts := make(map[string]string, len(config.HostMap))
+	keys := make([]string, 0, len(config.HostMap))
+
+	for from, to := range config.HostMap {
+		if !hostnameRegex.MatchString(from) || len(from) > 253 {
+			return fmt.Errorf("hostMap: invalid hostname %q", from)
+		}
+
+		if !hostnameRegex.MatchString(to) || len(to) > 253 {
+			return fmt.Errorf("hostMap: invalid hostname %q for %q", to, from)
+		}
+
+		from = strings.ToLower(from)
+		hosts[from] = to
+		keys = append(keys, regexp.QuoteMeta(from))
+	}
+
+	// Longest keys first, so that a key that is a suffix of another cannot
+	// shadow it.
+	sort.Slice(keys, func(i, j int) bool {
+		if len(keys[i]) != len(keys[j]) {
+			return len(keys[i]) > len(keys[j])
+		}
+
+		return keys[i] < keys[j]
+	})
+
+	s.hostFilters = []filter{{
+		regex:  regexp.MustCompile(`(?i)` + strings.Join(keys, "|")),
+		accept: acceptHostname,
+		hosts:  hosts,
+	}}
+
+	return nil
+}
+
+// acceptHostname rejects matches that are only part of a longer hostname,
+// such as "a.corp" in "internal-a.corp" or "a.corp.evil".
+func acceptHostname(src []byte, start, end int) bool {
+	if start > 0 && (isAlnum(src[start-1]) || src[start-1] == '-' || src[start-1] == '.') {
+		return false
+	}
+
+	if end < len(src) && (isAlnum(src[end]) || src[end] == '-') {
+		return false
+	}
+
+	return !(end+1 < len(src) && src[end] == '.' && isAlnum(src[end+1]))
+}
@@ -0,0 +1,80 @@
+package subfilter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHostMap(t *testing.T) {
+	config := CreateConfig()
+	config.HostMap = map[string]string{
+		"internal-a.corp": "a.example.com",
+		"internal-b.corp": "b.example.com",
+		"b.corp":          "legacy.example.com",
+	}
+
+	tests := []struct {
+		desc       string
+		resBody    string
+		expResBody string
+	}{
+		{
+			desc:       "should rewrite two hosts in one body",
+			resBody:    `<a href="https://internal-a.corp/x?y=1">a</a> <a href="//INTERNAL-B.corp/">b</a>`,
+			expResBody: `<a href="https://a.example.com/x?y=1">a</a> <a href="//b.example.com/">b</a>`,
+		},
+		{
+			desc:       "should keep the port and path",
+			resBody:    "http://internal-a.corp:8443/api/v1",
+			expResBody: "http://a.example.com:8443/api/v1",
+		},
+		{
+			desc:       "should not let a key that is a suffix of another shadow it",
+			resBody:    "internal-b.corp b.corp",
+			expResBody: "b.example.com legacy.example.com",
+		},
+		{
+			desc:       "should leave longer hostnames alone",
+			resBody:    "b.corp.evil x-b.corp internal-a.corporate",
+			expResBody: "b.corp.evil x-b.corp internal-a.corporate",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			next := func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(test.resBody))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if got := recorder.Body.String(); got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+		})
+	}
+}
+
+func TestHostMapInvalid(t *testing.T) {
+	for _, hosts := range []map[string]string{
+		{"https://internal.corp": "example.com"},
+		{"internal.corp": "example.com/path"},
+		{"-internal.corp": "example.com"},
+	} {
+		config := CreateConfig()
+		config.HostMap = hosts
+
+		if _, err := New(context.Background(), nil, config, "subfilter"); err == nil {
+			t.Errorf("expected error for host map %v", hosts)
+		}
+	}
+}
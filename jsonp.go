@@ -0,0 +1,137 @@
+package subfilter
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// defaultJSONPCallback matches JavaScript identifiers and dotted paths such
+// as "jQuery123" or "app.handlers.done".
+const defaultJSONPCallback = `[A-Za-z_$][\w$]*(?:\.[A-Za-z_$][\w$]*)*`
+
+func (s *SubFilter) setupJSONP(config *Config) error {
+	if !config.JSONP {
+		if config.JSONPCallback != "" {
+			return errors.New("jsonpCallback requires jsonp")
+		}
+
+		return nil
+	}
+
+	callback := config.JSONPCallback
+	if callback == "" {
+		callback = defaultJSONPCallback
+	}
+
+	// The optional empty comment is what many servers prepend to guard
+	// against content sniffing attacks.
+	regex, err := regexp.Compile(`^(\s*(?:/\*\*/)?\s*(?:` + callback + `)\s*\()([\s\S]*)(\)\s*;?\s*)$`)
+	if err != nil {
+		return fmt.Errorf("error compiling jsonpCallback regex %q: %w", config.JSONPCallback, err)
+	}
+
+	s.jsonp = regex
+
+	return nil
+}
+
+// isJavaScriptContentType reports whether the given Content-Type header value
+// describes a script, as JSONP responses are served.
+func isJavaScriptContentType(contentType string) bool {
+	switch mediaType(contentType) {
+	case "application/javascript", "text/javascript", "application/x-javascript":
+		return true
+	default:
+		return false
+	}
+}
+
+// filterJSONP applies fn to the string values of the JSON payload of the
+// JSONP response b, leaving the callback wrapper and the object keys alone.
+// It reports false, leaving b untouched, when b is not a JSONP response.
+func filterJSONP(regex *regexp.Regexp, b []byte, fn func([]byte) []byte) ([]byte, bool) {
+	m := regex.FindSubmatchIndex(b)
+	if m == nil || !json.Valid(b[m[4]:m[5]]) {
+		return b, false
+	}
+
+	out := make([]byte, 0, len(b))
+	out = append(out, b[:m[4]]...)
+	out = append(out, filterJSONStrings(b[m[4]:m[5]], fn)...)
+
+	return append(out, b[m[5]:]...), true
+}
+
+// filterJSONStrings applies fn to the decoded content of every string value
+// of the valid JSON document b and re-encodes the result. Everything else,
+// including the strings fn leaves unchanged, is copied through untouched.
+func filterJSONStrings(b []byte, fn func([]byte) []byte) []byte {
+	out := make([]byte, 0, len(b))
+
+	for {
+		i := bytes.IndexByte(b, '"')
+		if i < 0 {
+			return append(out, b...)
+		}
+
+		out = append(out, b[:i]...)
+
+		n := i + jsonStringLen(b[i:])
+		literal := b[i:n]
+		b = b[n:]
+
+		if isJSONKey(b) {
+			out = append(out, literal...)
+		} else {
+			out = append(out, filterJSONString(literal, fn)...)
+		}
+	}
+}
+
+// jsonStringLen returns the length of the string literal at the start of b,
+// quotes included.
+func jsonStringLen(b []byte) int {
+	for i := 1; i < len(b); i++ {
+		switch b[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1
+		}
+	}
+
+	return len(b)
+}
+
+// isJSONKey reports whether the string literal followed by rest is an object
+// key.
+func isJSONKey(rest []byte) bool {
+	rest = bytes.TrimLeft(rest, " \t\r\n")
+
+	return len(rest) > 0 && rest[0] == ':'
+}
+
+// filterJSONString applies fn to the string literal's content. The result is
+// encoded with json.Marshal, which also escapes the U+2028 and U+2029 line
+// separators older JavaScript engines reject in string literals.
+func filterJSONString(literal []byte, fn func([]byte) []byte) []byte {
+	var s string
+	if err := json.Unmarshal(literal, &s); err != nil {
+		return literal
+	}
+
+	filtered := fn([]byte(s))
+	if string(filtered) == s {
+		return literal
+	}
+
+	encoded, err := json.Marshal(string(filtered))
+	if err != nil {
+		return literal
+	}
+
+	return encoded
+}
@@ -0,0 +1,97 @@
+package subfilter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestJSONP(t *testing.T) {
+	tests := []struct {
+		desc        string
+		callback    string
+		replacement string
+		contentType string
+		resBody     string
+		expResBody  string
+	}{
+		{
+			desc:        "should filter the string values of the payload",
+			contentType: "application/javascript",
+			resBody:     `cb({"url":"http://old.example.com/a","old.example.com":1,"n":"old.example.com"});`,
+			expResBody:  `cb({"url":"http://new.example.com/a","old.example.com":1,"n":"new.example.com"});`,
+		},
+		{
+			desc:        "should keep the callback and the guard comment",
+			contentType: "text/javascript; charset=utf-8",
+			resBody:     "/**/ jQuery.cb_1 ( [\"old.example.com\"] )\n",
+			expResBody:  "/**/ jQuery.cb_1 ( [\"new.example.com\"] )\n",
+		},
+		{
+			desc:        "should escape the replacement as JSON",
+			replacement: `"quoted" <b>`,
+			contentType: "application/javascript",
+			resBody:     `cb({"q":"old.example.com"})`,
+			expResBody:  `cb({"q":"\"quoted\" \u003cb\u003e"})`,
+		},
+		{
+			desc:        "should match a configured callback",
+			callback:    `handle\d+`,
+			contentType: "application/javascript",
+			resBody:     `handle42({"a":"old.example.com"})`,
+			expResBody:  `handle42({"a":"new.example.com"})`,
+		},
+		{
+			desc:        "should filter other callbacks as plain scripts",
+			callback:    `handle\d+`,
+			contentType: "application/javascript",
+			resBody:     `other({"old.example.com":1})`,
+			expResBody:  `other({"new.example.com":1})`,
+		},
+		{
+			desc:        "should filter invalid payloads as plain scripts",
+			contentType: "application/javascript",
+			resBody:     `cb(old.example.com)`,
+			expResBody:  `cb(new.example.com)`,
+		},
+		{
+			desc:        "should not apply to other content types",
+			contentType: "application/json",
+			resBody:     `cb({"old.example.com":1})`,
+			expResBody:  `cb({"new.example.com":1})`,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			replacement := test.replacement
+			if replacement == "" {
+				replacement = "new.example.com"
+			}
+
+			config.Filters = []Filter{{Regex: `old\.example\.com`, Replacement: replacement}}
+			config.JSONP = true
+			config.JSONPCallback = test.callback
+
+			next := func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Type", test.contentType)
+				_, _ = w.Write([]byte(test.resBody))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if got := recorder.Body.String(); got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+		})
+	}
+}
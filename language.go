@@ -0,0 +1,159 @@
+package subfilter
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// defaultLanguage is the language condition matching requests for which no
+// configured language fits.
+const defaultLanguage = "*"
+
+// parseAcceptLanguage returns the lowercased language tags of an
+// Accept-Language header value, most preferred first. Tags with q=0, the
+// wildcard and malformed tags are dropped, as they name no language to select.
+func parseAcceptLanguage(v string) []string {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+
+	var tags []weighted
+
+	for _, part := range strings.Split(v, ",") {
+		fields := strings.Split(part, ";")
+
+		tag := strings.ToLower(strings.TrimSpace(fields[0]))
+		if !isLanguageTag(tag) {
+			continue
+		}
+
+		q := 1.0
+
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if len(param) < 2 || !strings.EqualFold(param[:2], "q=") {
+				continue
+			}
+
+			parsed, err := strconv.ParseFloat(param[2:], 64)
+			if err != nil || parsed < 0 || parsed > 1 {
+				parsed = 0
+			}
+
+			q = parsed
+		}
+
+		if q > 0 {
+			tags = append(tags, weighted{tag: tag, q: q})
+		}
+	}
+
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+
+	languages := make([]string, len(tags))
+	for i, t := range tags {
+		languages[i] = t.tag
+	}
+
+	return languages
+}
+
+// isLanguageTag reports whether tag is made of alphanumeric subtags separated
+// by dashes, which keeps it safe to insert in a body.
+func isLanguageTag(tag string) bool {
+	if tag == "" || tag[0] == '-' || tag[len(tag)-1] == '-' {
+		return false
+	}
+
+	for _, c := range tag {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// negotiateLanguage returns the language of available best matching the
+// Accept-Language header value accept, or "" when none does. The client
+// preferences are tried in order, each matching the most specific available
+// language it equals or is a subtag of, so that "de-AT" falls back to "de".
+func negotiateLanguage(accept string, available []string) string {
+	if len(available) == 0 || accept == "" {
+		return ""
+	}
+
+	for _, tag := range parseAcceptLanguage(accept) {
+		best := ""
+
+		for _, lang := range available {
+			if len(lang) > len(best) && (tag == lang || strings.HasPrefix(tag, lang+"-")) {
+				best = lang
+			}
+		}
+
+		if best != "" {
+			return best
+		}
+	}
+
+	return ""
+}
+
+// ruleLanguages returns every language the conditions of rules select, the
+// default one excluded.
+func ruleLanguages(rules []rule) []string {
+	var languages []string
+
+	for _, rl := range rules {
+		if rl.conditions == nil {
+			continue
+		}
+
+		for _, lang := range rl.conditions.languages {
+			if lang != defaultLanguage {
+				languages = append(languages, lang)
+			}
+		}
+	}
+
+	return languages
+}
+
+// requestLanguage returns the language the rules select for r.
+func (s *SubFilter) requestLanguage(r *http.Request) string {
+	return negotiateLanguage(r.Header.Get("Accept-Language"), s.languages)
+}
+
+// addVary adds name to the Vary header of h unless it is already listed or
+// Vary is "*". It is appended to the last Vary value rather than added as a
+// separate header line.
+func addVary(h http.Header, name string) {
+	values := h.Values("Vary")
+
+	for _, v := range values {
+		for _, field := range strings.Split(v, ",") {
+			field = strings.TrimSpace(field)
+			if field == "*" || strings.EqualFold(field, name) {
+				return
+			}
+		}
+	}
+
+	if n := len(values); n > 0 && strings.TrimSpace(values[n-1]) != "" {
+		merged := append(append([]string(nil), values[:n-1]...), strings.TrimSpace(values[n-1])+", "+name)
+
+		h.Del("Vary")
+
+		for _, v := range merged {
+			h.Add("Vary", v)
+		}
+
+		return
+	}
+
+	h.Set("Vary", name)
+}
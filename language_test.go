@@ -0,0 +1,98 @@
+package subfilter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNegotiateLanguage(t *testing.T) {
+	available := []string{"en", "de", "fr-ca"}
+
+	tests := []struct {
+		desc    string
+		accept  string
+		expLang string
+	}{
+		{desc: "should pick the first preference", accept: "de, en", expLang: "de"},
+		{desc: "should order preferences by q-value", accept: "en;q=0.5, de;q=0.8", expLang: "de"},
+		{desc: "should fall back from a region to its base language", accept: "de-AT", expLang: "de"},
+		{desc: "should not fall back from a base language to a region", accept: "fr", expLang: ""},
+		{desc: "should match regions case-insensitively", accept: "FR-CA", expLang: "fr-ca"},
+		{desc: "should skip unavailable preferences", accept: "es, en;q=0.1", expLang: "en"},
+		{desc: "should ignore refused languages", accept: "de;q=0, en;q=0.1", expLang: "en"},
+		{desc: "should ignore malformed tags", accept: `"><script>, en;q=0.1`, expLang: "en"},
+		{desc: "should not match without the header", accept: "", expLang: ""},
+		{desc: "should not match the wildcard", accept: "*", expLang: ""},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			if got := negotiateLanguage(test.accept, available); got != test.expLang {
+				t.Errorf("got language %q, want %q", got, test.expLang)
+			}
+		})
+	}
+}
+
+func TestRulesLanguages(t *testing.T) {
+	tests := []struct {
+		desc       string
+		accept     string
+		expResBody string
+	}{
+		{desc: "should apply the rule of the preferred language", accept: "fr;q=0.9, de", expResBody: "Hallo (de)"},
+		{desc: "should apply the rule of the base language", accept: "de-AT", expResBody: "Hallo (de)"},
+		{desc: "should apply the default rule without a match", accept: "es", expResBody: "Hello (es)"},
+		{desc: "should apply the default rule without the header", expResBody: "Hello ()"},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.Rules = []Rule{
+				{
+					Conditions: Conditions{Languages: []string{"de"}},
+					Filters:    []Filter{{Regex: "BANNER", Replacement: "Hallo"}},
+				},
+				{
+					Conditions: Conditions{Languages: []string{"fr"}},
+					Filters:    []Filter{{Regex: "BANNER", Replacement: "Bonjour"}},
+				},
+				{
+					Conditions: Conditions{Languages: []string{"*"}},
+					Filters:    []Filter{{Regex: "BANNER", Replacement: "Hello"}},
+				},
+			}
+			config.Filters = []Filter{{Regex: "LANG", Replacement: "${lang}", Transforms: true}}
+
+			next := func(w http.ResponseWriter, _ *http.Request) {
+				_, _ = w.Write([]byte("BANNER (LANG)"))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if test.accept != "" {
+				req.Header.Set("Accept-Language", test.accept)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+
+			if got := recorder.Body.String(); got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+
+			if got := recorder.Header().Get("Vary"); got != "Accept-Language" {
+				t.Errorf("got Vary %q, want %q", got, "Accept-Language")
+			}
+		})
+	}
+}
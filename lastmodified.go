@@ -0,0 +1,87 @@
+package subfilter
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	lastModifiedRemove = "remove"
+	lastModifiedKeep   = "keep"
+	lastModifiedUpdate = "update"
+)
+
+// LastModifiedMode is what happens to the Last-Modified header of filtered
+// responses: "remove" (the default) drops it, "keep" keeps the upstream value
+// and "update" sets it to the time of the rewrite when the body changed. The
+// boolean values of older configurations, true for "keep" and false for
+// "remove", are still accepted, including in the "1" and "0" forms weakly
+// typed decoders turn them into.
+type LastModifiedMode string
+
+// UnmarshalJSON accepts both the mode names and the legacy booleans.
+func (m *LastModifiedMode) UnmarshalJSON(b []byte) error {
+	var keep bool
+	if err := json.Unmarshal(b, &keep); err == nil {
+		*m = LastModifiedMode(legacyLastModified(keep))
+
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("lastModified must be a string or a boolean: %w", err)
+	}
+
+	*m = LastModifiedMode(s)
+
+	return nil
+}
+
+func legacyLastModified(keep bool) string {
+	if keep {
+		return lastModifiedKeep
+	}
+
+	return lastModifiedRemove
+}
+
+func (s *SubFilter) setupLastModified(config *Config) error {
+	switch mode := strings.ToLower(string(config.LastModified)); mode {
+	case "", lastModifiedRemove, "false", "0":
+		s.lastModified = lastModifiedRemove
+	case lastModifiedKeep, "true", "1":
+		s.lastModified = lastModifiedKeep
+	case lastModifiedUpdate:
+		s.lastModified = mode
+	default:
+		return fmt.Errorf("invalid lastModified %q: must be %q, %q or %q",
+			config.LastModified, lastModifiedRemove, lastModifiedKeep, lastModifiedUpdate)
+	}
+
+	return nil
+}
+
+// updateLastModified sets the Last-Modified header of a response whose body
+// was changed to the current time, in update mode.
+func (s *SubFilter) updateLastModified(h http.Header) {
+	if s.lastModified == lastModifiedUpdate {
+		h.Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+	}
+}
+
+// updateAgeDate resets the Age header of a response whose body was changed
+// and sets its Date header to the time the body started being filtered, as
+// configured.
+func (s *SubFilter) updateAgeDate(h http.Header, sc *scope) {
+	if s.resetAge && h.Get("Age") != "" {
+		h.Set("Age", "0")
+	}
+
+	if s.updateDate {
+		h.Set("Date", sc.time().UTC().Format(http.TimeFormat))
+	}
+}
@@ -0,0 +1,156 @@
+package subfilter
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLastModifiedModes(t *testing.T) {
+	const upstream = "Thu, 02 Jun 2016 06:01:08 GMT"
+
+	tests := []struct {
+		desc    string
+		mode    LastModifiedMode
+		resBody string
+		// exp is the expected header: "" for none, "now" for a fresh time.
+		exp string
+	}{
+		{desc: "should remove by default when modified", resBody: "foo", exp: ""},
+		{desc: "should remove by default when unmodified", resBody: "baz", exp: ""},
+		{desc: "should remove when modified", mode: "remove", resBody: "foo", exp: ""},
+		{desc: "should remove when unmodified", mode: "Remove", resBody: "baz", exp: ""},
+		{desc: "should keep when modified", mode: "keep", resBody: "foo", exp: upstream},
+		{desc: "should keep when unmodified", mode: "keep", resBody: "baz", exp: upstream},
+		{desc: "should update when modified", mode: "update", resBody: "foo", exp: "now"},
+		{desc: "should keep the upstream value in update mode when unmodified", mode: "update", resBody: "baz", exp: upstream},
+		{desc: "should keep with the legacy true", mode: "true", resBody: "foo", exp: upstream},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.Filters = []Filter{{Regex: "foo", Replacement: "bar"}}
+			config.LastModified = test.mode
+
+			next := func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Last-Modified", upstream)
+				_, _ = w.Write([]byte(test.resBody))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			before := time.Now().Add(-time.Second)
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			got := recorder.Header().Get("Last-Modified")
+
+			if test.exp != "now" {
+				if got != test.exp {
+					t.Errorf("got Last-Modified %q, want %q", got, test.exp)
+				}
+
+				return
+			}
+
+			at, err := http.ParseTime(got)
+			if err != nil || at.Before(before) || at.After(time.Now()) {
+				t.Errorf("got Last-Modified %q, want the time of the rewrite", got)
+			}
+		})
+	}
+}
+
+func TestLastModifiedModeDecoding(t *testing.T) {
+	tests := []struct {
+		json string
+		exp  LastModifiedMode
+	}{
+		{`{"lastModified": true}`, "keep"},
+		{`{"lastModified": false}`, "remove"},
+		{`{"lastModified": "update"}`, "update"},
+	}
+
+	for _, test := range tests {
+		var config Config
+		if err := json.Unmarshal([]byte(test.json), &config); err != nil {
+			t.Fatalf("unable to decode %s: %v", test.json, err)
+		}
+
+		if config.LastModified != test.exp {
+			t.Errorf("got %q from %s, want %q", config.LastModified, test.json, test.exp)
+		}
+	}
+
+	config := CreateConfig()
+	config.Filters = []Filter{{Regex: "foo"}}
+	config.LastModified = "touch"
+
+	if _, err := New(context.Background(), nil, config, "subfilter"); err == nil {
+		t.Error("expected error for an unknown lastModified mode")
+	}
+}
+
+func TestAgeDateOnRewrite(t *testing.T) {
+	const upstreamDate = "Thu, 02 Jun 2016 06:01:08 GMT"
+
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		desc       string
+		resetAge   bool
+		updateDate bool
+		resBody    string
+		expAge     string
+		expDate    string
+	}{
+		{desc: "should keep both by default", resBody: "foo", expAge: "120", expDate: upstreamDate},
+		{desc: "should reset Age when modified", resetAge: true, resBody: "foo", expAge: "0", expDate: upstreamDate},
+		{desc: "should update Date when modified", updateDate: true, resBody: "foo", expAge: "120", expDate: now.Format(http.TimeFormat)},
+		{desc: "should do both when modified", resetAge: true, updateDate: true, resBody: "foo", expAge: "0", expDate: now.Format(http.TimeFormat)},
+		{desc: "should keep both when unmodified", resetAge: true, updateDate: true, resBody: "baz", expAge: "120", expDate: upstreamDate},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.Filters = []Filter{{Regex: "foo", Replacement: "bar"}}
+			config.ResetAgeOnRewrite = test.resetAge
+			config.UpdateDateOnRewrite = test.updateDate
+
+			next := func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Age", "120")
+				w.Header().Set("Date", upstreamDate)
+				_, _ = w.Write([]byte(test.resBody))
+			}
+
+			opts := Options{Now: func() time.Time { return now }}
+
+			handler, err := NewWithOptions(context.Background(), http.HandlerFunc(next), config, "subfilter", opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if got := recorder.Header().Get("Age"); got != test.expAge {
+				t.Errorf("got Age %q, want %q", got, test.expAge)
+			}
+
+			if got := recorder.Header().Get("Date"); got != test.expDate {
+				t.Errorf("got Date %q, want %q", got, test.expDate)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package subfilter
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	overflowWait   = "wait"
+	overflowBypass = "bypass"
+
+	defaultConcurrencyWait = time.Second
+)
+
+// limiter caps the number of bodies filtered at the same time.
+type limiter struct {
+	slots chan struct{}
+	// wait is how long to wait for a slot before passing the response
+	// through unfiltered; zero does not wait.
+	wait time.Duration
+}
+
+func (s *SubFilter) setupLimiter(config *Config) error {
+	if config.MaxConcurrent <= 0 {
+		return nil
+	}
+
+	l := &limiter{slots: make(chan struct{}, config.MaxConcurrent), wait: defaultConcurrencyWait}
+
+	switch strings.ToLower(config.ConcurrencyOverflow) {
+	case "", overflowWait:
+		if config.ConcurrencyWait != "" {
+			wait, err := time.ParseDuration(config.ConcurrencyWait)
+			if err != nil || wait < 0 {
+				return fmt.Errorf("invalid concurrencyWait %q", config.ConcurrencyWait)
+			}
+
+			l.wait = wait
+		}
+	case overflowBypass:
+		l.wait = 0
+	default:
+		return fmt.Errorf("invalid concurrencyOverflow %q: must be %q or %q", config.ConcurrencyOverflow, overflowWait, overflowBypass)
+	}
+
+	s.limiter = l
+
+	return nil
+}
+
+// acquire takes a slot, waiting for one as configured, and reports whether it
+// got one. A nil limiter always succeeds.
+func (l *limiter) acquire(ctx context.Context) bool {
+	if l == nil {
+		return true
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+
+	if l.wait == 0 {
+		return false
+	}
+
+	timer := time.NewTimer(l.wait)
+	defer timer.Stop()
+
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (l *limiter) release() {
+	if l != nil {
+		<-l.slots
+	}
+}
@@ -0,0 +1,136 @@
+package subfilter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	l := &limiter{slots: make(chan struct{}, 2), wait: time.Minute}
+
+	var (
+		wg       sync.WaitGroup
+		inFlight int32
+		peak     int32
+	)
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			if !l.acquire(context.Background()) {
+				t.Error("got no slot")
+
+				return
+			}
+
+			n := atomic.AddInt32(&inFlight, 1)
+			for {
+				p := atomic.LoadInt32(&peak)
+				if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+					break
+				}
+			}
+
+			time.Sleep(5 * time.Millisecond)
+			atomic.AddInt32(&inFlight, -1)
+			l.release()
+		}()
+	}
+
+	wg.Wait()
+
+	if peak > 2 {
+		t.Errorf("got %d bodies in flight, want at most 2", peak)
+	}
+}
+
+func TestMaxConcurrent(t *testing.T) {
+	tests := []struct {
+		desc       string
+		overflow   string
+		wait       string
+		releaseIn  time.Duration
+		expResBody string
+	}{
+		{
+			desc:       "should bypass filtering beyond the limit",
+			overflow:   "bypass",
+			releaseIn:  time.Second,
+			expResBody: "foo",
+		},
+		{
+			desc:       "should bypass filtering when no slot frees up in time",
+			wait:       "20ms",
+			releaseIn:  time.Second,
+			expResBody: "foo",
+		},
+		{
+			desc:       "should wait for a slot",
+			wait:       "5s",
+			releaseIn:  20 * time.Millisecond,
+			expResBody: "bar",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.Filters = []Filter{{Regex: "foo", Replacement: "bar"}}
+			config.MaxConcurrent = 1
+			config.ConcurrencyOverflow = test.overflow
+			config.ConcurrencyWait = test.wait
+
+			started := make(chan struct{})
+			unblock := make(chan struct{})
+
+			next := func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+
+				if r.URL.Path == "/slow" {
+					close(started)
+					<-unblock
+				}
+
+				_, _ = w.Write([]byte("foo"))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			done := make(chan struct{})
+
+			go func() {
+				defer close(done)
+				handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/slow", nil))
+			}()
+
+			<-started
+
+			timer := time.AfterFunc(test.releaseIn, func() { close(unblock) })
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if got := recorder.Body.String(); got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+
+			if timer.Stop() {
+				close(unblock)
+			}
+
+			<-done
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package subfilter
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+
+	logLevelDebug = "debug"
+	logLevelWarn  = "warn"
+	logLevelError = "error"
+)
+
+// jsonLogMu serializes the JSON lines written to the log output, which they
+// reach without going through the logger.
+var jsonLogMu sync.Mutex
+
+func (s *SubFilter) setupLogFormat(config *Config) error {
+	switch strings.ToLower(config.LogFormat) {
+	case "", logFormatText:
+	case logFormatJSON:
+		s.jsonLogs = true
+	default:
+		return fmt.Errorf("invalid logFormat %q: must be %q or %q", config.LogFormat, logFormatText, logFormatJSON)
+	}
+
+	return nil
+}
+
+// logEntry is the line of the JSON log format of the time a filter spent on a
+// body.
+type logEntry struct {
+	Time       string  `json:"time"`
+	Level      string  `json:"level"`
+	Middleware string  `json:"middleware"`
+	Message    string  `json:"msg"`
+	Method     string  `json:"method,omitempty"`
+	Path       string  `json:"path,omitempty"`
+	Filter     string  `json:"filter,omitempty"`
+	Matches    int     `json:"matches"`
+	BodySize   int     `json:"bodySize"`
+	DurationMs float64 `json:"durationMs"`
+}
+
+// logMessage is the line of the JSON log format of any other event of a
+// response.
+type logMessage struct {
+	Time       string `json:"time"`
+	Level      string `json:"level"`
+	Middleware string `json:"middleware"`
+	Message    string `json:"msg"`
+	Method     string `json:"method,omitempty"`
+	Path       string `json:"path,omitempty"`
+}
+
+// logJSON writes e as a single JSON line to the log output, without the
+// prefix and date of text lines, which its time field stands for.
+func (s *SubFilter) logJSON(e logEntry) {
+	e.Time = time.Now().UTC().Format(time.RFC3339Nano)
+	e.Middleware = s.name
+
+	s.writeJSONLog(e)
+}
+
+// logResponse logs the message formatted from format and args about the
+// response to r: as a JSON line with the JSON log format, and otherwise
+// prefixed with the middleware name, and with "debug:" at the debug level.
+func (s *SubFilter) logResponse(level string, r *http.Request, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+
+	if !s.jsonLogs {
+		if level == logLevelDebug {
+			msg = "debug: " + msg
+		}
+
+		log.Print(s.name + ": " + msg)
+
+		return
+	}
+
+	s.writeJSONLog(logMessage{
+		Time:       time.Now().UTC().Format(time.RFC3339Nano),
+		Level:      level,
+		Middleware: s.name,
+		Message:    msg,
+		Method:     r.Method,
+		Path:       r.URL.Path,
+	})
+}
+
+func (s *SubFilter) writeJSONLog(v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("%s: unable to encode log entry: %v", s.name, err)
+
+		return
+	}
+
+	jsonLogMu.Lock()
+	defer jsonLogMu.Unlock()
+
+	_, _ = log.Writer().Write(append(b, '\n'))
+}
@@ -0,0 +1,161 @@
+package subfilter
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestJSONLogs(t *testing.T) {
+	var logs bytes.Buffer
+
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	config := CreateConfig()
+	config.Debug = true
+	config.LogFormat = "JSON"
+	config.Filters = []Filter{{Regex: "foo", Replacement: "bar"}, {Regex: "absent"}}
+
+	next := func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("foo foo baz"))
+	}
+
+	handler, err := New(context.Background(), http.HandlerFunc(next), config, "my-filter")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/page", nil))
+
+	var entries []map[string]interface{}
+
+	for scanner := bufio.NewScanner(&logs); scanner.Scan(); {
+		var e map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("got non-JSON log line %q: %v", scanner.Text(), err)
+		}
+
+		entries = append(entries, e)
+	}
+
+	if len(entries) != 2 {
+		t.Fatalf("got %d log entries, want 2", len(entries))
+	}
+
+	for i, exp := range []map[string]interface{}{
+		{"level": "debug", "middleware": "my-filter", "method": "GET", "path": "/page", "filter": "foo",
+			"matches": 2.0, "bodySize": 11.0},
+		{"filter": "absent", "matches": 0.0, "bodySize": 11.0},
+	} {
+		for k, v := range exp {
+			if entries[i][k] != v {
+				t.Errorf("entry %d: got %s %v, want %v", i, k, entries[i][k], v)
+			}
+		}
+
+		if _, ok := entries[i]["durationMs"].(float64); !ok {
+			t.Errorf("entry %d: got durationMs %v, want a number", i, entries[i]["durationMs"])
+		}
+	}
+}
+
+func TestJSONResponseLogs(t *testing.T) {
+	var logs bytes.Buffer
+
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	config := CreateConfig()
+	config.LogFormat = "json"
+	config.LogSkipReasons = true
+	config.Filters = []Filter{{Regex: "foo", Replacement: "bar"}}
+
+	next := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/download" {
+			w.Header().Set("Content-Disposition", "attachment")
+		}
+
+		w.Header().Set("Content-Length", "100")
+		_, _ = w.Write([]byte("foo"))
+	}
+
+	handler, err := New(context.Background(), http.HandlerFunc(next), config, "my-filter")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/download", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/page", nil))
+
+	var entries []map[string]interface{}
+
+	for scanner := bufio.NewScanner(&logs); scanner.Scan(); {
+		var e map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("got non-JSON log line %q: %v", scanner.Text(), err)
+		}
+
+		entries = append(entries, e)
+	}
+
+	if len(entries) != 2 {
+		t.Fatalf("got %d log entries, want 2", len(entries))
+	}
+
+	for i, exp := range []map[string]interface{}{
+		{"level": "debug", "middleware": "my-filter", "method": "GET", "path": "/download",
+			"msg": "not filtering /download: attachment"},
+		{"level": "warn", "middleware": "my-filter", "method": "GET", "path": "/page",
+			"msg": `response to /page declares a Content-Length of "100" bytes but has 3`},
+	} {
+		for k, v := range exp {
+			if entries[i][k] != v {
+				t.Errorf("entry %d: got %s %v, want %v", i, k, entries[i][k], v)
+			}
+		}
+	}
+}
+
+func TestTextLogs(t *testing.T) {
+	var logs bytes.Buffer
+
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	config := CreateConfig()
+	config.Debug = true
+	config.Filters = []Filter{{Regex: "foo", Replacement: "bar"}}
+
+	next := func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("foo"))
+	}
+
+	handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/page", nil))
+
+	if got := logs.String(); !strings.Contains(got, `subfilter: filter "foo" took `) || strings.Contains(got, "{") {
+		t.Errorf("got logs %q, want a text line", got)
+	}
+}
+
+func TestLogFormatConfig(t *testing.T) {
+	config := CreateConfig()
+	config.Filters = []Filter{{Regex: "foo"}}
+	config.LogFormat = "xml"
+
+	if _, err := New(context.Background(), nil, config, "subfilter"); err == nil {
+		t.Error("expected error")
+	}
+}
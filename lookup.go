@@ -0,0 +1,253 @@
+package subfilter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	lookupPlaceholder = "{match}"
+
+	defaultLookupTimeout   = time.Second
+	defaultLookupCacheTTL  = 5 * time.Minute
+	defaultLookupCacheSize = 1000
+
+	// maxLookupResponseSize bounds the replacement read from a lookup.
+	maxLookupResponseSize = 64 << 10
+
+	// lookupFailureTTL is how long a failed lookup is not retried: matches
+	// the service had no value for are not looked up again, and none are
+	// while it is unreachable or failing, so that a down service does not
+	// cost a timeout per match of every response.
+	lookupFailureTTL = 10 * time.Second
+)
+
+// Lookup replaces every match with the body of a GET request to URL, in which
+// {match} stands for the query-escaped match.
+type Lookup struct {
+	URL string `json:"url,omitempty"`
+	// Allow lists the origins (scheme://host[:port]) URL may point to. It is
+	// required.
+	Allow []string `json:"allow,omitempty"`
+	// Timeout bounds each request (1s by default).
+	Timeout string `json:"timeout,omitempty"`
+	// CacheTTL is how long a looked up value is reused (5m by default), and
+	// CacheSize how many values are kept (1000 by default).
+	CacheTTL  string `json:"cacheTTL,omitempty"`
+	CacheSize int    `json:"cacheSize,omitempty"`
+}
+
+type lookup struct {
+	url    string
+	allow  map[string]bool
+	client *http.Client
+	cache  *ttlCache
+	// misses holds the matches the service had no value for, and downUntil
+	// is when the service is tried again after it last failed.
+	misses    *ttlCache
+	mu        sync.Mutex
+	downUntil time.Time
+}
+
+func newLookup(config *Lookup) (*lookup, error) {
+	if !strings.Contains(config.URL, lookupPlaceholder) {
+		return nil, fmt.Errorf("lookup url %q must contain %s", config.URL, lookupPlaceholder)
+	}
+
+	if len(config.Allow) == 0 {
+		return nil, errors.New("lookup allow list is required")
+	}
+
+	l := &lookup{url: config.URL, allow: make(map[string]bool, len(config.Allow))}
+
+	for _, origin := range config.Allow {
+		l.allow[strings.ToLower(strings.TrimSuffix(origin, "/"))] = true
+	}
+
+	if !l.allowed(strings.Replace(config.URL, lookupPlaceholder, "x", -1)) {
+		return nil, fmt.Errorf("lookup url %q is not in the allow list", config.URL)
+	}
+
+	timeout, err := parseDurationDefault(config.Timeout, defaultLookupTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("invalid lookup timeout: %w", err)
+	}
+
+	ttl, err := parseDurationDefault(config.CacheTTL, defaultLookupCacheTTL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid lookup cacheTTL: %w", err)
+	}
+
+	size := config.CacheSize
+	if size <= 0 {
+		size = defaultLookupCacheSize
+	}
+
+	l.client = &http.Client{
+		Timeout: timeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	l.cache = newTTLCache(ttl, size)
+	l.misses = newTTLCache(lookupFailureTTL, size)
+
+	return l, nil
+}
+
+func parseDurationDefault(s string, def time.Duration) (time.Duration, error) {
+	if s == "" {
+		return def, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+
+	if d <= 0 {
+		return 0, fmt.Errorf("duration %q must be positive", s)
+	}
+
+	return d, nil
+}
+
+// allowed reports whether the origin of rawURL is in the allow list.
+func (l *lookup) allowed(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return false
+	}
+
+	return l.allow[strings.ToLower(u.Scheme+"://"+u.Host)]
+}
+
+// resolve returns the replacement for match, from the cache or the lookup
+// service, and reports whether it got one.
+func (l *lookup) resolve(ctx context.Context, match string) (string, bool) {
+	if v, ok := l.cache.get(match); ok {
+		return v, true
+	}
+
+	if _, missed := l.misses.get(match); missed || l.down() {
+		return "", false
+	}
+
+	target := strings.Replace(l.url, lookupPlaceholder, url.QueryEscape(match), -1)
+	if !l.allowed(target) {
+		return "", false
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return "", false
+	}
+
+	res, err := l.client.Do(req)
+	if err != nil {
+		// A request canceled along with the response is no sign of the
+		// service being down.
+		if ctx.Err() == nil {
+			l.markDown()
+		}
+
+		return "", false
+	}
+
+	defer func() { _ = res.Body.Close() }()
+
+	if res.StatusCode >= 500 {
+		l.markDown()
+
+		return "", false
+	}
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		l.misses.set(match, "")
+
+		return "", false
+	}
+
+	b, err := ioutil.ReadAll(io.LimitReader(res.Body, maxLookupResponseSize))
+	if err != nil {
+		l.markDown()
+
+		return "", false
+	}
+
+	v := strings.TrimSpace(string(b))
+	l.cache.set(match, v)
+
+	return v, true
+}
+
+// down reports whether the service failed less than lookupFailureTTL ago.
+func (l *lookup) down() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return time.Now().Before(l.downUntil)
+}
+
+// markDown stops looking values up for lookupFailureTTL.
+func (l *lookup) markDown() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.downUntil = time.Now().Add(lookupFailureTTL)
+}
+
+// ttlCache is a size-bounded cache whose entries expire after ttl. When full,
+// the oldest entry is evicted.
+type ttlCache struct {
+	mu    sync.Mutex
+	ttl   time.Duration
+	size  int
+	items map[string]cacheItem
+	order []string
+}
+
+type cacheItem struct {
+	value   string
+	expires time.Time
+}
+
+func newTTLCache(ttl time.Duration, size int) *ttlCache {
+	return &ttlCache{ttl: ttl, size: size, items: make(map[string]cacheItem)}
+}
+
+func (c *ttlCache) get(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	item, ok := c.items[key]
+	if !ok || time.Now().After(item.expires) {
+		return "", false
+	}
+
+	return item.value, true
+}
+
+func (c *ttlCache) set(key, value string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.items[key]; !ok {
+		for len(c.order) >= c.size {
+			delete(c.items, c.order[0])
+			c.order = c.order[1:]
+		}
+
+		c.order = append(c.order, key)
+	}
+
+	c.items[key] = cacheItem{value: value, expires: time.Now().Add(c.ttl)}
+}
@@ -0,0 +1,147 @@
+package subfilter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLookup(t *testing.T) {
+	var hits int32
+
+	catalog := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+
+		host := r.URL.Query().Get("host")
+		if strings.HasPrefix(host, "slow") {
+			time.Sleep(200 * time.Millisecond)
+		}
+
+		if host == "missing.internal" {
+			http.NotFound(w, r)
+
+			return
+		}
+
+		_, _ = w.Write([]byte(strings.Replace(host, ".internal", ".example.com", 1) + "\n"))
+	}))
+	defer catalog.Close()
+
+	newLookupFilter := func(ttl, replacement string) Filter {
+		return Filter{
+			Regex:       `\w+\.internal`,
+			Replacement: replacement,
+			Lookup: &Lookup{
+				URL:      catalog.URL + "/resolve?host={match}",
+				Allow:    []string{catalog.URL},
+				Timeout:  "50ms",
+				CacheTTL: ttl,
+			},
+		}
+	}
+
+	t.Run("should replace matches and cache the values", func(t *testing.T) {
+		atomic.StoreInt32(&hits, 0)
+
+		filter := newLookupFilter("", "")
+
+		config := CreateConfig()
+		config.Filters = []Filter{filter}
+
+		next := func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("api.internal and api.internal"))
+		}
+
+		handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 2; i++ {
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if got := recorder.Body.String(); got != "api.example.com and api.example.com" {
+				t.Errorf("got body %q", got)
+			}
+		}
+
+		if got := atomic.LoadInt32(&hits); got != 1 {
+			t.Errorf("got %d lookups, want 1", got)
+		}
+	})
+
+	t.Run("should look values up again once expired", func(t *testing.T) {
+		atomic.StoreInt32(&hits, 0)
+
+		got := serveTransform(t, newLookupFilter("20ms", ""), "db.internal")
+		if got != "db.example.com" {
+			t.Errorf("got body %q", got)
+		}
+
+		time.Sleep(40 * time.Millisecond)
+
+		_ = serveTransform(t, newLookupFilter("20ms", ""), "db.internal")
+
+		if got := atomic.LoadInt32(&hits); got != 2 {
+			t.Errorf("got %d lookups, want 2", got)
+		}
+	})
+
+	t.Run("should fall back to the replacement when the lookup times out", func(t *testing.T) {
+		if got := serveTransform(t, newLookupFilter("", "unknown"), "slow.internal"); got != "unknown" {
+			t.Errorf("got body %q, want the static replacement", got)
+		}
+
+		if got := serveTransform(t, newLookupFilter("", ""), "slow.internal"); got != "slow.internal" {
+			t.Errorf("got body %q, want the match unchanged", got)
+		}
+	})
+
+	t.Run("should stop looking values up once the lookup times out", func(t *testing.T) {
+		atomic.StoreInt32(&hits, 0)
+
+		got := serveTransform(t, newLookupFilter("", ""), "slow1.internal slow2.internal slow3.internal")
+		if got != "slow1.internal slow2.internal slow3.internal" {
+			t.Errorf("got body %q, want the matches unchanged", got)
+		}
+
+		if got := atomic.LoadInt32(&hits); got != 1 {
+			t.Errorf("got %d lookups, want 1", got)
+		}
+	})
+
+	t.Run("should not look up again values the service does not have", func(t *testing.T) {
+		atomic.StoreInt32(&hits, 0)
+
+		got := serveTransform(t, newLookupFilter("", ""), "missing.internal missing.internal api.internal")
+		if got != "missing.internal missing.internal api.example.com" {
+			t.Errorf("got body %q", got)
+		}
+
+		if got := atomic.LoadInt32(&hits); got != 2 {
+			t.Errorf("got %d lookups, want 2", got)
+		}
+	})
+}
+
+func TestLookupInvalid(t *testing.T) {
+	for _, l := range []*Lookup{
+		{URL: "https://catalog.corp/resolve?host={match}"},
+		{URL: "https://catalog.corp/resolve", Allow: []string{"https://catalog.corp"}},
+		{URL: "https://evil.corp/resolve?host={match}", Allow: []string{"https://catalog.corp"}},
+		{URL: "file:///etc/{match}", Allow: []string{"file://"}},
+		{URL: "https://catalog.corp/{match}", Allow: []string{"https://catalog.corp"}, Timeout: "soon"},
+	} {
+		config := CreateConfig()
+		config.Filters = []Filter{{Regex: "foo", Lookup: l}}
+
+		if _, err := New(context.Background(), nil, config, "subfilter"); err == nil {
+			t.Errorf("expected error for lookup %+v", l)
+		}
+	}
+}
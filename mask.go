@@ -0,0 +1,90 @@
+package subfilter
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	maskFull    = "full"
+	maskPartial = "partial"
+	maskHash    = "hash"
+
+	// maskKeep is the number of trailing letters and digits partial masks
+	// keep of matches that are not email addresses.
+	maskKeep = 4
+)
+
+// compileMask returns the normalized mask mode of f, setting the hasher of nf
+// for the hash mode.
+func compileMask(f Filter, nf *filter) (string, error) {
+	switch mode := strings.ToLower(f.Mask); mode {
+	case "":
+		return "", nil
+	case maskFull, maskPartial:
+		return mode, nil
+	case maskHash:
+		nf.hash = &hasher{newHash: sha256.New}
+
+		return "", nil
+	default:
+		return "", fmt.Errorf("invalid mask %q: must be %q, %q or %q", f.Mask, maskFull, maskPartial, maskHash)
+	}
+}
+
+// maskMatch returns match masked with mode. Full masks replace every
+// character with '*'. Partial masks of email addresses keep the first
+// character of the local part and the top-level domain, as in a***@***.com;
+// those of other matches, such as phone numbers, keep their punctuation and
+// last letters or digits, as in +* (***) ***-4567.
+func maskMatch(mode string, match []byte) []byte {
+	if mode == maskFull {
+		return bytes.Repeat([]byte("*"), utf8.RuneCount(match))
+	}
+
+	if at := bytes.LastIndexByte(match, '@'); at > 0 {
+		_, size := utf8.DecodeRune(match)
+		out := append(append([]byte(nil), match[:size]...), "***@***"...)
+
+		if dot := bytes.LastIndexByte(match[at:], '.'); dot >= 0 {
+			out = append(out, match[at+dot:]...)
+		}
+
+		return out
+	}
+
+	isAlnum := func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }
+
+	// masked is the number of letters and digits to mask; short matches are
+	// masked whole.
+	masked := 0
+
+	for _, r := range string(match) {
+		if isAlnum(r) {
+			masked++
+		}
+	}
+
+	if masked > maskKeep {
+		masked -= maskKeep
+	}
+
+	out := make([]byte, 0, len(match))
+
+	for _, r := range string(match) {
+		if isAlnum(r) && masked > 0 {
+			out = append(out, '*')
+			masked--
+
+			continue
+		}
+
+		out = append(out, string(r)...)
+	}
+
+	return out
+}
@@ -0,0 +1,73 @@
+package subfilter
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+func TestMask(t *testing.T) {
+	sum := sha256.Sum256([]byte("alice@example.com"))
+
+	tests := []struct {
+		desc       string
+		filter     Filter
+		resBody    string
+		expResBody string
+	}{
+		{
+			desc:       "should mask emails partially",
+			filter:     Filter{Preset: "email", Mask: "partial"},
+			resBody:    "contact alice@example.com or bob@mail.example.org",
+			expResBody: "contact a***@***.com or b***@***.org",
+		},
+		{
+			desc:       "should mask phone numbers partially, keeping their format",
+			filter:     Filter{Regex: `\+?[0-9][0-9 ()-]{6,}[0-9]`, Mask: "partial"},
+			resBody:    "call +1 (555) 123-4567 or 555-123-4567",
+			expResBody: "call +* (***) ***-4567 or ***-***-4567",
+		},
+		{
+			desc:       "should mask short matches whole",
+			filter:     Filter{Regex: `[0-9]+`, Mask: "partial"},
+			resBody:    "pin 1234",
+			expResBody: "pin ****",
+		},
+		{
+			desc:       "should mask matches fully, preserving their length",
+			filter:     Filter{Preset: "email", Mask: "full"},
+			resBody:    "contact alice@example.com",
+			expResBody: "contact *****************",
+		},
+		{
+			desc:       "should hash matches",
+			filter:     Filter{Preset: "email", Mask: "hash"},
+			resBody:    "contact alice@example.com",
+			expResBody: "contact " + hex.EncodeToString(sum[:]),
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			if got := serveTransform(t, test.filter, test.resBody); got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+		})
+	}
+}
+
+func TestMaskConfig(t *testing.T) {
+	for _, f := range []Filter{
+		{Regex: "foo", Mask: "blur"},
+		{Regex: "foo", Mask: "full", Replacement: "bar"},
+		{Regex: "foo", Mask: "full", HashReplacement: &HashReplacement{}},
+		{Regex: "foo", Mask: "partial", Transforms: true},
+		{Type: "bytes", Regex: "00", Mask: "full"},
+		{Type: "range", Start: "<a>", End: "</a>", Mask: "full"},
+	} {
+		if _, err := compileFilters([]Filter{f}); err == nil {
+			t.Errorf("expected an error for %+v", f)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package subfilter
+
+import (
+	"mime"
+	"strings"
+)
+
+// mediaType returns the lowercased media type of a Content-Type header value,
+// without parameters. Values that cannot be parsed yield an empty string.
+func mediaType(contentType string) string {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+
+	return strings.ToLower(mt)
+}
+
+// matchMediaType reports whether the media type of contentType matches one of
+// patterns.
+func matchMediaType(patterns []string, contentType string) bool {
+	mt := mediaType(contentType)
+	if mt == "" {
+		return false
+	}
+
+	for _, p := range patterns {
+		if mediaTypeMatches(p, mt) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// mediaTypeMatches reports whether the normalized media type mt matches
+// pattern. Patterns are "type/subtype" pairs in which either part may contain
+// "*" wildcards, such as "text/*" or "application/*+json". A pattern without a
+// slash, such as "*+xml", is matched against the subtype alone, and "*" or
+// "*/*" match everything.
+func mediaTypeMatches(pattern, mt string) bool {
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+
+	i := strings.IndexByte(mt, '/')
+	if i < 0 {
+		return false
+	}
+
+	j := strings.IndexByte(pattern, '/')
+	if j < 0 {
+		return globMatch(pattern, mt[i+1:])
+	}
+
+	return globMatch(pattern[:j], mt[:i]) && globMatch(pattern[j+1:], mt[i+1:])
+}
+
+// globMatch reports whether s matches pattern, in which "*" stands for any
+// run of characters.
+func globMatch(pattern, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == s
+	}
+
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+
+	s = s[len(parts[0]):]
+
+	for _, part := range parts[1 : len(parts)-1] {
+		k := strings.Index(s, part)
+		if k < 0 {
+			return false
+		}
+
+		s = s[k+len(part):]
+	}
+
+	return strings.HasSuffix(s, parts[len(parts)-1])
+}
+
+// mediaTypeSpecificity ranks patterns from the most specific (0) to the least
+// specific.
+func mediaTypeSpecificity(pattern string) int {
+	pattern = strings.TrimSpace(pattern)
+
+	switch {
+	case pattern == "*/*" || pattern == "*":
+		return 2
+	case strings.Contains(pattern, "*"):
+		return 1
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,156 @@
+package subfilter
+
+import (
+	"bufio"
+	"bytes"
+	"log"
+	"mime"
+	"net/http"
+	"net/textproto"
+	"strconv"
+)
+
+var crlf = []byte("\r\n")
+
+// multipartBoundary returns the boundary of a multipart Content-Type value, or
+// an empty string.
+func multipartBoundary(contentType string) string {
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+
+	return params["boundary"]
+}
+
+// filterMultipart applies to each part of the multipart body b the filters
+// selectFilters picks for the part headers. Delimiters, part headers, and parts
+// left unchanged are copied byte for byte. Malformed bodies are returned as
+// they are.
+func (s *SubFilter) filterMultipart(selectFilters func(http.Header) []filter, b []byte, boundary string, sc *scope) []byte {
+	dash := []byte("--" + boundary)
+	delim := append(append([]byte(nil), crlf...), dash...)
+
+	i := 0
+	if !bytes.HasPrefix(b, dash) {
+		if i = bytes.Index(b, delim); i < 0 {
+			return b
+		}
+
+		i += len(crlf)
+	}
+
+	out := make([]byte, 0, len(b))
+	out = append(out, b[:i]...)
+
+	for {
+		after := b[i+len(dash):]
+		if bytes.HasPrefix(after, []byte("--")) {
+			return append(out, b[i:]...)
+		}
+
+		eol := bytes.Index(after, crlf)
+		if eol < 0 {
+			return b
+		}
+
+		start := i + len(dash) + eol + len(crlf)
+
+		end := bytes.Index(b[start:], delim)
+		if end < 0 {
+			return b
+		}
+
+		part, ok := s.filterPart(selectFilters, b[start:start+end], dash, sc)
+		if !ok {
+			return b
+		}
+
+		out = append(out, b[i:start]...)
+		out = append(out, part...)
+		out = append(out, crlf...)
+		i = start + end + len(crlf)
+	}
+}
+
+// filterPart filters the body of one multipart part, made of its headers, an
+// empty line and its body. It reports false if the headers cannot be parsed.
+func (s *SubFilter) filterPart(selectFilters func(http.Header) []filter, part, dash []byte, sc *scope) ([]byte, bool) {
+	headerLen := bytes.Index(part, []byte("\r\n\r\n")) + 4
+	if bytes.HasPrefix(part, crlf) {
+		headerLen = len(crlf)
+	} else if headerLen < 4 {
+		return nil, false
+	}
+
+	mh, err := textproto.NewReader(bufio.NewReader(bytes.NewReader(part[:headerLen]))).ReadMIMEHeader()
+	if err != nil {
+		return nil, false
+	}
+
+	header := http.Header(mh)
+
+	filters := selectFilters(header)
+	if len(filters) == 0 {
+		return part, true
+	}
+
+	ce := header.Get("Content-Encoding")
+	if ce != "" && ce != "identity" && ce != contentEncodingGzip {
+		return part, true
+	}
+
+	body := part[headerLen:]
+
+	if ce == contentEncodingGzip {
+		if body, err = gzipDecode(body, s.readBufferSize); err != nil {
+			log.Printf("unable to decode multipart part: %v", err)
+
+			return part, true
+		}
+	}
+
+	filtered := s.filterBody(filters, body, header.Get("Content-Type"), sc)
+	if bytes.Equal(filtered, body) {
+		return part, true
+	}
+
+	if bytes.Contains(filtered, dash) {
+		log.Printf("%s: filtered multipart part contains the boundary, keeping it unfiltered", s.name)
+
+		return part, true
+	}
+
+	if ce == contentEncodingGzip {
+		if filtered, err = gzipEncode(filtered); err != nil {
+			log.Printf("unable to encode multipart part: %v", err)
+
+			return part, true
+		}
+	}
+
+	headers := part[:headerLen]
+	if header.Get("Content-Length") != "" {
+		headers = setHeaderLine(headers, "Content-Length", strconv.Itoa(len(filtered)))
+	}
+
+	out := make([]byte, 0, len(headers)+len(filtered))
+	out = append(out, headers...)
+
+	return append(out, filtered...), true
+}
+
+// setHeaderLine replaces the value of every line of the raw header block raw
+// that sets the header key.
+func setHeaderLine(raw []byte, key, value string) []byte {
+	lines := bytes.SplitAfter(raw, crlf)
+
+	for i, line := range lines {
+		colon := bytes.IndexByte(line, ':')
+		if colon > 0 && textproto.CanonicalMIMEHeaderKey(string(bytes.TrimSpace(line[:colon]))) == key {
+			lines[i] = []byte(key + ": " + value + "\r\n")
+		}
+	}
+
+	return bytes.Join(lines, nil)
+}
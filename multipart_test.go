@@ -0,0 +1,92 @@
+package subfilter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMultipart(t *testing.T) {
+	binary := "\x00\x01secret\xff\r\n"
+
+	tests := []struct {
+		desc       string
+		resBody    func(t *testing.T) string
+		expResBody string
+	}{
+		{
+			desc: "should rewrite the JSON part and keep the binary part byte for byte",
+			resBody: func(t *testing.T) string {
+				return "preamble\r\n" +
+					"--b1\r\nContent-Type: application/json\r\nContent-Length: 18\r\n\r\n{\"token\":\"secret\"}\r\n" +
+					"--b1  \r\ncontent-type: application/octet-stream\r\n\r\n" + binary + "\r\n" +
+					"--b1--\r\nepilogue"
+			},
+			expResBody: "preamble\r\n" +
+				"--b1\r\nContent-Type: application/json\r\nContent-Length: 22\r\n\r\n{\"token\":\"[redacted]\"}\r\n" +
+				"--b1  \r\ncontent-type: application/octet-stream\r\n\r\n" + binary + "\r\n" +
+				"--b1--\r\nepilogue",
+		},
+		{
+			desc: "should decode and re-encode a gzip-encoded part",
+			resBody: func(t *testing.T) string {
+				return "--b1\r\nContent-Type: application/json\r\nContent-Encoding: gzip\r\n\r\n" +
+					string(gzipString(t, `{"token":"secret"}`)) + "\r\n--b1--"
+			},
+		},
+		{
+			desc: "should pass a body without a closing delimiter through",
+			resBody: func(t *testing.T) string {
+				return "--b1\r\nContent-Type: application/json\r\n\r\nsecret\r\n--b1\r\n"
+			},
+			expResBody: "--b1\r\nContent-Type: application/json\r\n\r\nsecret\r\n--b1\r\n",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.MultipartTypes = []string{"multipart/mixed"}
+			config.ContentTypeFilters = map[string][]Filter{
+				"application/json": {{Regex: "secret", Replacement: "[redacted]"}},
+			}
+
+			next := func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `multipart/mixed; boundary="b1"`)
+				_, _ = w.Write([]byte(test.resBody(t)))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			got := recorder.Body.String()
+
+			if test.expResBody == "" {
+				const header = "--b1\r\nContent-Type: application/json\r\nContent-Encoding: gzip\r\n\r\n"
+
+				if !strings.HasPrefix(got, header) || !strings.HasSuffix(got, "\r\n--b1--") {
+					t.Fatalf("got body %q, want the part delimiters and headers kept", got)
+				}
+
+				got = gunzipString(t, []byte(got[len(header):len(got)-len("\r\n--b1--")]))
+				if got != `{"token":"[redacted]"}` {
+					t.Errorf("got part %q, want it redacted", got)
+				}
+
+				return
+			}
+
+			if got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+		})
+	}
+}
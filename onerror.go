@@ -0,0 +1,53 @@
+package subfilter
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	filterErrorSkip        = "skip"
+	filterErrorPassthrough = "passthrough"
+	filterErrorAbort       = "abort"
+)
+
+// parseOnError returns the policy of f for the replacements it fails to
+// render, empty for the default skip policy. Only template filters can fail.
+func parseOnError(f Filter, typ string) (string, error) {
+	if f.OnError == "" {
+		return "", nil
+	}
+
+	if typ != filterTypeTemplate {
+		return "", fmt.Errorf("onError requires type %q", filterTypeTemplate)
+	}
+
+	switch policy := strings.ToLower(f.OnError); policy {
+	case filterErrorSkip:
+		return "", nil
+	case filterErrorPassthrough, filterErrorAbort:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("invalid onError %q: must be %q, %q or %q", f.OnError,
+			filterErrorSkip, filterErrorPassthrough, filterErrorAbort)
+	}
+}
+
+// failed resolves the output of f over b once one of its replacements failed
+// with err: out, where the failing matches were kept, with the skip policy,
+// or b itself otherwise. The abort policy also records err in the scope, for
+// the response to be answered with an error. Matches counted since matches
+// are discounted when b is kept.
+func (f *filter) failed(b, out []byte, err error, sc *scope, matches int) []byte {
+	if f.onError == "" {
+		return out
+	}
+
+	sc.matches = matches
+
+	if f.onError == filterErrorAbort && sc.aborted == nil {
+		sc.aborted = err
+	}
+
+	return b
+}
@@ -0,0 +1,94 @@
+package subfilter
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestFilterOnError(t *testing.T) {
+	tests := []struct {
+		desc       string
+		onError    string
+		expStatus  int
+		expResBody string
+	}{
+		{
+			desc:       "should keep the failing matches by default",
+			expStatus:  http.StatusOK,
+			expResBody: "X b X done",
+		},
+		{
+			desc:       "should keep the failing matches with skip",
+			onError:    "skip",
+			expStatus:  http.StatusOK,
+			expResBody: "X b X done",
+		},
+		{
+			desc:       "should leave the body to the next filters with passthrough",
+			onError:    "passthrough",
+			expStatus:  http.StatusOK,
+			expResBody: "a b a done",
+		},
+		{
+			desc:       "should answer with a 502 with abort",
+			onError:    "Abort",
+			expStatus:  http.StatusBadGateway,
+			expResBody: "Bad Gateway\n",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			log.SetOutput(&bytes.Buffer{})
+			defer log.SetOutput(os.Stderr)
+
+			config := CreateConfig()
+			config.Filters = []Filter{
+				{
+					Type:        "template",
+					Regex:       `\b[ab]\b`,
+					Replacement: `{{if eq .Match "b"}}{{.Named.missing}}{{else}}X{{end}}`,
+					OnError:     test.onError,
+				},
+				{Regex: "end", Replacement: "done"},
+			}
+
+			next := func(w http.ResponseWriter, _ *http.Request) {
+				_, _ = w.Write([]byte("a b a end"))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if recorder.Code != test.expStatus {
+				t.Errorf("got status %d, want %d", recorder.Code, test.expStatus)
+			}
+
+			if got := recorder.Body.String(); got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+		})
+	}
+}
+
+func TestFilterOnErrorConfig(t *testing.T) {
+	for i, f := range []Filter{
+		{Regex: "foo", Replacement: "bar", OnError: "abort"},
+		{Type: "template", Regex: "foo", Replacement: "{{.Match}}", OnError: "fail"},
+	} {
+		if _, err := compileFilters([]Filter{f}); err == nil {
+			t.Errorf("filter %d: expected error", i)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package subfilter
+
+import (
+	"net/http"
+)
+
+// serveNext serves r with the next handler, reporting whether it returned.
+// With recoverPanics, a panic of the handler is recovered and the response
+// ended as recoverNext says; otherwise it goes up the chain as is. Panics
+// with http.ErrAbortHandler, which handlers raise to abort a response on
+// purpose, are never recovered.
+func (s *SubFilter) serveNext(rw *responseWriter, r *http.Request) (ok bool) {
+	if s.recoverPanics {
+		defer func() {
+			if err := recover(); err != nil {
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
+
+				s.recoverNext(rw, r, err)
+			}
+		}()
+	}
+
+	s.next.ServeHTTP(rw, r)
+
+	return true
+}
+
+// recoverNext ends the response to r once the next handler panicked with
+// err. A response nothing of which was sent yet is replaced by a 500, the
+// partial body buffered dropped rather than filtered. One already started,
+// passed through or streamed, cannot be taken back: it is aborted with
+// http.ErrAbortHandler, for the client to see it cut short rather than
+// take it for complete.
+func (s *SubFilter) recoverNext(rw *responseWriter, r *http.Request, err interface{}) {
+	if rw.passthrough || rw.stream != nil && rw.stream.started {
+		s.logResponse(logLevelError, r, "recovered from a panic of the next handler on %s, aborting the response: %v", r.URL.Path, err)
+
+		panic(http.ErrAbortHandler)
+	}
+
+	s.logResponse(logLevelError, r, "recovered from a panic of the next handler on %s, sending a 500: %v", r.URL.Path, err)
+
+	rw.buffer = nil
+	rw.stream = nil
+	writeStatus(rw, http.StatusInternalServerError)
+}
@@ -0,0 +1,207 @@
+package subfilter
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRecoverPanics(t *testing.T) {
+	tests := []struct {
+		desc        string
+		stream      bool
+		contentType string
+		expStatus   int
+		expResBody  string
+		expAbort    bool
+	}{
+		{
+			desc:       "should answer a 500 instead of the buffered body",
+			expStatus:  http.StatusInternalServerError,
+			expResBody: "Internal Server Error\n",
+		},
+		{
+			desc:        "should abort a passed through response",
+			contentType: "image/png",
+			expStatus:   http.StatusOK,
+			expResBody:  "foo ",
+			expAbort:    true,
+		},
+		{
+			desc:       "should answer a 500 when nothing was streamed yet",
+			stream:     true,
+			expStatus:  http.StatusInternalServerError,
+			expResBody: "Internal Server Error\n",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			var logs bytes.Buffer
+
+			log.SetOutput(&logs)
+			defer log.SetOutput(os.Stderr)
+
+			config := CreateConfig()
+			config.Filters = []Filter{{Regex: "foo", Replacement: "bar"}}
+			config.TextTypesOnly = true
+			config.RecoverPanics = true
+			config.Stream = test.stream
+
+			contentType := test.contentType
+			if contentType == "" {
+				contentType = "text/plain"
+			}
+
+			next := func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Type", contentType)
+				w.Header().Set("X-Upstream", "1")
+				_, _ = w.Write([]byte("foo "))
+
+				panic("boom")
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+
+			func() {
+				defer func() {
+					if err := recover(); (err == http.ErrAbortHandler) != test.expAbort {
+						t.Errorf("got panic %v, want abort %t", err, test.expAbort)
+					}
+				}()
+
+				handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+			}()
+
+			if recorder.Code != test.expStatus {
+				t.Errorf("got status %d, want %d", recorder.Code, test.expStatus)
+			}
+
+			if got := recorder.Body.String(); got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+
+			if test.expStatus == http.StatusInternalServerError && recorder.Header().Get("X-Upstream") != "" {
+				t.Error("got the upstream headers on the 500")
+			}
+
+			if !bytes.Contains(logs.Bytes(), []byte("boom")) {
+				t.Errorf("got logs %q, want the panic logged", logs.String())
+			}
+		})
+	}
+}
+
+func TestRecoverPanicsDisabled(t *testing.T) {
+	config := CreateConfig()
+	config.Filters = []Filter{{Regex: "foo", Replacement: "bar"}}
+
+	next := func(http.ResponseWriter, *http.Request) {
+		panic("boom")
+	}
+
+	handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		if err := recover(); err != "boom" {
+			t.Errorf("got panic %v, want %q", err, "boom")
+		}
+	}()
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+}
+
+func TestRecoverPanicsDisabledStream(t *testing.T) {
+	config := CreateConfig()
+	config.Filters = []Filter{{Regex: "foo", Replacement: "bar"}}
+	config.Stream = true
+	config.StreamOverlap = 4
+	config.FlushInterval = "20ms"
+
+	next := func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("foo xxxxxx"))
+		panic("boom")
+	}
+
+	handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := &flushRecorder{ResponseRecorder: httptest.NewRecorder()}
+
+	func() {
+		defer func() {
+			if err := recover(); err != "boom" {
+				t.Errorf("got panic %v, want %q", err, "boom")
+			}
+		}()
+
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	}()
+
+	// Past the flush interval, nothing may be flushed to the aborted response.
+	time.Sleep(60 * time.Millisecond)
+
+	if len(recorder.flushes) != 0 {
+		t.Errorf("got flushes at %v bytes after the panic, want none", recorder.flushes)
+	}
+}
+
+func TestRecoverPanicsAbort(t *testing.T) {
+	for _, contentType := range []string{"text/plain", "image/png"} {
+		contentType := contentType
+		t.Run(contentType, func(t *testing.T) {
+			config := CreateConfig()
+			config.Filters = []Filter{{Regex: "foo", Replacement: "bar"}}
+			config.TextTypesOnly = true
+			config.RecoverPanics = true
+
+			next := func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Type", contentType)
+				_, _ = w.Write([]byte("partial"))
+
+				if f, ok := w.(http.Flusher); ok {
+					f.Flush()
+				}
+
+				panic(http.ErrAbortHandler)
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			server := httptest.NewServer(handler)
+			defer server.Close()
+
+			res, err := http.Get(server.URL)
+			if err != nil {
+				// Buffered responses are aborted before anything is sent.
+				return
+			}
+
+			defer func() { _ = res.Body.Close() }()
+
+			if _, err := ioutil.ReadAll(res.Body); err == nil {
+				t.Errorf("got a complete %d response, want it aborted", res.StatusCode)
+			}
+		})
+	}
+}
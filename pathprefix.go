@@ -0,0 +1,49 @@
+package subfilter
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// setupPathPrefixHeader has the path conditions of every rule match the path
+// prefixed with the PathPrefixHeader request header.
+func (s *SubFilter) setupPathPrefixHeader(config *Config) error {
+	if config.PathPrefixHeader == "" {
+		return nil
+	}
+
+	if !isToken(config.PathPrefixHeader) {
+		return fmt.Errorf("invalid pathPrefixHeader %q", config.PathPrefixHeader)
+	}
+
+	name := http.CanonicalHeaderKey(config.PathPrefixHeader)
+
+	for _, rl := range s.rules {
+		if rl.conditions != nil {
+			rl.conditions.prefixHeader = name
+		}
+	}
+
+	return nil
+}
+
+// requestPath returns the path of r that path conditions are matched
+// against: prefixed with the value of the prefix header, if any, without its
+// trailing slash, so that "/app/" and "/x" make "/app/x".
+func (c *conditions) requestPath(r *http.Request) string {
+	if c.prefixHeader == "" {
+		return r.URL.Path
+	}
+
+	prefix := strings.TrimRight(r.Header.Get(c.prefixHeader), "/")
+	if prefix == "" {
+		return r.URL.Path
+	}
+
+	if !strings.HasPrefix(prefix, "/") {
+		prefix = "/" + prefix
+	}
+
+	return prefix + r.URL.Path
+}
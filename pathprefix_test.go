@@ -0,0 +1,92 @@
+package subfilter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPathPrefixHeader(t *testing.T) {
+	tests := []struct {
+		desc         string
+		prefixHeader string
+		prefix       string
+		path         string
+		expResBody   string
+	}{
+		{
+			desc:         "should match the path with the forwarded prefix",
+			prefixHeader: "X-Forwarded-Prefix",
+			prefix:       "/app",
+			path:         "/index.html",
+			expResBody:   "bar",
+		},
+		{
+			desc:         "should drop the trailing slash of the prefix",
+			prefixHeader: "x-forwarded-prefix",
+			prefix:       "/app/",
+			path:         "/index.html",
+			expResBody:   "bar",
+		},
+		{
+			desc:         "should match the stripped path without the header",
+			prefixHeader: "X-Forwarded-Prefix",
+			path:         "/app/index.html",
+			expResBody:   "bar",
+		},
+		{
+			desc:         "should not match another prefix",
+			prefixHeader: "X-Forwarded-Prefix",
+			prefix:       "/admin",
+			path:         "/index.html",
+			expResBody:   "foo",
+		},
+		{
+			desc:       "should ignore the header unless configured",
+			prefix:     "/app",
+			path:       "/index.html",
+			expResBody: "foo",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.PathPrefixHeader = test.prefixHeader
+			config.Rules = []Rule{{
+				Conditions: Conditions{Paths: []string{`^/app/`}},
+				Filters:    []Filter{{Regex: "foo", Replacement: "bar"}},
+			}}
+
+			next := func(w http.ResponseWriter, _ *http.Request) {
+				_, _ = w.Write([]byte("foo"))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, test.path, nil)
+			if test.prefix != "" {
+				req.Header.Set("X-Forwarded-Prefix", test.prefix)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+
+			if got := recorder.Body.String(); got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+		})
+	}
+
+	config := CreateConfig()
+	config.PathPrefixHeader = "X Forwarded Prefix"
+
+	if _, err := New(context.Background(), nil, config, "subfilter"); err == nil {
+		t.Error("expected error for an invalid pathPrefixHeader")
+	}
+}
@@ -0,0 +1,97 @@
+package subfilter
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Pipeline stages, in their default order.
+const (
+	stageFilters            = "filters"
+	stageStatusFilters      = "statusFilters"
+	stageContentTypeFilters = "contentTypeFilters"
+	stageRules              = "rules"
+	// stageInserts injects the baseHref and scripts of HTML documents, so
+	// that the filters of the stages after it also apply to what it inserts.
+	stageInserts = "inserts"
+)
+
+var defaultPipeline = []string{stageFilters, stageStatusFilters, stageContentTypeFilters, stageRules, stageInserts}
+
+func (s *SubFilter) setupPipeline(config *Config) error {
+	seen := make(map[string]bool, len(defaultPipeline))
+
+	for _, name := range config.PipelineOrder {
+		stage, ok := lookupStage(name)
+		if !ok {
+			return fmt.Errorf("unknown pipeline stage %q: must be one of %s", name, strings.Join(defaultPipeline, ", "))
+		}
+
+		if seen[stage] {
+			return fmt.Errorf("duplicate pipeline stage %q", name)
+		}
+
+		seen[stage] = true
+		s.pipeline = append(s.pipeline, stage)
+	}
+
+	for _, stage := range defaultPipeline {
+		if !seen[stage] {
+			s.pipeline = append(s.pipeline, stage)
+		}
+	}
+
+	return nil
+}
+
+func lookupStage(name string) (string, bool) {
+	for _, stage := range defaultPipeline {
+		if strings.EqualFold(stage, strings.TrimSpace(name)) {
+			return stage, true
+		}
+	}
+
+	return "", false
+}
+
+// selectFilters returns the filters applying to a response, in pipeline order,
+// and the number of them whose stages run before the inserts. A matching
+// status or content type group in replace mode drops the top-level filters.
+func (s *SubFilter) selectFilters(rules []rule, r *http.Request, status int, header http.Header) ([]filter, int) {
+	byStage := map[string][]filter{
+		stageStatusFilters:      statusFilters(s.statusGroups, status),
+		stageContentTypeFilters: contentTypeFilters(s.typeGroups, header.Get("Content-Type")),
+		stageRules:              ruleFilters(rules, s.stopAtFirstRule, r, s.requestLanguage(r), status, header),
+	}
+
+	replace := byStage[stageStatusFilters] != nil && s.replaceStatus ||
+		byStage[stageContentTypeFilters] != nil && s.replaceType
+	if !replace {
+		byStage[stageFilters] = rules[0].filters
+
+		if len(s.urlFilters) > 0 || len(s.hostFilters) > 0 {
+			byStage[stageFilters] = append(append(append([]filter(nil), rules[0].filters...), s.urlFilters...),
+				s.hostFilters...)
+		}
+	}
+
+	var selected []filter
+
+	insertAt := 0
+
+	for _, stage := range s.pipeline {
+		if stage == stageInserts {
+			insertAt = len(selected)
+		}
+
+		selected = append(selected, byStage[stage]...)
+	}
+
+	before, after := gateFilters(selected[:insertAt], header), gateFilters(selected[insertAt:], header)
+	if len(before)+len(after) == len(selected) {
+		return selected, insertAt
+	}
+
+	return append(append([]filter(nil), before...), after...), len(before)
+}
@@ -0,0 +1,124 @@
+package subfilter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPipelineOrder(t *testing.T) {
+	tests := []struct {
+		desc       string
+		order      []string
+		expResBody string
+		expErr     bool
+	}{
+		{
+			desc:       "should run the stages in their default order",
+			expResBody: "baz",
+		},
+		{
+			desc:       "should run the stages in the configured order",
+			order:      []string{"contentTypeFilters", "filters"},
+			expResBody: "bar",
+		},
+		{
+			desc:       "should match stage names case-insensitively",
+			order:      []string{"ContentTypeFilters"},
+			expResBody: "bar",
+		},
+		{
+			desc:   "should reject an unknown stage",
+			order:  []string{"encode"},
+			expErr: true,
+		},
+		{
+			desc:   "should reject a duplicate stage",
+			order:  []string{"filters", "filters"},
+			expErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.Filters = []Filter{{Regex: "foo", Replacement: "bar"}}
+			config.ContentTypeFilters = map[string][]Filter{
+				"text/html": {{Regex: "bar", Replacement: "baz"}},
+			}
+			config.PipelineOrder = test.order
+
+			next := func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/html")
+				_, _ = w.Write([]byte("foo"))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if test.expErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if got := recorder.Body.String(); got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+		})
+	}
+}
+
+func TestPipelineOrderInserts(t *testing.T) {
+	tests := []struct {
+		desc       string
+		order      []string
+		expResBody string
+	}{
+		{
+			desc:       "should insert after the filters by default",
+			expResBody: `<html><head><base href="/app/"><title>v2</title></head></html>`,
+		},
+		{
+			desc:       "should filter what the inserts stage inserted when it runs first",
+			order:      []string{"inserts"},
+			expResBody: `<html><head><base href="/v2/"><title>v2</title></head></html>`,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.Filters = []Filter{{Regex: "app", Replacement: "v2"}}
+			config.BaseHref = "/app/"
+			config.PipelineOrder = test.order
+
+			next := func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/html")
+				_, _ = w.Write([]byte("<html><head><title>app</title></head></html>"))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if got := recorder.Body.String(); got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+		})
+	}
+}
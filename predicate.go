@@ -0,0 +1,148 @@
+package subfilter
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+)
+
+// HeaderPredicate gates a filter on the headers of the response: every
+// condition of All must hold and, unless Any is empty, at least one of Any.
+type HeaderPredicate struct {
+	All []HeaderCondition `json:"all,omitempty"`
+	Any []HeaderCondition `json:"any,omitempty"`
+}
+
+// HeaderCondition holds when the response has the header Name and, if Regex
+// is set, one of its values matches it, or, with Absent, when it lacks the
+// header.
+type HeaderCondition struct {
+	Name   string `json:"name,omitempty"`
+	Regex  string `json:"regex,omitempty"`
+	Absent bool   `json:"absent,omitempty"`
+}
+
+type headerPredicate struct {
+	all, any []headerCondition
+}
+
+type headerCondition struct {
+	name   string
+	regex  *regexp.Regexp
+	absent bool
+}
+
+func compileHeaderPredicate(p *HeaderPredicate) (*headerPredicate, error) {
+	if p == nil {
+		return nil, nil
+	}
+
+	if len(p.All) == 0 && len(p.Any) == 0 {
+		return nil, errors.New("when requires all or any")
+	}
+
+	hp := &headerPredicate{}
+
+	for _, list := range []struct {
+		conditions []HeaderCondition
+		compiled   *[]headerCondition
+	}{{p.All, &hp.all}, {p.Any, &hp.any}} {
+		for _, c := range list.conditions {
+			hc, err := compileHeaderCondition(c)
+			if err != nil {
+				return nil, err
+			}
+
+			*list.compiled = append(*list.compiled, hc)
+		}
+	}
+
+	return hp, nil
+}
+
+func compileHeaderCondition(c HeaderCondition) (headerCondition, error) {
+	if !isToken(c.Name) {
+		return headerCondition{}, fmt.Errorf("invalid header name %q", c.Name)
+	}
+
+	hc := headerCondition{name: http.CanonicalHeaderKey(c.Name), absent: c.Absent}
+
+	if c.Regex == "" {
+		return hc, nil
+	}
+
+	if c.Absent {
+		return headerCondition{}, fmt.Errorf("header %q: absent cannot be combined with regex", c.Name)
+	}
+
+	regex, err := regexp.Compile(c.Regex)
+	if err != nil {
+		return headerCondition{}, fmt.Errorf("error compiling regex %q for header %q: %w", c.Regex, c.Name, err)
+	}
+
+	hc.regex = regex
+
+	return hc, nil
+}
+
+// match reports whether the predicate holds for header. A nil predicate
+// always does.
+func (p *headerPredicate) match(header http.Header) bool {
+	if p == nil {
+		return true
+	}
+
+	for _, c := range p.all {
+		if !c.match(header) {
+			return false
+		}
+	}
+
+	if len(p.any) == 0 {
+		return true
+	}
+
+	for _, c := range p.any {
+		if c.match(header) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c headerCondition) match(header http.Header) bool {
+	values := header.Values(c.name)
+
+	switch {
+	case c.absent:
+		return len(values) == 0
+	case c.regex == nil:
+		return len(values) > 0
+	default:
+		return matchHeader(values, c.regex)
+	}
+}
+
+// gateFilters returns the filters whose predicate holds for header, sharing
+// filters when they all do.
+func gateFilters(filters []filter, header http.Header) []filter {
+	for i := range filters {
+		if filters[i].when.match(header) {
+			continue
+		}
+
+		gated := append([]filter(nil), filters[:i]...)
+
+		for j := i + 1; j < len(filters); j++ {
+			if filters[j].when.match(header) {
+				gated = append(gated, filters[j])
+			}
+		}
+
+		return gated
+	}
+
+	return filters
+}
@@ -0,0 +1,113 @@
+package subfilter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHeaderPredicate(t *testing.T) {
+	and := &HeaderPredicate{All: []HeaderCondition{
+		{Name: "Content-Type", Regex: "^text/html"},
+		{Name: "X-Rewrite", Regex: "^on$"},
+	}}
+	or := &HeaderPredicate{Any: []HeaderCondition{
+		{Name: "X-Legacy"},
+		{Name: "X-Version", Regex: "^1\\."},
+	}}
+
+	tests := []struct {
+		desc       string
+		when       *HeaderPredicate
+		headers    map[string]string
+		expResBody string
+	}{
+		{
+			desc:       "should apply an AND gate when both conditions hold",
+			when:       and,
+			headers:    map[string]string{"Content-Type": "text/html", "X-Rewrite": "on"},
+			expResBody: "bar",
+		},
+		{
+			desc:       "should skip an AND gate when one condition fails",
+			when:       and,
+			headers:    map[string]string{"Content-Type": "text/html", "X-Rewrite": "off"},
+			expResBody: "foo",
+		},
+		{
+			desc:       "should skip an AND gate when a header is missing",
+			when:       and,
+			headers:    map[string]string{"Content-Type": "text/html"},
+			expResBody: "foo",
+		},
+		{
+			desc:       "should apply an OR gate when the first condition holds",
+			when:       or,
+			headers:    map[string]string{"X-Legacy": ""},
+			expResBody: "bar",
+		},
+		{
+			desc:       "should apply an OR gate when the second condition holds",
+			when:       or,
+			headers:    map[string]string{"X-Version": "1.4"},
+			expResBody: "bar",
+		},
+		{
+			desc:       "should skip an OR gate when no condition holds",
+			when:       or,
+			headers:    map[string]string{"X-Version": "2.0"},
+			expResBody: "foo",
+		},
+		{
+			desc: "should combine both lists",
+			when: &HeaderPredicate{
+				All: []HeaderCondition{{Name: "X-Rewrite", Absent: true}},
+				Any: []HeaderCondition{{Name: "X-Legacy"}, {Name: "X-Version", Regex: "^1\\."}},
+			},
+			headers:    map[string]string{"X-Version": "1.0"},
+			expResBody: "bar",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.Filters = []Filter{{Regex: "foo", Replacement: "bar", When: test.when}}
+
+			next := func(w http.ResponseWriter, _ *http.Request) {
+				for name, value := range test.headers {
+					w.Header().Set(name, value)
+				}
+
+				_, _ = w.Write([]byte("foo"))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if got := recorder.Body.String(); got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+		})
+	}
+}
+
+func TestHeaderPredicateConfig(t *testing.T) {
+	for _, when := range []*HeaderPredicate{
+		{},
+		{All: []HeaderCondition{{Name: "X Flag"}}},
+		{Any: []HeaderCondition{{Name: "X-Flag", Regex: "("}}},
+		{All: []HeaderCondition{{Name: "X-Flag", Regex: "on", Absent: true}}},
+	} {
+		if _, err := compileFilters([]Filter{{Regex: "foo", When: when}}); err == nil {
+			t.Errorf("expected an error for %+v", when)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package subfilter
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+const (
+	ipv4Octet = `(?:25[0-5]|2[0-4][0-9]|1[0-9]{2}|[1-9]?[0-9])`
+	ipv4      = ipv4Octet + `(?:\.` + ipv4Octet + `){3}`
+	hextet    = `[0-9A-Fa-f]{1,4}`
+)
+
+// preset is a maintained built-in pattern selectable by name.
+type preset struct {
+	pattern string
+	accept  func(src []byte, start, end int) bool
+}
+
+// presets are ordered so that alternatives consuming more of the input come
+// first, as Go regexps prefer the leftmost alternative rather than the longest.
+var presets = map[string]preset{
+	"email": {
+		pattern: `[A-Za-z0-9._%+\-]+@[A-Za-z0-9](?:[A-Za-z0-9\-]*[A-Za-z0-9])?(?:\.[A-Za-z0-9](?:[A-Za-z0-9\-]*[A-Za-z0-9])?)*\.[A-Za-z]{2,}`,
+	},
+	"ipv4": {
+		pattern: ipv4,
+		accept:  acceptIPv4,
+	},
+	"ipv6": {
+		pattern: strings.Join([]string{
+			`(?:` + hextet + `:){7}` + hextet,
+			`::(?:[fF]{4}(?::0{1,4})?:)?` + ipv4,
+			`(?:` + hextet + `:){1,4}:` + ipv4,
+			hextet + `:(?::` + hextet + `){1,6}`,
+			`(?:` + hextet + `:){1,2}(?::` + hextet + `){1,5}`,
+			`(?:` + hextet + `:){1,3}(?::` + hextet + `){1,4}`,
+			`(?:` + hextet + `:){1,4}(?::` + hextet + `){1,3}`,
+			`(?:` + hextet + `:){1,5}(?::` + hextet + `){1,2}`,
+			`(?:` + hextet + `:){1,6}:` + hextet,
+			`:(?::` + hextet + `){1,7}`,
+			`(?:` + hextet + `:){1,7}:`,
+		}, "|"),
+		accept: acceptIPv6,
+	},
+	// The token must be at least 16 characters so prose such as "the bearer
+	// of bad news" is left alone. The scheme and token are available to the
+	// replacement as ${scheme} and ${token}.
+	"bearer-token": {
+		pattern: `(?i)\b(?P<scheme>bearer)\s+(?P<token>[A-Za-z0-9\-._~+/]{16,}=*)`,
+	},
+	"aws-access-key": {
+		pattern: `\b(?:AKIA|ASIA|ABIA|ACCA|A3T[A-Z0-9])[A-Z0-9]{16}\b`,
+	},
+}
+
+func presetNames() []string {
+	names := make([]string, 0, len(presets))
+	for name := range presets {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+func lookupPreset(f Filter) (preset, error) {
+	if f.Regex != "" {
+		return preset{}, errors.New("preset and regex are mutually exclusive")
+	}
+
+	p, ok := presets[strings.ToLower(f.Preset)]
+	if !ok {
+		return preset{}, fmt.Errorf("unknown preset %q: must be one of %s", f.Preset, strings.Join(presetNames(), ", "))
+	}
+
+	return p, nil
+}
+
+func isAlnum(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// acceptIPv4 rejects dotted quads that are part of a longer dotted sequence or
+// word, such as the version strings 1.2.3.4.5 and v1.2.3.4.
+func acceptIPv4(src []byte, start, end int) bool {
+	if start > 0 && (isAlnum(src[start-1]) || src[start-1] == '.') {
+		return false
+	}
+
+	if end < len(src) && isAlnum(src[end]) {
+		return false
+	}
+
+	return !(end+1 < len(src) && src[end] == '.' && isAlnum(src[end+1]))
+}
+
+// acceptIPv6 rejects candidates embedded in longer words or colon sequences,
+// such as "std::vector" or MAC addresses, and anything net.ParseIP refuses.
+func acceptIPv6(src []byte, start, end int) bool {
+	if start > 0 && (isAlnum(src[start-1]) || src[start-1] == ':' || src[start-1] == '.') {
+		return false
+	}
+
+	if end < len(src) && (isAlnum(src[end]) || src[end] == ':') {
+		return false
+	}
+
+	return net.ParseIP(string(src[start:end])) != nil
+}
@@ -0,0 +1,97 @@
+package subfilter
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	queryModeRaw     = "raw"
+	queryModeDecoded = "decoded"
+)
+
+func (s *SubFilter) setupQueryFilters(config *Config) error {
+	filters, err := compileFilters(config.QueryFilters)
+	if err != nil {
+		return fmt.Errorf("queryFilters: %w", err)
+	}
+
+	if err := rejectHeaderTargets(filters); err != nil {
+		return fmt.Errorf("queryFilters: %w", err)
+	}
+
+	s.queryFilters = filters
+
+	switch mode := strings.ToLower(config.QueryFiltersMode); mode {
+	case "", queryModeRaw:
+		s.decodedQuery = false
+	case queryModeDecoded:
+		s.decodedQuery = true
+	default:
+		return fmt.Errorf("invalid queryFiltersMode %q: must be %q or %q", config.QueryFiltersMode, queryModeRaw, queryModeDecoded)
+	}
+
+	return nil
+}
+
+// filterQuery rewrites the query string of r before it is passed upstream and
+// keeps r.RequestURI in line with the new URL, as Traefik forwards the latter.
+func (s *SubFilter) filterQuery(r *http.Request) {
+	if len(s.queryFilters) == 0 || r.URL.RawQuery == "" {
+		return
+	}
+
+	var query string
+
+	if s.decodedQuery {
+		query = s.filterDecodedQuery(r.URL.RawQuery, s.newScope(r))
+	} else {
+		query = string(applyFilters(s.queryFilters, []byte(r.URL.RawQuery), s.newScope(r)))
+	}
+
+	if query == r.URL.RawQuery {
+		return
+	}
+
+	r.URL.RawQuery = query
+	r.URL.ForceQuery = false
+	r.RequestURI = r.URL.RequestURI()
+	r.Form = nil
+}
+
+// filterDecodedQuery applies the query filters to the decoded name and value of
+// every parameter in query on their own and re-encodes those they change,
+// keeping the parameters in order. Parameters that cannot be decoded, or that
+// the filters leave alone, keep their original encoding.
+func (s *SubFilter) filterDecodedQuery(query string, sc *scope) string {
+	params := strings.Split(query, "&")
+
+	for i, param := range params {
+		if param == "" {
+			continue
+		}
+
+		parts := strings.SplitN(param, "=", 2)
+
+		for j, part := range parts {
+			decoded, err := url.QueryUnescape(part)
+			if err != nil {
+				parts = nil
+
+				break
+			}
+
+			if filtered := string(applyFilters(s.queryFilters, []byte(decoded), sc)); filtered != decoded {
+				parts[j] = url.QueryEscape(filtered)
+			}
+		}
+
+		if parts != nil {
+			params[i] = strings.Join(parts, "=")
+		}
+	}
+
+	return strings.Join(params, "&")
+}
@@ -0,0 +1,111 @@
+package subfilter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestQueryFilters(t *testing.T) {
+	tests := []struct {
+		desc       string
+		mode       string
+		filters    []Filter
+		target     string
+		expQuery   string
+		expInvalid bool
+	}{
+		{
+			desc:     "should rewrite the raw query string",
+			filters:  []Filter{{Regex: `(^|&)q=`, Replacement: "${1}q_legacy="}},
+			target:   "/search?page=2&q=a%26b",
+			expQuery: "page=2&q_legacy=a%26b",
+		},
+		{
+			desc:     "should match the raw query string before decoding",
+			filters:  []Filter{{Regex: `%20`, Replacement: "+"}},
+			target:   "/search?q=a%20b",
+			expQuery: "q=a+b",
+		},
+		{
+			desc:     "should rewrite decoded names and values and re-encode them",
+			mode:     "decoded",
+			filters:  []Filter{{Regex: `^q$`, Replacement: "q_legacy"}, {Regex: `^tom and jerry$`, Replacement: "tom & jerry"}},
+			target:   "/search?page=2&q=tom+and+jerry",
+			expQuery: "page=2&q_legacy=tom+%26+jerry",
+		},
+		{
+			desc:     "should keep an encoded & inside a decoded value",
+			mode:     "decoded",
+			filters:  []Filter{{Regex: `b`, Replacement: "c"}},
+			target:   "/search?q=a%26b&flag",
+			expQuery: "q=a%26c&flag",
+		},
+		{
+			desc:     "should leave parameters that cannot be decoded as they are",
+			mode:     "decoded",
+			filters:  []Filter{{Regex: `a`, Replacement: "b"}},
+			target:   "/search?x=%zz&y=a",
+			expQuery: "x=%zz&y=b",
+		},
+		{
+			desc:     "should keep the encoding of a query the filters leave alone",
+			mode:     "decoded",
+			filters:  []Filter{{Regex: `^nothing$`, Replacement: "something"}},
+			target:   "/search?q=tom%20%2A%20jerry&path=%2Fhome&page=2",
+			expQuery: "q=tom%20%2A%20jerry&path=%2Fhome&page=2",
+		},
+		{
+			desc:     "should only re-encode the parts the filters change",
+			mode:     "decoded",
+			filters:  []Filter{{Regex: `^q$`, Replacement: "query"}},
+			target:   "/search?q=tom%20%2A%20jerry",
+			expQuery: "query=tom%20%2A%20jerry",
+		},
+		{
+			desc:       "should reject an unknown mode",
+			mode:       "parsed",
+			filters:    []Filter{{Regex: `a`, Replacement: "b"}},
+			expInvalid: true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.QueryFilters = test.filters
+			config.QueryFiltersMode = test.mode
+
+			var seen *http.Request
+
+			next := func(w http.ResponseWriter, r *http.Request) {
+				seen = r
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if test.expInvalid {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, test.target, nil))
+
+			if seen.URL.RawQuery != test.expQuery {
+				t.Errorf("got query %q, want %q", seen.URL.RawQuery, test.expQuery)
+			}
+
+			if exp := "/search?" + test.expQuery; seen.RequestURI != exp {
+				t.Errorf("got request URI %q, want %q", seen.RequestURI, exp)
+			}
+		})
+	}
+}
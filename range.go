@@ -0,0 +1,101 @@
+package subfilter
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// rangeFilter replaces the regions of a body that run from a match of start
+// to the next match of end.
+type rangeFilter struct {
+	start, end  *regexp.Regexp
+	replacement []byte
+	// inclusive replaces the boundary markers along with the region.
+	inclusive bool
+	// unterminated replaces a region whose end marker is missing up to the
+	// end of the body, instead of leaving it untouched.
+	unterminated bool
+	// def is the definition the filter was compiled from.
+	def *Filter
+}
+
+func compileRange(f Filter) (*rangeFilter, error) {
+	switch {
+	case f.Start == "" || f.End == "":
+		return nil, errors.New("range filters require start and end")
+	case f.Regex != "" || f.Preset != "":
+		return nil, errors.New("range filters use start and end instead of regex or preset")
+	case f.HashReplacement != nil || f.Lookup != nil || f.Transforms || len(f.Replacements) > 0:
+		return nil, errors.New("range filters only support a literal replacement")
+	}
+
+	start, err := regexp.Compile(f.Start)
+	if err != nil {
+		return nil, fmt.Errorf("error compiling start %q: %w", f.Start, err)
+	}
+
+	end, err := regexp.Compile(f.End)
+	if err != nil {
+		return nil, fmt.Errorf("error compiling end %q: %w", f.End, err)
+	}
+
+	return &rangeFilter{
+		start:        start,
+		end:          end,
+		replacement:  []byte(f.Replacement),
+		inclusive:    f.Inclusive,
+		unterminated: f.ReplaceUnterminated,
+	}, nil
+}
+
+// apply replaces every non-overlapping region of b. The end marker is looked
+// for after the start marker only, so a missing one never makes a region
+// swallow the next.
+func (rf *rangeFilter) apply(b []byte, sc *scope) []byte {
+	var out []byte
+
+	last, pos := 0, 0
+
+	for pos <= len(b) {
+		s := rf.start.FindIndex(b[pos:])
+		if s == nil {
+			break
+		}
+
+		s0, s1 := pos+s[0], pos+s[1]
+
+		from, to := s1, len(b)
+		if rf.inclusive {
+			from = s0
+		}
+
+		pos = len(b) + 1
+
+		if e := rf.end.FindIndex(b[s1:]); e != nil {
+			if to, pos = s1+e[0], s1+e[1]; rf.inclusive {
+				to = pos
+			}
+
+			if pos == s0 {
+				// Both markers matched the empty string: move on.
+				pos++
+			}
+		} else if !rf.unterminated {
+			break
+		}
+
+		sc.countMatch(rf.def)
+		sc.reportMatch(rf.def, b, from, to)
+
+		out = append(out, b[last:from]...)
+		out = append(out, rf.replacement...)
+		last = to
+	}
+
+	if out == nil {
+		return b
+	}
+
+	return append(out, b[last:]...)
+}
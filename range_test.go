@@ -0,0 +1,92 @@
+package subfilter
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRangeFilters(t *testing.T) {
+	tests := []struct {
+		desc       string
+		filter     Filter
+		resBody    string
+		expResBody string
+	}{
+		{
+			desc:       "should replace the region between the markers",
+			filter:     Filter{Type: "range", Start: "<!-- nav-start -->", End: "<!-- nav-end -->", Replacement: "<nav/>"},
+			resBody:    "a<!-- nav-start -->\n<ul>\n</ul>\n<!-- nav-end -->b",
+			expResBody: "a<!-- nav-start --><nav/><!-- nav-end -->b",
+		},
+		{
+			desc:       "should replace the markers too when inclusive",
+			filter:     Filter{Type: "range", Start: "<!-- nav-start -->", End: "<!-- nav-end -->", Replacement: "<nav/>", Inclusive: true},
+			resBody:    "a<!-- nav-start -->\n<ul>\n</ul>\n<!-- nav-end -->b",
+			expResBody: "a<nav/>b",
+		},
+		{
+			desc:       "should replace every region",
+			filter:     Filter{Type: "range", Start: `\[`, End: `\]`, Replacement: "x"},
+			resBody:    "[a] [b]\n[c\n]",
+			expResBody: "[x] [x]\n[x]",
+		},
+		{
+			desc:       "should not nest regions",
+			filter:     Filter{Type: "range", Start: `\[`, End: `\]`, Replacement: "x", Inclusive: true},
+			resBody:    "[a [b] c]",
+			expResBody: "x c]",
+		},
+		{
+			desc:       "should leave an unterminated region untouched",
+			filter:     Filter{Type: "range", Start: "BEGIN", End: "END", Replacement: "x"},
+			resBody:    "BEGIN a END BEGIN b",
+			expResBody: "BEGINxEND BEGIN b",
+		},
+		{
+			desc:       "should replace an unterminated region to the end of the body",
+			filter:     Filter{Type: "range", Start: "BEGIN", End: "END", Replacement: "x", ReplaceUnterminated: true},
+			resBody:    "BEGIN a END BEGIN b",
+			expResBody: "BEGINxEND BEGINx",
+		},
+		{
+			desc:       "should replace an unterminated region and its start marker when inclusive",
+			filter:     Filter{Type: "range", Start: "BEGIN", End: "END", Replacement: "x", Inclusive: true, ReplaceUnterminated: true},
+			resBody:    "a BEGIN b",
+			expResBody: "a x",
+		},
+		{
+			desc:       "should leave bodies without markers untouched",
+			filter:     Filter{Type: "range", Start: "BEGIN", End: "END", Replacement: "x"},
+			resBody:    "nothing END here",
+			expResBody: "nothing END here",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			got := serveTransform(t, test.filter, test.resBody)
+			if got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+		})
+	}
+}
+
+func TestRangeFiltersInvalid(t *testing.T) {
+	for _, f := range []Filter{
+		{Type: "range", Start: "a"},
+		{Type: "range", End: "b"},
+		{Type: "range", Start: "(", End: "b"},
+		{Type: "range", Start: "a", End: "b", Regex: "c"},
+		{Type: "range", Start: "a", End: "b", Transforms: true},
+		{Type: "range", Start: "a", End: "b", Action: "deleteLine"},
+	} {
+		config := CreateConfig()
+		config.Filters = []Filter{f}
+
+		if _, err := New(context.Background(), nil, config, "subfilter"); err == nil {
+			t.Errorf("expected error for filter %+v", f)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package subfilter
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+func validateRelTime(args []string) error {
+	if !isToken(args[0]) {
+		return fmt.Errorf("invalid header name %q", args[0])
+	}
+
+	return nil
+}
+
+// relTimeTransform implements ${reltime:header[:default]}: the time in the
+// named response header, such as Last-Modified, relative to the time the
+// body started being filtered, as in "3 hours ago". It is default, or
+// nothing, when the header is missing or is not an HTTP date.
+func relTimeTransform(dst []byte, args []string, _ *regexp.Regexp, _ []byte, _ []int, sc *scope) []byte {
+	if sc != nil && sc.header != nil {
+		if t, err := http.ParseTime(sc.header.Get(args[0])); err == nil {
+			return append(dst, relativeTime(sc.time().Sub(t))...)
+		}
+	}
+
+	if len(args) == 2 {
+		return append(dst, args[1]...)
+	}
+
+	return dst
+}
+
+// relativeTime humanizes the duration d elapsed since a point in time, in
+// the largest unit that fits: "just now", "5 minutes ago", "1 day ago",
+// "in 2 hours" for points in the future. Months are 30 days and years 365.
+func relativeTime(d time.Duration) string {
+	future := d < 0
+	if future {
+		d = -d
+	}
+
+	if d < time.Minute {
+		return "just now"
+	}
+
+	units := []struct {
+		name string
+		size time.Duration
+	}{
+		{"year", 365 * 24 * time.Hour},
+		{"month", 30 * 24 * time.Hour},
+		{"day", 24 * time.Hour},
+		{"hour", time.Hour},
+		{"minute", time.Minute},
+	}
+
+	for _, u := range units {
+		if d < u.size {
+			continue
+		}
+
+		n := int64(d / u.size)
+
+		s := strconv.FormatInt(n, 10) + " " + u.name
+		if n > 1 {
+			s += "s"
+		}
+
+		if future {
+			return "in " + s
+		}
+
+		return s + " ago"
+	}
+
+	return "just now"
+}
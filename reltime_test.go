@@ -0,0 +1,117 @@
+package subfilter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRelTimeTransform(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		desc         string
+		lastModified string
+		replacement  string
+		expResBody   string
+	}{
+		{
+			desc:         "should humanize hours",
+			lastModified: now.Add(-3*time.Hour - 20*time.Minute).Format(http.TimeFormat),
+			replacement:  "${reltime:Last-Modified}",
+			expResBody:   "updated 3 hours ago",
+		},
+		{
+			desc:         "should use singular units",
+			lastModified: now.Add(-25 * time.Hour).Format(http.TimeFormat),
+			replacement:  "${reltime:Last-Modified}",
+			expResBody:   "updated 1 day ago",
+		},
+		{
+			desc:         "should humanize minutes",
+			lastModified: now.Add(-5 * time.Minute).Format(http.TimeFormat),
+			replacement:  "${reltime:Last-Modified}",
+			expResBody:   "updated 5 minutes ago",
+		},
+		{
+			desc:         "should say just now for seconds",
+			lastModified: now.Add(-30 * time.Second).Format(http.TimeFormat),
+			replacement:  "${reltime:Last-Modified}",
+			expResBody:   "updated just now",
+		},
+		{
+			desc:         "should humanize years",
+			lastModified: now.AddDate(-2, 0, 0).Format(http.TimeFormat),
+			replacement:  "${reltime:Last-Modified}",
+			expResBody:   "updated 2 years ago",
+		},
+		{
+			desc:         "should handle times in the future",
+			lastModified: now.Add(2 * time.Hour).Format(http.TimeFormat),
+			replacement:  "${reltime:Last-Modified}",
+			expResBody:   "updated in 2 hours",
+		},
+		{
+			desc:         "should read other date formats",
+			lastModified: now.Add(-2 * time.Hour).Format(time.RFC850),
+			replacement:  "${reltime:Last-Modified}",
+			expResBody:   "updated 2 hours ago",
+		},
+		{
+			desc:        "should fall back to the default without header",
+			replacement: "${reltime:Last-Modified:recently}",
+			expResBody:  "updated recently",
+		},
+		{
+			desc:         "should fall back to the default on invalid dates",
+			lastModified: "yesterday",
+			replacement:  "${reltime:Last-Modified:recently}",
+			expResBody:   "updated recently",
+		},
+		{
+			desc:        "should be empty without header nor default",
+			replacement: "${reltime:Last-Modified}",
+			expResBody:  "updated ",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.Filters = []Filter{{Regex: `\{\{lastUpdated\}\}`, Replacement: test.replacement, Transforms: true}}
+
+			next := func(w http.ResponseWriter, _ *http.Request) {
+				if test.lastModified != "" {
+					w.Header().Set("Last-Modified", test.lastModified)
+				}
+
+				_, _ = w.Write([]byte("updated {{lastUpdated}}"))
+			}
+
+			opts := Options{Now: func() time.Time { return now }}
+
+			handler, err := NewWithOptions(context.Background(), http.HandlerFunc(next), config, "subfilter", opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if got := recorder.Body.String(); got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+		})
+	}
+}
+
+func TestRelTimeTransformInvalid(t *testing.T) {
+	for i, replacement := range []string{"${reltime}", "${reltime:bad header}"} {
+		if _, err := compileFilters([]Filter{{Regex: "x", Replacement: replacement, Transforms: true}}); err == nil {
+			t.Errorf("replacement %d: expected error", i)
+		}
+	}
+}
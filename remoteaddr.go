@@ -0,0 +1,44 @@
+package subfilter
+
+import (
+	"net"
+	"regexp"
+	"strings"
+)
+
+// remoteAddrTransform implements ${remoteaddr}: the IP address of the client,
+// or nothing when it is not known.
+func remoteAddrTransform(dst []byte, _ []string, _ *regexp.Regexp, _ []byte, _ []int, sc *scope) []byte {
+	if sc == nil || sc.req == nil {
+		return dst
+	}
+
+	sc.markRequestDependent()
+
+	return append(dst, sc.remoteAddr()...)
+}
+
+// remoteAddr returns the IP address of the client of the request: when
+// trusted, the last address of its X-Forwarded-For header, the one the proxy
+// in front appended, as those before it are whatever the client sent; or else
+// that of the peer. Values that are not IP addresses are never returned, so
+// that they need no escaping.
+func (sc *scope) remoteAddr() string {
+	if values := sc.req.Header.Values("X-Forwarded-For"); sc.trustForwardedFor && len(values) > 0 {
+		entries := strings.Split(values[len(values)-1], ",")
+		if ip := net.ParseIP(strings.TrimSpace(entries[len(entries)-1])); ip != nil {
+			return ip.String()
+		}
+	}
+
+	host, _, err := net.SplitHostPort(sc.req.RemoteAddr)
+	if err != nil {
+		host = sc.req.RemoteAddr
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String()
+	}
+
+	return ""
+}
@@ -0,0 +1,89 @@
+package subfilter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRemoteAddrTransform(t *testing.T) {
+	tests := []struct {
+		desc         string
+		trust        bool
+		remoteAddr   string
+		forwardedFor string
+		expResBody   string
+	}{
+		{
+			desc:       "should expand to the peer address",
+			remoteAddr: "192.0.2.1:1234",
+			expResBody: "ip=192.0.2.1",
+		},
+		{
+			desc:         "should ignore X-Forwarded-For unless trusted",
+			remoteAddr:   "192.0.2.1:1234",
+			forwardedFor: "198.51.100.7",
+			expResBody:   "ip=192.0.2.1",
+		},
+		{
+			desc:         "should expand to the forwarded address when trusted",
+			trust:        true,
+			remoteAddr:   "10.0.0.2:1234",
+			forwardedFor: "198.51.100.7",
+			expResBody:   "ip=198.51.100.7",
+		},
+		{
+			desc:         "should expand to the last forwarded address, added by the trusted proxy",
+			trust:        true,
+			remoteAddr:   "10.0.0.2:1234",
+			forwardedFor: "203.0.113.66, 198.51.100.7",
+			expResBody:   "ip=198.51.100.7",
+		},
+		{
+			desc:       "should expand IPv6 addresses",
+			trust:      true,
+			remoteAddr: "[2001:db8::2]:443",
+			expResBody: "ip=2001:db8::2",
+		},
+		{
+			desc:         "should fall back to the peer on invalid forwarded addresses",
+			trust:        true,
+			remoteAddr:   "10.0.0.2:1234",
+			forwardedFor: "<script>",
+			expResBody:   "ip=10.0.0.2",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.Filters = []Filter{{Regex: "ip=\\?", Replacement: "ip=${remoteaddr}", Transforms: true}}
+			config.TrustForwardedFor = test.trust
+
+			next := func(w http.ResponseWriter, _ *http.Request) {
+				_, _ = w.Write([]byte("ip=?"))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = test.remoteAddr
+
+			if test.forwardedFor != "" {
+				req.Header.Set("X-Forwarded-For", test.forwardedFor)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+
+			if got := recorder.Body.String(); got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package subfilter
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const defaultRequestBodyMaxSize = 1 << 20
+
+// readCloser pairs a reader with the Close method of the body it replaces.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+func (s *SubFilter) setupRequestFilters(config *Config) error {
+	filters, err := compileFilters(config.RequestFilters)
+	if err != nil {
+		return fmt.Errorf("requestFilters: %w", err)
+	}
+
+	if err := rejectHeaderTargets(filters); err != nil {
+		return fmt.Errorf("requestFilters: %w", err)
+	}
+
+	s.requestFilters = filters
+	s.decodeRequestBody = config.DecodeRequestBody
+
+	s.requestBodyMaxSize = config.RequestBodyMaxSize
+	if s.requestBodyMaxSize <= 0 {
+		s.requestBodyMaxSize = defaultRequestBodyMaxSize
+	}
+
+	return nil
+}
+
+// filterRequestBody applies the request filters to the body of r before it is
+// passed upstream, fixing up its length, and its encoding when gzip bodies are
+// forwarded decoded. Bodies larger than the configured limit, with an
+// unsupported encoding, or that cannot be read or decoded are forwarded
+// unmodified.
+func (s *SubFilter) filterRequestBody(r *http.Request) {
+	if len(s.requestFilters) == 0 || r.Body == nil || r.Body == http.NoBody {
+		return
+	}
+
+	ce := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+	gzipped := ce == contentEncodingGzip || ce == "x-gzip"
+
+	if ce != "" && ce != "identity" && !gzipped {
+		return
+	}
+
+	raw, err := readAll(io.LimitReader(r.Body, s.requestBodyMaxSize+1), s.readBufferSize)
+	if err != nil || int64(len(raw)) > s.requestBodyMaxSize {
+		if err != nil {
+			s.logResponse(logLevelWarn, r, "unable to read request body: %v", err)
+		}
+
+		r.Body = readCloser{Reader: io.MultiReader(bytes.NewReader(raw), r.Body), Closer: r.Body}
+
+		return
+	}
+
+	if err = r.Body.Close(); err != nil {
+		s.logResponse(logLevelWarn, r, "unable to close request body: %v", err)
+	}
+
+	if !gzipped {
+		setRequestBody(r, applyFilters(s.requestFilters, raw, s.newScope(r)))
+
+		return
+	}
+
+	b, err := s.rewriteGzipRequestBody(raw, s.newScope(r))
+	if err != nil {
+		s.logResponse(logLevelWarn, r, "unable to filter request body: %v", err)
+		setRequestBody(r, raw)
+
+		return
+	}
+
+	if s.decodeRequestBody {
+		r.Header.Del("Content-Encoding")
+	}
+
+	setRequestBody(r, b)
+}
+
+// rewriteGzipRequestBody filters the gzip request body raw, re-encoding it
+// unless decodeRequestBody is set. Bodies the filters leave alone are
+// returned as they came when they stay encoded. Bodies decoding to more than
+// requestBodyMaxSize bytes fail with errDecodedTooLarge.
+func (s *SubFilter) rewriteGzipRequestBody(raw []byte, sc *scope) ([]byte, error) {
+	// requestBodyMaxSize bounds the decoded body too, which could otherwise
+	// be orders of magnitude larger than the encoded one.
+	decoded, err := gzipDecodeLimit(raw, s.readBufferSize, s.requestBodyMaxSize)
+	if err != nil {
+		return nil, err
+	}
+
+	b := applyFilters(s.requestFilters, decoded, sc)
+
+	switch {
+	case s.decodeRequestBody:
+		return b, nil
+	case bytes.Equal(b, decoded):
+		return raw, nil
+	default:
+		return gzipEncode(b)
+	}
+}
+
+// setRequestBody replaces the body of r with b and makes its length explicit.
+func setRequestBody(r *http.Request, b []byte) {
+	r.Body = ioutil.NopCloser(bytes.NewReader(b))
+	r.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(b)), nil
+	}
+	r.ContentLength = int64(len(b))
+	r.TransferEncoding = nil
+	r.Header.Set("Content-Length", strconv.Itoa(len(b)))
+}
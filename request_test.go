@@ -0,0 +1,265 @@
+package subfilter
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func gzipString(t *testing.T, s string) []byte {
+	t.Helper()
+
+	var b bytes.Buffer
+
+	gw := gzip.NewWriter(&b)
+	if _, err := gw.Write([]byte(s)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return b.Bytes()
+}
+
+func gunzipString(t *testing.T, b []byte) string {
+	t.Helper()
+
+	gr, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := ioutil.ReadAll(gr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(decoded)
+}
+
+// upstreamRequest is what the upstream handler observed of the request.
+type upstreamRequest struct {
+	body          []byte
+	contentLength int64
+	header        http.Header
+}
+
+func serveRequest(t *testing.T, config *Config, req *http.Request) upstreamRequest {
+	t.Helper()
+
+	var seen upstreamRequest
+
+	next := func(w http.ResponseWriter, r *http.Request) {
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		seen = upstreamRequest{body: b, contentLength: r.ContentLength, header: r.Header.Clone()}
+	}
+
+	handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	return seen
+}
+
+func TestRequestFilters(t *testing.T) {
+	const (
+		body     = `{"callback":"https://public.example.com/hook"}`
+		expected = `{"callback":"https://internal.corp/hook"}`
+	)
+
+	config := CreateConfig()
+	config.RequestFilters = []Filter{{Regex: `public\.example\.com`, Replacement: "internal.corp"}}
+
+	t.Run("should rewrite a JSON body", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Content-Length", strconv.Itoa(len(body)))
+
+		seen := serveRequest(t, config, req)
+
+		if string(seen.body) != expected {
+			t.Errorf("got upstream body %q, want %q", seen.body, expected)
+		}
+
+		if seen.contentLength != int64(len(expected)) || seen.header.Get("Content-Length") != strconv.Itoa(len(expected)) {
+			t.Errorf("got content length %d (header %q), want %d", seen.contentLength, seen.header.Get("Content-Length"), len(expected))
+		}
+	})
+
+	t.Run("should rewrite a gzip-encoded body", func(t *testing.T) {
+		gz := gzipString(t, body)
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(gz))
+		req.Header.Set("Content-Encoding", "gzip")
+
+		seen := serveRequest(t, config, req)
+
+		if got := gunzipString(t, seen.body); got != expected {
+			t.Errorf("got upstream body %q, want %q", got, expected)
+		}
+
+		if got := seen.header.Get("Content-Encoding"); got != "gzip" {
+			t.Errorf("got Content-Encoding %q, want %q", got, "gzip")
+		}
+
+		if seen.contentLength != int64(len(seen.body)) {
+			t.Errorf("got content length %d, want %d", seen.contentLength, len(seen.body))
+		}
+	})
+
+	t.Run("should forward unchanged gzip bodies as they came", func(t *testing.T) {
+		gz := gzipString(t, expected)
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(gz))
+		req.Header.Set("Content-Encoding", "GZIP")
+
+		seen := serveRequest(t, config, req)
+
+		if !bytes.Equal(seen.body, gz) {
+			t.Errorf("got upstream body %q, want the original gzip stream", seen.body)
+		}
+	})
+
+	t.Run("should forward gzip bodies decoded", func(t *testing.T) {
+		decoding := *config
+		decoding.DecodeRequestBody = true
+
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(gzipString(t, body)))
+		req.Header.Set("Content-Encoding", "x-gzip")
+
+		seen := serveRequest(t, &decoding, req)
+
+		if string(seen.body) != expected {
+			t.Errorf("got upstream body %q, want %q", seen.body, expected)
+		}
+
+		if got := seen.header.Get("Content-Encoding"); got != "" {
+			t.Errorf("got Content-Encoding %q, want none", got)
+		}
+
+		if seen.contentLength != int64(len(expected)) || seen.header.Get("Content-Length") != strconv.Itoa(len(expected)) {
+			t.Errorf("got content length %d (header %q), want %d", seen.contentLength, seen.header.Get("Content-Length"), len(expected))
+		}
+	})
+
+	t.Run("should pass gzip bodies decoding over the size limit through", func(t *testing.T) {
+		decoding := *config
+		decoding.DecodeRequestBody = true
+		decoding.RequestBodyMaxSize = 1 << 20
+
+		// A few kilobytes inflating to 8MiB.
+		gz := gzipString(t, strings.Repeat(body, (8<<20)/len(body)))
+		if len(gz) > 64<<10 {
+			t.Fatalf("got a %d-byte gzip body, want a high compression ratio", len(gz))
+		}
+
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(gz))
+		req.Header.Set("Content-Encoding", "gzip")
+
+		seen := serveRequest(t, &decoding, req)
+
+		if !bytes.Equal(seen.body, gz) {
+			t.Errorf("got a %d-byte upstream body, want the original %d-byte gzip stream", len(seen.body), len(gz))
+		}
+
+		if got := seen.header.Get("Content-Encoding"); got != "gzip" {
+			t.Errorf("got Content-Encoding %q, want %q", got, "gzip")
+		}
+	})
+
+	t.Run("should pass bodies over the size limit through", func(t *testing.T) {
+		limited := *config
+		limited.RequestBodyMaxSize = 16
+
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+
+		seen := serveRequest(t, &limited, req)
+
+		if string(seen.body) != body {
+			t.Errorf("got upstream body %q, want %q", seen.body, body)
+		}
+
+		if seen.contentLength != int64(len(body)) {
+			t.Errorf("got content length %d, want %d", seen.contentLength, len(body))
+		}
+	})
+}
+
+func TestRequestFiltersWithResponseFilters(t *testing.T) {
+	const body = "name=public"
+
+	config := CreateConfig()
+	config.RequestFilters = []Filter{{Regex: "public", Replacement: "internal"}}
+	config.Filters = []Filter{{Regex: "internal", Replacement: "public"}}
+
+	var (
+		seen    upstreamRequest
+		rewound []byte
+	)
+
+	next := func(w http.ResponseWriter, r *http.Request) {
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		seen = upstreamRequest{body: b, contentLength: r.ContentLength, header: r.Header.Clone()}
+
+		if r.GetBody != nil {
+			again, err := r.GetBody()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if rewound, err = ioutil.ReadAll(again); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		_, _ = w.Write([]byte("echo " + string(b)))
+	}
+
+	handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A chunked request, without a length.
+	req := httptest.NewRequest(http.MethodPost, "/", ioutil.NopCloser(strings.NewReader(body)))
+	req.ContentLength = -1
+	req.TransferEncoding = []string{"chunked"}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+
+	const expected = "name=internal"
+
+	if string(seen.body) != expected {
+		t.Errorf("got upstream body %q, want %q", seen.body, expected)
+	}
+
+	if seen.contentLength != int64(len(expected)) || seen.header.Get("Content-Length") != strconv.Itoa(len(expected)) {
+		t.Errorf("got content length %d (header %q), want %d", seen.contentLength, seen.header.Get("Content-Length"), len(expected))
+	}
+
+	if string(rewound) != expected {
+		t.Errorf("got rewound body %q, want %q", rewound, expected)
+	}
+
+	if got := recorder.Body.String(); got != "echo name=public" {
+		t.Errorf("got response body %q, want %q", got, "echo name=public")
+	}
+}
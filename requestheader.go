@@ -0,0 +1,89 @@
+package subfilter
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+)
+
+// HeaderFilter rewrites the values of one header.
+type HeaderFilter struct {
+	Header      string `json:"header,omitempty"`
+	Regex       string `json:"regex,omitempty"`
+	Replacement string `json:"replacement,omitempty"`
+}
+
+type headerFilter struct {
+	header      string
+	regex       *regexp.Regexp
+	replacement string
+}
+
+func compileHeaderFilters(defs []HeaderFilter) ([]headerFilter, error) {
+	filters := make([]headerFilter, 0, len(defs))
+
+	for _, f := range defs {
+		if f.Header == "" {
+			return nil, fmt.Errorf("header filter %q: header name is required", f.Regex)
+		}
+
+		regex, err := regexp.Compile(f.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("error compiling regex %q for header %q: %w", f.Regex, f.Header, err)
+		}
+
+		filters = append(filters, headerFilter{
+			header:      http.CanonicalHeaderKey(f.Header),
+			regex:       regex,
+			replacement: f.Replacement,
+		})
+	}
+
+	return filters, nil
+}
+
+// apply rewrites every value of the filter's header in h, dropping values that
+// end up empty and the header itself when no value is left.
+func (f *headerFilter) apply(h http.Header) {
+	values, ok := h[f.header]
+	if !ok {
+		return
+	}
+
+	rewritten := values[:0]
+
+	for _, v := range values {
+		if v = f.regex.ReplaceAllString(v, f.replacement); v != "" {
+			rewritten = append(rewritten, v)
+		}
+	}
+
+	if len(rewritten) == 0 {
+		delete(h, f.header)
+
+		return
+	}
+
+	h[f.header] = rewritten
+}
+
+func (s *SubFilter) setupRequestHeaderFilters(config *Config) error {
+	filters, err := compileHeaderFilters(config.RequestHeaderFilters)
+	if err != nil {
+		return fmt.Errorf("requestHeaderFilters: %w", err)
+	}
+
+	s.requestHeaderFilters = filters
+
+	return nil
+}
+
+// filterRequestHeaders rewrites the request headers before the request is
+// passed upstream. The original values are not restored afterwards: like
+// Traefik's own headers middleware, the rewrite is visible to everything
+// handling the request after this middleware, including the access log.
+func (s *SubFilter) filterRequestHeaders(r *http.Request) {
+	for i := range s.requestHeaderFilters {
+		s.requestHeaderFilters[i].apply(r.Header)
+	}
+}
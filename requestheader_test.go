@@ -0,0 +1,75 @@
+package subfilter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestRequestHeaderFilters(t *testing.T) {
+	config := CreateConfig()
+	config.RequestHeaderFilters = []HeaderFilter{
+		{Header: "referer", Regex: `^https://public\.example\.com`, Replacement: "http://legacy.internal"},
+		{Header: "X-Groups", Regex: `^internal-`, Replacement: ""},
+		{Header: "X-Groups", Regex: `^legacy-admins$`, Replacement: ""},
+	}
+
+	var seen http.Header
+
+	next := func(w http.ResponseWriter, r *http.Request) {
+		seen = r.Header.Clone()
+	}
+
+	handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Referer", "https://public.example.com/form")
+	req.Header.Add("X-Groups", "internal-users")
+	req.Header.Add("X-Groups", "legacy-admins")
+	req.Header.Add("X-Groups", "internal-ops")
+	req.Header.Set("X-Untouched", "https://public.example.com")
+
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if got := seen.Get("Referer"); got != "http://legacy.internal/form" {
+		t.Errorf("got upstream referer %q, want %q", got, "http://legacy.internal/form")
+	}
+
+	if got, want := seen.Values("X-Groups"), []string{"users", "ops"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got upstream X-Groups %q, want %q", got, want)
+	}
+
+	if got := seen.Get("X-Untouched"); got != "https://public.example.com" {
+		t.Errorf("got upstream X-Untouched %q", got)
+	}
+}
+
+func TestRequestHeaderFiltersRemoveEmptyHeader(t *testing.T) {
+	config := CreateConfig()
+	config.RequestHeaderFilters = []HeaderFilter{{Header: "X-Debug", Regex: ".*", Replacement: ""}}
+
+	var seen http.Header
+
+	next := func(w http.ResponseWriter, r *http.Request) {
+		seen = r.Header.Clone()
+	}
+
+	handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Debug", "1")
+
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if _, exists := seen["X-Debug"]; exists {
+		t.Error("a header whose values all became empty must be removed")
+	}
+}
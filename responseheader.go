@@ -0,0 +1,88 @@
+package subfilter
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// framingHeaders describe how the body is sent and are managed by the
+// middleware itself, so AddHeaders cannot set them.
+var framingHeaders = map[string]bool{
+	"Content-Length":    true,
+	"Content-Encoding":  true,
+	"Transfer-Encoding": true,
+}
+
+// headerEdits remove and add response headers right before they are sent.
+type headerEdits struct {
+	remove []string
+	add    http.Header
+	// appendValues adds values next to the upstream ones instead of
+	// replacing them.
+	appendValues bool
+}
+
+func (s *SubFilter) setupResponseHeaders(config *Config) error {
+	if len(config.RemoveHeaders) == 0 && len(config.AddHeaders) == 0 {
+		return nil
+	}
+
+	edits := &headerEdits{add: make(http.Header, len(config.AddHeaders)), appendValues: config.AppendHeaders}
+
+	for _, name := range config.RemoveHeaders {
+		if name == "" {
+			return fmt.Errorf("removeHeaders: empty header name")
+		}
+
+		edits.remove = append(edits.remove, http.CanonicalHeaderKey(name))
+	}
+
+	for name, value := range config.AddHeaders {
+		name = http.CanonicalHeaderKey(name)
+
+		switch {
+		case name == "":
+			return fmt.Errorf("addHeaders: empty header name")
+		case framingHeaders[name]:
+			return fmt.Errorf("addHeaders: %s is managed by the middleware", name)
+		}
+
+		edits.add[name] = []string{value}
+	}
+
+	s.headerEdits = edits
+
+	return nil
+}
+
+// apply removes, then adds, the configured headers in h. A nil *headerEdits
+// leaves h untouched.
+func (e *headerEdits) apply(h http.Header) {
+	if e == nil {
+		return
+	}
+
+	for _, name := range e.remove {
+		h.Del(name)
+	}
+
+	for name, values := range e.add {
+		if e.appendValues {
+			h[name] = append(h[name], values...)
+		} else {
+			h[name] = values
+		}
+	}
+}
+
+// hasAnyHeader reports whether h holds any of the named headers, even with an
+// empty value.
+func hasAnyHeader(h http.Header, names []string) bool {
+	for _, name := range names {
+		if _, ok := h[http.CanonicalHeaderKey(name)]; ok {
+			return true
+		}
+	}
+
+	return false
+}
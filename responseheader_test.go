@@ -0,0 +1,109 @@
+package subfilter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestResponseHeaderEdits(t *testing.T) {
+	tests := []struct {
+		desc       string
+		appendVals bool
+		body       string
+		gated      bool
+		expFrame   []string
+		expResBody string
+	}{
+		{
+			desc:       "should edit the headers of a filtered response",
+			body:       "foo",
+			expFrame:   []string{"DENY"},
+			expResBody: "bar",
+		},
+		{
+			desc:       "should edit the headers of a response passed through",
+			body:       "nothing",
+			expFrame:   []string{"DENY"},
+			expResBody: "nothing",
+		},
+		{
+			desc:       "should edit the headers of a response the gate excludes",
+			body:       "foo",
+			gated:      true,
+			expFrame:   []string{"DENY"},
+			expResBody: "foo",
+		},
+		{
+			desc:       "should append to the upstream values",
+			appendVals: true,
+			body:       "foo",
+			expFrame:   []string{"SAMEORIGIN", "DENY"},
+			expResBody: "bar",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.Filters = []Filter{{Regex: "foo", Replacement: "bar"}}
+			config.RemoveHeaders = []string{"server", "X-Powered-By"}
+			config.AddHeaders = map[string]string{"x-frame-options": "DENY"}
+			config.AppendHeaders = test.appendVals
+
+			if test.gated {
+				config.Cookies = map[string]string{"beta": "on"}
+			}
+
+			next := func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Add("Server", "Apache")
+				w.Header().Add("Server", "Tomcat")
+				w.Header().Set("X-Powered-By", "PHP")
+				w.Header().Set("X-Frame-Options", "SAMEORIGIN")
+				w.Header().Set("Content-Length", strconv.Itoa(len(test.body)))
+				_, _ = w.Write([]byte(test.body))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			for _, name := range []string{"Server", "X-Powered-By"} {
+				if v, ok := recorder.Header()[name]; ok {
+					t.Errorf("got %s %q, want none", name, v)
+				}
+			}
+
+			if got := recorder.Header().Values("X-Frame-Options"); !reflect.DeepEqual(got, test.expFrame) {
+				t.Errorf("got X-Frame-Options %q, want %q", got, test.expFrame)
+			}
+
+			if got := recorder.Body.String(); got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+		})
+	}
+}
+
+func TestResponseHeaderEditsAlone(t *testing.T) {
+	config := CreateConfig()
+	config.RemoveHeaders = []string{"Server"}
+
+	if _, err := New(context.Background(), nil, config, "subfilter"); err != nil {
+		t.Fatalf("got error %v, want header edits alone to be a valid configuration", err)
+	}
+
+	config.AddHeaders = map[string]string{"content-length": "0"}
+
+	if _, err := New(context.Background(), nil, config, "subfilter"); err == nil {
+		t.Fatal("expected error when adding Content-Length")
+	}
+}
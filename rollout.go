@@ -0,0 +1,97 @@
+package subfilter
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// rolloutBuckets is the resolution of key-hash sampling: 10000 buckets give
+// percentages with two decimals.
+const rolloutBuckets = 10000
+
+var (
+	rolloutMu   sync.Mutex
+	rolloutRand = rand.New(rand.NewSource(time.Now().UnixNano())) // nolint:gosec // used for sampling, not security
+)
+
+// rollout applies a filter to a percentage of the requests only.
+type rollout struct {
+	percent float64
+	// keyHeader, when set, names the request header whose value decides on
+	// which side a request falls, so that a given user consistently sees or
+	// does not see the filter.
+	keyHeader string
+}
+
+// compileRollout returns the rollout of f, or nil when f applies to every
+// request.
+func compileRollout(f Filter) (*rollout, error) {
+	switch {
+	case f.SamplePercent < 0 || f.SamplePercent > 100:
+		return nil, fmt.Errorf("samplePercent %v is not between 0 and 100", f.SamplePercent)
+	case f.SampleKeyHeader != "" && f.SamplePercent == 0:
+		return nil, errors.New("sampleKeyHeader requires samplePercent")
+	case f.SamplePercent == 0 || f.SamplePercent == 100:
+		return nil, nil
+	}
+
+	return &rollout{percent: f.SamplePercent, keyHeader: f.SampleKeyHeader}, nil
+}
+
+// include reports whether the filtering of sc falls within the rollout. The
+// decision is drawn once per scope, so that every body part, and every match,
+// sees the same outcome. A nil *rollout includes everything.
+func (ro *rollout) include(sc *scope) bool {
+	if ro == nil {
+		return true
+	}
+
+	if sc == nil {
+		return ro.draw(nil)
+	}
+
+	if in, ok := sc.rollouts[ro]; ok {
+		return in
+	}
+
+	in := ro.draw(sc)
+	if !in {
+		sc.sampledOut++
+	}
+
+	if sc.rollouts == nil {
+		sc.rollouts = make(map[*rollout]bool)
+	}
+
+	sc.rollouts[ro] = in
+	sc.markRequestDependent()
+
+	return in
+}
+
+// draw decides whether sc falls within the rollout, hashing the key header
+// when the request carries it and drawing at random otherwise.
+func (ro *rollout) draw(sc *scope) bool {
+	if ro.keyHeader != "" && sc != nil && sc.req != nil {
+		if key := sc.req.Header.Get(ro.keyHeader); key != "" {
+			h := fnv.New32a()
+			_, _ = h.Write([]byte(key))
+
+			return float64(h.Sum32()%rolloutBuckets) < ro.percent*rolloutBuckets/100
+		}
+	}
+
+	return sampleRandom(ro.percent)
+}
+
+// sampleRandom reports whether a random draw falls within percent.
+func sampleRandom(percent float64) bool {
+	rolloutMu.Lock()
+	defer rolloutMu.Unlock()
+
+	return rolloutRand.Float64()*100 < percent
+}
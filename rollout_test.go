@@ -0,0 +1,97 @@
+package subfilter
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSamplePercent(t *testing.T) {
+	tests := []struct {
+		desc    string
+		percent float64
+		expIn   int
+	}{
+		{desc: "should always apply when disabled", percent: 0, expIn: 100},
+		{desc: "should always apply at 100%", percent: 100, expIn: 100},
+		{desc: "should apply to part of the keys", percent: 50},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.Filters = []Filter{{Regex: "foo", Replacement: "bar", SamplePercent: test.percent}}
+			if test.percent > 0 {
+				config.Filters[0].SampleKeyHeader = "X-User"
+			}
+
+			next := func(w http.ResponseWriter, _ *http.Request) {
+				_, _ = w.Write([]byte("foo"))
+			}
+
+			sf, err := NewSubFilter(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			serve := func(user string) bool {
+				req := httptest.NewRequest(http.MethodGet, "/", nil)
+				req.Header.Set("X-User", user)
+
+				recorder := httptest.NewRecorder()
+				sf.ServeHTTP(recorder, req)
+
+				return recorder.Body.String() == "bar"
+			}
+
+			var in int
+
+			for i := 0; i < 100; i++ {
+				user := fmt.Sprintf("user-%d", i)
+
+				applied := serve(user)
+				if serve(user) != applied {
+					t.Fatalf("got a different assignment for %s on the second request", user)
+				}
+
+				if applied {
+					in++
+				}
+			}
+
+			switch {
+			case test.expIn != 0 && in != test.expIn:
+				t.Errorf("got %d users in, want %d", in, test.expIn)
+			case test.expIn == 0 && (in == 0 || in == 100):
+				t.Errorf("got %d users in, want some on each side", in)
+			}
+
+			if got, exp := sf.Stats().SampledOut, uint64(2*(100-in)); got != exp {
+				t.Errorf("got %d sampled out, want %d", got, exp)
+			}
+		})
+	}
+}
+
+func TestSamplePercentConfig(t *testing.T) {
+	tests := []struct {
+		desc   string
+		filter Filter
+	}{
+		{desc: "should reject negative percentages", filter: Filter{Regex: "foo", SamplePercent: -1}},
+		{desc: "should reject percentages over 100", filter: Filter{Regex: "foo", SamplePercent: 101}},
+		{desc: "should reject a key header without percentage", filter: Filter{Regex: "foo", SampleKeyHeader: "X-User"}},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			if _, err := compileFilters([]Filter{test.filter}); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
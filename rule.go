@@ -0,0 +1,373 @@
+package subfilter
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Rule is a named, ordered set of filters that only applies to responses
+// matching its conditions.
+type Rule struct {
+	Name       string     `json:"name,omitempty"`
+	Conditions Conditions `json:"conditions,omitempty"`
+	Filters    []Filter   `json:"filters,omitempty"`
+}
+
+// Conditions restricts which requests and responses a set of filters applies
+// to. Every non-empty list must match; an empty list matches everything.
+type Conditions struct {
+	// Paths are regexes matched against the request path.
+	Paths []string `json:"paths,omitempty"`
+	// ContentTypes are media types matched against the response Content-Type.
+	ContentTypes []string `json:"contentTypes,omitempty"`
+	Methods      []string `json:"methods,omitempty"`
+	// StatusCodes are response status codes ("404"), classes ("2xx") or
+	// inclusive ranges ("500-599").
+	StatusCodes []string `json:"statusCodes,omitempty"`
+	// ResponseHeaders maps response header names to regexes one of their
+	// values must match, such as {"X-Cache": "^MISS"}. A missing header does
+	// not match.
+	ResponseHeaders map[string]string `json:"responseHeaders,omitempty"`
+	// Languages are language tags matched against the language negotiated
+	// from the request Accept-Language header among those of every rule, so
+	// that "de" also serves "de-AT". "*" matches when no rule language does.
+	Languages []string `json:"languages,omitempty"`
+	// ContentLanguages are language tags matched against the response
+	// Content-Language header, "fr" also matching "fr-CA". Responses without
+	// the header do not match.
+	ContentLanguages []string `json:"contentLanguages,omitempty"`
+	// Backends are the names of upstreams, matched case-insensitively
+	// against the BackendHeader response header (X-Backend by default)
+	// identifying the one that served the response. Responses without the
+	// header do not match.
+	Backends      []string `json:"backends,omitempty"`
+	BackendHeader string   `json:"backendHeader,omitempty"`
+}
+
+// defaultBackendHeader is the response header Backends are matched against
+// by default.
+const defaultBackendHeader = "X-Backend"
+
+type rule struct {
+	name       string
+	conditions *conditions
+	filters    []filter
+}
+
+type statusRange struct {
+	min, max int
+}
+
+func (sr statusRange) contains(status int) bool {
+	return status >= sr.min && status <= sr.max
+}
+
+type conditions struct {
+	paths        []*regexp.Regexp
+	contentTypes []string
+	methods      []string
+	statusCodes  []statusRange
+	headers      map[string]*regexp.Regexp
+	languages    []string
+	contentLangs []string
+	backends     []string
+	backendName  string
+	prefixHeader string
+}
+
+func compileConditions(c Conditions) (*conditions, error) {
+	cc := &conditions{
+		contentTypes: c.ContentTypes,
+		methods:      c.Methods,
+	}
+
+	for _, p := range c.Paths {
+		regex, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("error compiling path regex %q: %w", p, err)
+		}
+
+		cc.paths = append(cc.paths, regex)
+	}
+
+	for _, p := range c.StatusCodes {
+		sr, err := parseStatusPattern(p)
+		if err != nil {
+			return nil, err
+		}
+
+		cc.statusCodes = append(cc.statusCodes, sr)
+	}
+
+	for name, p := range c.ResponseHeaders {
+		regex, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("error compiling regex %q for response header %q: %w", p, name, err)
+		}
+
+		if cc.headers == nil {
+			cc.headers = make(map[string]*regexp.Regexp, len(c.ResponseHeaders))
+		}
+
+		cc.headers[http.CanonicalHeaderKey(name)] = regex
+	}
+
+	for _, lang := range c.Languages {
+		cc.languages = append(cc.languages, strings.ToLower(strings.TrimSpace(lang)))
+	}
+
+	for _, lang := range c.ContentLanguages {
+		tag := strings.ToLower(strings.TrimSpace(lang))
+		if !isLanguageTag(tag) {
+			return nil, fmt.Errorf("invalid content language %q", lang)
+		}
+
+		cc.contentLangs = append(cc.contentLangs, tag)
+	}
+
+	if c.BackendHeader != "" && len(c.Backends) == 0 {
+		return nil, errors.New("backendHeader requires backends")
+	}
+
+	if len(c.Backends) > 0 {
+		cc.backends = c.Backends
+		cc.backendName = defaultBackendHeader
+
+		if c.BackendHeader != "" {
+			if !isToken(c.BackendHeader) {
+				return nil, fmt.Errorf("invalid backendHeader %q", c.BackendHeader)
+			}
+
+			cc.backendName = http.CanonicalHeaderKey(c.BackendHeader)
+		}
+	}
+
+	return cc, nil
+}
+
+// parseStatusPattern parses "404", "2xx" or "500-599" into a status range.
+func parseStatusPattern(p string) (statusRange, error) {
+	p = strings.ToLower(strings.TrimSpace(p))
+
+	if len(p) == 3 && strings.HasSuffix(p, "xx") && p[0] >= '1' && p[0] <= '9' {
+		class := int(p[0]-'0') * 100
+
+		return statusRange{min: class, max: class + 99}, nil
+	}
+
+	bounds := strings.SplitN(p, "-", 2)
+
+	lo, err := strconv.Atoi(strings.TrimSpace(bounds[0]))
+	if err != nil {
+		return statusRange{}, fmt.Errorf("invalid status code pattern %q", p)
+	}
+
+	hi := lo
+
+	if len(bounds) == 2 {
+		hi, err = strconv.Atoi(strings.TrimSpace(bounds[1]))
+		if err != nil || hi < lo {
+			return statusRange{}, fmt.Errorf("invalid status code pattern %q", p)
+		}
+	}
+
+	return statusRange{min: lo, max: hi}, nil
+}
+
+// match reports whether the conditions hold for the request, its negotiated
+// language and the response status and headers. A nil *conditions matches
+// everything.
+func (c *conditions) match(r *http.Request, lang string, status int, header http.Header) bool {
+	if c == nil {
+		return true
+	}
+
+	return c.matchRequest(r) && c.matchLanguage(lang) && c.matchResponse(status, header)
+}
+
+// matchLanguage reports whether lang, as negotiated among the languages of
+// every rule, is one of the conditions, "" matching the default language.
+func (c *conditions) matchLanguage(lang string) bool {
+	if len(c.languages) == 0 {
+		return true
+	}
+
+	if lang == "" {
+		lang = defaultLanguage
+	}
+
+	for _, l := range c.languages {
+		if l == lang {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *conditions) matchRequest(r *http.Request) bool {
+	if len(c.methods) > 0 && !containsFold(c.methods, r.Method) {
+		return false
+	}
+
+	if len(c.paths) == 0 {
+		return true
+	}
+
+	path := c.requestPath(r)
+
+	for _, p := range c.paths {
+		if p.MatchString(path) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *conditions) matchResponse(status int, header http.Header) bool {
+	if len(c.contentTypes) > 0 && !matchMediaType(c.contentTypes, header.Get("Content-Type")) {
+		return false
+	}
+
+	for name, regex := range c.headers {
+		if !matchHeader(header.Values(name), regex) {
+			return false
+		}
+	}
+
+	if len(c.contentLangs) > 0 && !matchContentLanguage(header.Values("Content-Language"), c.contentLangs) {
+		return false
+	}
+
+	if len(c.backends) > 0 && !matchBackend(header.Values(c.backendName), c.backends) {
+		return false
+	}
+
+	if len(c.statusCodes) == 0 {
+		return true
+	}
+
+	for _, sr := range c.statusCodes {
+		if sr.contains(status) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchHeader reports whether any of values matches regex.
+func matchHeader(values []string, regex *regexp.Regexp) bool {
+	for _, v := range values {
+		if regex.MatchString(v) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchBackend reports whether any of values names one of backends.
+func matchBackend(values, backends []string) bool {
+	for _, v := range values {
+		if containsFold(backends, strings.TrimSpace(v)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchContentLanguage reports whether any language of the Content-Language
+// values is one of langs or a subtag of one.
+func matchContentLanguage(values, langs []string) bool {
+	for _, v := range values {
+		for _, tag := range strings.Split(v, ",") {
+			tag = strings.ToLower(strings.TrimSpace(tag))
+
+			for _, lang := range langs {
+				if tag == lang || strings.HasPrefix(tag, lang+"-") {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(strings.TrimSpace(v), s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// compileRules compiles the legacy top-level filters into an implicit
+// unconditional rule, followed by the configured rules.
+func compileRules(legacy []Filter, rules []Rule) ([]rule, error) {
+	filters, err := compileFilters(legacy)
+	if err != nil {
+		return nil, err
+	}
+
+	compiled := []rule{{filters: filters}}
+
+	for i, r := range rules {
+		name := r.Name
+		if name == "" {
+			name = strconv.Itoa(i)
+		}
+
+		cond, err := compileConditions(r.Conditions)
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: %w", name, err)
+		}
+
+		filters, err := compileFilters(r.Filters)
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: %w", name, err)
+		}
+
+		compiled = append(compiled, rule{name: name, conditions: cond, filters: filters})
+	}
+
+	return compiled, nil
+}
+
+// ruleFilters returns the filters of every rule matching the response, in
+// order. The implicit rule holding the top-level filters is not included.
+func ruleFilters(rules []rule, stopAtFirst bool, r *http.Request, lang string, status int, header http.Header) []filter {
+	var selected []filter
+
+	for _, rl := range rules[1:] {
+		if !rl.conditions.match(r, lang, status, header) {
+			continue
+		}
+
+		selected = append(selected, rl.filters...)
+
+		if stopAtFirst {
+			break
+		}
+	}
+
+	return selected
+}
+
+func countFilters(rules []rule) int {
+	n := 0
+	for _, r := range rules {
+		n += len(r.filters)
+	}
+
+	return n
+}
@@ -0,0 +1,380 @@
+package subfilter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRules(t *testing.T) {
+	rules := []Rule{
+		{
+			Name:       "html",
+			Conditions: Conditions{ContentTypes: []string{"text/html"}, StatusCodes: []string{"2xx"}},
+			Filters:    []Filter{{Regex: "html", Replacement: "HTML"}},
+		},
+		{
+			Name:       "api",
+			Conditions: Conditions{Paths: []string{"^/api/"}, Methods: []string{"get", "POST"}},
+			Filters:    []Filter{{Regex: "api", Replacement: "API"}},
+		},
+		{
+			Name:    "catch-all",
+			Filters: []Filter{{Regex: "any", Replacement: "ANY"}},
+		},
+	}
+
+	tests := []struct {
+		desc        string
+		stopAtFirst bool
+		method      string
+		path        string
+		contentType string
+		status      int
+		expResBody  string
+	}{
+		{
+			desc:        "should apply the top-level filters before the matching rules",
+			path:        "/index.html",
+			contentType: "text/html; charset=utf-8",
+			expResBody:  "TOP HTML api ANY",
+		},
+		{
+			desc:        "should apply a rule matching on path and method",
+			path:        "/api/users",
+			contentType: "application/json",
+			expResBody:  "TOP html API ANY",
+		},
+		{
+			desc:        "should skip a rule whose status condition does not match",
+			path:        "/index.html",
+			contentType: "text/html",
+			status:      http.StatusNotFound,
+			expResBody:  "TOP html api ANY",
+		},
+		{
+			desc:        "should skip a rule whose method condition does not match",
+			method:      http.MethodDelete,
+			path:        "/api/users",
+			contentType: "application/json",
+			expResBody:  "TOP html api ANY",
+		},
+		{
+			desc:        "should stop at the first matching rule",
+			stopAtFirst: true,
+			path:        "/api/users",
+			contentType: "application/json",
+			expResBody:  "TOP html API any",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.Filters = []Filter{{Regex: "top", Replacement: "TOP"}}
+			config.Rules = rules
+			config.StopAtFirstRule = test.stopAtFirst
+
+			next := func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", test.contentType)
+
+				if test.status != 0 {
+					w.WriteHeader(test.status)
+				}
+
+				_, _ = w.Write([]byte("top html api any"))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			method := test.method
+			if method == "" {
+				method = http.MethodGet
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(method, test.path, nil))
+
+			if got := recorder.Body.String(); got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+		})
+	}
+}
+
+func TestRulesResponseHeaders(t *testing.T) {
+	tests := []struct {
+		desc       string
+		xCache     []string
+		expResBody string
+	}{
+		{
+			desc:       "should apply the rule on a cache miss",
+			xCache:     []string{"MISS"},
+			expResBody: "<body><p>fresh</p>",
+		},
+		{
+			desc:       "should match any value of the header",
+			xCache:     []string{"HIT from edge", "MISS from origin"},
+			expResBody: "<body><p>fresh</p>",
+		},
+		{
+			desc:       "should skip the rule on a cache hit",
+			xCache:     []string{"HIT"},
+			expResBody: "<body>",
+		},
+		{
+			desc:       "should skip the rule without the header",
+			expResBody: "<body>",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.Rules = []Rule{{
+				Name:       "banner",
+				Conditions: Conditions{ResponseHeaders: map[string]string{"x-cache": "^MISS"}},
+				Filters:    []Filter{{Regex: "<body>", Replacement: "<p>fresh</p>", Action: "insertAfter"}},
+			}}
+
+			next := func(w http.ResponseWriter, r *http.Request) {
+				for _, v := range test.xCache {
+					w.Header().Add("X-Cache", v)
+				}
+
+				_, _ = w.Write([]byte("<body>"))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if got := recorder.Body.String(); got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+		})
+	}
+}
+
+func TestRulesContentLanguages(t *testing.T) {
+	tests := []struct {
+		desc            string
+		contentLanguage string
+		expResBody      string
+	}{
+		{
+			desc:            "should apply the rule to French responses",
+			contentLanguage: "fr",
+			expResBody:      "Courriel",
+		},
+		{
+			desc:            "should apply the rule to regional French responses",
+			contentLanguage: "de, FR-ca",
+			expResBody:      "Courriel",
+		},
+		{
+			desc:            "should skip the rule for English responses",
+			contentLanguage: "en",
+			expResBody:      "E-mail",
+		},
+		{
+			desc:            "should not match languages sharing a prefix",
+			contentLanguage: "fra",
+			expResBody:      "E-mail",
+		},
+		{
+			desc:       "should skip the rule without the header",
+			expResBody: "E-mail",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.Rules = []Rule{{
+				Conditions: Conditions{ContentLanguages: []string{"fr"}},
+				Filters:    []Filter{{Regex: "E-mail", Replacement: "Courriel"}},
+			}}
+
+			next := func(w http.ResponseWriter, r *http.Request) {
+				if test.contentLanguage != "" {
+					w.Header().Set("Content-Language", test.contentLanguage)
+				}
+
+				_, _ = w.Write([]byte("E-mail"))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if got := recorder.Body.String(); got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+		})
+	}
+
+	config := CreateConfig()
+	config.Rules = []Rule{{
+		Conditions: Conditions{ContentLanguages: []string{"fr_FR"}},
+		Filters:    []Filter{{Regex: "foo"}},
+	}}
+
+	if _, err := New(context.Background(), nil, config, "subfilter"); err == nil {
+		t.Error("expected error for an invalid content language")
+	}
+}
+
+func TestRulesBackends(t *testing.T) {
+	tests := []struct {
+		desc          string
+		backendHeader string
+		header        string
+		backend       string
+		expResBody    string
+	}{
+		{
+			desc:       "should apply the rule to the legacy backend",
+			header:     "X-Backend",
+			backend:    "legacy",
+			expResBody: "https://example.com",
+		},
+		{
+			desc:       "should match backends case-insensitively",
+			header:     "X-Backend",
+			backend:    "Legacy",
+			expResBody: "https://example.com",
+		},
+		{
+			desc:       "should leave the modern backend untouched",
+			header:     "X-Backend",
+			backend:    "modern",
+			expResBody: "http://example.com",
+		},
+		{
+			desc:       "should skip the rule without the header",
+			expResBody: "http://example.com",
+		},
+		{
+			desc:          "should match the configured header",
+			backendHeader: "x-served-by",
+			header:        "X-Served-By",
+			backend:       "legacy",
+			expResBody:    "https://example.com",
+		},
+		{
+			desc:          "should ignore the default header when another is configured",
+			backendHeader: "X-Served-By",
+			header:        "X-Backend",
+			backend:       "legacy",
+			expResBody:    "http://example.com",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.Rules = []Rule{{
+				Conditions: Conditions{Backends: []string{"legacy"}, BackendHeader: test.backendHeader},
+				Filters:    []Filter{{Regex: "http://", Replacement: "https://"}},
+			}}
+
+			next := func(w http.ResponseWriter, r *http.Request) {
+				if test.header != "" {
+					w.Header().Set(test.header, test.backend)
+				}
+
+				_, _ = w.Write([]byte("http://example.com"))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if got := recorder.Body.String(); got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+		})
+	}
+
+	for _, c := range []Conditions{
+		{BackendHeader: "X-Backend"},
+		{Backends: []string{"legacy"}, BackendHeader: "X Backend"},
+	} {
+		config := CreateConfig()
+		config.Rules = []Rule{{Conditions: c, Filters: []Filter{{Regex: "foo"}}}}
+
+		if _, err := New(context.Background(), nil, config, "subfilter"); err == nil {
+			t.Errorf("expected error for %+v", c)
+		}
+	}
+}
+
+func TestRulesWithoutTopLevelFilters(t *testing.T) {
+	config := CreateConfig()
+	config.Rules = []Rule{{Name: "only", Filters: []Filter{{Regex: "foo", Replacement: "bar"}}}}
+
+	if _, err := New(context.Background(), nil, config, "subfilter"); err != nil {
+		t.Fatalf("got error %v, want rules alone to be a valid configuration", err)
+	}
+
+	config.Rules[0].Conditions.StatusCodes = []string{"2xy"}
+
+	if _, err := New(context.Background(), nil, config, "subfilter"); err == nil {
+		t.Fatal("expected error on invalid status code pattern")
+	}
+
+	config.Rules[0].Conditions.StatusCodes = nil
+	config.Rules[0].Conditions.ResponseHeaders = map[string]string{"X-Cache": "("}
+
+	if _, err := New(context.Background(), nil, config, "subfilter"); err == nil {
+		t.Fatal("expected error on invalid response header regex")
+	}
+}
+
+func TestParseStatusPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		exp     statusRange
+		expErr  bool
+	}{
+		{pattern: "404", exp: statusRange{min: 404, max: 404}},
+		{pattern: "2xx", exp: statusRange{min: 200, max: 299}},
+		{pattern: "5XX", exp: statusRange{min: 500, max: 599}},
+		{pattern: "500-503", exp: statusRange{min: 500, max: 503}},
+		{pattern: "503-500", expErr: true},
+		{pattern: "x", expErr: true},
+	}
+
+	for _, test := range tests {
+		got, err := parseStatusPattern(test.pattern)
+		if (err != nil) != test.expErr {
+			t.Errorf("%q: got error %v, want error %v", test.pattern, err, test.expErr)
+		}
+
+		if !test.expErr && got != test.exp {
+			t.Errorf("%q: got %+v, want %+v", test.pattern, got, test.exp)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package subfilter
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultSampleBytes = 256
+
+	// sampleInterval is the minimum time between two logged samples.
+	sampleInterval = 10 * time.Second
+)
+
+func (s *SubFilter) setupSample(config *Config) error {
+	if !config.LogUnmatchedSample {
+		return nil
+	}
+
+	s.sampleBytes = config.SampleBytes
+	if s.sampleBytes <= 0 {
+		s.sampleBytes = defaultSampleBytes
+	}
+
+	return nil
+}
+
+// sampleUnmatched logs the start of a body left unchanged by every filter, at
+// most once per sampleInterval.
+func (s *SubFilter) sampleUnmatched(r *http.Request, b []byte) {
+	if s.sampleBytes == 0 {
+		return
+	}
+
+	now := time.Now().UnixNano()
+
+	last := atomic.LoadInt64(&s.lastSample)
+	if now-last < int64(sampleInterval) || !atomic.CompareAndSwapInt64(&s.lastSample, last, now) {
+		return
+	}
+
+	if len(b) > s.sampleBytes {
+		b = b[:s.sampleBytes]
+	}
+
+	s.logResponse(logLevelDebug, r, "no filter matched %s, body starts with %q", r.URL.Path, b)
+}
@@ -0,0 +1,53 @@
+package subfilter
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLogUnmatchedSample(t *testing.T) {
+	var logs bytes.Buffer
+
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	config := CreateConfig()
+	config.Filters = []Filter{{Regex: "foo", Replacement: "bar"}}
+	config.LogUnmatchedSample = true
+	config.SampleBytes = 9
+
+	body := "nothing to see here"
+
+	next := func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(body))
+	}
+
+	handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/page", nil))
+	}
+
+	if got := strings.Count(logs.String(), `no filter matched /page, body starts with "nothing t"`); got != 1 {
+		t.Errorf("got %d samples, want 1 within the rate limit: %s", got, logs.String())
+	}
+
+	logs.Reset()
+	body = "foo"
+
+	handler.(*SubFilter).lastSample = 0
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/page", nil))
+
+	if logs.Len() != 0 {
+		t.Errorf("got a sample for a matched body: %s", logs.String())
+	}
+}
@@ -0,0 +1,234 @@
+package subfilter
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// scope carries what a replacement may depend on besides the match itself. It
+// lives for the filtering of one body, so that values generated for it agree
+// across all its matches.
+type scope struct {
+	req *http.Request
+	// lang is the language the rules selected for req, if any.
+	lang string
+	// contentType is the Content-Type of the body being filtered, if known.
+	contentType string
+	// header holds the headers of the response being filtered, if known.
+	header http.Header
+	// trustForwardedFor takes the client address of req from its
+	// X-Forwarded-For header.
+	trustForwardedFor bool
+	// tokenizerFallback says what HTML-aware filters do with malformed
+	// markup.
+	tokenizerFallback string
+
+	// matches counts the matches replaced so far, each reported to onMatch
+	// when set, once it is sure to be acted upon.
+	matches int
+	onMatch func(MatchInfo)
+	// traced, when set, counts the matches of every filter by definition.
+	traced map[*Filter]int
+	// resolve, when set, resolves the ${lookup:key} transform tokens.
+	resolve func(key string) (string, bool)
+	// applied holds the filters that matched at least once, and used the
+	// number of matches acted upon by those with a maxMatches.
+	applied map[*filter]bool
+	used    map[*filter]int
+	// independent applies the filters to the body independently of each
+	// other, conflicts counting the edits dropped as they overlapped others.
+	independent bool
+	conflicts   int
+	// autoScope restricts the filters without a scope of their own to the
+	// parts of the body its Content-Type makes safe to rewrite, scoping being
+	// set while one of them runs within its scope.
+	autoScope bool
+	scoping   bool
+	// inserted, when set, holds the fingerprints of the insertions made so
+	// far, for identical ones to be skipped.
+	inserted map[[sha256.Size]byte]bool
+	// streamed is set when the body is filtered as it is written, and
+	// firstChunkLeft is then the number of bytes of the first write of the
+	// upstream at the start of the piece being filtered.
+	streamed       bool
+	firstChunkLeft int
+	// failure holds the error of the replacement that failed in the filter
+	// being applied, and aborted the one of a filter with the abort policy.
+	failure error
+	aborted error
+	now     time.Time
+	// clock, when set, stands for time.Now.
+	clock func() time.Time
+	uuid  string
+	// requestDependent is set once a replacement used request data, so the
+	// result differs from one requester to the next.
+	requestDependent bool
+	// rollouts holds the decision of every sampled filter, and sampledOut the
+	// number of filters it left out.
+	rollouts   map[*rollout]bool
+	sampledOut int
+	// timing enables the measure of the time of every filter, accumulated
+	// in durations in the order the filters first ran.
+	timing        bool
+	durations     []FilterDuration
+	durationIndex map[*Filter]int
+}
+
+// context returns the context of the request, if any.
+func (sc *scope) context() context.Context {
+	if sc == nil || sc.req == nil {
+		return context.Background()
+	}
+
+	return sc.req.Context()
+}
+
+// countMatch counts a match of the filter defined by def, as acted upon until
+// taken back.
+func (sc *scope) countMatch(def *Filter) {
+	if sc == nil {
+		return
+	}
+
+	sc.matches++
+
+	if sc.traced != nil && def != nil {
+		sc.traced[def]++
+	}
+}
+
+// reportMatch reports the match of b[start:end] by the filter defined by def
+// to onMatch, once it is counted for good.
+func (sc *scope) reportMatch(def *Filter, b []byte, start, end int) {
+	if sc != nil && sc.onMatch != nil && def != nil {
+		sc.onMatch(MatchInfo{Filter: *def, Start: start, End: end, Text: string(b[start:end])})
+	}
+}
+
+// discountMatch takes back the last match counted for the filter defined by
+// def, whose replacement turned out to be the match itself.
+func (sc *scope) discountMatch(def *Filter) {
+	if sc == nil {
+		return
+	}
+
+	sc.matches--
+
+	if sc.traced != nil && def != nil {
+		sc.traced[def]--
+	}
+}
+
+// fail reports that a replacement of the filter being applied failed.
+func (sc *scope) fail(err error) {
+	if sc != nil && sc.failure == nil {
+		sc.failure = err
+	}
+}
+
+// takeFailure returns and clears the error reported by fail, if any.
+func (sc *scope) takeFailure() error {
+	if sc == nil {
+		return nil
+	}
+
+	err := sc.failure
+	sc.failure = nil
+
+	return err
+}
+
+// matchesLeft returns the number of matches f may still act upon, or -1 when
+// there is no limit. Without a scope, the limit holds for each body alone.
+func (sc *scope) matchesLeft(f *filter) int {
+	switch {
+	case f.maxMatches == 0:
+		return -1
+	case sc == nil:
+		return f.maxMatches
+	default:
+		return f.maxMatches - sc.used[f]
+	}
+}
+
+// useMatch records a match acted upon by f.
+func (sc *scope) useMatch(f *filter) {
+	if sc == nil || f.maxMatches == 0 {
+		return
+	}
+
+	if sc.used == nil {
+		sc.used = make(map[*filter]int)
+	}
+
+	sc.used[f]++
+}
+
+func (sc *scope) markRequestDependent() {
+	if sc != nil {
+		sc.requestDependent = true
+	}
+}
+
+// language returns the language the rules selected for the request or, when
+// none did, the one the client prefers.
+func (sc *scope) language() string {
+	if sc == nil || sc.req == nil {
+		return ""
+	}
+
+	if sc.lang != "" {
+		return sc.lang
+	}
+
+	if tags := parseAcceptLanguage(sc.req.Header.Get("Accept-Language")); len(tags) > 0 {
+		return tags[0]
+	}
+
+	return ""
+}
+
+// time returns the time at which the scope first needed it.
+func (sc *scope) time() time.Time {
+	if sc == nil {
+		return time.Now()
+	}
+
+	if sc.now.IsZero() {
+		sc.now = time.Now()
+
+		if sc.clock != nil {
+			sc.now = sc.clock()
+		}
+	}
+
+	return sc.now
+}
+
+// uuidV4 returns a random version 4 UUID, drawn once per scope.
+func (sc *scope) uuidV4() string {
+	if sc != nil && sc.uuid != "" {
+		return sc.uuid
+	}
+
+	var b [16]byte
+
+	if _, err := rand.Read(b[:]); err != nil {
+		log.Printf("unable to generate a UUID: %v", err)
+	}
+
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+
+	uuid := fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+	if sc != nil {
+		sc.uuid = uuid
+	}
+
+	return uuid
+}
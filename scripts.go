@@ -0,0 +1,149 @@
+package subfilter
+
+import (
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	defaultScriptFetchTimeout = 5 * time.Second
+
+	// maxScriptSize bounds the scripts read to compute their integrity.
+	maxScriptSize = 10 << 20
+)
+
+var (
+	bodyCloseRegex = regexp.MustCompile(`(?i)</body\s*>`)
+	integrityRegex = regexp.MustCompile(`^sha(?:256|384|512)-[A-Za-z0-9+/]+={0,2}$`)
+)
+
+// ScriptInjection is an external script inserted into HTML documents as
+// <script src="Src">. Integrity is its Subresource Integrity hash, as in
+// "sha384-...", or ComputeIntegrity fetches Src at startup to compute it.
+// CrossOrigin defaults to "anonymous" when there is an integrity hash.
+type ScriptInjection struct {
+	Src              string `json:"src,omitempty"`
+	Integrity        string `json:"integrity,omitempty"`
+	ComputeIntegrity bool   `json:"computeIntegrity,omitempty"`
+	CrossOrigin      string `json:"crossOrigin,omitempty"`
+	Async            bool   `json:"async,omitempty"`
+	Defer            bool   `json:"defer,omitempty"`
+}
+
+func (s *SubFilter) setupInjectScripts(config *Config) error {
+	client := &http.Client{Timeout: defaultScriptFetchTimeout}
+
+	for i, script := range config.InjectScripts {
+		if script.Src == "" {
+			return fmt.Errorf("injectScripts[%d]: src is required", i)
+		}
+
+		integrity := script.Integrity
+
+		switch {
+		case integrity != "" && script.ComputeIntegrity:
+			return fmt.Errorf("injectScripts[%d]: integrity and computeIntegrity are mutually exclusive", i)
+		case integrity != "":
+			for _, hash := range strings.Fields(integrity) {
+				if !integrityRegex.MatchString(hash) {
+					return fmt.Errorf("injectScripts[%d]: invalid integrity %q: must be sha256, sha384 or sha512 "+
+						"followed by a dash and the base64 digest", i, hash)
+				}
+			}
+		case script.ComputeIntegrity:
+			var err error
+			if integrity, err = fetchIntegrity(client, script.Src); err != nil {
+				return fmt.Errorf("injectScripts[%d]: unable to compute the integrity of %q: %w", i, script.Src, err)
+			}
+		}
+
+		s.scriptTags = append(s.scriptTags, scriptTag(script, integrity)...)
+	}
+
+	return nil
+}
+
+// fetchIntegrity returns the sha384 integrity hash of the script at src.
+func fetchIntegrity(client *http.Client, src string) (string, error) {
+	u, err := url.Parse(src)
+	if err != nil {
+		return "", err
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return "", fmt.Errorf("%q is not an absolute http(s) URL", src)
+	}
+
+	res, err := client.Get(u.String())
+	if err != nil {
+		return "", err
+	}
+
+	defer func() { _ = res.Body.Close() }()
+
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %d", res.StatusCode)
+	}
+
+	b, err := ioutil.ReadAll(io.LimitReader(res.Body, maxScriptSize+1))
+	if err != nil {
+		return "", fmt.Errorf("could not read script: %w", err)
+	}
+
+	if len(b) > maxScriptSize {
+		return "", fmt.Errorf("script larger than %d bytes", maxScriptSize)
+	}
+
+	sum := sha512.Sum384(b)
+
+	return "sha384-" + base64.StdEncoding.EncodeToString(sum[:]), nil
+}
+
+// scriptTag returns the <script> tag of script.
+func scriptTag(script ScriptInjection, integrity string) []byte {
+	tag := `<script src="` + html.EscapeString(script.Src) + `"`
+
+	if integrity != "" {
+		crossOrigin := script.CrossOrigin
+		if crossOrigin == "" {
+			crossOrigin = "anonymous"
+		}
+
+		tag += ` integrity="` + html.EscapeString(integrity) + `" crossorigin="` + html.EscapeString(crossOrigin) + `"`
+	} else if script.CrossOrigin != "" {
+		tag += ` crossorigin="` + html.EscapeString(script.CrossOrigin) + `"`
+	}
+
+	if script.Async {
+		tag += " async"
+	}
+
+	if script.Defer {
+		tag += " defer"
+	}
+
+	return []byte(tag + "></script>")
+}
+
+// injectScripts inserts the scripts at the end of the <head> of the HTML
+// document b, or at the end of its body without one, or else at its end.
+func (s *SubFilter) injectScripts(b []byte) []byte {
+	if loc := headEndRegex.FindIndex(b); loc != nil {
+		return splice(b, loc[0], loc[0], s.scriptTags)
+	}
+
+	if loc := bodyCloseRegex.FindIndex(b); loc != nil {
+		return splice(b, loc[0], loc[0], s.scriptTags)
+	}
+
+	return splice(b, len(b), len(b), s.scriptTags)
+}
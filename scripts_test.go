@@ -0,0 +1,140 @@
+package subfilter
+
+import (
+	"context"
+	"crypto/sha512"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+func TestInjectScripts(t *testing.T) {
+	const script = `console.log("hello")`
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(script))
+	}))
+	defer server.Close()
+
+	sum := sha512.Sum384([]byte(script))
+	computed := "sha384-" + base64.StdEncoding.EncodeToString(sum[:])
+
+	tests := []struct {
+		desc        string
+		contentType string
+		scripts     []ScriptInjection
+		resBody     string
+		expResBody  string
+	}{
+		{
+			desc:        "should insert a script at the end of the head",
+			contentType: "text/html",
+			scripts:     []ScriptInjection{{Src: "/app.js", Defer: true}},
+			resBody:     `<html><head><title>x</title></HEAD><body></body></html>`,
+			expResBody:  `<html><head><title>x</title><script src="/app.js" defer></script></HEAD><body></body></html>`,
+		},
+		{
+			desc:        "should include a precomputed integrity",
+			contentType: "text/html",
+			scripts: []ScriptInjection{{
+				Src:       "https://cdn.example.com/lib.js",
+				Integrity: "sha256-47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=",
+			}},
+			resBody: `<head></head>`,
+			expResBody: `<head><script src="https://cdn.example.com/lib.js" ` +
+				`integrity="sha256-47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=" crossorigin="anonymous"></script></head>`,
+		},
+		{
+			desc:        "should compute the integrity of the fetched script",
+			contentType: "text/html",
+			scripts:     []ScriptInjection{{Src: server.URL + "/lib.js", ComputeIntegrity: true, CrossOrigin: "use-credentials"}},
+			resBody:     `<head></head>`,
+			expResBody: `<head><script src="` + server.URL + `/lib.js" integrity="` + computed +
+				`" crossorigin="use-credentials"></script></head>`,
+		},
+		{
+			desc:        "should insert at the end of the body without a head",
+			contentType: "text/html",
+			scripts:     []ScriptInjection{{Src: "/a.js"}, {Src: "/b.js", Async: true}},
+			resBody:     `<body>x</body>`,
+			expResBody:  `<body>x<script src="/a.js"></script><script src="/b.js" async></script></body>`,
+		},
+		{
+			desc:        "should leave other content types alone",
+			contentType: "text/plain",
+			scripts:     []ScriptInjection{{Src: "/app.js"}},
+			resBody:     `<head></head>`,
+			expResBody:  `<head></head>`,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.InjectScripts = test.scripts
+
+			next := func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", test.contentType)
+				_, _ = w.Write([]byte(test.resBody))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if got := recorder.Body.String(); got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+		})
+	}
+}
+
+func TestInjectScriptsIntegrityFormat(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("alert(1)"))
+	}))
+	defer server.Close()
+
+	config := CreateConfig()
+	config.InjectScripts = []ScriptInjection{{Src: server.URL, ComputeIntegrity: true}}
+
+	sf, err := New(context.Background(), nil, config, "subfilter")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tag := string(sf.(*SubFilter).scriptTags)
+	if !regexp.MustCompile(` integrity="sha384-[A-Za-z0-9+/]{64}" crossorigin="anonymous">`).MatchString(tag) {
+		t.Errorf("got tag %q, want a sha384 integrity attribute", tag)
+	}
+}
+
+func TestInjectScriptsConfig(t *testing.T) {
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer broken.Close()
+
+	for i, scripts := range [][]ScriptInjection{
+		{{}},
+		{{Src: "/app.js", Integrity: "md5-abc"}},
+		{{Src: "/app.js", Integrity: "sha384"}},
+		{{Src: "/app.js", Integrity: "sha384-abc", ComputeIntegrity: true}},
+		{{Src: "/app.js", ComputeIntegrity: true}},
+		{{Src: broken.URL, ComputeIntegrity: true}},
+	} {
+		config := CreateConfig()
+		config.InjectScripts = scripts
+
+		if _, err := New(context.Background(), nil, config, "subfilter"); err == nil {
+			t.Errorf("config %d: expected error", i)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package subfilter
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// skipReason is why a response was not filtered.
+type skipReason int
+
+const (
+	skipExcluded skipReason = iota
+	skipEncoding
+	skipNoTransform
+	skipProcessed
+	skipAttachment
+	skipContentType
+	skipSize
+	skipHeader
+	skipByteRanges
+	skipNoFilters
+	skipBusy
+
+	skipReasons
+)
+
+var skipReasonNames = [skipReasons]string{
+	skipExcluded:    "request-excluded",
+	skipEncoding:    "unsupported-encoding",
+	skipNoTransform: "no-transform",
+	skipProcessed:   "already-processed",
+	skipAttachment:  "attachment",
+	skipContentType: "content-type",
+	skipSize:        "size-limit",
+	skipHeader:      "skip-header",
+	skipByteRanges:  "byteranges",
+	skipNoFilters:   "no-filters",
+	skipBusy:        "concurrency-limit",
+}
+
+func (r skipReason) String() string {
+	return skipReasonNames[r]
+}
+
+// skipLogInterval is the minimum time between two logs of the same reason.
+const skipLogInterval = 10 * time.Second
+
+// logSkip logs that the response to r is not filtered for reason, when
+// LogSkipReasons is set, at most once per skipLogInterval for each reason.
+// It returns false, for filterable to return it.
+func (s *SubFilter) logSkip(r *http.Request, reason skipReason) bool {
+	if !s.logSkips {
+		return false
+	}
+
+	now := time.Now().UnixNano()
+
+	last := atomic.LoadInt64(&s.lastSkipLogs[reason])
+	if last != 0 && now-last < int64(skipLogInterval) || !atomic.CompareAndSwapInt64(&s.lastSkipLogs[reason], last, now) {
+		return false
+	}
+
+	s.logResponse(logLevelDebug, r, "not filtering %s: %s", r.URL.Path, reason)
+
+	return false
+}
@@ -0,0 +1,180 @@
+package subfilter
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestLogSkipReasons(t *testing.T) {
+	var logs bytes.Buffer
+
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	tests := []struct {
+		desc      string
+		configure func(*Config)
+		reqHeader http.Header
+		header    http.Header
+		expReason string
+	}{
+		{
+			desc:      "should log excluded requests",
+			configure: func(c *Config) { c.SkipAuthenticated = true },
+			reqHeader: http.Header{"Authorization": {"Bearer x"}},
+			expReason: "request-excluded",
+		},
+		{
+			desc:      "should log unsupported encodings",
+			header:    http.Header{"Content-Encoding": {"br"}},
+			expReason: "unsupported-encoding",
+		},
+		{
+			desc:      "should log no-transform responses",
+			configure: func(c *Config) { c.HonorNoTransform = true },
+			header:    http.Header{"Cache-Control": {"no-transform"}},
+			expReason: "no-transform",
+		},
+		{
+			desc:      "should log processed responses",
+			configure: func(c *Config) { c.SkipIfAlreadyProcessed = true },
+			header:    http.Header{processedHeader: {"other"}},
+			expReason: "already-processed",
+		},
+		{
+			desc:      "should log attachments",
+			header:    http.Header{"Content-Disposition": {`attachment; filename="a.txt"`}},
+			expReason: "attachment",
+		},
+		{
+			desc:      "should log content types",
+			configure: func(c *Config) { c.TextTypesOnly = true },
+			header:    http.Header{"Content-Type": {"image/png"}},
+			expReason: "content-type",
+		},
+		{
+			desc:      "should log size guards",
+			configure: func(c *Config) { c.MaxBufferSize = 2 },
+			header:    http.Header{"Content-Length": {"3"}},
+			expReason: "size-limit",
+		},
+		{
+			desc:      "should log skip headers",
+			configure: func(c *Config) { c.SkipIfResponseHeaderPresent = []string{"X-Raw"} },
+			header:    http.Header{"X-Raw": {"1"}},
+			expReason: "skip-header",
+		},
+		{
+			desc:      "should log byte ranges",
+			header:    http.Header{"Content-Type": {"multipart/byteranges; boundary=x"}},
+			expReason: "byteranges",
+		},
+		{
+			desc: "should log responses without filters",
+			configure: func(c *Config) {
+				c.Filters = nil
+				c.Rules = []Rule{{Conditions: Conditions{Paths: []string{"^/other"}}, Filters: []Filter{{Regex: "foo"}}}}
+			},
+			expReason: "no-filters",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			logs.Reset()
+
+			config := CreateConfig()
+			config.Filters = []Filter{{Regex: "foo", Replacement: "bar"}}
+			config.LogSkipReasons = true
+
+			if test.configure != nil {
+				test.configure(config)
+			}
+
+			next := func(w http.ResponseWriter, _ *http.Request) {
+				for name, values := range test.header {
+					w.Header()[name] = values
+				}
+
+				_, _ = w.Write([]byte("foo"))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i := 0; i < 2; i++ {
+				req := httptest.NewRequest(http.MethodGet, "/page", nil)
+				for name, values := range test.reqHeader {
+					req.Header[name] = values
+				}
+
+				recorder := httptest.NewRecorder()
+				handler.ServeHTTP(recorder, req)
+
+				if got := recorder.Body.String(); got != "foo" {
+					t.Errorf("got body %q, want it unfiltered", got)
+				}
+			}
+
+			want := "not filtering /page: " + test.expReason + "\n"
+			if got := strings.Count(logs.String(), want); got != 1 {
+				t.Errorf("got logs %q, want %q once", logs.String(), want)
+			}
+		})
+	}
+
+	t.Run("should log the concurrency limit", func(t *testing.T) {
+		logs.Reset()
+
+		config := CreateConfig()
+		config.Filters = []Filter{{Regex: "foo", Replacement: "bar"}}
+		config.LogSkipReasons = true
+		config.MaxConcurrent = 1
+		config.ConcurrencyOverflow = "bypass"
+
+		started, release := make(chan struct{}), make(chan struct{})
+
+		next := func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/slow" {
+				w.WriteHeader(http.StatusOK)
+				close(started)
+				<-release
+			}
+
+			_, _ = w.Write([]byte("foo"))
+		}
+
+		handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var wg sync.WaitGroup
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/slow", nil))
+		}()
+
+		<-started
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/page", nil))
+		close(release)
+		wg.Wait()
+
+		if want := "not filtering /page: concurrency-limit\n"; !strings.Contains(logs.String(), want) {
+			t.Errorf("got logs %q, want %q", logs.String(), want)
+		}
+	})
+}
@@ -0,0 +1,68 @@
+package subfilter
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// sourceMapRegex matches the sourceMappingURL and sourceURL magic comments of
+// JavaScript and CSS, in both the //# and /*# ... */ forms (and the legacy @
+// marker), capturing the URL.
+var sourceMapRegex = regexp.MustCompile(`(?://|/\*)[#@][ \t]*source(?:Mapping)?URL=([^\s*]+)`)
+
+func (s *SubFilter) setupSourceMap(config *Config) error {
+	filters, err := compileFilters(config.SourceMapFilters)
+	if err != nil {
+		return fmt.Errorf("sourceMapFilters: %w", err)
+	}
+
+	if err := rejectHeaderTargets(filters); err != nil {
+		return fmt.Errorf("sourceMapFilters: %w", err)
+	}
+
+	if config.SourceMapHostMap {
+		if len(s.hostFilters) == 0 {
+			return errors.New("sourceMapHostMap requires hostMap")
+		}
+
+		filters = append(filters, s.hostFilters...)
+	}
+
+	s.sourceMapFilters = filters
+
+	return nil
+}
+
+// isSourceMapContentType reports whether contentType describes a JavaScript
+// or CSS file, which may reference a source map.
+func isSourceMapContentType(contentType string) bool {
+	return isJavaScriptContentType(contentType) || mediaType(contentType) == "text/css"
+}
+
+// rewriteSourceMaps applies the source map filters to the URL of every
+// sourceMappingURL and sourceURL comment in b. Inline data: source maps are
+// left alone.
+func (s *SubFilter) rewriteSourceMaps(b []byte, sc *scope) []byte {
+	matches := sourceMapRegex.FindAllSubmatchIndex(b, -1)
+	if matches == nil {
+		return b
+	}
+
+	out := make([]byte, 0, len(b))
+	last := 0
+
+	for _, m := range matches {
+		url := b[m[2]:m[3]]
+		if len(url) >= 5 && bytes.EqualFold(url[:5], []byte("data:")) {
+			continue
+		}
+
+		out = append(out, b[last:m[2]]...)
+		out = append(out, applyFilters(s.sourceMapFilters, url, sc)...)
+		last = m[3]
+	}
+
+	return append(out, b[last:]...)
+}
@@ -0,0 +1,101 @@
+package subfilter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSourceMapFilters(t *testing.T) {
+	tests := []struct {
+		desc        string
+		contentType string
+		hostMap     bool
+		resBody     string
+		expResBody  string
+	}{
+		{
+			desc:        "should rewrite a line comment in JavaScript",
+			contentType: "application/javascript",
+			resBody:     "var a = 1; // internal.corp\n//# sourceMappingURL=https://internal.corp/app.js.map",
+			expResBody:  "var a = 1; // internal.corp\n//# sourceMappingURL=https://cdn.example.com/app.js.map",
+		},
+		{
+			desc:        "should rewrite a block comment in CSS",
+			contentType: "text/css; charset=utf-8",
+			resBody:     "a{}\n/*# sourceMappingURL=//internal.corp/app.css.map */",
+			expResBody:  "a{}\n/*# sourceMappingURL=//cdn.example.com/app.css.map */",
+		},
+		{
+			desc:        "should rewrite sourceURL comments",
+			contentType: "text/javascript",
+			resBody:     "//# sourceURL=https://internal.corp/app.js",
+			expResBody:  "//# sourceURL=https://cdn.example.com/app.js",
+		},
+		{
+			desc:        "should skip data URIs",
+			contentType: "application/javascript",
+			resBody:     "//# sourceMappingURL=data:application/json;base64,aW50ZXJuYWwuY29ycA==",
+			expResBody:  "//# sourceMappingURL=data:application/json;base64,aW50ZXJuYWwuY29ycA==",
+		},
+		{
+			desc:        "should leave other content types alone",
+			contentType: "text/html",
+			resBody:     "//# sourceMappingURL=https://internal.corp/app.js.map",
+			expResBody:  "//# sourceMappingURL=https://internal.corp/app.js.map",
+		},
+		{
+			desc:        "should leave files without the comment alone",
+			contentType: "application/javascript",
+			resBody:     "fetch('https://internal.corp/api')",
+			expResBody:  "fetch('https://internal.corp/api')",
+		},
+		{
+			desc:        "should apply the host map",
+			contentType: "application/javascript",
+			hostMap:     true,
+			resBody:     "//# sourceMappingURL=https://assets.corp/app.js.map",
+			expResBody:  "//# sourceMappingURL=https://assets.example.com/app.js.map",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.SourceMapFilters = []Filter{{Regex: `internal\.corp`, Replacement: "cdn.example.com"}}
+			config.SourceMapHostMap = test.hostMap
+
+			if test.hostMap {
+				config.HostMap = map[string]string{"assets.corp": "assets.example.com"}
+			}
+
+			next := func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", test.contentType)
+				_, _ = w.Write([]byte(test.resBody))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if got := recorder.Body.String(); got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+		})
+	}
+}
+
+func TestSourceMapHostMapWithoutHostMap(t *testing.T) {
+	config := CreateConfig()
+	config.SourceMapHostMap = true
+
+	if _, err := New(context.Background(), nil, config, "subfilter"); err == nil {
+		t.Error("expected error for sourceMapHostMap without hostMap")
+	}
+}
@@ -0,0 +1,91 @@
+package subfilter
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	groupModeAppend  = "append"
+	groupModeReplace = "replace"
+)
+
+// statusGroup holds the filters configured for one StatusFilters key.
+type statusGroup struct {
+	key     string
+	status  statusRange
+	filters []filter
+}
+
+// compileStatusGroups compiles StatusFilters, ordered from the most specific
+// key (narrowest status range) to the least specific one. Keys of equal width
+// are ordered by their lowest status code, then alphabetically.
+func compileStatusGroups(groups map[string][]Filter) ([]statusGroup, error) {
+	compiled := make([]statusGroup, 0, len(groups))
+
+	for key, defs := range groups {
+		sr, err := parseStatusPattern(key)
+		if err != nil {
+			return nil, fmt.Errorf("statusFilters: %w", err)
+		}
+
+		filters, err := compileFilters(defs)
+		if err != nil {
+			return nil, fmt.Errorf("statusFilters %q: %w", key, err)
+		}
+
+		compiled = append(compiled, statusGroup{key: key, status: sr, filters: filters})
+	}
+
+	sort.Slice(compiled, func(i, j int) bool {
+		a, b := compiled[i], compiled[j]
+
+		if wa, wb := a.status.max-a.status.min, b.status.max-b.status.min; wa != wb {
+			return wa < wb
+		}
+
+		if a.status.min != b.status.min {
+			return a.status.min < b.status.min
+		}
+
+		return a.key < b.key
+	})
+
+	return compiled, nil
+}
+
+// parseGroupMode reports whether the filter groups configured by option
+// replace the top-level filters rather than being appended to them.
+func parseGroupMode(option, mode string) (bool, error) {
+	switch strings.ToLower(mode) {
+	case "", groupModeAppend:
+		return false, nil
+	case groupModeReplace:
+		return true, nil
+	default:
+		return false, fmt.Errorf("invalid %s %q: must be %q or %q", option, mode, groupModeAppend, groupModeReplace)
+	}
+}
+
+// statusFilters returns the filters of every group matching status.
+func statusFilters(groups []statusGroup, status int) []filter {
+	var filters []filter
+
+	for _, g := range groups {
+		if g.status.contains(status) {
+			filters = append(filters, g.filters...)
+		}
+	}
+
+	return filters
+}
+
+func countStatusFilters(groups []statusGroup) int {
+	n := 0
+	for _, g := range groups {
+		n += len(g.filters)
+	}
+
+	return n
+}
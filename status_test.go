@@ -0,0 +1,103 @@
+package subfilter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStatusFilters(t *testing.T) {
+	statusFilters := map[string][]Filter{
+		"2xx":     {{Regex: "page", Replacement: "content"}},
+		"5xx":     {{Regex: "help", Replacement: "support"}},
+		"503":     {{Regex: "help", Replacement: "status page"}},
+		"500-502": {{Regex: "help", Replacement: "oncall"}},
+	}
+
+	tests := []struct {
+		desc       string
+		mode       string
+		status     int
+		expResBody string
+	}{
+		{
+			desc:       "should apply the 2xx group after the default filters",
+			status:     http.StatusOK,
+			expResBody: "welcome to the content, see help",
+		},
+		{
+			desc:       "should apply the most specific matching group first",
+			status:     http.StatusServiceUnavailable,
+			expResBody: "welcome to the page, see status page",
+		},
+		{
+			desc:       "should apply a range group",
+			status:     http.StatusBadGateway,
+			expResBody: "welcome to the page, see oncall",
+		},
+		{
+			desc:       "should fall back to the class group",
+			status:     http.StatusGatewayTimeout,
+			expResBody: "welcome to the page, see support",
+		},
+		{
+			desc:       "should only apply the default filters without a matching group",
+			status:     http.StatusNotFound,
+			expResBody: "welcome to the page, see help",
+		},
+		{
+			desc:       "should replace the default filters in replace mode",
+			mode:       "replace",
+			status:     http.StatusServiceUnavailable,
+			expResBody: "hello to the page, see status page",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.Filters = []Filter{{Regex: "hello", Replacement: "welcome"}}
+			config.StatusFilters = statusFilters
+			config.StatusFiltersMode = test.mode
+
+			next := func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(test.status)
+				_, _ = w.Write([]byte("hello to the page, see help"))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if recorder.Code != test.status {
+				t.Errorf("got status %d, want %d", recorder.Code, test.status)
+			}
+
+			if got := recorder.Body.String(); got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+		})
+	}
+}
+
+func TestStatusFiltersInvalid(t *testing.T) {
+	config := CreateConfig()
+	config.StatusFilters = map[string][]Filter{"oops": {{Regex: "foo"}}}
+
+	if _, err := New(context.Background(), nil, config, "subfilter"); err == nil {
+		t.Fatal("expected error on invalid status key")
+	}
+
+	config.StatusFilters = map[string][]Filter{"5xx": {{Regex: "foo"}}}
+	config.StatusFiltersMode = "instead"
+
+	if _, err := New(context.Background(), nil, config, "subfilter"); err == nil {
+		t.Fatal("expected error on invalid status filters mode")
+	}
+}
@@ -0,0 +1,371 @@
+package subfilter
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultStreamOverlap is the longest match streaming is sure to find by
+// default.
+const defaultStreamOverlap = 4 << 10
+
+func (s *SubFilter) setupStream(config *Config) error {
+	if config.StreamOverlap < 0 {
+		return fmt.Errorf("invalid streamOverlap %d: must not be negative", config.StreamOverlap)
+	}
+
+	s.stream = config.Stream
+	s.streamOverlap = config.StreamOverlap
+
+	if s.streamOverlap == 0 {
+		s.streamOverlap = defaultStreamOverlap
+	}
+
+	if config.FlushInterval != "" {
+		interval, err := time.ParseDuration(config.FlushInterval)
+		if err != nil || interval < 0 {
+			return fmt.Errorf("invalid flushInterval %q", config.FlushInterval)
+		}
+
+		s.flushInterval = interval
+	}
+
+	if config.FlushBytes < 0 {
+		return fmt.Errorf("invalid flushBytes %d: must not be negative", config.FlushBytes)
+	}
+
+	s.flushBytes = config.FlushBytes
+
+	return nil
+}
+
+// canStream reports whether the response described by header, about to be
+// filtered with the filters selected for rw, can be filtered as it is
+// written. Compressed and multipart bodies, filters that need the whole body
+// and options that act on it once filtered are always buffered.
+func (s *SubFilter) canStream(rw *responseWriter, header http.Header) bool {
+	if !s.stream || rw.errorPage || rw.partFilters != nil || gzipLayers(header) > 0 {
+		return false
+	}
+
+	if len(s.options.Transformers) > 0 || s.verifier != nil || s.auditor != nil || s.skipUntilMarker != nil ||
+		s.setContentLength || s.cacheControlOnRewrite != "" || s.autoScope || s.transformWarning {
+		return false
+	}
+
+	ct := header.Get("Content-Type")
+
+	if s.xmlSafe && isXMLContentType(ct) || s.jsonp != nil && isJavaScriptContentType(ct) ||
+		(s.baseHref != "" || s.scriptTags != nil) && isHTMLContentType(ct) || len(s.sourceMapFilters) > 0 && isSourceMapContentType(ct) {
+		return false
+	}
+
+	for i := range rw.filters {
+		if !rw.filters[i].streamable() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// streamable reports whether the filter only ever looks at its matches, so
+// that it can be applied to a body piece by piece.
+func (f *filter) streamable() bool {
+	return f.regex != nil && f.within == nil && f.head == nil && f.rng == nil && f.bytes == nil && f.csv == nil &&
+		f.yaml == nil && f.action != actionDeleteLine && f.every <= 1 && f.onError == ""
+}
+
+// bodyStream filters the body of a response as the upstream writes it. The
+// last overlap bytes written are held back, along with any match of a filter
+// straddling them, until more of the body comes: a match is only sure to be
+// found when it is at most overlap bytes long.
+type bodyStream struct {
+	s       *SubFilter
+	rw      *responseWriter
+	r       *http.Request
+	sc      *scope
+	overlap int
+	start   time.Time
+
+	pending  []byte
+	started  bool
+	modified bool
+	// emptied holds the upstream bytes the filters emptied before anything
+	// was sent, to be sent unfiltered if they empty the whole body, as
+	// GuardEmptyOutput commands, and finishing tells the last piece.
+	emptied   []byte
+	finishing bool
+	// firstChunk is the length of the first write of the upstream, and
+	// emitted the number of bytes of the body filtered so far.
+	firstChunk int
+	emitted    int
+	// lastFlush is when the output was last flushed, and unflushed the
+	// number of bytes written since.
+	lastFlush time.Time
+	unflushed int
+
+	// mu guards the writes to the client against the flushes of flushTimer,
+	// which flushes the output once FlushInterval went by without the
+	// upstream writing, until the stream is done.
+	mu         sync.Mutex
+	flushTimer *time.Timer
+	done       bool
+}
+
+func (s *SubFilter) newBodyStream(rw *responseWriter, r *http.Request) *bodyStream {
+	sc := s.newScope(r)
+	sc.lang = s.requestLanguage(r)
+	sc.contentType = rw.Header().Get("Content-Type")
+	// The headers the stream was decided upon hold for all of its pieces,
+	// whatever the filters of headers or the upstream change afterwards.
+	sc.header = rw.Header().Clone()
+	sc.streamed = true
+
+	return &bodyStream{s: s, rw: rw, r: r, sc: sc, overlap: s.streamOverlap, start: time.Now(), lastFlush: s.now()}
+}
+
+func (bs *bodyStream) write(b []byte) (int, error) {
+	if bs.firstChunk == 0 && bs.emitted == 0 && len(bs.pending) == 0 {
+		bs.firstChunk = len(b)
+	}
+
+	bs.pending = append(bs.pending, b...)
+
+	if len(bs.pending) <= bs.overlap {
+		return len(b), nil
+	}
+
+	if bs.gzipped() {
+		return len(b), nil
+	}
+
+	cut := streamCut(bs.rw.filters, bs.pending, len(bs.pending)-bs.overlap, bs.sc)
+	if cut == 0 {
+		return len(b), nil
+	}
+
+	if err := bs.emit(bs.pending[:cut]); err != nil {
+		return 0, err
+	}
+
+	bs.pending = append(bs.pending[:0], bs.pending[cut:]...)
+
+	return len(b), nil
+}
+
+// gzipped reports whether the body turned out to be an unlabeled gzip stream,
+// in which case it is buffered from then on, to be decoded whole.
+func (bs *bodyStream) gzipped() bool {
+	if bs.started || !bs.s.sniffsEncoding(bs.sc.header) || !bytes.HasPrefix(bs.pending, gzipMagic) {
+		return false
+	}
+
+	bs.rw.stream = nil
+	bs.rw.buffer.Write(bs.pending)
+
+	return true
+}
+
+// streamCut returns the offset, at most cut, up to which b can be filtered on
+// its own: the start of the earliest match of a filter straddling cut.
+// Filters done with their maxMatches cannot match any more.
+func streamCut(filters []filter, b []byte, cut int, sc *scope) int {
+	for moved := true; moved && cut > 0; {
+		moved = false
+
+		for i := range filters {
+			if sc.matchesLeft(&filters[i]) == 0 {
+				continue
+			}
+
+			for _, m := range filters[i].regex.FindAllIndex(b, -1) {
+				if m[0] < cut && m[1] > cut {
+					cut, moved = m[0], true
+				}
+			}
+		}
+	}
+
+	return cut
+}
+
+// emit filters b and sends it, along with the headers before the first piece.
+func (bs *bodyStream) emit(b []byte) error {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	bs.sc.firstChunkLeft = bs.firstChunk - bs.emitted
+	bs.emitted += len(b)
+
+	filtered := applyFilters(bs.rw.filters, b, bs.sc)
+	if !bytes.Equal(filtered, b) {
+		bs.modified = true
+	}
+
+	if !bs.started && bs.s.guardEmptyOutput && len(filtered) == 0 {
+		bs.emptied = append(bs.emptied, b...)
+		if !bs.finishing {
+			return nil
+		}
+
+		if len(bs.emptied) > 0 {
+			bs.s.logResponse(logLevelWarn, bs.r, "filters emptied the %d-byte body of %s, sending it unfiltered",
+				len(bs.emptied), bs.r.URL.Path)
+
+			b, filtered = bs.emptied, bs.emptied
+			bs.modified = false
+		}
+	}
+
+	if !bs.started {
+		atomic.AddUint64(&bs.s.stats.Filtered, 1)
+		// The start of a body is in its first piece but for tiny
+		// streamOverlaps.
+		bs.s.guardSniffing(bs.rw.Header(), b, filtered)
+
+		bs.started = true
+		bs.sendHeader()
+	}
+
+	if _, err := bs.rw.ResponseWriter.Write(filtered); err != nil {
+		return fmt.Errorf("could not write response: %w", err)
+	}
+
+	bs.unflushed += len(filtered)
+
+	switch {
+	case bs.flushDue():
+		bs.flushLocked()
+	case bs.s.flushInterval > 0 && bs.flushTimer == nil && bs.unflushed > 0:
+		bs.flushTimer = time.AfterFunc(bs.s.flushInterval-bs.s.now().Sub(bs.lastFlush), bs.flushLate)
+	}
+
+	return nil
+}
+
+// flushDue reports whether the output is due to be flushed, as configured by
+// FlushInterval and FlushBytes.
+func (bs *bodyStream) flushDue() bool {
+	interval, size := bs.s.flushInterval, bs.s.flushBytes
+
+	return interval == 0 && size == 0 || interval > 0 && bs.s.now().Sub(bs.lastFlush) >= interval ||
+		size > 0 && bs.unflushed >= size
+}
+
+// sendHeader sends the status and headers, which cannot depend on the body
+// any more: the final length and digests are not known yet.
+func (bs *bodyStream) sendHeader() {
+	h := bs.rw.Header()
+
+	filterHeaders(bs.rw.filters, h, bs.sc)
+
+	h.Set(processedHeader, bs.s.name)
+	bs.s.preserveOriginalLength(h)
+	h.Del("Content-Length")
+	h.Del("Digest")
+	h.Del("Content-Digest")
+	h.Del("Repr-Digest")
+
+	if bs.s.stripAcceptRanges {
+		h.Del("Accept-Ranges")
+	}
+
+	bs.rw.applyHeaderEdits()
+	bs.s.declareFilterTrailer(h)
+
+	if bs.s.lastModified == lastModifiedRemove {
+		h.Del("Last-Modified")
+	}
+
+	bs.rw.ResponseWriter.WriteHeader(bs.rw.statusCode())
+}
+
+// finish filters and sends what is left of the body once the upstream is
+// done.
+func (bs *bodyStream) finish() {
+	bs.finishing = true
+
+	if len(bs.pending) > 0 || !bs.started {
+		if err := bs.emit(bs.pending); err != nil {
+			bs.stop()
+
+			return
+		}
+	}
+
+	bs.stop()
+
+	bs.s.setFilterTrailer(bs.rw.Header(), bs.sc)
+
+	if bs.modified {
+		atomic.AddUint64(&bs.s.stats.Modified, 1)
+	}
+
+	bs.s.reportRewrite(RewriteSummary{
+		Request:      bs.r,
+		Status:       bs.rw.statusCode(),
+		Modified:     bs.modified,
+		Replacements: bs.sc.matches,
+		Duration:     time.Since(bs.start),
+	})
+	bs.s.reportTrace(bs.r, bs.rw.filters, bs.sc)
+	bs.s.publishEvents(bs.r, bs.rw.filters, bs.sc)
+}
+
+// flush sends what was filtered so far to the client.
+func (bs *bodyStream) flush() {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	bs.flushLocked()
+}
+
+// flushLate flushes the output FlushInterval after it was written, when the
+// upstream wrote nothing since that flushed it.
+func (bs *bodyStream) flushLate() {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	bs.flushTimer = nil
+
+	if !bs.done && bs.unflushed > 0 {
+		bs.flushLocked()
+	}
+}
+
+// stop ends the flushes of flushTimer, once the client must not be written
+// to any more but by the handler.
+func (bs *bodyStream) stop() {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	bs.done = true
+
+	if bs.flushTimer != nil {
+		bs.flushTimer.Stop()
+		bs.flushTimer = nil
+	}
+}
+
+func (bs *bodyStream) flushLocked() {
+	if !bs.started || bs.done {
+		return
+	}
+
+	if bs.flushTimer != nil {
+		bs.flushTimer.Stop()
+		bs.flushTimer = nil
+	}
+
+	bs.lastFlush = bs.s.now()
+	bs.unflushed = 0
+
+	if f, ok := bs.rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
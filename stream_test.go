@@ -0,0 +1,417 @@
+package subfilter
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStream(t *testing.T) {
+	tests := []struct {
+		desc       string
+		regex      string
+		overlap    int
+		chunks     []string
+		expResBody string
+	}{
+		{
+			desc:       "should replace matches within a write",
+			regex:      "foo",
+			chunks:     []string{"foo bar foo", " baz"},
+			expResBody: "qux bar qux baz",
+		},
+		{
+			desc:       "should replace matches shorter than the overlap across writes",
+			regex:      "foobar",
+			overlap:    8,
+			chunks:     []string{"xxxxxxxxxxfoo", "barxxxx"},
+			expResBody: "xxxxxxxxxxquxxxxx",
+		},
+		{
+			desc:       "should replace complete matches longer than the overlap",
+			regex:      "abcdefghijkl",
+			overlap:    8,
+			chunks:     []string{"xxabcdefghijklxx", "xxxxxxxxxx"},
+			expResBody: "xxqux" + strings.Repeat("x", 12),
+		},
+		{
+			desc:       "should miss matches longer than the overlap across writes",
+			regex:      "abcdefghijkl",
+			overlap:    8,
+			chunks:     []string{"abcdefghij", "kl"},
+			expResBody: "abcdefghijkl",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.Filters = []Filter{{Regex: test.regex, Replacement: "qux"}}
+			config.Stream = true
+			config.StreamOverlap = test.overlap
+
+			next := func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Length", "1")
+
+				for _, chunk := range test.chunks {
+					_, _ = w.Write([]byte(chunk))
+				}
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if got := recorder.Body.String(); got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+
+			if got := recorder.Header().Get("Content-Length"); got != "" {
+				t.Errorf("got Content-Length %q, want none", got)
+			}
+		})
+	}
+}
+
+func TestStreamWritesEarly(t *testing.T) {
+	config := CreateConfig()
+	config.Filters = []Filter{{Regex: "foo", Replacement: "bar"}}
+	config.Stream = true
+	config.StreamOverlap = 4
+
+	recorder := httptest.NewRecorder()
+
+	next := func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("foo foo foo"))
+		w.(http.Flusher).Flush()
+
+		if got := recorder.Body.String(); got != "bar bar" || !recorder.Flushed {
+			t.Errorf("got body %q and flushed %t before the upstream was done", got, recorder.Flushed)
+		}
+
+		_, _ = w.Write([]byte(" foo"))
+	}
+
+	handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if got := recorder.Body.String(); got != "bar bar bar bar" {
+		t.Errorf("got body %q, want %q", got, "bar bar bar bar")
+	}
+}
+
+func TestStreamInsertOnce(t *testing.T) {
+	config := CreateConfig()
+	config.Filters = []Filter{{
+		Regex:       "</head>",
+		Replacement: `<script src="/x.js"></script>`,
+		Action:      "insertBefore",
+		MaxMatches:  1,
+	}}
+	config.Stream = true
+	config.StreamOverlap = 8
+
+	head := "<html><head><title>t</title>"
+	body := "<body><pre></head></pre>" + strings.Repeat("x", 32) + "</body></html>"
+
+	recorder := httptest.NewRecorder()
+
+	next := func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(head))
+		w.(http.Flusher).Flush()
+
+		if got := recorder.Body.String(); got != head[:len(head)-8] {
+			t.Errorf("got body %q before the marker, want %q", got, head[:len(head)-8])
+		}
+
+		// The marker straddles two writes.
+		_, _ = w.Write([]byte("</he"))
+		_, _ = w.Write([]byte("ad>" + body))
+		w.(http.Flusher).Flush()
+
+		if got := recorder.Body.String(); !strings.Contains(got, `<script src="/x.js"></script></head>`) {
+			t.Errorf("got body %q before the upstream was done, want the insert flushed", got)
+		}
+	}
+
+	handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	want := head + `<script src="/x.js"></script></head>` + body
+	if got := recorder.Body.String(); got != want {
+		t.Errorf("got body %q, want %q", got, want)
+	}
+}
+
+func TestStreamBuffersGzip(t *testing.T) {
+	body := strings.Repeat("foo ", 10)
+
+	for _, label := range []string{"gzip", ""} {
+		label := label
+		t.Run(label, func(t *testing.T) {
+			config := CreateConfig()
+			config.Filters = []Filter{{Regex: "foo", Replacement: "bar"}}
+			config.Stream = true
+			config.StreamOverlap = 4
+
+			next := func(w http.ResponseWriter, _ *http.Request) {
+				if label != "" {
+					w.Header().Set("Content-Encoding", label)
+				}
+
+				b := gzipString(t, body)
+				_, _ = w.Write(b[:10])
+				_, _ = w.Write(b[10:])
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			got := recorder.Body.String()
+			if label != "" {
+				got = gunzipString(t, recorder.Body.Bytes())
+			}
+
+			if expected := strings.Repeat("bar ", 10); got != expected {
+				t.Errorf("got body %q, want %q", got, expected)
+			}
+		})
+	}
+}
+
+func TestStreamGzipFile(t *testing.T) {
+	config := CreateConfig()
+	config.Filters = []Filter{{Regex: "foo", Replacement: "bar"}}
+	config.Stream = true
+	config.StreamOverlap = 4
+
+	b := gzipString(t, strings.Repeat("baz ", 10))
+	recorder := httptest.NewRecorder()
+
+	next := func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/gzip")
+		_, _ = w.Write(b[:10])
+		w.(http.Flusher).Flush()
+
+		if recorder.Body.Len() == 0 {
+			t.Error("got the gzip file buffered instead of streamed")
+		}
+
+		_, _ = w.Write(b[10:])
+	}
+
+	handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if !bytes.Equal(recorder.Body.Bytes(), b) {
+		t.Errorf("got body %q, want %q", recorder.Body.String(), b)
+	}
+}
+
+// flushRecorder records the length of the body written at every flush.
+type flushRecorder struct {
+	*httptest.ResponseRecorder
+	flushes []int
+}
+
+func (fr *flushRecorder) Flush() {
+	fr.flushes = append(fr.flushes, fr.Body.Len())
+	fr.ResponseRecorder.Flush()
+}
+
+func TestStreamFlush(t *testing.T) {
+	tests := []struct {
+		desc       string
+		interval   string
+		size       int
+		expFlushes []int
+	}{
+		{desc: "should flush every chunk by default", expFlushes: []int{6, 16, 26, 36, 46, 50}},
+		{desc: "should flush once enough bytes were written", size: 25, expFlushes: []int{26}},
+		{desc: "should flush once the interval went by", interval: "1s", expFlushes: []int{36}},
+		{desc: "should flush on either threshold", interval: "1s", size: 15, expFlushes: []int{16, 36}},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.Filters = []Filter{{Regex: "foo", Replacement: "bar"}}
+			config.Stream = true
+			config.StreamOverlap = 4
+			config.FlushInterval = test.interval
+			config.FlushBytes = test.size
+
+			now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+			opts := Options{Now: func() time.Time { return now }}
+
+			next := func(w http.ResponseWriter, _ *http.Request) {
+				for i := 0; i < 5; i++ {
+					_, _ = w.Write([]byte("xxxxxxxxxx"))
+					now = now.Add(400 * time.Millisecond)
+				}
+			}
+
+			handler, err := NewWithOptions(context.Background(), http.HandlerFunc(next), config, "subfilter", opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := &flushRecorder{ResponseRecorder: httptest.NewRecorder()}
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if got := recorder.Body.Len(); got != 50 {
+				t.Errorf("got %d bytes, want 50", got)
+			}
+
+			if !reflect.DeepEqual(recorder.flushes, test.expFlushes) {
+				t.Errorf("got flushes at %v bytes, want %v", recorder.flushes, test.expFlushes)
+			}
+		})
+	}
+}
+
+func TestStreamFlushStalled(t *testing.T) {
+	config := CreateConfig()
+	config.Filters = []Filter{{Regex: "foo", Replacement: "bar"}}
+	config.Stream = true
+	config.StreamOverlap = 4
+	config.FlushInterval = "20ms"
+
+	next := func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("foo xxxxxx"))
+		// The upstream stalls: what was filtered is flushed all the same.
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := &flushRecorder{ResponseRecorder: httptest.NewRecorder()}
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if got := recorder.Body.String(); got != "bar xxxxxx" {
+		t.Errorf("got body %q, want %q", got, "bar xxxxxx")
+	}
+
+	if exp := []int{6, 10}; !reflect.DeepEqual(recorder.flushes, exp) {
+		t.Errorf("got flushes at %v bytes, want %v", recorder.flushes, exp)
+	}
+}
+
+func TestStreamDecidesOnce(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("should filter every chunk of a streamed response", func(t *testing.T) {
+		config := CreateConfig()
+		config.Filters = []Filter{
+			{Regex: "foo", Replacement: "bar"},
+			{Regex: "when", Replacement: "${reltime:Last-Modified}", Transforms: true},
+		}
+		config.Stream = true
+		config.StreamOverlap = 4
+
+		next := func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("Last-Modified", now.Add(-2*time.Hour).Format(http.TimeFormat))
+			_, _ = w.Write([]byte("when foo xxxxxxxx"))
+
+			// None of this may change the decision made on the headers.
+			w.Header().Set("Content-Type", "image/png")
+			w.Header().Set("Content-Encoding", "br")
+			w.Header().Set("Last-Modified", now.Add(-5*time.Hour).Format(http.TimeFormat))
+			w.WriteHeader(http.StatusNotFound)
+
+			_, _ = w.Write([]byte("when foo xxxx"))
+			_, _ = w.Write([]byte(" when foo"))
+		}
+
+		handler, err := NewWithOptions(context.Background(), http.HandlerFunc(next), config, "subfilter",
+			Options{Now: func() time.Time { return now }})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		want := "2 hours ago bar xxxxxxxx2 hours ago bar xxxx 2 hours ago bar"
+		if got := recorder.Body.String(); got != want {
+			t.Errorf("got body %q, want %q", got, want)
+		}
+
+		if recorder.Code != http.StatusOK {
+			t.Errorf("got status %d, want %d", recorder.Code, http.StatusOK)
+		}
+	})
+
+	t.Run("should pass every chunk of a skipped response through", func(t *testing.T) {
+		config := CreateConfig()
+		config.Filters = []Filter{{Regex: "foo", Replacement: "bar"}}
+		config.Stream = true
+		config.StreamOverlap = 4
+		config.TextTypesOnly = true
+
+		next := func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Content-Type", "image/png")
+			_, _ = w.Write([]byte("foo xxxxxxxx"))
+
+			w.Header().Set("Content-Type", "text/plain")
+			_, _ = w.Write([]byte("foo xxxxxxxx"))
+		}
+
+		handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		if got := recorder.Body.String(); got != "foo xxxxxxxxfoo xxxxxxxx" {
+			t.Errorf("got body %q, want it unfiltered", got)
+		}
+	})
+}
+
+func TestStreamConfig(t *testing.T) {
+	for _, config := range []*Config{
+		{Filters: []Filter{{Regex: "foo"}}, Stream: true, StreamOverlap: -1},
+		{Filters: []Filter{{Regex: "foo"}}, Stream: true, FlushInterval: "soon"},
+		{Filters: []Filter{{Regex: "foo"}}, Stream: true, FlushInterval: "-1s"},
+		{Filters: []Filter{{Regex: "foo"}}, Stream: true, FlushBytes: -1},
+	} {
+		if _, err := New(context.Background(), nil, config, "subfilter"); err == nil {
+			t.Errorf("expected error for %+v", config)
+		}
+	}
+}
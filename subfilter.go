@@ -8,145 +8,1298 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
 	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 const contentEncodingGzip = "gzip"
 
-// Filter holds one Filter definition.
-type Filter struct {
-	Regex       string `json:"regex,omitempty"`
-	Replacement string `json:"replacement,omitempty"`
-}
+// processedHeader marks responses whose body a subfilter instance filtered.
+const processedHeader = "X-Subfilter-Processed"
+
+var errNoFilters = errors.New("no valid filters. disabling")
+
+// defaultMaxFilters caps the number of filters of a configuration when
+// MaxFilters is not set.
+const defaultMaxFilters = 1000
 
 // Config holds the plugin configuration.
 type Config struct {
-	LastModified bool     `json:"lastModified,omitempty"`
-	Filters      []Filter `json:"filters,omitempty"`
+	LastModified LastModifiedMode `json:"lastModified,omitempty"`
+	XMLSafe      bool             `json:"xmlSafe,omitempty"`
+	// JSONP filters only the string values of the JSON payload of JavaScript
+	// responses wrapped in a callback call, such as callback({...}), whose
+	// name matches the JSONPCallback regex (JavaScript identifiers and dotted
+	// paths by default).
+	JSONP         bool   `json:"jsonp,omitempty"`
+	JSONPCallback string `json:"jsonpCallback,omitempty"`
+	// SkipUntilMarker leaves everything up to and including the first
+	// occurrence of the marker untouched. Bodies without it are not filtered.
+	SkipUntilMarker string `json:"skipUntilMarker,omitempty"`
+	DigestMode      string `json:"digestMode,omitempty"`
+	// EmitContentDigest, "sha-256" or "sha-512", sets the RFC 9530
+	// Content-Digest of modified responses, and with EmitReprDigest their
+	// Repr-Digest, computed over the decoded body.
+	EmitContentDigest string `json:"emitContentDigest,omitempty"`
+	EmitReprDigest    bool   `json:"emitReprDigest,omitempty"`
+	// VerifyAbsent lists regexes that must not match the filtered body.
+	VerifyAbsent           []string `json:"verifyAbsent,omitempty"`
+	VerifyAction           string   `json:"verifyAction,omitempty"`
+	VerifyBlockStatus      int      `json:"verifyBlockStatus,omitempty"`
+	VerifySkipContentTypes []string `json:"verifySkipContentTypes,omitempty"`
+	// Cookies maps cookie names to regexes their whole value must match for the
+	// response to be filtered. Responses then vary with Cookie.
+	Cookies map[string]string `json:"cookies,omitempty"`
+	// HonorNoTransform skips filtering when either the request or the response
+	// carries Cache-Control: no-transform.
+	HonorNoTransform bool `json:"honorNoTransform,omitempty"`
+	// SkipAuthenticated passes responses to authenticated requests through
+	// untouched: requests carrying any of AuthHeaders (["Authorization"] by
+	// default) or AuthCookies.
+	SkipAuthenticated bool     `json:"skipAuthenticated,omitempty"`
+	AuthHeaders       []string `json:"authHeaders,omitempty"`
+	AuthCookies       []string `json:"authCookies,omitempty"`
+	// Accept lists media types, such as "text/html", one of which the request
+	// Accept header must accept for the response to be filtered. Wildcard
+	// ranges like "text/*" and "*/*" accept them unless StrictAccept is set.
+	// Requests without an Accept header are filtered. Responses then vary
+	// with Accept, filtered or not.
+	Accept       []string `json:"accept,omitempty"`
+	StrictAccept bool     `json:"strictAccept,omitempty"`
+	// UserAgents are regexes one of which the request User-Agent must match
+	// for the response to be filtered. Responses then vary with User-Agent.
+	UserAgents []string `json:"userAgents,omitempty"`
+
+	Filters []Filter `json:"filters,omitempty"`
+	// FiltersURL serves a JSON list of filters applied after Filters, fetched
+	// on startup within FiltersURLTimeout (5s by default) and then every
+	// FiltersURLRefresh, if set. OnFiltersURLError is "fail" (default) to
+	// refuse to start when they cannot be loaded, or "empty" to start without
+	// them.
+	FiltersURL        string `json:"filtersURL,omitempty"`
+	FiltersURLTimeout string `json:"filtersURLTimeout,omitempty"`
+	FiltersURLRefresh string `json:"filtersURLRefresh,omitempty"`
+	OnFiltersURLError string `json:"onFiltersURLError,omitempty"`
+	// Rules are applied after Filters, in order, to matching responses.
+	Rules           []Rule `json:"rules,omitempty"`
+	StopAtFirstRule bool   `json:"stopAtFirstRule,omitempty"`
+	// PathPrefixHeader names a request header, such as X-Forwarded-Prefix,
+	// holding a prefix stripped from the path before it reached the
+	// middleware. Rule paths are then matched against the prefix followed by
+	// the path, the externally visible one.
+	PathPrefixHeader string `json:"pathPrefixHeader,omitempty"`
+	// TrustForwardedFor has ${remoteaddr} expand to the last address of the
+	// X-Forwarded-For request header, rather than to the address of the
+	// peer, for deployments behind a trusted proxy that appends it. Earlier
+	// addresses are left alone, as clients can forge them.
+	TrustForwardedFor bool `json:"trustForwardedFor,omitempty"`
+	// StatusFilters maps status patterns ("404", "2xx", "500-599") to filters
+	// applied to responses with a matching status, after Filters or instead
+	// of them when StatusFiltersMode is "replace".
+	StatusFilters     map[string][]Filter `json:"statusFilters,omitempty"`
+	StatusFiltersMode string              `json:"statusFiltersMode,omitempty"`
+	// ContentTypeFilters maps media types ("text/html", "text/*") to filters
+	// applied to responses with a matching Content-Type, after the status
+	// filters. With ContentTypeFiltersMode "replace" they are used instead of
+	// Filters.
+	ContentTypeFilters     map[string][]Filter `json:"contentTypeFilters,omitempty"`
+	ContentTypeFiltersMode string              `json:"contentTypeFiltersMode,omitempty"`
+	// SkipIfAlreadyProcessed passes responses through that another subfilter
+	// instance, further down the middleware chain, already filtered.
+	SkipIfAlreadyProcessed bool `json:"skipIfAlreadyProcessed,omitempty"`
+	// SkipIfResponseHeaderPresent passes responses carrying any of the listed
+	// headers through, e.g. to only inject a tag the upstream did not set.
+	SkipIfResponseHeaderPresent []string `json:"skipIfResponseHeaderPresent,omitempty"`
+	// FilterAttachments filters responses with Content-Disposition:
+	// attachment, which are downloads and passed through by default.
+	FilterAttachments bool `json:"filterAttachments,omitempty"`
+	// ContentTypes restricts filtering to the responses of the listed media
+	// types, which may contain wildcards as in "text/*" or "*+json".
+	// TextTypesOnly restricts it to a default list of text-like types
+	// instead: text/*, JSON, JavaScript, XML, SVG and YAML.
+	ContentTypes  []string `json:"contentTypes,omitempty"`
+	TextTypesOnly bool     `json:"textTypesOnly,omitempty"`
+	// FilterMissingContentType filters the responses without a Content-Type
+	// despite ContentTypes or TextTypesOnly; they are passed through by
+	// default, as their body may be anything.
+	FilterMissingContentType bool `json:"filterMissingContentType,omitempty"`
+	// MultipartTypes lists the multipart media types, such as
+	// "multipart/mixed", whose parts are filtered one by one, each with the
+	// filters selected for its own headers.
+	MultipartTypes []string `json:"multipartTypes,omitempty"`
+	// OnUnknownEncoding decides what happens to responses with an unrecognized
+	// Content-Encoding: "skip" (the default) passes them through, "warn" also
+	// logs each distinct value once, and "identity" filters the body as if it
+	// was not encoded. Content-Encoding lists of several codings are always
+	// passed through.
+	OnUnknownEncoding string `json:"onUnknownEncoding,omitempty"`
+	// PassthroughEncodings are Content-Encoding values whose responses are
+	// always passed through, even those that could be decoded.
+	PassthroughEncodings []string `json:"passthroughEncodings,omitempty"`
+	// OnTransformError is what happens when a Transformer registered through
+	// Options fails: "skip" (the default) logs it and goes on without it,
+	// "passthrough" sends the upstream body unmodified and "fail" answers 502
+	// Bad Gateway.
+	OnTransformError string `json:"onTransformError,omitempty"`
+	// LogUnmatchedSample logs the first SampleBytes bytes (256 by default) of
+	// bodies no filter matched, at most once every ten seconds, to help tune
+	// filters.
+	LogUnmatchedSample bool `json:"logUnmatchedSample,omitempty"`
+	SampleBytes        int  `json:"sampleBytes,omitempty"`
+	// AuditFile, when set, receives a JSON line with the request metadata
+	// and the original and filtered bodies, each cut to AuditMaxBytes (64KiB
+	// by default), for AuditSamplePercent (all by default) of the filtered
+	// responses. Lines are written in the background and dropped rather than
+	// delaying responses when the file cannot keep up.
+	AuditFile          string  `json:"auditFile,omitempty"`
+	AuditSamplePercent float64 `json:"auditSamplePercent,omitempty"`
+	AuditMaxBytes      int     `json:"auditMaxBytes,omitempty"`
+	// DisableEncodingSniffing trusts the Content-Encoding header instead of
+	// checking the body for the gzip magic bytes.
+	DisableEncodingSniffing bool `json:"disableEncodingSniffing,omitempty"`
+	// MaxConcurrent caps how many bodies are filtered at the same time. Beyond
+	// it, responses wait up to ConcurrencyWait (1s by default) for a slot when
+	// ConcurrencyOverflow is "wait", the default, or right away when it is
+	// "bypass", and are passed through unfiltered if none frees up.
+	MaxConcurrent int `json:"maxConcurrent,omitempty"`
+	// MaxFilters caps the number of filters, 1000 by default, counting
+	// those of rules, groups and FiltersURL, so that a configuration cannot
+	// slow every response down with thousands of them.
+	MaxFilters          int    `json:"maxFilters,omitempty"`
+	ConcurrencyOverflow string `json:"concurrencyOverflow,omitempty"`
+	ConcurrencyWait     string `json:"concurrencyWait,omitempty"`
+	// MaxBufferSize caps, in bytes, the response bodies buffered for
+	// filtering; zero means no limit. Responses declaring a larger
+	// Content-Length are passed through, and those crossing it partway are
+	// handled as OnBufferLimit says: "passthrough", the default, sends the
+	// buffered prefix untouched and streams the rest, while "rewritePrefix"
+	// filters the prefix before sending it.
+	// SizeGuard groups both, as MaxSize and OnExceed, and cannot be combined
+	// with them.
+	MaxBufferSize int64      `json:"maxBufferSize,omitempty"`
+	OnBufferLimit string     `json:"onBufferLimit,omitempty"`
+	SizeGuard     *SizeGuard `json:"sizeGuard,omitempty"`
+	// Stream filters uncompressed bodies as the upstream writes them rather
+	// than once it is done, holding back the last StreamOverlap bytes (4096
+	// by default) in case a match straddles the next write. Matches longer
+	// than StreamOverlap may be missed. Responses that need the whole body,
+	// such as gzip or multipart ones, are still buffered.
+	Stream        bool `json:"stream,omitempty"`
+	StreamOverlap int  `json:"streamOverlap,omitempty"`
+	// FlushInterval and FlushBytes set how often streamed output is flushed
+	// to the client: once FlushInterval went by, or FlushBytes bytes were
+	// written, since the last flush, whether or not the upstream writes more.
+	// With neither set, every filtered chunk is flushed. Flushes of the
+	// upstream are passed on either way.
+	FlushInterval string `json:"flushInterval,omitempty"`
+	FlushBytes    int    `json:"flushBytes,omitempty"`
+	// HostMap rewrites hostnames, matched case-insensitively as whole
+	// hostnames, to the hostnames they map to. It runs with the top-level
+	// filters, after them.
+	HostMap map[string]string `json:"hostMap,omitempty"`
+	// RewriteURLs rewrite absolute upstream URLs to public ones, keeping
+	// what follows the base URL. They run with the top-level filters, after
+	// them and before HostMap.
+	RewriteURLs []URLRewrite `json:"rewriteURLs,omitempty"`
+	// SourceMapFilters rewrite the URLs of the sourceMappingURL and sourceURL
+	// comments of JavaScript and CSS responses, and nothing else.
+	// SourceMapHostMap applies HostMap to them too.
+	SourceMapFilters []Filter `json:"sourceMapFilters,omitempty"`
+	SourceMapHostMap bool     `json:"sourceMapHostMap,omitempty"`
+	// BaseHref sets, or inserts, the <base href> of HTML documents, for apps
+	// served under a path prefix.
+	BaseHref string `json:"baseHref,omitempty"`
+	// InjectScripts inserts external scripts at the end of the <head> of
+	// HTML documents, with their integrity hash if given or computed.
+	InjectScripts []ScriptInjection `json:"injectScripts,omitempty"`
+	// TokenizerFallback says what withinTags and textNodesOnly filters do
+	// with HTML they cannot tokenize, such as an unterminated tag or
+	// attribute value: "passthrough", the default, leaves the body to them
+	// untouched, and "regex" applies them to the whole body instead.
+	TokenizerFallback string `json:"tokenizerFallback,omitempty"`
+	// PreserveTrailingBytes keeps the bytes some upstreams append after the
+	// gzip stream of a response, writing them back after the re-encoded body.
+	// They are dropped by default.
+	PreserveTrailingBytes bool `json:"preserveTrailingBytes,omitempty"`
+	// PreserveGzipHeader, on by default, carries the name, comment, extra
+	// field, modification time and OS of the upstream gzip header over to
+	// the re-encoded body.
+	PreserveGzipHeader bool `json:"preserveGzipHeader,omitempty"`
+	// ReadBufferSize is the size of the buffers used to read request bodies
+	// and decompress bodies (32 KiB by default).
+	ReadBufferSize int `json:"readBufferSize,omitempty"`
+	// CacheControlOnRewrite replaces the Cache-Control header, and drops
+	// Expires, of responses whose body was changed by a replacement that
+	// depends on the request, such as a template using .Request, so that
+	// shared caches do not serve one requester's variant to everyone.
+	CacheControlOnRewrite string `json:"cacheControlOnRewrite,omitempty"`
+	// ResetAgeOnRewrite sets the Age header of responses whose body was
+	// changed to 0, and UpdateDateOnRewrite their Date header to the time of
+	// the rewrite, as the changed body is a new response to shared caches.
+	ResetAgeOnRewrite   bool `json:"resetAgeOnRewrite,omitempty"`
+	UpdateDateOnRewrite bool `json:"updateDateOnRewrite,omitempty"`
+	// AddTransformationWarning adds a Warning: 214 "Transformation Applied"
+	// header, with the middleware name as warn-agent, to responses whose body
+	// or headers the filters changed.
+	AddTransformationWarning bool `json:"addTransformationWarning,omitempty"`
+	// ErrorPage replaces the body of responses with matching statuses.
+	ErrorPage *ErrorPage `json:"errorPage,omitempty"`
+	// StripAcceptRangesOnModify drops the Accept-Ranges header of responses
+	// whose body was changed, since ranges of the upstream body do not
+	// apply to it.
+	StripAcceptRangesOnModify bool `json:"stripAcceptRangesOnModify,omitempty"`
+	// ForceFullResponse strips Range and If-Range from the requests whose
+	// response may be filtered, judging by the conditions of the rules and
+	// the extension of the path, so that the upstream sends the whole body,
+	// and Accept-Ranges from the responses that are filtered.
+	ForceFullResponse bool `json:"forceFullResponse,omitempty"`
+	// SetContentLength sends the length of filtered bodies in Content-Length
+	// instead of dropping the header and letting the server compute it or
+	// fall back to chunked encoding.
+	SetContentLength bool `json:"setContentLength,omitempty"`
+	// PreserveOriginalLengthHeader copies the Content-Length of the upstream
+	// to X-Original-Content-Length before it is dropped or replaced, for
+	// debugging compression ratios.
+	PreserveOriginalLengthHeader bool `json:"preserveOriginalLengthHeader,omitempty"`
+	// OnContentLengthMismatch is what to do with filtered responses whose
+	// body, as received and still encoded, does not have the length of their
+	// Content-Length: "log", the default, warns and filters what was
+	// received, "trust-body" does so silently and "fail" sends a 502.
+	OnContentLengthMismatch string `json:"onContentLengthMismatch,omitempty"`
+	// GuardEmptyOutput, on by default, sends the original body instead when
+	// filtering emptied a body that was not empty, which is almost always a
+	// broken filter. Streamed bodies are held back until the filters leave
+	// something of them.
+	GuardEmptyOutput bool `json:"guardEmptyOutput,omitempty"`
+	// Debug measures the time every filter spends on every filtered body and
+	// logs it.
+	Debug bool `json:"debug,omitempty"`
+	// LogSkipReasons logs why responses are not filtered, such as an
+	// unsupported encoding or Content-Type, at most once every 10 seconds
+	// for each reason.
+	LogSkipReasons bool `json:"logSkipReasons,omitempty"`
+	// LogFormat is the format of the logs about responses, debug ones
+	// included: "text", the default, or "json" for one JSON object per line.
+	LogFormat string `json:"logFormat,omitempty"`
+	// EmitServerTiming adds a Server-Timing entry, named after the middleware,
+	// with the time spent filtering to the responses that were filtered.
+	EmitServerTiming bool `json:"emitServerTiming,omitempty"`
+	// EmitFilterTrailer declares an X-Subfilter-Applied trailer on filtered
+	// responses and sets it, once the body is sent, to the number of filters
+	// that matched it. It cannot be combined with SetContentLength.
+	EmitFilterTrailer bool `json:"emitFilterTrailer,omitempty"`
+	// RecoverPanics recovers from the panics of the next handler on the
+	// requests whose response may be filtered, instead of letting them go up
+	// the chain: responses not sent yet get a 500 and those already started
+	// are aborted with http.ErrAbortHandler, with the panic logged. Panics
+	// with http.ErrAbortHandler itself are not recovered.
+	RecoverPanics bool `json:"recoverPanics,omitempty"`
+	// IndependentFilters matches regex filters all against the body as the
+	// upstream sent it and merges their replacements, those overlapping a
+	// replacement of an earlier filter being dropped and logged. Filters of
+	// other types then run in order over the result. By default every filter
+	// runs over the output of the previous ones instead, so that a filter can
+	// match what an earlier one wrote.
+	IndependentFilters bool `json:"independentFilters,omitempty"`
+	// DedupeInserts skips the insertions of insertBefore and insertAfter
+	// filters identical to one already made in the same response, so that
+	// two filters injecting the same script only inject it once.
+	DedupeInserts bool `json:"dedupeInserts,omitempty"`
+	// AutoScope restricts the filters without a scope of their own to the
+	// parts of the body that are safe to rewrite given its Content-Type: the
+	// url() URLs of CSS, the string literals of JavaScript and the text
+	// nodes of HTML. Bodies of other types are filtered whole.
+	AutoScope bool `json:"autoScope,omitempty"`
+	// PreserveEncodingCasing sends re-compressed bodies with the casing of
+	// the upstream Content-Encoding, such as GZIP, instead of gzip.
+	PreserveEncodingCasing bool `json:"preserveEncodingCasing,omitempty"`
+	// RemoveHeaders lists response headers to drop, with all their values,
+	// and AddHeaders response headers to set, replacing the upstream values
+	// unless AppendHeaders is set. They apply to every response, filtered or
+	// not.
+	RemoveHeaders []string          `json:"removeHeaders,omitempty"`
+	AddHeaders    map[string]string `json:"addHeaders,omitempty"`
+	AppendHeaders bool              `json:"appendHeaders,omitempty"`
+	// ContentTypeOptions is what happens to X-Content-Type-Options when the
+	// middleware sends another Content-Type than the upstream one, through
+	// AddHeaders or ErrorPage: "keep" (the default) leaves it as is, "nosniff"
+	// sets it to nosniff and "remove" drops it.
+	ContentTypeOptions string `json:"contentTypeOptions,omitempty"`
+	// NosniffOnLeadingChange sets X-Content-Type-Options: nosniff on
+	// responses whose filters change their start, up to the end of the first
+	// word or tag name browsers sniff the type of a body from, as inserts
+	// before <html> do.
+	NosniffOnLeadingChange bool `json:"nosniffOnLeadingChange,omitempty"`
+	// PipelineOrder sets the order in which the filter stages, and the inserts
+	// of BaseHref and InjectScripts, run. Stages left out run afterwards, in
+	// their default order.
+	PipelineOrder []string `json:"pipelineOrder,omitempty"`
+
+	// RequestFilters are applied to request bodies of at most
+	// RequestBodyMaxSize bytes (1 MiB by default) before calling the upstream.
+	// Gzip bodies are decoded for them and encoded again, or forwarded
+	// decoded, without Content-Encoding, with DecodeRequestBody.
+	RequestFilters     []Filter `json:"requestFilters,omitempty"`
+	RequestBodyMaxSize int64    `json:"requestBodyMaxSize,omitempty"`
+	DecodeRequestBody  bool     `json:"decodeRequestBody,omitempty"`
+	// RequestHeaderFilters rewrite request headers before calling the
+	// upstream.
+	RequestHeaderFilters []HeaderFilter `json:"requestHeaderFilters,omitempty"`
+	// QueryFilters rewrite the request query string before calling the
+	// upstream, either as a whole (QueryFiltersMode "raw", the default) or
+	// per decoded parameter name and value ("decoded").
+	QueryFilters     []Filter `json:"queryFilters,omitempty"`
+	QueryFiltersMode string   `json:"queryFiltersMode,omitempty"`
 }
 
 // CreateConfig creates and initializes the plugin configuration.
 func CreateConfig() *Config {
-	return &Config{}
+	return &Config{GuardEmptyOutput: true, PreserveGzipHeader: true}
 }
 
-type filter struct {
-	regex       *regexp.Regexp
-	replacement []byte
+// SubFilter is the middleware handler. New returns it as an opaque
+// http.Handler for Traefik; library users can call NewSubFilter to manage it
+// programmatically.
+type SubFilter struct {
+	stats      Stats
+	lastSample int64
+	// lastSkipLogs holds the time each skip reason was last logged at.
+	lastSkipLogs [skipReasons]int64
+
+	name          string
+	next          http.Handler
+	config        Config
+	lastModified  string
+	xmlSafe       bool
+	jsonp         *regexp.Regexp
+	digestMode    string
+	contentDigest string
+	reprDigest    bool
+	verifier      *verifier
+	auditor       *auditor
+	gate          *requestGate
+
+	skipUntilMarker []byte
+
+	stopAtFirstRule    bool
+	statusGroups       []statusGroup
+	replaceStatus      bool
+	typeGroups         []contentTypeGroup
+	replaceType        bool
+	pipeline           []string
+	skipProcessed      bool
+	skipHeaders        []string
+	contentTypeOptions string
+	onTransformError   string
+	languages          []string
+	filterAttachments  bool
+	multipartTypes     []string
+
+	onUnknownEncoding      string
+	passthroughEncodings   map[string]bool
+	warnedEncodings        warnOnce
+	sampleBytes            int
+	sniffEncoding          bool
+	preserveTrailingBytes  bool
+	preserveGzipHeader     bool
+	stripAcceptRanges      bool
+	forceFull              bool
+	transformWarning       bool
+	serverTiming           bool
+	filterTrailer          bool
+	decodeRequestBody      bool
+	independent            bool
+	dedupeInserts          bool
+	autoScope              bool
+	trustForwardedFor      bool
+	setContentLength       bool
+	keepOriginalLength     bool
+	nosniffLeading         bool
+	contentTypes           []string
+	filterMissingType      bool
+	recoverPanics          bool
+	onLengthMismatch       string
+	guardEmptyOutput       bool
+	debug                  bool
+	logSkips               bool
+	maxFilters             int
+	jsonLogs               bool
+	preserveEncodingCasing bool
+	cacheControlOnRewrite  string
+	resetAge               bool
+	updateDate             bool
+	limiter                *limiter
+	maxBufferSize          int64
+	onBufferLimit          string
+	hostFilters            []filter
+	tokenizerFallback      string
+	urlFilters             []filter
+	locationRewrites       []locationRewrite
+	sourceMapFilters       []filter
+	headerEdits            *headerEdits
+	errorPage              *errorPage
+	baseHref               string
+	scriptTags             []byte
+	readBufferSize         int
+
+	requestFilters       []filter
+	requestBodyMaxSize   int64
+	requestHeaderFilters []headerFilter
+	queryFilters         []filter
+	decodedQuery         bool
+
+	options Options
+
+	stream        bool
+	streamOverlap int
+	flushInterval time.Duration
+	flushBytes    int
+
+	filtersURL     string
+	filtersClient  *http.Client
+	filtersRefresh time.Duration
+
+	mu            sync.RWMutex
+	rules         []rule
+	remoteFilters []Filter
+	events        chan<- ReplacementEvent
 }
 
-type subfilter struct {
-	name         string
-	next         http.Handler
-	filters      []filter
-	lastModified bool
+// Stats holds the counters accumulated by a SubFilter since it was created.
+type Stats struct {
+	// Requests is the number of responses handled.
+	Requests uint64 `json:"requests"`
+	// Filtered is the number of responses whose body went through the filters.
+	Filtered uint64 `json:"filtered"`
+	// Modified is the number of responses whose body was changed.
+	Modified uint64 `json:"modified"`
+	// SampledOut is the number of times a filter was skipped on a response
+	// by its SamplePercent.
+	SampledOut uint64 `json:"sampledOut"`
+	// BufferLimited is the number of responses streamed once their body grew
+	// past MaxBufferSize.
+	BufferLimited uint64 `json:"bufferLimited"`
+	// DroppedEvents is the number of replacement events dropped as the
+	// channel registered with SetEvents was full.
+	DroppedEvents uint64 `json:"droppedEvents"`
 }
 
 // New creates and returns a new rewrite body plugin instance.
-func New(_ context.Context, next http.Handler, config *Config, name string) (http.Handler, error) {
-	filters := make([]filter, 0)
+func New(ctx context.Context, next http.Handler, config *Config, name string) (http.Handler, error) {
+	sf, err := NewSubFilter(ctx, next, config, name)
+	if err != nil {
+		// Return an untyped nil so callers comparing the handler to nil see
+		// it as missing.
+		return nil, err
+	}
 
-	for _, f := range config.Filters {
-		regex, err := regexp.Compile(f.Regex)
-		if err != nil {
-			log.Printf("error compiling regex %q: %v", f.Regex, err)
+	return sf, nil
+}
 
-			continue
-		}
+// NewSubFilter creates and returns a new SubFilter.
+func NewSubFilter(ctx context.Context, next http.Handler, config *Config, name string) (*SubFilter, error) {
+	return NewWithOptions(ctx, next, config, name, Options{})
+}
+
+// NewWithOptions creates and returns a new SubFilter with the given options.
+func NewWithOptions(ctx context.Context, next http.Handler, config *Config, name string, opts Options) (*SubFilter, error) {
+	sf := &SubFilter{
+		options:                opts,
+		name:                   name,
+		next:                   next,
+		config:                 *config,
+		xmlSafe:                config.XMLSafe,
+		stopAtFirstRule:        config.StopAtFirstRule,
+		skipProcessed:          config.SkipIfAlreadyProcessed,
+		skipHeaders:            config.SkipIfResponseHeaderPresent,
+		filterAttachments:      config.FilterAttachments,
+		multipartTypes:         config.MultipartTypes,
+		baseHref:               config.BaseHref,
+		sniffEncoding:          !config.DisableEncodingSniffing,
+		preserveTrailingBytes:  config.PreserveTrailingBytes,
+		preserveGzipHeader:     config.PreserveGzipHeader,
+		stripAcceptRanges:      config.StripAcceptRangesOnModify,
+		forceFull:              config.ForceFullResponse,
+		setContentLength:       config.SetContentLength,
+		keepOriginalLength:     config.PreserveOriginalLengthHeader,
+		recoverPanics:          config.RecoverPanics,
+		guardEmptyOutput:       config.GuardEmptyOutput,
+		debug:                  config.Debug,
+		logSkips:               config.LogSkipReasons,
+		preserveEncodingCasing: config.PreserveEncodingCasing,
+		cacheControlOnRewrite:  config.CacheControlOnRewrite,
+		resetAge:               config.ResetAgeOnRewrite,
+		updateDate:             config.UpdateDateOnRewrite,
+		transformWarning:       config.AddTransformationWarning,
+		serverTiming:           config.EmitServerTiming,
+		independent:            config.IndependentFilters,
+		dedupeInserts:          config.DedupeInserts,
+		autoScope:              config.AutoScope,
+		trustForwardedFor:      config.TrustForwardedFor,
+	}
+
+	sf.config.Filters = append([]Filter(nil), config.Filters...)
+	sf.config.Rules = append([]Rule(nil), config.Rules...)
 
-		newFilter := filter{
-			regex:       regex,
-			replacement: []byte(f.Replacement),
+	if config.SkipUntilMarker != "" {
+		sf.skipUntilMarker = []byte(config.SkipUntilMarker)
+	}
+
+	for _, setup := range []func(*Config) error{
+		sf.setupMaxFilters,
+		sf.setupFiltersURL,
+		sf.setupFilters,
+		sf.setupPathPrefixHeader,
+		sf.setupJSONP,
+		sf.setupLastModified,
+		sf.setupPipeline,
+		sf.setupEncoding,
+		sf.setupSample,
+		sf.setupLimiter,
+		sf.setupBufferLimit,
+		sf.setupStream,
+		sf.setupFilterTrailer,
+		sf.setupLengthMismatch,
+		sf.setupHostMap,
+		sf.setupURLRewrites,
+		sf.setupSourceMap,
+		sf.setupInjectScripts,
+		sf.setupResponseHeaders,
+		sf.setupContentTypeOptions,
+		sf.setupTokenizerFallback,
+		sf.setupTransformErrors,
+		sf.setupLogFormat,
+		sf.setupTextTypes,
+		sf.setupErrorPage,
+		sf.setupRequestFilters,
+		sf.setupRequestHeaderFilters,
+		sf.setupQueryFilters,
+		sf.setupDigest,
+		sf.setupVerifier,
+		sf.setupAudit,
+		sf.setupGate,
+	} {
+		if err := setup(config); err != nil {
+			return nil, err
 		}
+	}
 
-		filters = append(filters, newFilter)
+	// Filters served at FiltersURL may come later.
+	if sf.filterCount(sf.rules) == 0 && sf.filtersURL == "" {
+		return nil, errNoFilters
 	}
 
-	if len(filters) == 0 {
-		return nil, errors.New("no valid filters. disabling")
+	if err := sf.checkFilterCount(sf.rules); err != nil {
+		return nil, err
 	}
 
-	sf := &subfilter{
-		name:         name,
-		next:         next,
-		filters:      filters,
-		lastModified: config.LastModified,
+	if sf.filtersRefresh > 0 {
+		go sf.refreshFilters(ctx)
 	}
 
 	return sf, nil
 }
 
-func (s *subfilter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+// setupFilters compiles the top-level filters, rules and filter groups.
+func (s *SubFilter) setupFilters(config *Config) error {
+	var err error
+
+	filters := append(append([]Filter(nil), config.Filters...), s.remoteFilters...)
+	if s.rules, err = compileRules(filters, config.Rules); err != nil {
+		return err
+	}
+
+	s.languages = ruleLanguages(s.rules)
+
+	if s.statusGroups, err = compileStatusGroups(config.StatusFilters); err != nil {
+		return err
+	}
+
+	if s.replaceStatus, err = parseGroupMode("statusFiltersMode", config.StatusFiltersMode); err != nil {
+		return err
+	}
+
+	if s.typeGroups, err = compileContentTypeGroups(config.ContentTypeFilters); err != nil {
+		return err
+	}
+
+	s.replaceType, err = parseGroupMode("contentTypeFiltersMode", config.ContentTypeFiltersMode)
+
+	return err
+}
+
+// filterCount returns the number of filters configured, given rules.
+func (s *SubFilter) filterCount(rules []rule) int {
+	n := countFilters(rules) + countStatusFilters(s.statusGroups) + countContentTypeFilters(s.typeGroups) +
+		len(s.requestFilters) + len(s.requestHeaderFilters) + len(s.queryFilters) + len(s.hostFilters) +
+		len(s.urlFilters) + len(s.sourceMapFilters)
+
+	if s.baseHref != "" {
+		n++
+	}
+
+	if s.scriptTags != nil {
+		n++
+	}
+
+	if s.headerEdits != nil {
+		n++
+	}
+
+	if s.errorPage != nil {
+		n++
+	}
+
+	return n + len(s.options.Transformers)
+}
+
+func (s *SubFilter) setupMaxFilters(config *Config) error {
+	if config.MaxFilters < 0 {
+		return fmt.Errorf("invalid maxFilters %d: must not be negative", config.MaxFilters)
+	}
+
+	s.maxFilters = config.MaxFilters
+
+	if s.maxFilters == 0 {
+		s.maxFilters = defaultMaxFilters
+	}
+
+	return nil
+}
+
+// checkFilterCount rejects rules taking the filter count past maxFilters.
+func (s *SubFilter) checkFilterCount(rules []rule) error {
+	if n := s.filterCount(rules); n > s.maxFilters {
+		return fmt.Errorf("too many filters: %d, more than maxFilters %d", n, s.maxFilters)
+	}
+
+	return nil
+}
+
+// UpdateFilters atomically replaces the top-level filters applied to
+// subsequent responses; rules are left as they are. The current filters are
+// kept if the new ones fail to compile.
+func (s *SubFilter) UpdateFilters(filters []Filter) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	compiled, err := compileFilters(append(append([]Filter(nil), filters...), s.remoteFilters...))
+	if err != nil {
+		return err
+	}
+
+	rules := append([]rule{{filters: compiled}}, s.rules[1:]...)
+	if s.filterCount(rules) == 0 {
+		return errNoFilters
+	}
+
+	if err := s.checkFilterCount(rules); err != nil {
+		return err
+	}
+
+	s.rules = rules
+	s.config.Filters = append([]Filter(nil), filters...)
+
+	return nil
+}
+
+// EffectiveConfig returns a copy of the configuration currently in use,
+// including filters installed by UpdateFilters.
+func (s *SubFilter) EffectiveConfig() Config {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	config := s.config
+	config.Filters = append([]Filter(nil), s.config.Filters...)
+	config.Rules = append([]Rule(nil), s.config.Rules...)
+
+	return config
+}
+
+// Stats returns a snapshot of the counters accumulated so far.
+func (s *SubFilter) Stats() Stats {
+	return Stats{
+		Requests:      atomic.LoadUint64(&s.stats.Requests),
+		Filtered:      atomic.LoadUint64(&s.stats.Filtered),
+		Modified:      atomic.LoadUint64(&s.stats.Modified),
+		SampledOut:    atomic.LoadUint64(&s.stats.SampledOut),
+		BufferLimited: atomic.LoadUint64(&s.stats.BufferLimited),
+		DroppedEvents: atomic.LoadUint64(&s.stats.DroppedEvents),
+	}
+}
+
+func (s *SubFilter) activeRules() []rule {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.rules
+}
+
+func (s *SubFilter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	atomic.AddUint64(&s.stats.Requests, 1)
+
+	s.filterRequestHeaders(r)
+	s.filterQuery(r)
+	s.filterRequestBody(r)
+
+	if !s.gate.allow(r) {
+		s.logSkip(r, skipExcluded)
+		s.passThrough(w, r)
+
+		return
+	}
+
+	rules := s.activeRules()
+	s.forceFullResponse(r, rules)
+
+	rw := &responseWriter{
+		ResponseWriter:     w,
+		buffer:             &bytes.Buffer{},
+		headerEdits:        s.headerEdits,
+		locationRewrites:   s.locationRewrites,
+		contentTypeOptions: s.contentTypeOptions,
+		vary:               s.gate.vary,
+		bufferLimit:        s.maxBufferSize,
+	}
+
+	rw.overflow = func(b []byte) (int, error) {
+		return s.overflowBuffer(rw, r, b)
+	}
+
+	filterable := func(status int, header http.Header) bool {
+		if !s.acceptEncoding(header, r) || !supportedTransferCodings(header) {
+			return s.logSkip(r, skipEncoding)
+		}
+
+		if s.gate.honorNoTransform && hasCacheDirective(header, directiveNoTransform) {
+			return s.logSkip(r, skipNoTransform)
+		}
+
+		if _, processed := header[processedHeader]; processed && s.skipProcessed {
+			return s.logSkip(r, skipProcessed)
+		}
+
+		if !s.filterAttachments && isAttachment(header) {
+			return s.logSkip(r, skipAttachment)
+		}
+
+		if !s.filterableType(header.Get("Content-Type")) {
+			return s.logSkip(r, skipContentType)
+		}
+
+		if s.exceedsBufferLimit(header) {
+			return s.logSkip(r, skipSize)
+		}
+
+		if hasAnyHeader(header, s.skipHeaders) {
+			return s.logSkip(r, skipHeader)
+		}
+
+		if len(s.languages) > 0 {
+			// The filters selected depend on the language of the request.
+			addVary(header, "Accept-Language")
+		}
+
+		// The parts of a multipart/byteranges response are slices of the
+		// upstream representation and their Content-Range headers refer to
+		// its offsets, which no rewrite can preserve.
+		if mediaType(header.Get("Content-Type")) == "multipart/byteranges" {
+			return s.logSkip(r, skipByteRanges)
+		}
+
+		if ct := header.Get("Content-Type"); matchMediaType(s.multipartTypes, ct) {
+			if rw.boundary = multipartBoundary(ct); rw.boundary != "" {
+				rw.partFilters = func(h http.Header) []filter {
+					filters, _ := s.selectFilters(rules, r, status, h)
+
+					return filters
+				}
+
+				return true
+			}
+		}
+
+		rw.filters, rw.insertAt = s.selectFilters(rules, r, status, header)
+
+		ct := header.Get("Content-Type")
+
+		if len(rw.filters) > 0 || (s.baseHref != "" || s.scriptTags != nil) && isHTMLContentType(ct) ||
+			len(s.sourceMapFilters) > 0 && isSourceMapContentType(ct) || len(s.options.Transformers) > 0 {
+			return true
+		}
+
+		return s.logSkip(r, skipNoFilters)
+	}
+
+	acquired := false
+
+	defer func() {
+		if acquired {
+			s.limiter.release()
+		}
+	}()
+
+	defer func() {
+		// Once ServeHTTP is done, by returning or panicking, the flush timer
+		// of a stream must not write to the client any more.
+		if rw.stream != nil {
+			rw.stream.stop()
+		}
+	}()
+
+	rw.decide = func(status int, header http.Header) bool {
+		if s.errorPage.match(status) {
+			rw.errorPage, rw.errorPageKeep = true, s.errorPage.logBytes
+
+			return true
+		}
+
+		if !filterable(status, header) {
+			return false
+		}
+
+		if s.forceFull {
+			// Clients should not ask for ranges the upstream will not get.
+			header.Del("Accept-Ranges")
+		}
+
+		acquired = s.limiter.acquire(r.Context())
+		if !acquired {
+			return s.logSkip(r, skipBusy)
+		}
+
+		if s.canStream(rw, header) {
+			rw.stream = s.newBodyStream(rw, r)
+		}
+
+		return true
+	}
+
+	if !s.serveNext(rw, r) {
+		return
+	}
+
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+
+	if rw.passthrough {
+		return
+	}
+
+	if rw.stream != nil && !rw.stream.gzipped() {
+		rw.stream.finish()
+
+		return
+	}
+
+	if rw.errorPage {
+		s.writeErrorPage(rw, r)
+
+		return
+	}
+
+	s.rewrite(rw, r)
+}
+
+// passThrough serves r without filtering the response, only editing its
+// headers if configured to.
+func (s *SubFilter) passThrough(w http.ResponseWriter, r *http.Request) {
+	if s.headerEdits == nil && s.locationRewrites == nil && len(s.gate.vary) == 0 {
+		s.next.ServeHTTP(w, r)
+
+		return
+	}
+
 	rw := &responseWriter{
-		lastModified:   s.lastModified,
-		ResponseWriter: w,
-		buffer:         &bytes.Buffer{},
+		ResponseWriter:     w,
+		headerEdits:        s.headerEdits,
+		locationRewrites:   s.locationRewrites,
+		contentTypeOptions: s.contentTypeOptions,
+		vary:               s.gate.vary,
+		decide:             func(int, http.Header) bool { return false },
 	}
 
 	s.next.ServeHTTP(rw, r)
 
-	ce := rw.Header().Get("Content-Encoding")
-	b := rw.buffer.Bytes()
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+}
+
+// rewrite filters the buffered body of rw and sends the response.
+func (s *SubFilter) rewrite(rw *responseWriter, r *http.Request) {
+	atomic.AddUint64(&s.stats.Filtered, 1)
+
+	start := time.Now()
+
+	if !s.checkContentLength(rw, r) {
+		return
+	}
+
+	original, err := s.decodeBody(rw, r)
+	if err != nil {
+		log.Printf("unable to decode response: %v", err)
+		s.writeResponse(rw, rw.buffer.Bytes())
+
+		return
+	}
+
+	sc := s.newScope(r)
+	sc.lang = s.requestLanguage(r)
+	sc.header = rw.Header()
+	sc.timing = s.debug
+
+	b, err := s.runTransformers(BeforeFilters, original, rw, r)
+	if err != nil {
+		s.writeTransformError(rw, r, err)
+
+		return
+	}
+
+	headersModified := false
+
+	if rw.partFilters != nil {
+		b = s.filterMultipart(rw.partFilters, b, rw.boundary, sc)
+	} else {
+		b = s.filterBody(rw.filters[:rw.insertAt], b, rw.Header().Get("Content-Type"), sc)
+
+		if s.baseHref != "" && isHTMLContentType(rw.Header().Get("Content-Type")) {
+			b = s.injectBaseHref(b)
+		}
+
+		if s.scriptTags != nil && isHTMLContentType(rw.Header().Get("Content-Type")) {
+			b = s.injectScripts(b)
+		}
+
+		if rw.insertAt < len(rw.filters) {
+			b = s.filterBody(rw.filters[rw.insertAt:], b, rw.Header().Get("Content-Type"), sc)
+		}
 
-	if ce != "" && ce != "identity" && ce != contentEncodingGzip {
-		if _, err := w.Write(b); err != nil {
-			log.Printf("unable to write response: %v", err)
+		if len(s.sourceMapFilters) > 0 && isSourceMapContentType(rw.Header().Get("Content-Type")) {
+			b = s.rewriteSourceMaps(b, sc)
 		}
 
+		headersModified = filterHeaders(rw.filters, rw.Header(), sc)
+	}
+
+	if sc.aborted != nil {
+		s.logResponse(logLevelError, r, "aborting %s: a replacement failed: %v", r.URL.Path, sc.aborted)
+		writeStatus(rw, http.StatusBadGateway)
+
 		return
 	}
 
-	for _, f := range s.filters {
-		b = f.regex.ReplaceAll(b, f.replacement)
+	if b, err = s.runTransformers(AfterFilters, b, rw, r); err != nil {
+		s.writeTransformError(rw, r, err)
+
+		return
 	}
-	// fmt.Printf("Regexed Page: %v\n", string(b))
-	if ce == "gzip" {
-		// fmt.Printf("Gzipping regexed page: %s\n", string(b))
-		var buf bytes.Buffer
-		gz := gzip.NewWriter(&buf)
 
-		_, err := gz.Write(b)
-		if err != nil {
-			log.Printf("unable to write gzipped modified response: %v", err)
+	if s.guardEmptyOutput && len(b) == 0 && len(original) > 0 {
+		s.logResponse(logLevelWarn, r, "filters emptied the %d-byte body of %s, sending it unfiltered", len(original), r.URL.Path)
+
+		b = original
+	}
+
+	atomic.AddUint64(&s.stats.SampledOut, uint64(sc.sampledOut))
+
+	modified := !bytes.Equal(original, b)
+	if modified {
+		atomic.AddUint64(&s.stats.Modified, 1)
+		s.guardSniffing(rw.Header(), original, b)
+	} else if sc.sampledOut == 0 {
+		// A body left alone because its filters were sampled out was skipped,
+		// not missed by them.
+		s.sampleUnmatched(r, original)
+	}
+
+	s.reportRewrite(RewriteSummary{
+		Request:         r,
+		Status:          rw.statusCode(),
+		Modified:        modified,
+		Replacements:    sc.matches,
+		Duration:        time.Since(start),
+		FilterDurations: sc.durations,
+	})
+	s.reportTrace(r, rw.filters, sc)
+	s.publishEvents(r, rw.filters, sc)
+
+	if s.debug {
+		s.logDurations(r, sc.durations, len(original))
+	}
+
+	if sc.conflicts > 0 {
+		s.logResponse(logLevelWarn, r, "dropped %d replacements of %s overlapping those of earlier filters", sc.conflicts, r.URL.Path)
+	}
+
+	s.auditor.record(r, rw.statusCode(), original, b, modified)
+
+	if pattern, found := s.verifier.violation(b, rw.Header().Get("Content-Type")); found {
+		s.logResponse(logLevelWarn, r, "filtered body of %s still matches verifyAbsent pattern %q", r.URL.Path, pattern)
+
+		if s.verifier.block {
+			s.verifier.writeBlocked(rw)
 
 			return
 		}
+	}
+
+	rw.Header().Set(processedHeader, s.name)
+
+	if rw.gzipLayers > 0 {
+		// The coding sent back depends on the Accept-Encoding of the request.
+		addVary(rw.Header(), "Accept-Encoding")
+
+		if !acceptsGzip(r) {
+			// Clients refusing gzip get the body unencoded, without the
+			// bytes that followed the gzip stream.
+			rw.gzipLayers = 0
+			rw.trailing = nil
+			rw.Header().Del("Content-Encoding")
+			rw.Header().Del("Transfer-Encoding")
+		}
+	}
+
+	// The upstream bytes, digests included, still hold for a body left alone
+	// and sent with the single gzip Content-Encoding it came with.
+	raw, decoded := rw.buffer.Bytes(), b
+	keepRaw := !modified && rw.gzipLayers == 1 && len(contentCodings(rw.Header())) == 1
+
+	if rw.gzipLayers > 0 && !keepRaw {
+		// Whichever header the upstream used, the body is sent back with a
+		// single gzip Content-Encoding, which clients universally understand.
+		ce := contentEncodingGzip
+		if original := rw.Header().Get("Content-Encoding"); s.preserveEncodingCasing && strings.EqualFold(original, ce) {
+			ce = original
+		}
 
-		if err = gz.Close(); err != nil {
-			log.Printf("unable to close gzip writer: %v", err)
+		rw.Header().Set("Content-Encoding", ce)
+		rw.Header().Del("Transfer-Encoding")
+
+		b, err = gzipEncodeHeader(b, rw.gzipHeader)
+		if err != nil {
+			log.Printf("unable to encode modified response: %v", err)
+			s.writeResponse(rw, nil)
 
 			return
 		}
+	}
 
-		b = buf.Bytes()
+	if keepRaw {
+		b = raw
+	} else {
+		b = append(b, rw.trailing...)
 	}
 
-	// log.Printf("regexed page Gzipped: %s\n", b)
-	if _, err := w.Write(b); err != nil {
-		log.Printf("unable to write modified response: %v", err)
+	if !modified && !bytes.Equal(b, raw) {
+		// Re-encoding alone changed the bytes sent.
+		s.updateReencodedDigest(rw.Header(), b, decoded)
 	}
+
+	if modified {
+		s.updateDigest(rw.Header(), b)
+		s.emitContentDigest(rw.Header(), b, decoded)
+		s.updateLastModified(rw.Header())
+		s.updateAgeDate(rw.Header(), sc)
+
+		if s.stripAcceptRanges {
+			rw.Header().Del("Accept-Ranges")
+		}
+
+		if s.cacheControlOnRewrite != "" && sc.requestDependent {
+			rw.Header().Set("Cache-Control", s.cacheControlOnRewrite)
+			rw.Header().Del("Expires")
+		}
+	}
+
+	if s.transformWarning && (modified || headersModified) {
+		addTransformationWarning(rw.Header(), s.name)
+	}
+
+	if s.serverTiming {
+		addServerTiming(rw.Header(), s.name, time.Since(start))
+	}
+
+	s.declareFilterTrailer(rw.Header())
+	s.writeResponse(rw, b)
+	s.setFilterTrailer(rw.Header(), sc)
+}
+
+// writeResponse flushes the buffered status and headers followed by b to the
+// underlying http.ResponseWriter.
+func (s *SubFilter) writeResponse(rw *responseWriter, b []byte) {
+	rw.applyHeaderEdits()
+
+	if s.lastModified == lastModifiedRemove {
+		rw.Header().Del("Last-Modified")
+	}
+
+	s.preserveOriginalLength(rw.Header())
+
+	// The upstream Content-Length, which may not even have matched the body
+	// it sent, was ignored as the body was buffered until its end. A chunked
+	// Transfer-Encoding would contradict the length sent in its place; without
+	// one, the server picks the framing itself.
+	if s.setContentLength {
+		rw.Header().Set("Content-Length", strconv.Itoa(len(b)))
+		rw.Header().Del("Transfer-Encoding")
+	} else {
+		rw.Header().Del("Content-Length")
+	}
+
+	rw.ResponseWriter.WriteHeader(rw.statusCode())
+
+	if _, err := rw.ResponseWriter.Write(b); err != nil {
+		log.Printf("unable to write response: %v", err)
+	}
+}
+
+// filterBody returns the filtered version of the decoded body b.
+func (s *SubFilter) filterBody(filters []filter, b []byte, contentType string, sc *scope) []byte {
+	sc.contentType = contentType
+
+	var head []byte
+
+	if s.skipUntilMarker != nil {
+		i := bytes.Index(b, s.skipUntilMarker)
+		if i < 0 {
+			return b
+		}
+
+		i += len(s.skipUntilMarker)
+		head, b = b[:i], b[i:]
+	}
+
+	apply := func(text []byte) []byte {
+		return applyFilters(filters, text, sc)
+	}
+
+	switch {
+	case s.xmlSafe && isXMLContentType(contentType):
+		b = filterXMLText(b, apply)
+	case s.jsonp != nil && isJavaScriptContentType(contentType):
+		var ok bool
+		if b, ok = filterJSONP(s.jsonp, b, apply); !ok {
+			b = apply(b)
+		}
+	default:
+		b = apply(b)
+	}
+
+	if head == nil {
+		return b
+	}
+
+	out := make([]byte, 0, len(head)+len(b))
+	out = append(out, head...)
+
+	return append(out, b...)
 }
 
 type responseWriter struct {
-	lastModified bool
-	wroteHeader  bool
-	buffer       *bytes.Buffer
+	wroteHeader bool
+	status      int
+	buffer      *bytes.Buffer
+
+	// decide is called once the status and headers are known and reports
+	// whether the response should be filtered. If not, the response is passed
+	// through untouched. Either way the decision holds for the whole body,
+	// streamed or not, whatever the upstream does to the headers afterwards.
+	decide      func(status int, header http.Header) bool
+	passthrough bool
+	filters     []filter
+	// insertAt is the number of filters run before the inserts stage.
+	insertAt   int
+	gzipLayers int
+	// trailing holds the bytes that followed the gzip stream of the body,
+	// when they are preserved.
+	trailing []byte
+	// gzipHeader is the header of the upstream gzip stream, when preserved.
+	gzipHeader *gzip.Header
+
+	// partFilters selects the filters of each part of a multipart response
+	// delimited by boundary.
+	partFilters func(header http.Header) []filter
+	boundary    string
+
+	// headerEdits are applied to the headers right before they are sent,
+	// after the Location header is rewritten with locationRewrites and vary
+	// added to the Vary header.
+	headerEdits      *headerEdits
+	locationRewrites []locationRewrite
+	vary             []string
+	// contentTypeOptions is how X-Content-Type-Options is adjusted when the
+	// Content-Type sent is not upstreamType.
+	contentTypeOptions string
+	upstreamType       string
+
+	// errorPage replaces the upstream body, of which only the first
+	// errorPageKeep bytes are kept for logging.
+	errorPage     bool
+	errorPageKeep int
+
+	// bufferLimit, when positive, is the size beyond which the body is no
+	// longer buffered: overflow is called with the write that crosses it.
+	bufferLimit int64
+	overflow    func(b []byte) (int, error)
+
+	// stream, when set, filters the body as it is written instead.
+	stream *bodyStream
 
 	http.ResponseWriter
 }
 
+// WriteHeader records the status code. Responses that are filtered have their
+// headers sent once the body has been rewritten, so they can still be adjusted
+// to match it; the others are sent right away.
 func (r *responseWriter) WriteHeader(status int) {
-	if !r.lastModified {
-		r.Header().Del("Last-Modified")
+	if r.wroteHeader {
+		return
 	}
 
 	r.wroteHeader = true
-	r.Header().Del("Content-Length")
-	r.ResponseWriter.WriteHeader(status)
+	r.status = status
+	r.upstreamType = r.Header().Get("Content-Type")
+
+	if !r.decide(status, r.Header()) {
+		r.passthrough = true
+		r.applyHeaderEdits()
+		r.ResponseWriter.WriteHeader(status)
+
+		return
+	}
+
+	r.gzipLayers = gzipLayers(r.Header())
+}
+
+// applyHeaderEdits rewrites the Location header, adds the request headers the
+// gate decides upon to Vary and edits the headers as configured, then adjusts
+// X-Content-Type-Options if that changed the Content-Type.
+func (r *responseWriter) applyHeaderEdits() {
+	rewriteLocation(r.Header(), r.locationRewrites)
+
+	for _, name := range r.vary {
+		addVary(r.Header(), name)
+	}
+
+	r.headerEdits.apply(r.Header())
+	fixContentTypeOptions(r.Header(), r.contentTypeOptions, r.upstreamType)
+}
+
+func (r *responseWriter) statusCode() int {
+	if !r.wroteHeader {
+		return http.StatusOK
+	}
+
+	return r.status
 }
 
 func (r *responseWriter) Write(b []byte) (int, error) {
@@ -154,29 +1307,28 @@ func (r *responseWriter) Write(b []byte) (int, error) {
 		r.WriteHeader(http.StatusOK)
 	}
 
-	if r.Header().Get("Content-Encoding") == "gzip" {
-		// fmt.Printf("Received GZIP encoded page: %s\n", b)
-		gr, err := gzip.NewReader(bytes.NewReader(b))
-		if err != nil {
-			return 0, fmt.Errorf("unable to create gzip reader: %w", err)
-		}
+	if r.passthrough {
+		return r.ResponseWriter.Write(b)
+	}
 
-		var cleanBytes []byte
+	if r.errorPage {
+		if keep := r.errorPageKeep - r.buffer.Len(); keep > 0 {
+			if keep > len(b) {
+				keep = len(b)
+			}
 
-		cleanBytes, err = ioutil.ReadAll(gr)
-		if err != nil {
-			return 0, fmt.Errorf("unable to read gzipped response: %w", err)
+			r.buffer.Write(b[:keep])
 		}
-		// fmt.Printf("Decoded page: %s\n", cleanBytes)
 
-		var i int
+		return len(b), nil
+	}
 
-		i, err = r.buffer.Write(cleanBytes)
-		if err != nil {
-			return i, fmt.Errorf("could not write buffer: %w", err)
-		}
+	if r.stream != nil {
+		return r.stream.write(b)
+	}
 
-		return i, nil
+	if r.bufferLimit > 0 && int64(r.buffer.Len()+len(b)) > r.bufferLimit {
+		return r.overflow(b)
 	}
 
 	i, err := r.buffer.Write(b)
@@ -201,7 +1353,21 @@ func (r *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	return c, w, nil
 }
 
+// Flush is a no-op for filtered responses: the body is buffered until the
+// upstream handler returns, and flushing the underlying writer early would
+// commit the headers before they have been adjusted. Streamed responses flush
+// what was filtered so far.
 func (r *responseWriter) Flush() {
+	if r.stream != nil {
+		r.stream.flush()
+
+		return
+	}
+
+	if !r.passthrough {
+		return
+	}
+
 	if f, ok := r.ResponseWriter.(http.Flusher); ok {
 		f.Flush()
 	}
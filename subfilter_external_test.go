@@ -0,0 +1,106 @@
+package subfilter_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/DirtyCajunRice/subfilter"
+	"github.com/DirtyCajunRice/subfilter/subfiltertest"
+)
+
+func TestAttachmentPassthrough(t *testing.T) {
+	tests := []struct {
+		desc              string
+		disposition       string
+		filterAttachments bool
+		expResBody        string
+	}{
+		{
+			desc:        "should pass an attachment through",
+			disposition: `attachment; filename="report.csv"`,
+			expResBody:  "foo",
+		},
+		{
+			desc:        "should match the disposition type case-insensitively",
+			disposition: "Attachment",
+			expResBody:  "foo",
+		},
+		{
+			desc:        "should pass an attachment through despite a malformed parameter",
+			disposition: "attachment; filename=a b.csv",
+			expResBody:  "foo",
+		},
+		{
+			desc:        "should filter an inline response",
+			disposition: "inline",
+			expResBody:  "bar",
+		},
+		{
+			desc:              "should filter an attachment with filterAttachments",
+			disposition:       "attachment",
+			filterAttachments: true,
+			expResBody:        "bar",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := subfilter.CreateConfig()
+			config.Filters = []subfilter.Filter{{Regex: "foo", Replacement: "bar"}}
+			config.FilterAttachments = test.filterAttachments
+
+			res := subfiltertest.Run(t, config, "foo", http.Header{
+				"Content-Type":        {"text/csv"},
+				"Content-Disposition": {test.disposition},
+			})
+
+			if res.Body != test.expResBody {
+				t.Errorf("got body %q, want %q", res.Body, test.expResBody)
+			}
+		})
+	}
+}
+
+func TestSkipIfResponseHeaderPresent(t *testing.T) {
+	tests := []struct {
+		desc       string
+		header     string
+		expResBody string
+	}{
+		{
+			desc:       "should pass a response with the header through",
+			header:     "Link",
+			expResBody: "<head></head>",
+		},
+		{
+			desc:       "should match the header name case-insensitively",
+			header:     "link",
+			expResBody: "<head></head>",
+		},
+		{
+			desc:       "should filter a response without the header",
+			expResBody: `<head><link rel="canonical"></head>`,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := subfilter.CreateConfig()
+			config.Filters = []subfilter.Filter{{Regex: "<head>", Replacement: `<link rel="canonical">`, Action: "insertAfter"}}
+			config.SkipIfResponseHeaderPresent = []string{"LINK"}
+
+			header := http.Header{"Content-Type": {"text/html"}}
+			if test.header != "" {
+				header.Set(test.header, "<https://example.com/>; rel=canonical")
+			}
+
+			res := subfiltertest.Run(t, config, "<head></head>", header)
+
+			if res.Body != test.expResBody {
+				t.Errorf("got body %q, want %q", res.Body, test.expResBody)
+			}
+		})
+	}
+}
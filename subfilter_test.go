@@ -6,9 +6,12 @@ import (
 	"context"
 	"fmt"
 	"io/ioutil"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"net/textproto"
 	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -18,10 +21,11 @@ func TestServeHTTP(t *testing.T) {
 		desc            string
 		contentEncoding string
 		filters         []Filter
-		lastModified    bool
+		lastModified    LastModifiedMode
 		resBody         string
 		expResBody      string
 		expLastModified bool
+		expPassthrough  bool
 	}{
 		{
 			desc: "should replace foo by bar",
@@ -60,6 +64,8 @@ func TestServeHTTP(t *testing.T) {
 			contentEncoding: "br",
 			resBody:         "foo is the new bar",
 			expResBody:      "foo is the new bar",
+			expLastModified: true,
+			expPassthrough:  true,
 		},
 		{
 			desc: "should unzip, replace foo by bar, then zip",
@@ -94,7 +100,7 @@ func TestServeHTTP(t *testing.T) {
 				},
 			},
 			contentEncoding: "identity",
-			lastModified:    true,
+			lastModified:    "keep",
 			resBody:         "foo is the new bar",
 			expResBody:      "bar is the new bar",
 			expLastModified: true,
@@ -147,8 +153,8 @@ func TestServeHTTP(t *testing.T) {
 				t.Errorf("got last-modified header %v, want %v", exists, test.expLastModified)
 			}
 
-			if _, exists := recorder.Result().Header["Content-Length"]; exists {
-				t.Error("The Content-Length Header must be deleted")
+			if _, exists := recorder.Result().Header["Content-Length"]; exists != test.expPassthrough {
+				t.Errorf("got content-length header %v, want %v", exists, test.expPassthrough)
 			}
 			if test.contentEncoding == contentEncodingGzip {
 				t.Logf("received gzipped page: %v", recorder.Body.String())
@@ -217,6 +223,20 @@ func TestNew(t *testing.T) {
 			},
 			expErr: true,
 		},
+		{
+			desc: "should return an error when only one filter is invalid",
+			rewrites: []Filter{
+				{
+					Regex:       "foo",
+					Replacement: "bar",
+				},
+				{
+					Regex:       "(",
+					Replacement: "bar",
+				},
+			},
+			expErr: true,
+		},
 	}
 	for _, test := range tests {
 		t.Run(test.desc, func(t *testing.T) {
@@ -224,10 +244,572 @@ func TestNew(t *testing.T) {
 				Filters: test.rewrites,
 			}
 
-			_, err := New(context.Background(), nil, config, "rewriteBody")
+			handler, err := New(context.Background(), nil, config, "rewriteBody")
 			if test.expErr && err == nil {
 				t.Fatal("expected error on bad regexp format")
 			}
+
+			if test.expErr && handler != nil {
+				t.Errorf("got handler %v, want nil on error", handler)
+			}
+		})
+	}
+}
+
+func TestMaxFilters(t *testing.T) {
+	filters := func(n int) []Filter {
+		fs := make([]Filter, n)
+		for i := range fs {
+			fs[i] = Filter{Regex: "foo", Replacement: "bar"}
+		}
+
+		return fs
+	}
+
+	tests := []struct {
+		desc       string
+		filters    int
+		ruleFilter int
+		maxFilters int
+		expErr     bool
+	}{
+		{desc: "should accept the default cap", filters: defaultMaxFilters},
+		{desc: "should reject one more filter than the default cap", filters: defaultMaxFilters + 1, expErr: true},
+		{desc: "should accept a count just under the cap", filters: 4, maxFilters: 5},
+		{desc: "should accept a count at the cap", filters: 5, maxFilters: 5},
+		{desc: "should reject a count just over the cap", filters: 6, maxFilters: 5, expErr: true},
+		{desc: "should count the filters of rules", filters: 5, ruleFilter: 1, maxFilters: 5, expErr: true},
+		{desc: "should reject a negative cap", filters: 1, maxFilters: -1, expErr: true},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.Filters = filters(test.filters)
+			config.MaxFilters = test.maxFilters
+
+			if test.ruleFilter > 0 {
+				config.Rules = []Rule{{Filters: filters(test.ruleFilter)}}
+			}
+
+			_, err := New(context.Background(), nil, config, "subfilter")
+			if test.expErr != (err != nil) {
+				t.Errorf("got error %v, want error %t", err, test.expErr)
+			}
+		})
+	}
+
+	config := CreateConfig()
+	config.Filters = filters(1)
+	config.MaxFilters = 2
+
+	handler, err := New(context.Background(), nil, config, "subfilter")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := handler.(*SubFilter).UpdateFilters(filters(3)); err == nil {
+		t.Error("expected UpdateFilters to reject filters over the cap")
+	}
+}
+
+func TestSkipUntilMarker(t *testing.T) {
+	tests := []struct {
+		desc       string
+		resBody    string
+		expResBody string
+	}{
+		{
+			desc:       "should only filter after the marker",
+			resBody:    "foo <!-- begin --> foo foo",
+			expResBody: "foo <!-- begin --> bar bar",
+		},
+		{
+			desc:       "should only consider the first marker",
+			resBody:    "foo <!-- begin --> foo <!-- begin --> foo",
+			expResBody: "foo <!-- begin --> bar <!-- begin --> bar",
+		},
+		{
+			desc:       "should not filter a body without the marker",
+			resBody:    "foo is the new bar",
+			expResBody: "foo is the new bar",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.SkipUntilMarker = "<!-- begin -->"
+			config.Filters = []Filter{{Regex: "foo", Replacement: "bar"}}
+
+			next := func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(test.resBody))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if got := recorder.Body.String(); got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+		})
+	}
+}
+
+func TestSubFilter(t *testing.T) {
+	config := CreateConfig()
+	config.LastModified = "keep"
+	config.Filters = []Filter{{Regex: "foo", Replacement: "bar"}}
+
+	next := func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("foo is the new bar"))
+	}
+
+	sf, err := NewSubFilter(context.Background(), http.HandlerFunc(next), config, "subfilter")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serve := func() string {
+		recorder := httptest.NewRecorder()
+		sf.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		return recorder.Body.String()
+	}
+
+	if got := serve(); got != "bar is the new bar" {
+		t.Errorf("got body %q, want %q", got, "bar is the new bar")
+	}
+
+	if err = sf.UpdateFilters([]Filter{{Regex: "*"}}); err == nil {
+		t.Error("expected error when updating to invalid filters")
+	}
+
+	newFilters := []Filter{{Regex: "new", Replacement: "old"}}
+	if err = sf.UpdateFilters(newFilters); err != nil {
+		t.Fatal(err)
+	}
+
+	newFilters[0].Replacement = "mutated"
+
+	if got := serve(); got != "foo is the old bar" {
+		t.Errorf("got body %q after update, want %q", got, "foo is the old bar")
+	}
+
+	effective := sf.EffectiveConfig()
+	if effective.LastModified != "keep" || len(effective.Filters) != 1 || effective.Filters[0].Replacement != "old" {
+		t.Errorf("got effective config %+v", effective)
+	}
+
+	effective.Filters[0].Regex = "mutated"
+	if sf.EffectiveConfig().Filters[0].Regex != "new" {
+		t.Error("mutating the effective config must not affect the middleware")
+	}
+
+	expStats := Stats{Requests: 2, Filtered: 2, Modified: 2}
+	if stats := sf.Stats(); stats != expStats {
+		t.Errorf("got stats %+v, want %+v", stats, expStats)
+	}
+}
+
+func TestPassthrough(t *testing.T) {
+	const body = "foo is the new bar"
+
+	tests := []struct {
+		desc            string
+		config          func(*Config)
+		contentEncoding string
+	}{
+		{
+			desc:            "unsupported content encoding",
+			contentEncoding: "br",
+		},
+		{
+			desc: "request gate",
+			config: func(c *Config) {
+				c.Cookies = map[string]string{"beta": "on"}
+			},
+		},
+		{
+			desc: "no matching rule",
+			config: func(c *Config) {
+				c.Filters = nil
+				c.Rules = []Rule{{
+					Conditions: Conditions{Paths: []string{"^/api/"}},
+					Filters:    []Filter{{Regex: "foo", Replacement: "bar"}},
+				}}
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.Filters = []Filter{{Regex: "foo", Replacement: "bar"}}
+
+			if test.config != nil {
+				test.config(config)
+			}
+
+			next := func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Encoding", test.contentEncoding)
+				w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+				w.Header().Set("Last-Modified", "Thu, 02 Jun 2016 06:01:08 GMT")
+				w.WriteHeader(http.StatusAccepted)
+				_, _ = w.Write([]byte(body))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if recorder.Code != http.StatusAccepted {
+				t.Errorf("got status %d, want %d", recorder.Code, http.StatusAccepted)
+			}
+
+			if got := recorder.Header().Get("Content-Length"); got != strconv.Itoa(len(body)) {
+				t.Errorf("got content-length %q, want %d", got, len(body))
+			}
+
+			if recorder.Header().Get("Last-Modified") == "" {
+				t.Error("the Last-Modified header must be preserved")
+			}
+
+			if got := recorder.Body.String(); got != body {
+				t.Errorf("got body %q, want %q", got, body)
+			}
+		})
+	}
+}
+
+func TestByteRangesPassthrough(t *testing.T) {
+	var body bytes.Buffer
+
+	mw := multipart.NewWriter(&body)
+
+	for _, part := range []struct{ contentRange, content string }{
+		{"bytes 0-6/40", "foo bar"},
+		{"bytes 20-26/40", "bar foo"},
+	} {
+		pw, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":  {"text/plain"},
+			"Content-Range": {part.contentRange},
 		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, _ = pw.Write([]byte(part.content))
+	}
+
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	config := CreateConfig()
+	config.Filters = []Filter{{Regex: "foo", Replacement: "foobar"}}
+	config.ContentTypeFilters = map[string][]Filter{"*/*": {{Regex: "bar", Replacement: "baz"}}}
+
+	next := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "multipart/byteranges; boundary="+mw.Boundary())
+		w.Header().Set("Content-Length", strconv.Itoa(body.Len()))
+		w.WriteHeader(http.StatusPartialContent)
+		_, _ = w.Write(body.Bytes())
+	}
+
+	handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if recorder.Code != http.StatusPartialContent {
+		t.Errorf("got status %d, want %d", recorder.Code, http.StatusPartialContent)
+	}
+
+	if got := recorder.Header().Get("Content-Length"); got != strconv.Itoa(body.Len()) {
+		t.Errorf("got content length %q, want %d", got, body.Len())
+	}
+
+	if !bytes.Equal(recorder.Body.Bytes(), body.Bytes()) {
+		t.Errorf("got body %q, want %q", recorder.Body.String(), body.String())
+	}
+}
+
+func TestStripAcceptRangesOnModify(t *testing.T) {
+	tests := []struct {
+		desc      string
+		strip     bool
+		resBody   string
+		expRanges string
+	}{
+		{
+			desc:      "should keep Accept-Ranges by default",
+			resBody:   "foo",
+			expRanges: "bytes",
+		},
+		{
+			desc:      "should strip Accept-Ranges when the body changed",
+			strip:     true,
+			resBody:   "foo",
+			expRanges: "",
+		},
+		{
+			desc:      "should keep Accept-Ranges when the body did not change",
+			strip:     true,
+			resBody:   "baz",
+			expRanges: "bytes",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.Filters = []Filter{{Regex: "foo", Replacement: "bar"}}
+			config.StripAcceptRangesOnModify = test.strip
+
+			next := func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Accept-Ranges", "bytes")
+				_, _ = w.Write([]byte(test.resBody))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if got := recorder.Header().Get("Accept-Ranges"); got != test.expRanges {
+				t.Errorf("got Accept-Ranges %q, want %q", got, test.expRanges)
+			}
+		})
+	}
+}
+
+func TestContentLengthMismatch(t *testing.T) {
+	body := strings.Repeat("foo is the new bar. ", 50)
+	expBody := strings.Repeat("bar is the new bar. ", 50)
+
+	tests := []struct {
+		desc             string
+		setContentLength bool
+	}{
+		{desc: "should drop the upstream Content-Length"},
+		{desc: "should send the length of the filtered body", setContentLength: true},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.Filters = []Filter{{Regex: "foo", Replacement: "bar"}}
+			config.SetContentLength = test.setContentLength
+
+			next := func(w http.ResponseWriter, _ *http.Request) {
+				compressed := gzipString(t, body)
+
+				w.Header().Set("Content-Encoding", "gzip")
+				w.Header().Set("Content-Length", strconv.Itoa(len(compressed)/2))
+				_, _ = w.Write(compressed)
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			server := httptest.NewServer(handler)
+			defer server.Close()
+
+			req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req.Header.Set("Accept-Encoding", "gzip")
+
+			res, err := server.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer func() { _ = res.Body.Close() }()
+
+			raw, err := ioutil.ReadAll(res.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := gunzipString(t, raw); got != expBody {
+				t.Errorf("got body %q, want %q", got, expBody)
+			}
+
+			if test.setContentLength && res.ContentLength != int64(len(raw)) {
+				t.Errorf("got Content-Length %d, want %d", res.ContentLength, len(raw))
+			}
+		})
+	}
+}
+
+func TestSkipIfAlreadyProcessed(t *testing.T) {
+	tests := []struct {
+		desc       string
+		skip       bool
+		expResBody string
+	}{
+		{
+			desc:       "should filter again by default",
+			expResBody: "baz",
+		},
+		{
+			desc:       "should pass through a response filtered by the inner instance",
+			skip:       true,
+			expResBody: "bar",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			next := func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte("foo"))
+			}
+
+			innerConfig := CreateConfig()
+			innerConfig.Filters = []Filter{{Regex: "foo", Replacement: "bar"}}
+
+			inner, err := New(context.Background(), http.HandlerFunc(next), innerConfig, "inner")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			outerConfig := CreateConfig()
+			outerConfig.Filters = []Filter{{Regex: "bar", Replacement: "baz"}}
+			outerConfig.SkipIfAlreadyProcessed = test.skip
+
+			outer, err := New(context.Background(), inner, outerConfig, "outer")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			outer.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if got := recorder.Body.String(); got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+
+			if got := recorder.Header().Get(processedHeader); got == "" {
+				t.Errorf("got no %s header", processedHeader)
+			}
+		})
+	}
+}
+
+func TestGuardEmptyOutput(t *testing.T) {
+	tests := []struct {
+		desc       string
+		guard      bool
+		stream     bool
+		filter     Filter
+		resBody    string
+		expResBody string
+	}{
+		{
+			desc:       "should send the original body when filters delete everything",
+			guard:      true,
+			filter:     Filter{Regex: `(?s).+`},
+			resBody:    "<html>foo</html>",
+			expResBody: "<html>foo</html>",
+		},
+		{
+			desc:       "should send the original body when every line is deleted",
+			guard:      true,
+			filter:     Filter{Regex: "(?m)^", Action: "deleteLine"},
+			resBody:    "a\nb\r\nc",
+			expResBody: "a\nb\r\nc",
+		},
+		{
+			desc:       "should send the original streamed body when filters delete every piece",
+			guard:      true,
+			stream:     true,
+			filter:     Filter{Regex: `[a-z]`},
+			resBody:    "foofoofoo",
+			expResBody: "foofoofoo",
+		},
+		{
+			desc:       "should stream bodies that are not emptied",
+			guard:      true,
+			stream:     true,
+			filter:     Filter{Regex: `[a-z]`},
+			resBody:    "foofoo!",
+			expResBody: "!",
+		},
+		{
+			desc:       "should still filter bodies that are not emptied",
+			guard:      true,
+			filter:     Filter{Regex: "foo"},
+			resBody:    "<html>foo</html>",
+			expResBody: "<html></html>",
+		},
+		{
+			desc:       "should send empty bodies without the guard",
+			filter:     Filter{Regex: "(?m)^", Action: "deleteLine"},
+			resBody:    "a\nb\r\nc",
+			expResBody: "",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.Filters = []Filter{test.filter}
+			config.GuardEmptyOutput = test.guard
+			config.Stream = test.stream
+			config.StreamOverlap = 1
+
+			next := func(w http.ResponseWriter, _ *http.Request) {
+				// Streamed bodies are filtered piece by piece as written.
+				for _, c := range []byte(test.resBody) {
+					_, _ = w.Write([]byte{c})
+				}
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if got := recorder.Body.String(); got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+		})
+	}
+}
+
+func TestGuardEmptyOutputDefault(t *testing.T) {
+	if !CreateConfig().GuardEmptyOutput {
+		t.Error("expected guardEmptyOutput to be on by default")
 	}
 }
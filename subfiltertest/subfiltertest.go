@@ -0,0 +1,113 @@
+// Package subfiltertest provides helpers to test subfilter configurations: it
+// runs a response through the middleware and returns what a client would see,
+// decoded. It only depends on the standard library, so that it can be used in
+// test setups loaded by Yaegi too.
+package subfiltertest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DirtyCajunRice/subfilter"
+)
+
+// Result is the response of the middleware to a request.
+type Result struct {
+	Status int
+	Header http.Header
+	// Body is the response body, gunzipped when it is gzip-encoded.
+	Body string
+	// Matches holds the number of matches of each filter the middleware
+	// selected for the response, in the order they ran, as reported by its
+	// Tracer. It is empty when the body was not filtered.
+	Matches []int
+}
+
+// Run sends a GET request for "/" through the middleware configured by config,
+// in front of an upstream answering with body and header, and returns the
+// response.
+func Run(t testing.TB, config *subfilter.Config, body string, header http.Header) Result {
+	t.Helper()
+
+	return RunRequest(t, config, httptest.NewRequest(http.MethodGet, "/", nil), body, header)
+}
+
+// RunRequest is like Run, with the request to send.
+func RunRequest(t testing.TB, config *subfilter.Config, req *http.Request, body string, header http.Header) Result {
+	t.Helper()
+
+	next := func(w http.ResponseWriter, _ *http.Request) {
+		for name, values := range header {
+			w.Header()[name] = append([]string(nil), values...)
+		}
+
+		_, _ = w.Write([]byte(body))
+	}
+
+	var matches []int
+
+	opts := subfilter.Options{
+		Tracer: func(trace subfilter.FilterTrace) {
+			for _, fm := range trace.Filters {
+				matches = append(matches, fm.Matches)
+			}
+		},
+	}
+
+	handler, err := subfilter.NewWithOptions(context.Background(), http.HandlerFunc(next), config, "subfiltertest", opts)
+	if err != nil {
+		t.Fatalf("unable to create the middleware: %v", err)
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+
+	res := recorder.Result()
+	defer func() { _ = res.Body.Close() }()
+
+	b := recorder.Body.Bytes()
+	if res.Header.Get("Content-Encoding") == "gzip" {
+		b = gunzip(t, b)
+	}
+
+	return Result{
+		Status:  res.StatusCode,
+		Header:  res.Header,
+		Body:    string(b),
+		Matches: matches,
+	}
+}
+
+// GzipBody returns s gzip-compressed, as an upstream fixture to send along with
+// a "Content-Encoding: gzip" header.
+func GzipBody(s string) string {
+	var buf bytes.Buffer
+
+	zw := gzip.NewWriter(&buf)
+	// Writes to a bytes.Buffer cannot fail.
+	_, _ = zw.Write([]byte(s))
+	_ = zw.Close()
+
+	return buf.String()
+}
+
+func gunzip(t testing.TB, b []byte) []byte {
+	t.Helper()
+
+	zr, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("unable to decode the gzip body: %v", err)
+	}
+
+	decoded, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("unable to decode the gzip body: %v", err)
+	}
+
+	return decoded
+}
@@ -0,0 +1,78 @@
+package subfiltertest
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/DirtyCajunRice/subfilter"
+)
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		desc       string
+		body       string
+		header     http.Header
+		maxMatches int
+		expResBody string
+		expMatches []int
+	}{
+		{
+			desc:       "should return the filtered body",
+			body:       "foo is the new bar",
+			expResBody: "bar is the new baz",
+			expMatches: []int{1, 1},
+		},
+		{
+			desc:       "should decode a gzip body",
+			body:       GzipBody("foo is the new bar"),
+			header:     http.Header{"Content-Encoding": {"gzip"}},
+			expResBody: "bar is the new baz",
+			expMatches: []int{1, 1},
+		},
+		{
+			desc:       "should count the matches the middleware acted upon",
+			body:       "foo foo bar",
+			maxMatches: 1,
+			expResBody: "bar foo baz",
+			expMatches: []int{1, 1},
+		},
+		{
+			desc:       "should count no matches of responses not filtered",
+			body:       "foo is the new bar",
+			header:     http.Header{"Content-Disposition": {"attachment"}},
+			expResBody: "foo is the new bar",
+		},
+		{
+			desc:       "should count no matches",
+			body:       "nothing",
+			expResBody: "nothing",
+			expMatches: []int{0, 0},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := subfilter.CreateConfig()
+			config.Filters = []subfilter.Filter{
+				{Regex: "bar", Replacement: "baz"},
+				{Regex: "foo", Replacement: "bar", MaxMatches: test.maxMatches},
+			}
+
+			res := Run(t, config, test.body, test.header)
+
+			if res.Status != http.StatusOK {
+				t.Errorf("got status %d, want %d", res.Status, http.StatusOK)
+			}
+
+			if res.Body != test.expResBody {
+				t.Errorf("got body %q, want %q", res.Body, test.expResBody)
+			}
+
+			if !reflect.DeepEqual(res.Matches, test.expMatches) {
+				t.Errorf("got matches %v, want %v", res.Matches, test.expMatches)
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package subfilter
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"text/template"
+	"text/template/parse"
+)
+
+// templateFuncs are available to replacements of template filters.
+var templateFuncs = template.FuncMap{
+	"upper":      strings.ToUpper,
+	"lower":      strings.ToLower,
+	"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+	"replace":    func(old, newValue, s string) string { return strings.ReplaceAll(s, old, newValue) },
+	"default": func(def, s string) string {
+		if s == "" {
+			return def
+		}
+
+		return s
+	},
+}
+
+// goTemplate is the replacement of a template filter.
+type goTemplate struct {
+	tmpl *template.Template
+	// usesRequest is set when the template refers to .Request, which makes
+	// its output differ from one request to the next.
+	usesRequest bool
+	// logOnce limits execution errors to one log line per filter.
+	logOnce sync.Once
+	// reportErrors records execution errors in the scope, for the onError
+	// policy of the filter to act upon.
+	reportErrors bool
+}
+
+// templateData is the data a replacement template is executed with.
+type templateData struct {
+	// Match is the text of the whole match.
+	Match string
+	// Groups holds the capture groups by index, starting with the whole match.
+	Groups []string
+	// Named holds the named capture groups.
+	Named map[string]string
+	// Request describes the request being handled, if any.
+	Request templateRequest
+}
+
+type templateRequest struct {
+	Method string
+	Host   string
+	Path   string
+	Query  string
+	Header http.Header
+}
+
+func parseGoTemplate(i int, text string) (*goTemplate, error) {
+	tmpl, err := template.New(fmt.Sprintf("filter %d", i)).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid template: %w", err)
+	}
+
+	return &goTemplate{tmpl: tmpl, usesRequest: usesRequest(tmpl.Tree.Root)}, nil
+}
+
+// usesRequest reports whether the template tree rooted at n refers to the
+// Request field of templateData.
+func usesRequest(n parse.Node) bool {
+	switch n := n.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return false
+		}
+
+		for _, c := range n.Nodes {
+			if usesRequest(c) {
+				return true
+			}
+		}
+	case *parse.ActionNode:
+		return usesRequest(n.Pipe)
+	case *parse.TemplateNode:
+		return usesRequest(n.Pipe)
+	case *parse.IfNode:
+		return usesBranchRequest(&n.BranchNode)
+	case *parse.RangeNode:
+		return usesBranchRequest(&n.BranchNode)
+	case *parse.WithNode:
+		return usesBranchRequest(&n.BranchNode)
+	case *parse.PipeNode:
+		if n == nil {
+			return false
+		}
+
+		for _, c := range n.Cmds {
+			if usesRequest(c) {
+				return true
+			}
+		}
+	case *parse.CommandNode:
+		for _, a := range n.Args {
+			if usesRequest(a) {
+				return true
+			}
+		}
+	case *parse.FieldNode:
+		return n.Ident[0] == "Request"
+	case *parse.VariableNode:
+		return len(n.Ident) > 1 && n.Ident[1] == "Request"
+	case *parse.ChainNode:
+		return usesRequest(n.Node)
+	}
+
+	return false
+}
+
+func usesBranchRequest(n *parse.BranchNode) bool {
+	return usesRequest(n.Pipe) || usesRequest(n.List) || usesRequest(n.ElseList)
+}
+
+// expand appends the executed template for the match m of src to dst. If the
+// template fails, the match is kept as it is and the error reported to sc
+// when the filter has an onError policy.
+func (t *goTemplate) expand(dst []byte, re *regexp.Regexp, src []byte, m []int, sc *scope) []byte {
+	data := templateData{
+		Match:  string(src[m[0]:m[1]]),
+		Groups: make([]string, len(m)/2),
+		Named:  make(map[string]string),
+	}
+
+	for i, name := range re.SubexpNames() {
+		if m[2*i] < 0 {
+			continue
+		}
+
+		data.Groups[i] = string(src[m[2*i]:m[2*i+1]])
+
+		if name != "" {
+			data.Named[name] = data.Groups[i]
+		}
+	}
+
+	if sc != nil && sc.req != nil {
+		data.Request = templateRequest{
+			Method: sc.req.Method,
+			Host:   sc.req.Host,
+			Path:   sc.req.URL.Path,
+			Query:  sc.req.URL.RawQuery,
+			Header: sc.req.Header,
+		}
+	}
+
+	var buf bytes.Buffer
+
+	if err := t.tmpl.Execute(&buf, data); err != nil {
+		t.logOnce.Do(func() {
+			log.Printf("unable to execute replacement template, keeping matches unchanged: %v", err)
+		})
+
+		if t.reportErrors {
+			sc.fail(err)
+		}
+
+		return append(dst, src[m[0]:m[1]]...)
+	}
+
+	if t.usesRequest {
+		sc.markRequestDependent()
+	}
+
+	return append(dst, buf.Bytes()...)
+}
@@ -0,0 +1,96 @@
+package subfilter
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestTemplateFilters(t *testing.T) {
+	tests := []struct {
+		desc        string
+		regex       string
+		replacement string
+		resBody     string
+		expResBody  string
+		expLog      bool
+	}{
+		{
+			desc:        "should expose groups by index and name",
+			regex:       `(?P<scheme>https?)://(\w+)\.example\.com`,
+			replacement: `{{.Named.scheme}}://{{index .Groups 2}}.example.org`,
+			resBody:     "see http://www.example.com",
+			expResBody:  "see http://www.example.org",
+		},
+		{
+			desc:        "should call functions",
+			regex:       `env=([\w-]*)`,
+			replacement: `env={{index .Groups 1 | trimPrefix "x-" | default "prod" | upper}}`,
+			resBody:     "env=x-staging env=",
+			expResBody:  "env=STAGING env=PROD",
+		},
+		{
+			desc:        "should expose the request",
+			regex:       `HOST`,
+			replacement: `{{.Request.Host}}{{.Request.Path}}`,
+			resBody:     "HOST",
+			expResBody:  "example.com/page",
+		},
+		{
+			desc:        "should keep the match and log once when the template fails",
+			regex:       `id-\d`,
+			replacement: `{{.Named.missing}}`,
+			resBody:     "id-1 id-2",
+			expResBody:  "id-1 id-2",
+			expLog:      true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			var logs bytes.Buffer
+
+			log.SetOutput(&logs)
+			defer log.SetOutput(os.Stderr)
+
+			config := CreateConfig()
+			config.Filters = []Filter{{Type: "template", Regex: test.regex, Replacement: test.replacement}}
+
+			next := func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(test.resBody))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://example.com/page", nil))
+
+			if got := recorder.Body.String(); got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+
+			if got, exp := strings.Count(logs.String(), "unable to execute replacement template"), map[bool]int{true: 1}[test.expLog]; got != exp {
+				t.Errorf("got %d template errors logged, want %d: %s", got, exp, logs.String())
+			}
+		})
+	}
+}
+
+func TestTemplateFiltersInvalid(t *testing.T) {
+	config := CreateConfig()
+	config.Filters = []Filter{{Regex: "foo", Replacement: "bar"}, {Type: "template", Regex: "foo", Replacement: "{{.Match"}}
+
+	_, err := New(context.Background(), nil, config, "subfilter")
+	if err == nil || !strings.Contains(err.Error(), "filter 1") {
+		t.Errorf("got error %v, want one naming filter 1", err)
+	}
+}
@@ -0,0 +1,55 @@
+package subfilter
+
+import (
+	"fmt"
+	"strings"
+)
+
+// defaultTextTypes are the text-like media types TextTypesOnly restricts
+// filtering to.
+var defaultTextTypes = []string{
+	"text/*",
+	"application/json",
+	"*+json",
+	"application/javascript",
+	"application/x-javascript",
+	"application/ecmascript",
+	"application/xml",
+	"*+xml",
+	"application/yaml",
+	"application/x-yaml",
+}
+
+func (s *SubFilter) setupTextTypes(config *Config) error {
+	for _, p := range config.ContentTypes {
+		if p = strings.TrimSpace(p); p == "" || strings.Count(p, "/") > 1 {
+			return fmt.Errorf("contentTypes: invalid media type %q", p)
+		}
+	}
+
+	switch {
+	case len(config.ContentTypes) > 0:
+		s.contentTypes = config.ContentTypes
+	case config.TextTypesOnly:
+		s.contentTypes = defaultTextTypes
+	}
+
+	s.filterMissingType = config.FilterMissingContentType
+
+	return nil
+}
+
+// filterableType reports whether responses of contentType may be filtered.
+// Without a Content-Type, a restricted response is only when
+// filterMissingType is set.
+func (s *SubFilter) filterableType(contentType string) bool {
+	if s.contentTypes == nil {
+		return true
+	}
+
+	if contentType == "" {
+		return s.filterMissingType
+	}
+
+	return matchMediaType(s.contentTypes, contentType)
+}
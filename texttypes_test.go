@@ -0,0 +1,86 @@
+package subfilter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTextTypesOnly(t *testing.T) {
+	tests := []struct {
+		desc          string
+		contentTypes  []string
+		filterMissing bool
+		contentType   string
+		expFiltered   bool
+	}{
+		{desc: "should filter HTML", contentType: "text/html; charset=utf-8", expFiltered: true},
+		{desc: "should filter JSON", contentType: "application/json", expFiltered: true},
+		{desc: "should filter structured JSON types", contentType: "application/ld+json", expFiltered: true},
+		{desc: "should filter SVG", contentType: "image/svg+xml", expFiltered: true},
+		{desc: "should filter JavaScript", contentType: "application/javascript", expFiltered: true},
+		{desc: "should not filter images", contentType: "image/png"},
+		{desc: "should not filter binary bodies", contentType: "application/octet-stream"},
+		{desc: "should not filter bodies without a type"},
+		{desc: "should filter bodies without a type when told to", filterMissing: true, expFiltered: true},
+		{desc: "should not filter bodies without a type with an explicit list", contentTypes: []string{"text/html"}},
+		{
+			desc:          "should filter bodies without a type with an explicit list when told to",
+			contentTypes:  []string{"text/html"},
+			filterMissing: true,
+			expFiltered:   true,
+		},
+		{desc: "should still check the types present", filterMissing: true, contentType: "image/png"},
+		{
+			desc:         "should prefer an explicit list",
+			contentTypes: []string{"application/octet-stream"},
+			contentType:  "application/octet-stream",
+			expFiltered:  true,
+		},
+		{desc: "should drop the default list for an explicit one", contentTypes: []string{"text/css"}, contentType: "text/html"},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.Filters = []Filter{{Regex: "foo", Replacement: "bar"}}
+			config.TextTypesOnly = true
+			config.ContentTypes = test.contentTypes
+			config.FilterMissingContentType = test.filterMissing
+
+			next := func(w http.ResponseWriter, _ *http.Request) {
+				w.Header()["Content-Type"] = nil
+				if test.contentType != "" {
+					w.Header().Set("Content-Type", test.contentType)
+				}
+
+				_, _ = w.Write([]byte("foo"))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			exp := map[bool]string{true: "bar", false: "foo"}[test.expFiltered]
+			if got := recorder.Body.String(); got != exp {
+				t.Errorf("got body %q, want %q", got, exp)
+			}
+		})
+	}
+}
+
+func TestContentTypesConfig(t *testing.T) {
+	config := CreateConfig()
+	config.Filters = []Filter{{Regex: "foo"}}
+	config.ContentTypes = []string{" "}
+
+	if _, err := New(context.Background(), nil, config, "subfilter"); err == nil {
+		t.Error("expected error")
+	}
+}
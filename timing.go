@@ -0,0 +1,139 @@
+package subfilter
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FilterDuration is the time a filter spent on a body, reported in
+// RewriteSummary when Debug is set, along with the number of matches it
+// replaced.
+type FilterDuration struct {
+	Filter   Filter
+	Duration time.Duration
+	Matches  int
+}
+
+// timeFilter runs the filter f over b, adding the time it took to the
+// durations of the scope when timing is enabled.
+func (sc *scope) timeFilter(f *filter, b []byte) []byte {
+	if sc == nil || !sc.timing {
+		return f.apply(b, sc)
+	}
+
+	// time.Since reads the monotonic clock, so wall clock jumps do not skew
+	// the measure.
+	start := time.Now()
+	matches := sc.matches
+	b = f.apply(b, sc)
+	d := time.Since(start)
+	matches = sc.matches - matches
+
+	// Filters applied several times to a body, to each of its XML text nodes
+	// or multipart parts for instance, get a single sample adding them up.
+	if i, ok := sc.durationIndex[f.def]; ok {
+		sc.durations[i].Duration += d
+		sc.durations[i].Matches += matches
+
+		return b
+	}
+
+	if sc.durationIndex == nil {
+		sc.durationIndex = make(map[*Filter]int)
+	}
+
+	sc.durationIndex[f.def] = len(sc.durations)
+
+	fd := FilterDuration{Duration: d, Matches: matches}
+	if f.def != nil {
+		fd.Filter = *f.def
+	}
+
+	sc.durations = append(sc.durations, fd)
+
+	return b
+}
+
+// logDurations logs the time every filter spent on the size-byte body of r.
+func (s *SubFilter) logDurations(r *http.Request, durations []FilterDuration, size int) {
+	for _, fd := range durations {
+		if s.jsonLogs {
+			s.logJSON(logEntry{
+				Level:      "debug",
+				Message:    "filter applied",
+				Method:     r.Method,
+				Path:       r.URL.Path,
+				Filter:     filterName(fd.Filter),
+				Matches:    fd.Matches,
+				BodySize:   size,
+				DurationMs: float64(fd.Duration) / float64(time.Millisecond),
+			})
+
+			continue
+		}
+
+		log.Printf("%s: filter %s took %s on %s", s.name, filterLabel(fd.Filter), fd.Duration, r.URL.Path)
+	}
+}
+
+// filterLabel names the filter defined by f in logs.
+func filterLabel(f Filter) string {
+	switch {
+	case f.Preset != "":
+		return "preset " + f.Preset
+	case f.Regex != "":
+		return strconv.Quote(f.Regex)
+	default:
+		return strconv.Quote(f.Start) + ".." + strconv.Quote(f.End)
+	}
+}
+
+// filterName names the filter defined by f in structured logs, where its
+// regex needs no quoting.
+func filterName(f Filter) string {
+	switch {
+	case f.Preset != "":
+		return "preset " + f.Preset
+	case f.Regex != "":
+		return f.Regex
+	default:
+		return f.Start + ".." + f.End
+	}
+}
+
+// addServerTiming appends a Server-Timing entry for the time d that the
+// middleware name spent filtering to h, after the entries already there.
+func addServerTiming(h http.Header, name string, d time.Duration) {
+	if !isToken(name) {
+		name = "subfilter"
+	}
+
+	entry := name + ";dur=" + strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64)
+
+	values := h.Values("Server-Timing")
+	if n := len(values); n > 0 && strings.TrimSpace(values[n-1]) != "" {
+		entry = strings.TrimSpace(values[n-1]) + ", " + entry
+		values = values[:n-1]
+	}
+
+	h["Server-Timing"] = append(append([]string(nil), values...), entry)
+}
+
+// isToken reports whether s is an HTTP token, as metric names must be.
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !isAlnum(c) && !strings.ContainsRune("!#$%&'*+-.^_`|~", rune(c)) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,147 @@
+package subfilter
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestFilterDurations(t *testing.T) {
+	tests := []struct {
+		desc  string
+		debug bool
+		exp   []string
+	}{
+		{desc: "should not time filters by default"},
+		{desc: "should time every filter once per body", debug: true, exp: []string{"foo", "missing", "(?i)FOO"}},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.Filters = []Filter{
+				{Regex: "foo", Replacement: "bar"},
+				{Regex: "missing"},
+				{Regex: "(?i)FOO", Replacement: "baz"},
+			}
+			config.XMLSafe = true
+			config.Debug = test.debug
+
+			next := func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Type", "application/xml")
+				// Every text node goes through the filters.
+				_, _ = w.Write([]byte("<a>foo</a><b>foo</b><c>foo</c>"))
+			}
+
+			var summaries []RewriteSummary
+
+			opts := Options{OnRewrite: func(summary RewriteSummary) { summaries = append(summaries, summary) }}
+
+			sf, err := NewWithOptions(context.Background(), http.HandlerFunc(next), config, "subfilter", opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var logs bytes.Buffer
+
+			log.SetOutput(&logs)
+			defer log.SetOutput(os.Stderr)
+
+			for i := 0; i < 2; i++ {
+				sf.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/feed.xml", nil))
+			}
+
+			if len(summaries) != 2 {
+				t.Fatalf("got %d summaries, want 2", len(summaries))
+			}
+
+			for _, summary := range summaries {
+				if len(summary.FilterDurations) != len(test.exp) {
+					t.Fatalf("got %d durations, want %d", len(summary.FilterDurations), len(test.exp))
+				}
+
+				for i, fd := range summary.FilterDurations {
+					if fd.Filter.Regex != test.exp[i] {
+						t.Errorf("got duration %d for filter %q, want %q", i, fd.Filter.Regex, test.exp[i])
+					}
+
+					if fd.Duration < 0 {
+						t.Errorf("got negative duration %s for filter %q", fd.Duration, fd.Filter.Regex)
+					}
+				}
+			}
+
+			if got, exp := strings.Count(logs.String(), " took "), 2*len(test.exp); got != exp {
+				t.Errorf("got %d duration log lines, want %d: %s", got, exp, logs.String())
+			}
+		})
+	}
+}
+
+func TestEmitServerTiming(t *testing.T) {
+	entry := `subfilter;dur=\d+\.\d{3}`
+
+	tests := []struct {
+		desc     string
+		disabled bool
+		existing []string
+		expected string
+	}{
+		{
+			desc:     "should add an entry",
+			expected: "^" + entry + "$",
+		},
+		{
+			desc:     "should merge with an existing entry",
+			existing: []string{`db;dur=53, app;dur=47.2`},
+			expected: `^db;dur=53, app;dur=47.2, ` + entry + "$",
+		},
+		{
+			desc:     "should keep earlier header lines",
+			existing: []string{"cache;desc=hit", "db;dur=53"},
+			expected: `^cache;desc=hit\|db;dur=53, ` + entry + "$",
+		},
+		{
+			desc:     "should not add an entry unless enabled",
+			disabled: true,
+			expected: "^$",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.Filters = []Filter{{Regex: "foo", Replacement: "bar"}}
+			config.EmitServerTiming = !test.disabled
+
+			next := func(w http.ResponseWriter, _ *http.Request) {
+				for _, v := range test.existing {
+					w.Header().Add("Server-Timing", v)
+				}
+
+				_, _ = w.Write([]byte("foo"))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			got := strings.Join(recorder.Header().Values("Server-Timing"), "|")
+			if !regexp.MustCompile(test.expected).MatchString(got) {
+				t.Errorf("got Server-Timing %q, want to match %q", got, test.expected)
+			}
+		})
+	}
+}
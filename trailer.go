@@ -0,0 +1,47 @@
+package subfilter
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+// filterTrailer is the trailer EmitFilterTrailer sends the number of filters
+// applied to the body in.
+const filterTrailer = "X-Subfilter-Applied"
+
+func (s *SubFilter) setupFilterTrailer(config *Config) error {
+	if config.EmitFilterTrailer && config.SetContentLength {
+		// Trailers need a chunked body, which a Content-Length rules out.
+		return errors.New("emitFilterTrailer cannot be combined with setContentLength")
+	}
+
+	s.filterTrailer = config.EmitFilterTrailer
+
+	return nil
+}
+
+// declareFilterTrailer announces the filter trailer in h, which must be sent
+// before the status.
+func (s *SubFilter) declareFilterTrailer(h http.Header) {
+	if s.filterTrailer {
+		h.Add("Trailer", filterTrailer)
+	}
+}
+
+// setFilterTrailer sets the filter trailer declared in h, once the body is
+// written.
+func (s *SubFilter) setFilterTrailer(h http.Header, sc *scope) {
+	if s.filterTrailer {
+		h.Set(filterTrailer, strconv.Itoa(len(sc.applied)))
+	}
+}
+
+// markApplied records that f replaced at least one match.
+func (sc *scope) markApplied(f *filter) {
+	if sc.applied == nil {
+		sc.applied = make(map[*filter]bool)
+	}
+
+	sc.applied[f] = true
+}
@@ -0,0 +1,96 @@
+package subfilter
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEmitFilterTrailer(t *testing.T) {
+	tests := []struct {
+		desc       string
+		stream     bool
+		resBody    string
+		expTrailer string
+	}{
+		{
+			desc:       "should count the filters that matched",
+			resBody:    "foo bar",
+			expTrailer: "2",
+		},
+		{
+			desc:       "should count filters matching several times once",
+			resBody:    "foo foo foo",
+			expTrailer: "1",
+		},
+		{
+			desc:       "should send zero when no filter matched",
+			resBody:    "qux",
+			expTrailer: "0",
+		},
+		{
+			desc:       "should count the filters of streamed bodies",
+			stream:     true,
+			resBody:    "foo bar",
+			expTrailer: "2",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.Filters = []Filter{
+				{Regex: "foo", Replacement: "1"},
+				{Regex: "bar", Replacement: "2"},
+				{Regex: "baz", Replacement: "3"},
+			}
+			config.EmitFilterTrailer = true
+			config.Stream = test.stream
+
+			next := func(w http.ResponseWriter, _ *http.Request) {
+				_, _ = w.Write([]byte(test.resBody))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			server := httptest.NewServer(handler)
+			defer server.Close()
+
+			res, err := http.Get(server.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer func() { _ = res.Body.Close() }()
+
+			if got := res.Header.Get("Trailer"); got != "" {
+				t.Errorf("got Trailer header %q, want it consumed as a declaration", got)
+			}
+
+			if _, err := ioutil.ReadAll(res.Body); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := res.Trailer.Get(filterTrailer); got != test.expTrailer {
+				t.Errorf("got trailer %q, want %q", got, test.expTrailer)
+			}
+		})
+	}
+}
+
+func TestEmitFilterTrailerConfig(t *testing.T) {
+	config := CreateConfig()
+	config.Filters = []Filter{{Regex: "foo"}}
+	config.EmitFilterTrailer = true
+	config.SetContentLength = true
+
+	if _, err := New(context.Background(), nil, config, "subfilter"); err == nil {
+		t.Error("expected error for emitFilterTrailer with setContentLength")
+	}
+}
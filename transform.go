@@ -0,0 +1,390 @@
+package subfilter
+
+import (
+	"crypto/sha1" // nolint:gosec // used for checksums, not security
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"html"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode"
+)
+
+// transformFunc appends the expansion of a transform token to dst. args are
+// the colon-separated arguments following the transform name; match is the
+// current match of re in src, and sc the scope of the body being filtered.
+// The expansion is appended as is, so generated values need no escaping.
+type transformFunc func(dst []byte, args []string, re *regexp.Regexp, src []byte, match []int, sc *scope) []byte
+
+type transformDef struct {
+	// minArgs and maxArgs bound the number of arguments of the token.
+	minArgs, maxArgs int
+	// validate, when set, checks the arguments when the filter is compiled.
+	validate func(args []string) error
+	fn       transformFunc
+	// compile, when set instead of fn, checks the arguments when the filter
+	// is compiled and returns the transform, bound to what it parsed of them.
+	compile func(args []string) (transformFunc, error)
+}
+
+// transforms are the ${name:args} tokens available in the replacement of
+// filters with Transforms enabled.
+var transforms = map[string]transformDef{
+	"bump":       {minArgs: 1, maxArgs: 2, validate: validateBump, fn: bumpTransform},
+	"bumpparam":  {minArgs: 1, maxArgs: 2, validate: validateBumpParam, fn: bumpParamTransform},
+	"gcounter":   {fn: gcounterTransform},
+	"upper":      {minArgs: 1, maxArgs: 1, fn: groupTransform(strings.ToUpper)},
+	"lower":      {minArgs: 1, maxArgs: 1, fn: groupTransform(strings.ToLower)},
+	"title":      {minArgs: 1, maxArgs: 1, fn: groupTransform(titleCase)},
+	"htmlesc":    {minArgs: 1, maxArgs: 1, fn: groupTransform(html.EscapeString)},
+	"urlenc":     {minArgs: 1, maxArgs: 1, fn: groupTransform(url.QueryEscape)},
+	"now":        {minArgs: 1, maxArgs: 1, validate: validateNow, fn: nowTransform},
+	"uuid":       {fn: uuidTransform},
+	"n":          {fn: matchIndexTransform},
+	"query":      {minArgs: 1, maxArgs: 2, validate: validateQuery, fn: queryTransform},
+	"lang":       {fn: langTransform},
+	"remoteaddr": {fn: remoteAddrTransform},
+	"lookup":     {minArgs: 1, maxArgs: 2, fn: lookupTransform},
+	"expr":       {minArgs: 1, maxArgs: 1, compile: compileExpr},
+	"reltime":    {minArgs: 1, maxArgs: 2, validate: validateRelTime, fn: relTimeTransform},
+	"crc32":      {minArgs: 1, maxArgs: 1, fn: checksumTransform(crc32Sum)},
+	"sha1":       {minArgs: 1, maxArgs: 1, fn: checksumTransform(sha1Sum)},
+}
+
+// globalCounter backs ${gcounter}. It is shared by every middleware instance
+// in the process and starts over when Traefik restarts.
+var globalCounter uint64
+
+// segment is either a literal part of a replacement, expanded with
+// regexp.Expand, or a transform token.
+type segment struct {
+	literal []byte
+	fn      transformFunc
+	args    []string
+}
+
+type replacementTemplate struct {
+	segments []segment
+}
+
+// parseReplacementTemplate splits replacement into literal segments and
+// ${name:args} transform tokens. Tokens without a colon are plain capture
+// group references and stay in the literal segments, unless they name a
+// transform taking no arguments.
+func parseReplacementTemplate(replacement string) (*replacementTemplate, error) {
+	t := &replacementTemplate{}
+	rest := replacement
+
+	for {
+		i := strings.Index(rest, "${")
+		if i < 0 {
+			break
+		}
+
+		end := strings.IndexByte(rest[i:], '}')
+		if end < 0 {
+			break
+		}
+
+		token := rest[i+2 : i+end]
+
+		parts := strings.Split(token, ":")
+		if len(parts) == 1 && transforms[token].fn == nil && transforms[token].compile == nil || (i > 0 && rest[i-1] == '$') {
+			t.appendLiteral(rest[:i+end+1])
+			rest = rest[i+end+1:]
+
+			continue
+		}
+
+		def, ok := transforms[parts[0]]
+		if !ok {
+			return nil, fmt.Errorf("unknown transform %q", parts[0])
+		}
+
+		args := parts[1:]
+		if len(args) < def.minArgs || len(args) > def.maxArgs {
+			return nil, fmt.Errorf("transform %q takes %d to %d arguments, got %d", parts[0], def.minArgs, def.maxArgs, len(args))
+		}
+
+		if def.validate != nil {
+			if err := def.validate(args); err != nil {
+				return nil, fmt.Errorf("transform %q: %w", parts[0], err)
+			}
+		}
+
+		fn := def.fn
+		if def.compile != nil {
+			var err error
+			if fn, err = def.compile(args); err != nil {
+				return nil, fmt.Errorf("transform %q: %w", parts[0], err)
+			}
+		}
+
+		t.appendLiteral(rest[:i])
+		t.segments = append(t.segments, segment{fn: fn, args: args})
+		rest = rest[i+end+1:]
+	}
+
+	t.appendLiteral(rest)
+
+	return t, nil
+}
+
+func (t *replacementTemplate) appendLiteral(s string) {
+	if s == "" {
+		return
+	}
+
+	if n := len(t.segments); n > 0 && t.segments[n-1].fn == nil {
+		t.segments[n-1].literal = append(t.segments[n-1].literal, s...)
+
+		return
+	}
+
+	t.segments = append(t.segments, segment{literal: []byte(s)})
+}
+
+func (t *replacementTemplate) expand(dst []byte, re *regexp.Regexp, src []byte, match []int, sc *scope) []byte {
+	for _, seg := range t.segments {
+		if seg.fn == nil {
+			dst = re.Expand(dst, seg.literal, src, match)
+
+			continue
+		}
+
+		dst = seg.fn(dst, seg.args, re, src, match, sc)
+	}
+
+	return dst
+}
+
+// group returns the text of the capture group ref, a number or a name, in the
+// match.
+func group(re *regexp.Regexp, src []byte, match []int, ref string) []byte {
+	i, err := strconv.Atoi(ref)
+	if err != nil {
+		i = re.SubexpIndex(ref)
+	}
+
+	if i < 0 || 2*i+1 >= len(match) || match[2*i] < 0 {
+		return nil
+	}
+
+	return src[match[2*i]:match[2*i+1]]
+}
+
+var semverRegex = regexp.MustCompile(`^(v?)(\d+)\.(\d+)\.(\d+)`)
+
+func validateBump(args []string) error {
+	switch args[0] {
+	case "major", "minor", "patch":
+		return nil
+	default:
+		return fmt.Errorf("invalid level %q: must be major, minor or patch", args[0])
+	}
+}
+
+// bumpTransform implements ${bump:level[:group]}: the semantic version found
+// in the capture group (1 by default) has its major, minor or patch number
+// incremented, resetting the lower ones and dropping any pre-release or build
+// suffix. Values that are not semantic versions are kept as they are.
+func bumpTransform(dst []byte, args []string, re *regexp.Regexp, src []byte, match []int, _ *scope) []byte {
+	ref := "1"
+	if len(args) > 1 {
+		ref = args[1]
+	}
+
+	value := group(re, src, match, ref)
+
+	m := semverRegex.FindSubmatch(value)
+	if m == nil {
+		return append(dst, value...)
+	}
+
+	var v [3]int
+
+	for i := range v {
+		n, err := strconv.Atoi(string(m[i+2]))
+		if err != nil {
+			return append(dst, value...)
+		}
+
+		v[i] = n
+	}
+
+	switch args[0] {
+	case "major":
+		v = [3]int{v[0] + 1, 0, 0}
+	case "minor":
+		v = [3]int{v[0], v[1] + 1, 0}
+	default:
+		v[2]++
+	}
+
+	return append(dst, fmt.Sprintf("%s%d.%d.%d", m[1], v[0], v[1], v[2])...)
+}
+
+// gcounterTransform implements ${gcounter}: a process-wide counter incremented
+// on every match, across all requests.
+func gcounterTransform(dst []byte, _ []string, _ *regexp.Regexp, _ []byte, _ []int, _ *scope) []byte {
+	return strconv.AppendUint(dst, atomic.AddUint64(&globalCounter, 1), 10)
+}
+
+// groupTransform implements ${upper:group}, ${lower:group}, ${title:group},
+// ${htmlesc:group} and ${urlenc:group}, which recase or escape the text of a
+// capture group with fn.
+func groupTransform(fn func(string) string) transformFunc {
+	return func(dst []byte, args []string, re *regexp.Regexp, src []byte, match []int, _ *scope) []byte {
+		return append(dst, fn(string(group(re, src, match, args[0])))...)
+	}
+}
+
+// checksumTransform returns a transformFunc appending the hex checksum of
+// the capture group named by its argument, 0 standing for the whole match.
+func checksumTransform(sum func([]byte) []byte) transformFunc {
+	return func(dst []byte, args []string, re *regexp.Regexp, src []byte, match []int, _ *scope) []byte {
+		digest := sum(group(re, src, match, args[0]))
+		n := len(dst)
+
+		dst = append(dst, make([]byte, hex.EncodedLen(len(digest)))...)
+		hex.Encode(dst[n:], digest)
+
+		return dst
+	}
+}
+
+// crc32Sum returns the IEEE CRC-32 of b, big-endian.
+func crc32Sum(b []byte) []byte {
+	sum := make([]byte, 4)
+	binary.BigEndian.PutUint32(sum, crc32.ChecksumIEEE(b))
+
+	return sum
+}
+
+// sha1Sum returns the SHA-1 digest of b, as a checksum rather than for
+// security.
+func sha1Sum(b []byte) []byte {
+	sum := sha1.Sum(b) // nolint:gosec // a checksum, not for security
+
+	return sum[:]
+}
+
+// titleCase upper-cases the first letter of every word in s and lower-cases
+// the others. Unlike strings.Title, "hELLO wORLD" becomes "Hello World".
+func titleCase(s string) string {
+	inWord := false
+
+	return strings.Map(func(r rune) rune {
+		first := !inWord
+		inWord = unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r) || r == '\''
+
+		switch {
+		case !inWord:
+			return r
+		case first:
+			return unicode.ToTitle(r)
+		default:
+			return unicode.ToLower(r)
+		}
+	}, s)
+}
+
+func validateNow(args []string) error {
+	switch args[0] {
+	case "unix", "rfc3339":
+		return nil
+	default:
+		return fmt.Errorf("invalid format %q: must be unix or rfc3339", args[0])
+	}
+}
+
+// nowTransform implements ${now:unix} and ${now:rfc3339}: the time at which
+// the body started being filtered, identical for all its matches.
+func nowTransform(dst []byte, args []string, _ *regexp.Regexp, _ []byte, _ []int, sc *scope) []byte {
+	if args[0] == "unix" {
+		return strconv.AppendInt(dst, sc.time().Unix(), 10)
+	}
+
+	return sc.time().UTC().AppendFormat(dst, time.RFC3339)
+}
+
+// uuidTransform implements ${uuid}: a random UUID, identical for all matches
+// in a body.
+func uuidTransform(dst []byte, _ []string, _ *regexp.Regexp, _ []byte, _ []int, sc *scope) []byte {
+	return append(dst, sc.uuidV4()...)
+}
+
+// matchIndexTransform implements ${n}: the 1-based index of the match within
+// the body, counting the matches of all filters.
+func matchIndexTransform(dst []byte, _ []string, _ *regexp.Regexp, _ []byte, _ []int, sc *scope) []byte {
+	n := 1
+	if sc != nil {
+		n = sc.matches
+	}
+
+	return strconv.AppendInt(dst, int64(n), 10)
+}
+
+func validateQuery(args []string) error {
+	if len(args) == 1 {
+		return nil
+	}
+
+	switch args[1] {
+	case "html", "raw":
+		return nil
+	default:
+		return fmt.Errorf("invalid escaping %q: must be html or raw", args[1])
+	}
+}
+
+// queryTransform implements ${query:name[:escaping]}: the first value of the
+// query parameter name of the request, or nothing when it is missing. The value
+// is HTML-escaped unless escaping is "raw".
+func queryTransform(dst []byte, args []string, _ *regexp.Regexp, _ []byte, _ []int, sc *scope) []byte {
+	if sc == nil || sc.req == nil {
+		return dst
+	}
+
+	sc.markRequestDependent()
+
+	v := sc.req.URL.Query().Get(args[0])
+	if len(args) == 1 || args[1] == "html" {
+		v = html.EscapeString(v)
+	}
+
+	return append(dst, v...)
+}
+
+// lookupTransform implements ${lookup:key[:default]}: the value the Resolver
+// of the options has for key, or default, or nothing, when it has none.
+func lookupTransform(dst []byte, args []string, _ *regexp.Regexp, _ []byte, _ []int, sc *scope) []byte {
+	if sc != nil && sc.resolve != nil {
+		// The values may change from one request to the next.
+		sc.markRequestDependent()
+
+		if v, ok := sc.resolve(args[0]); ok {
+			return append(dst, v...)
+		}
+	}
+
+	if len(args) == 2 {
+		return append(dst, args[1]...)
+	}
+
+	return dst
+}
+
+// langTransform implements ${lang}: the language negotiated from the request
+// Accept-Language header, or nothing when it has none.
+func langTransform(dst []byte, _ []string, _ *regexp.Regexp, _ []byte, _ []int, sc *scope) []byte {
+	sc.markRequestDependent()
+
+	return append(dst, sc.language()...)
+}
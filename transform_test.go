@@ -0,0 +1,436 @@
+package subfilter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func serveTransform(t *testing.T, filter Filter, body string) string {
+	t.Helper()
+
+	config := CreateConfig()
+	config.Filters = []Filter{filter}
+
+	next := func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(body))
+	}
+
+	handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	return recorder.Body.String()
+}
+
+func TestBumpTransform(t *testing.T) {
+	tests := []struct {
+		desc        string
+		regex       string
+		replacement string
+		resBody     string
+		expResBody  string
+	}{
+		{
+			desc:        "should bump the patch version",
+			regex:       `app-(\d+\.\d+\.\d+)\.js`,
+			replacement: "app-${bump:patch}.js",
+			resBody:     `<script src="/app-1.2.3.js">`,
+			expResBody:  `<script src="/app-1.2.4.js">`,
+		},
+		{
+			desc:        "should bump the minor version and reset the patch",
+			regex:       `app-(\d+\.\d+\.\d+)\.js`,
+			replacement: "app-${bump:minor}.js",
+			resBody:     `<script src="/app-1.2.3.js">`,
+			expResBody:  `<script src="/app-1.3.0.js">`,
+		},
+		{
+			desc:        "should bump the major version of a named group and keep the v prefix",
+			regex:       `(?P<name>\w+)@(?P<version>v[0-9.]+)`,
+			replacement: "${name}@${bump:major:version}",
+			resBody:     "lib@v1.9.9-beta",
+			expResBody:  "lib@v2.0.0-beta",
+		},
+		{
+			desc:        "should keep a value that is not a semantic version",
+			regex:       `app-([0-9.]+)\.js`,
+			replacement: "app-${bump:patch}.js",
+			resBody:     "app-1.2.js",
+			expResBody:  "app-1.2.js",
+		},
+		{
+			desc:        "should keep escaped dollars literal",
+			regex:       `(\d+\.\d+\.\d+)`,
+			replacement: "$${bump:patch} ${bump:patch}",
+			resBody:     "1.0.0",
+			expResBody:  "${bump:patch} 1.0.1",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			got := serveTransform(t, Filter{Regex: test.regex, Replacement: test.replacement, Transforms: true}, test.resBody)
+			if got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+		})
+	}
+}
+
+func TestTransformsOptIn(t *testing.T) {
+	got := serveTransform(t, Filter{Regex: `(\d+\.\d+\.\d+)`, Replacement: "${bump:patch}"}, "1.2.3")
+	if got != "${bump:patch}" {
+		t.Errorf("got body %q, want transform tokens to be left literal without transforms", got)
+	}
+}
+
+func TestTransformsInvalid(t *testing.T) {
+	for _, replacement := range []string{"${bump:build}", "${bump}x${nope:1}", "${bump:patch:1:2}", "${query:q:url}"} {
+		config := CreateConfig()
+		config.Filters = []Filter{{Regex: "foo", Replacement: replacement, Transforms: true}}
+
+		if _, err := New(context.Background(), nil, config, "subfilter"); err == nil {
+			t.Errorf("expected error for replacement %q", replacement)
+		}
+	}
+}
+
+func TestGCounterTransform(t *testing.T) {
+	const (
+		goroutines = 8
+		requests   = 25
+	)
+
+	config := CreateConfig()
+	config.Filters = []Filter{{Regex: "id", Replacement: "id-${gcounter}", Transforms: true}}
+
+	next := func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("id id"))
+	}
+
+	handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		seen = make(map[string]bool)
+	)
+
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < requests; j++ {
+				recorder := httptest.NewRecorder()
+				handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+				mu.Lock()
+				for _, id := range strings.Fields(recorder.Body.String()) {
+					seen[id] = true
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if len(seen) != 2*goroutines*requests {
+		t.Errorf("got %d unique ids, want %d", len(seen), 2*goroutines*requests)
+	}
+}
+
+func TestGroupTransforms(t *testing.T) {
+	tests := []struct {
+		desc        string
+		regex       string
+		replacement string
+		resBody     string
+		expResBody  string
+	}{
+		{
+			desc:        "should uppercase a captured word",
+			regex:       `status: (\w+)`,
+			replacement: "status: ${upper:1}",
+			resBody:     "status: ok",
+			expResBody:  "status: OK",
+		},
+		{
+			desc:        "should title-case a named group",
+			regex:       `<h1>(?P<title>[^<]+)</h1>`,
+			replacement: "<h1>${title:title}</h1>",
+			resBody:     "<h1>the sÃO pAULO o'neil guide</h1>",
+			expResBody:  "<h1>The São Paulo O'neil Guide</h1>",
+		},
+		{
+			desc:        "should lowercase non-ASCII letters",
+			regex:       `(ÉTÉ)`,
+			replacement: "${lower:1}",
+			resBody:     "ÉTÉ",
+			expResBody:  "été",
+		},
+		{
+			desc:        "should HTML-escape a capture",
+			regex:       `title: (.+)`,
+			replacement: `<img alt="${htmlesc:1}">`,
+			resBody:     `title: a<b & "c"`,
+			expResBody:  `<img alt="a&lt;b &amp; &#34;c&#34;">`,
+		},
+		{
+			desc:        "should URL-encode a named group",
+			regex:       `q: (?P<q>.+)`,
+			replacement: `<a href="/search?q=${urlenc:q}">`,
+			resBody:     "q: a<b & c",
+			expResBody:  `<a href="/search?q=a%3Cb+%26+c">`,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			got := serveTransform(t, Filter{Regex: test.regex, Replacement: test.replacement, Transforms: true}, test.resBody)
+			if got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+		})
+	}
+}
+
+func TestDynamicTransforms(t *testing.T) {
+	filter := Filter{Regex: "MARK", Replacement: "${n}:${uuid}:${now:unix}", Transforms: true}
+
+	first := strings.Fields(serveTransform(t, filter, "MARK MARK MARK"))
+	if len(first) != 3 {
+		t.Fatalf("got %d markers, want 3", len(first))
+	}
+
+	var uuid, unix string
+
+	for i, marker := range first {
+		parts := strings.Split(marker, ":")
+		if len(parts) != 3 {
+			t.Fatalf("got marker %q, want n:uuid:unix", marker)
+		}
+
+		if exp := strconv.Itoa(i + 1); parts[0] != exp {
+			t.Errorf("got index %q, want %q", parts[0], exp)
+		}
+
+		if i == 0 {
+			uuid, unix = parts[1], parts[2]
+		} else if parts[1] != uuid || parts[2] != unix {
+			t.Errorf("got marker %q, want uuid %q and time %q shared across matches", marker, uuid, unix)
+		}
+	}
+
+	if !regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`).MatchString(uuid) {
+		t.Errorf("got uuid %q, want a version 4 UUID", uuid)
+	}
+
+	second := strings.Split(serveTransform(t, filter, "MARK"), ":")
+	if second[1] == uuid {
+		t.Errorf("got uuid %q again for another response", uuid)
+	}
+
+	got := serveTransform(t, Filter{Regex: "T", Replacement: "${now:rfc3339}", Transforms: true}, "T")
+	if _, err := time.Parse(time.RFC3339, got); err != nil {
+		t.Errorf("got %q, want an RFC 3339 time: %v", got, err)
+	}
+}
+
+func TestQueryTransform(t *testing.T) {
+	tests := []struct {
+		desc        string
+		replacement string
+		target      string
+		expResBody  string
+	}{
+		{
+			desc:        "should expand a query parameter",
+			replacement: "Hello ${query:user}",
+			target:      "/?user=alice",
+			expResBody:  "<p>Hello alice</p>",
+		},
+		{
+			desc:        "should use the first value",
+			replacement: "Hello ${query:user}",
+			target:      "/?user=alice&user=bob",
+			expResBody:  "<p>Hello alice</p>",
+		},
+		{
+			desc:        "should expand a missing parameter to nothing",
+			replacement: "Hello ${query:user}",
+			target:      "/?name=alice",
+			expResBody:  "<p>Hello </p>",
+		},
+		{
+			desc:        "should escape the value for HTML",
+			replacement: "Hello ${query:user}",
+			target:      "/?user=%3Cscript%3E%26",
+			expResBody:  "<p>Hello &lt;script&gt;&amp;</p>",
+		},
+		{
+			desc:        "should insert the raw value when asked to",
+			replacement: "Hello ${query:user:raw}",
+			target:      "/?user=%3Cb%3Ealice%3C%2Fb%3E",
+			expResBody:  "<p>Hello <b>alice</b></p>",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.Filters = []Filter{{Regex: "GREETING", Replacement: test.replacement, Transforms: true}}
+
+			next := func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte("<p>GREETING</p>"))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, test.target, nil))
+
+			if got := recorder.Body.String(); got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+		})
+	}
+}
+
+func TestExprTransform(t *testing.T) {
+	tests := []struct {
+		desc        string
+		regex       string
+		replacement string
+		body        string
+		expResBody  string
+	}{
+		{
+			desc:        "should double a captured number",
+			regex:       `width="(\d+)"`,
+			replacement: `width="${expr:$1*2}"`,
+			body:        `<img width="150"><img width="32">`,
+			expResBody:  `<img width="300"><img width="64">`,
+		},
+		{
+			desc:        "should honor precedence and parentheses",
+			regex:       `(\d+) (\d+)`,
+			replacement: "${expr:$1 + $2 * 2} ${expr:($1 + $2) * 2} ${expr:-$1 - -1}",
+			body:        "3 4",
+			expResBody:  "11 14 -2",
+		},
+		{
+			desc:        "should compute decimals",
+			regex:       `\$(?P<price>\d+\.\d+)`,
+			replacement: "${expr:$price * 3 / 4} EUR",
+			body:        "$10.00",
+			expResBody:  "7.5 EUR",
+		},
+		{
+			desc:        "should keep the group on division by zero",
+			regex:       `(\d+)/(\d+)`,
+			replacement: "${expr:$1 / $2}",
+			body:        "7/0",
+			expResBody:  "7",
+		},
+		{
+			desc:        "should keep groups that are not numbers",
+			regex:       `n=(\w+)`,
+			replacement: "n=${expr:$1+1}",
+			body:        "n=abc n=NaN",
+			expResBody:  "n=abc n=NaN",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			got := serveTransform(t, Filter{Regex: test.regex, Replacement: test.replacement, Transforms: true}, test.body)
+			if got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+		})
+	}
+}
+
+func TestExprTransformInvalid(t *testing.T) {
+	for _, replacement := range []string{"${expr:}", "${expr:$1*}", "${expr:($1}", "${expr:$1^2}", "${expr:$}", "${expr:1..2}"} {
+		config := CreateConfig()
+		config.Filters = []Filter{{Regex: "(foo)", Replacement: replacement, Transforms: true}}
+
+		if _, err := New(context.Background(), nil, config, "subfilter"); err == nil {
+			t.Errorf("expected error for replacement %q", replacement)
+		}
+	}
+}
+
+func TestChecksumTransforms(t *testing.T) {
+	tests := []struct {
+		desc        string
+		regex       string
+		replacement string
+		resBody     string
+		expResBody  string
+	}{
+		{
+			desc:        "should expand to the crc32 of the match",
+			regex:       `hello`,
+			replacement: "${crc32:0}",
+			resBody:     "hello world",
+			expResBody:  "3610a686 world",
+		},
+		{
+			desc:        "should expand to the sha1 of the match",
+			regex:       `hello`,
+			replacement: "${sha1:0}",
+			resBody:     "hello world",
+			expResBody:  "aaf4c61ddcc5e8a2dabede0f3b482cd9aea9434d world",
+		},
+		{
+			desc:        "should checksum a named group",
+			regex:       `id=(?P<id>\w+)`,
+			replacement: "id=${id}&sum=${crc32:id}",
+			resBody:     "id=abc",
+			expResBody:  "id=abc&sum=352441c2",
+		},
+		{
+			desc:        "should checksum an empty group",
+			regex:       `x(y?)`,
+			replacement: "${crc32:1}",
+			resBody:     "x",
+			expResBody:  "00000000",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			got := serveTransform(t, Filter{Regex: test.regex, Replacement: test.replacement, Transforms: true}, test.resBody)
+			if got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+		})
+	}
+}
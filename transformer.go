@@ -0,0 +1,121 @@
+package subfilter
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	transformErrorSkip        = "skip"
+	transformErrorPassthrough = "passthrough"
+	transformErrorFail        = "fail"
+)
+
+// Transformer is custom Go code rewriting response bodies along with the
+// configured filters.
+type Transformer interface {
+	// Transform returns the transformed version of the decoded body.
+	Transform(body []byte, ctx *ResponseContext) ([]byte, error)
+}
+
+// ResponseContext describes the response a Transformer is applied to. Header
+// holds the upstream response headers, which transformers may edit.
+type ResponseContext struct {
+	Request *http.Request
+	Status  int
+	Header  http.Header
+}
+
+// TransformerPosition is where a Transformer runs relative to the filters.
+type TransformerPosition int
+
+const (
+	// BeforeFilters runs the transformer on the body before any filter.
+	BeforeFilters TransformerPosition = iota
+	// AfterFilters runs the transformer on the body once every filter ran.
+	AfterFilters
+)
+
+// RegisteredTransformer is a Transformer registered through Options.
+type RegisteredTransformer struct {
+	// Name identifies the transformer in logs.
+	Name        string
+	Position    TransformerPosition
+	Transformer Transformer
+}
+
+// transformError is returned by runTransformers when a transformer failed
+// and the policy does not skip it.
+type transformError struct {
+	name string
+	err  error
+}
+
+func (e *transformError) Error() string {
+	return fmt.Sprintf("transformer %q: %v", e.name, e.err)
+}
+
+func (e *transformError) Unwrap() error {
+	return e.err
+}
+
+func (s *SubFilter) setupTransformErrors(config *Config) error {
+	switch policy := strings.ToLower(config.OnTransformError); policy {
+	case "":
+		s.onTransformError = transformErrorSkip
+	case transformErrorSkip, transformErrorPassthrough, transformErrorFail:
+		s.onTransformError = policy
+	default:
+		return fmt.Errorf("invalid onTransformError %q: must be %q, %q or %q", config.OnTransformError,
+			transformErrorSkip, transformErrorPassthrough, transformErrorFail)
+	}
+
+	return nil
+}
+
+// runTransformers applies the transformers registered at position to b, in
+// order. With the skip policy a failing transformer is logged and the body
+// is kept as it was before it; otherwise its error is returned.
+func (s *SubFilter) runTransformers(position TransformerPosition, b []byte, rw *responseWriter, r *http.Request) ([]byte, error) {
+	var ctx *ResponseContext
+
+	for _, t := range s.options.Transformers {
+		if t.Position != position {
+			continue
+		}
+
+		if ctx == nil {
+			ctx = &ResponseContext{Request: r, Status: rw.statusCode(), Header: rw.Header()}
+		}
+
+		transformed, err := t.Transformer.Transform(b, ctx)
+		if err != nil {
+			if s.onTransformError != transformErrorSkip {
+				return nil, &transformError{name: t.Name, err: err}
+			}
+
+			s.logResponse(logLevelWarn, r, "skipping transformer %q on %s: %v", t.Name, r.URL.Path, err)
+
+			continue
+		}
+
+		b = transformed
+	}
+
+	return b, nil
+}
+
+// writeTransformError sends the response as the error policy commands once a
+// transformer failed.
+func (s *SubFilter) writeTransformError(rw *responseWriter, r *http.Request, err error) {
+	s.logResponse(logLevelError, r, "%v on %s", err, r.URL.Path)
+
+	if s.onTransformError == transformErrorFail {
+		writeStatus(rw, http.StatusBadGateway)
+
+		return
+	}
+
+	s.writeResponse(rw, rw.buffer.Bytes())
+}
@@ -0,0 +1,150 @@
+package subfilter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// transformerFunc adapts a function to the Transformer interface.
+type transformerFunc func(body []byte, ctx *ResponseContext) ([]byte, error)
+
+func (f transformerFunc) Transform(body []byte, ctx *ResponseContext) ([]byte, error) {
+	return f(body, ctx)
+}
+
+// appendTransformer appends s to the body.
+func appendTransformer(s string) Transformer {
+	return transformerFunc(func(body []byte, _ *ResponseContext) ([]byte, error) {
+		return append(body, s...), nil
+	})
+}
+
+func TestTransformers(t *testing.T) {
+	failing := transformerFunc(func([]byte, *ResponseContext) ([]byte, error) {
+		return nil, errors.New("boom")
+	})
+
+	tests := []struct {
+		desc         string
+		policy       string
+		transformers []RegisteredTransformer
+		expStatus    int
+		expResBody   string
+		expHeader    string
+	}{
+		{
+			desc: "should run transformers around the filters",
+			transformers: []RegisteredTransformer{
+				{Name: "after", Position: AfterFilters, Transformer: appendTransformer(" foo after")},
+				{Name: "before", Position: BeforeFilters, Transformer: appendTransformer(" foo before")},
+			},
+			expStatus:  http.StatusOK,
+			expResBody: "bar bar before foo after",
+		},
+		{
+			desc: "should pass the response context",
+			transformers: []RegisteredTransformer{{
+				Name:     "context",
+				Position: BeforeFilters,
+				Transformer: transformerFunc(func(body []byte, ctx *ResponseContext) ([]byte, error) {
+					ctx.Header.Set("X-Transformed", ctx.Request.URL.Path)
+
+					return bytes.ToUpper(body), nil
+				}),
+			}},
+			expStatus:  http.StatusOK,
+			expResBody: "FOO",
+			expHeader:  "/page",
+		},
+		{
+			desc: "should skip failing transformers by default",
+			transformers: []RegisteredTransformer{
+				{Name: "failing", Position: BeforeFilters, Transformer: failing},
+				{Name: "after", Position: AfterFilters, Transformer: appendTransformer(" after")},
+			},
+			expStatus:  http.StatusOK,
+			expResBody: "bar after",
+		},
+		{
+			desc:   "should pass the upstream body through on error",
+			policy: "passthrough",
+			transformers: []RegisteredTransformer{
+				{Name: "failing", Position: AfterFilters, Transformer: failing},
+			},
+			expStatus:  http.StatusOK,
+			expResBody: "foo",
+		},
+		{
+			desc:   "should fail the response on error",
+			policy: "fail",
+			transformers: []RegisteredTransformer{
+				{Name: "failing", Position: BeforeFilters, Transformer: failing},
+			},
+			expStatus:  http.StatusBadGateway,
+			expResBody: "Bad Gateway\n",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.Filters = []Filter{{Regex: "foo", Replacement: "bar"}}
+			config.OnTransformError = test.policy
+
+			next := func(w http.ResponseWriter, _ *http.Request) {
+				_, _ = w.Write([]byte("foo"))
+			}
+
+			opts := Options{Transformers: test.transformers}
+
+			sf, err := NewWithOptions(context.Background(), http.HandlerFunc(next), config, "subfilter", opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			sf.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/page", nil))
+
+			if recorder.Code != test.expStatus {
+				t.Errorf("got status %d, want %d", recorder.Code, test.expStatus)
+			}
+
+			if got := recorder.Body.String(); got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+
+			if got := recorder.Header().Get("X-Transformed"); got != test.expHeader {
+				t.Errorf("got X-Transformed %q, want %q", got, test.expHeader)
+			}
+		})
+	}
+}
+
+func TestTransformersWithoutFilters(t *testing.T) {
+	next := func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("foo"))
+	}
+
+	opts := Options{Transformers: []RegisteredTransformer{
+		{Name: "upper", Transformer: transformerFunc(func(body []byte, _ *ResponseContext) ([]byte, error) {
+			return bytes.ToUpper(body), nil
+		})},
+	}}
+
+	sf, err := NewWithOptions(context.Background(), http.HandlerFunc(next), CreateConfig(), "subfilter", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	sf.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if got := recorder.Body.String(); got != "FOO" {
+		t.Errorf("got body %q, want %q", got, "FOO")
+	}
+}
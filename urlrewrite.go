@@ -0,0 +1,158 @@
+package subfilter
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// URLRewrite rewrites the absolute URLs under the base URL From to the same
+// URLs under To, as in "http://backend:8080/x" to
+// "https://public.example.com/x". Location also rewrites the Location
+// header of redirects, parsed as a URL rather than matched as text.
+type URLRewrite struct {
+	From     string `json:"from,omitempty"`
+	To       string `json:"to,omitempty"`
+	Location bool   `json:"location,omitempty"`
+}
+
+func (s *SubFilter) setupURLRewrites(config *Config) error {
+	for i, rw := range config.RewriteURLs {
+		from, err := parseBaseURL(rw.From)
+		if err != nil {
+			return fmt.Errorf("rewriteURLs[%d]: invalid from: %w", i, err)
+		}
+
+		to, err := parseBaseURL(rw.To)
+		if err != nil {
+			return fmt.Errorf("rewriteURLs[%d]: invalid to: %w", i, err)
+		}
+
+		// Schemes and hosts are case-insensitive, paths are not.
+		pattern := `(?i:` + regexp.QuoteMeta(from.Scheme+"://"+from.Host) + `)` + regexp.QuoteMeta(from.Path)
+
+		s.urlFilters = append(s.urlFilters, filter{
+			regex:       regexp.MustCompile(pattern),
+			replacement: []byte(to.String()),
+			literal:     true,
+			accept:      acceptBaseURL,
+		})
+
+		if rw.Location {
+			s.locationRewrites = append(s.locationRewrites, locationRewrite{from: from, to: to})
+		}
+	}
+
+	return nil
+}
+
+// locationRewrite rewrites the Location headers under the base URL from to
+// the same URLs under to.
+type locationRewrite struct {
+	from, to *url.URL
+}
+
+// rewriteLocation rewrites the Location header of h with the first of
+// rewrites whose base URL it is under. Absolute and scheme-relative URLs, as
+// in "//backend:8080/x", are compared by scheme, host, with default ports
+// made explicit, and whole path segments. Relative URLs are left alone:
+// clients resolve them against the public URL they requested already.
+func rewriteLocation(h http.Header, rewrites []locationRewrite) {
+	location := h.Get("Location")
+	if len(rewrites) == 0 || location == "" {
+		return
+	}
+
+	u, err := url.Parse(location)
+	if err != nil || u.Host == "" || u.Opaque != "" {
+		return
+	}
+
+	for _, rw := range rewrites {
+		scheme := u.Scheme
+		if scheme == "" {
+			scheme = rw.from.Scheme
+		}
+
+		if !strings.EqualFold(scheme, rw.from.Scheme) ||
+			!strings.EqualFold(hostPort(u.Host, scheme), hostPort(rw.from.Host, scheme)) {
+			continue
+		}
+
+		base, path := rw.from.EscapedPath(), u.EscapedPath()
+		if path != base && !strings.HasPrefix(path, base+"/") {
+			continue
+		}
+
+		rawPath := rw.to.EscapedPath() + path[len(base):]
+
+		unescaped, err := url.PathUnescape(rawPath)
+		if err != nil {
+			return
+		}
+
+		rewritten := *u
+		rewritten.Scheme, rewritten.Host, rewritten.User = rw.to.Scheme, rw.to.Host, nil
+		rewritten.Path, rewritten.RawPath = unescaped, rawPath
+
+		h.Set("Location", rewritten.String())
+
+		return
+	}
+}
+
+// hostPort returns host with the default port of scheme when it has none.
+func hostPort(host, scheme string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+
+	switch strings.ToLower(scheme) {
+	case "http":
+		return net.JoinHostPort(strings.Trim(host, "[]"), "80")
+	case "https":
+		return net.JoinHostPort(strings.Trim(host, "[]"), "443")
+	default:
+		return host
+	}
+}
+
+// parseBaseURL parses an absolute URL without query nor fragment, and
+// returns it without its trailing slash.
+func parseBaseURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme == "" || u.Host == "" || u.Opaque != "" {
+		return nil, fmt.Errorf("%q is not an absolute URL", raw)
+	}
+
+	if u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+		return nil, fmt.Errorf("%q must not have a query, fragment nor user", raw)
+	}
+
+	u.Path = strings.TrimRight(u.Path, "/")
+	u.RawPath = ""
+
+	return u, nil
+}
+
+// acceptBaseURL rejects matches that are only part of a longer URL, such as
+// "http://backend" in "http://backend2", "http://backend:8080" or
+// "xhttp://backend", and "http://b/app" in "http://b/application".
+func acceptBaseURL(src []byte, start, end int) bool {
+	if start > 0 && (isAlnum(src[start-1]) || src[start-1] == '+' || src[start-1] == '-' || src[start-1] == '.') {
+		return false
+	}
+
+	if end < len(src) && (isAlnum(src[end]) || strings.IndexByte("-_~%:@", src[end]) >= 0) {
+		return false
+	}
+
+	return !(end+1 < len(src) && src[end] == '.' && isAlnum(src[end+1]))
+}
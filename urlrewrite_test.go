@@ -0,0 +1,214 @@
+package subfilter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRewriteURLs(t *testing.T) {
+	tests := []struct {
+		desc        string
+		rewrites    []URLRewrite
+		resBody     string
+		location    string
+		expResBody  string
+		expLocation string
+	}{
+		{
+			desc:       "should rewrite the base URL",
+			rewrites:   []URLRewrite{{From: "http://backend:8080", To: "https://public.example.com"}},
+			resBody:    `<a href="http://backend:8080/x">x</a> http://backend:8080`,
+			expResBody: `<a href="https://public.example.com/x">x</a> https://public.example.com`,
+		},
+		{
+			desc:       "should ignore trailing slashes",
+			rewrites:   []URLRewrite{{From: "http://backend:8080/", To: "https://public.example.com/"}},
+			resBody:    "http://backend:8080/x http://backend:8080/",
+			expResBody: "https://public.example.com/x https://public.example.com/",
+		},
+		{
+			desc:       "should rewrite base URLs with a path",
+			rewrites:   []URLRewrite{{From: "http://backend:8080/app/", To: "https://public.example.com"}},
+			resBody:    "http://backend:8080/app/x http://backend:8080/application http://backend:8080/x",
+			expResBody: "https://public.example.com/x http://backend:8080/application http://backend:8080/x",
+		},
+		{
+			desc:       "should leave longer hosts and other ports alone",
+			rewrites:   []URLRewrite{{From: "http://backend", To: "https://public.example.com"}},
+			resBody:    "http://backend2/x http://backend:8080/x http://backend.corp/x http://BACKEND/x.",
+			expResBody: "http://backend2/x http://backend:8080/x http://backend.corp/x https://public.example.com/x.",
+		},
+		{
+			desc:        "should only rewrite the Location header when asked to",
+			rewrites:    []URLRewrite{{From: "http://backend:8080", To: "https://public.example.com"}},
+			resBody:     "http://backend:8080/x",
+			location:    "http://backend:8080/login",
+			expResBody:  "https://public.example.com/x",
+			expLocation: "http://backend:8080/login",
+		},
+		{
+			desc: "should rewrite the Location header",
+			rewrites: []URLRewrite{
+				{From: "http://backend:8080", To: "https://public.example.com", Location: true},
+			},
+			resBody:     "http://backend:8080/x",
+			location:    "http://backend:8080/login?next=%2Fx",
+			expResBody:  "https://public.example.com/x",
+			expLocation: "https://public.example.com/login?next=%2Fx",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.RewriteURLs = test.rewrites
+
+			next := func(w http.ResponseWriter, _ *http.Request) {
+				if test.location != "" {
+					w.Header().Set("Location", test.location)
+				}
+
+				_, _ = w.Write([]byte(test.resBody))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if got := recorder.Body.String(); got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+
+			if got := recorder.Header().Get("Location"); got != test.expLocation {
+				t.Errorf("got Location %q, want %q", got, test.expLocation)
+			}
+		})
+	}
+}
+
+func TestRewriteURLsRedirect(t *testing.T) {
+	config := CreateConfig()
+	config.RewriteURLs = []URLRewrite{{From: "http://backend:8080", To: "https://public.example.com", Location: true}}
+
+	next := func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://backend:8080/login", http.StatusFound)
+	}
+
+	handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/", nil))
+
+	if got := recorder.Header().Get("Location"); got != "https://public.example.com/login" {
+		t.Errorf("got Location %q, want %q", got, "https://public.example.com/login")
+	}
+}
+
+func TestRewriteURLsLocation(t *testing.T) {
+	tests := []struct {
+		desc        string
+		rewrite     URLRewrite
+		location    string
+		expLocation string
+	}{
+		{
+			desc:        "should rewrite an absolute backend URL to the public one",
+			rewrite:     URLRewrite{From: "http://backend:8080", To: "https://public.example.com"},
+			location:    "http://backend:8080/login?next=%2Fx#form",
+			expLocation: "https://public.example.com/login?next=%2Fx#form",
+		},
+		{
+			desc:        "should compare schemes and hosts case-insensitively, with default ports",
+			rewrite:     URLRewrite{From: "http://backend", To: "https://public.example.com"},
+			location:    "HTTP://Backend:80/x",
+			expLocation: "https://public.example.com/x",
+		},
+		{
+			desc:        "should rewrite scheme-relative URLs",
+			rewrite:     URLRewrite{From: "http://backend:8080", To: "https://public.example.com"},
+			location:    "//backend:8080/x",
+			expLocation: "https://public.example.com/x",
+		},
+		{
+			desc:        "should move the path under the public base path, keeping its escapes",
+			rewrite:     URLRewrite{From: "http://backend:8080/app", To: "https://public.example.com/pub/"},
+			location:    "http://backend:8080/app/a%2Fb",
+			expLocation: "https://public.example.com/pub/a%2Fb",
+		},
+		{
+			desc:        "should leave relative URLs alone",
+			rewrite:     URLRewrite{From: "http://backend:8080", To: "https://public.example.com"},
+			location:    "/login?next=/x",
+			expLocation: "/login?next=/x",
+		},
+		{
+			desc:        "should leave URLs outside the base path alone",
+			rewrite:     URLRewrite{From: "http://backend:8080/app", To: "https://public.example.com"},
+			location:    "http://backend:8080/application",
+			expLocation: "http://backend:8080/application",
+		},
+		{
+			desc:        "should leave other hosts alone",
+			rewrite:     URLRewrite{From: "http://backend:8080", To: "https://public.example.com"},
+			location:    "http://backend:8081/x",
+			expLocation: "http://backend:8081/x",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			test.rewrite.Location = true
+
+			config := CreateConfig()
+			config.RewriteURLs = []URLRewrite{test.rewrite}
+			// Bodyless redirects are passed through, their Location rewritten
+			// all the same.
+			config.TextTypesOnly = true
+
+			next := func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Location", test.location)
+				w.WriteHeader(http.StatusFound)
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if got := recorder.Header().Get("Location"); got != test.expLocation {
+				t.Errorf("got Location %q, want %q", got, test.expLocation)
+			}
+		})
+	}
+}
+
+func TestRewriteURLsInvalid(t *testing.T) {
+	for _, rw := range []URLRewrite{
+		{From: "backend:8080", To: "https://public.example.com"},
+		{From: "/x", To: "https://public.example.com"},
+		{From: "http://backend:8080", To: ""},
+		{From: "http://backend:8080/?x=1", To: "https://public.example.com"},
+		{From: "http://backend:8080", To: "https://public.example.com/#top"},
+	} {
+		config := CreateConfig()
+		config.RewriteURLs = []URLRewrite{rw}
+
+		if _, err := New(context.Background(), nil, config, "subfilter"); err == nil {
+			t.Errorf("expected error for %+v", rw)
+		}
+	}
+}
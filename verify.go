@@ -0,0 +1,94 @@
+package subfilter
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+const (
+	verifyActionLog   = "log"
+	verifyActionBlock = "block"
+)
+
+// verifier scans the final body for patterns that must no longer be present
+// once the filters ran.
+type verifier struct {
+	patterns     []*regexp.Regexp
+	block        bool
+	status       int
+	contentTypes []string
+}
+
+func (s *SubFilter) setupVerifier(config *Config) error {
+	if len(config.VerifyAbsent) == 0 {
+		return nil
+	}
+
+	v := &verifier{
+		status:       config.VerifyBlockStatus,
+		contentTypes: config.VerifySkipContentTypes,
+	}
+
+	switch strings.ToLower(config.VerifyAction) {
+	case "", verifyActionLog:
+	case verifyActionBlock:
+		v.block = true
+	default:
+		return fmt.Errorf("invalid verifyAction %q: must be %q or %q", config.VerifyAction, verifyActionLog, verifyActionBlock)
+	}
+
+	if v.status == 0 {
+		v.status = http.StatusBadGateway
+	} else if v.status < 100 || v.status > 999 {
+		return fmt.Errorf("invalid verifyBlockStatus %d", v.status)
+	}
+
+	for _, p := range config.VerifyAbsent {
+		regex, err := regexp.Compile(p)
+		if err != nil {
+			return fmt.Errorf("error compiling verifyAbsent regex %q: %w", p, err)
+		}
+
+		v.patterns = append(v.patterns, regex)
+	}
+
+	s.verifier = v
+
+	return nil
+}
+
+// violation returns the first pattern found in the decoded body b, if any.
+func (v *verifier) violation(b []byte, contentType string) (string, bool) {
+	if v == nil || matchMediaType(v.contentTypes, contentType) {
+		return "", false
+	}
+
+	for _, p := range v.patterns {
+		if p.Match(b) {
+			return p.String(), true
+		}
+	}
+
+	return "", false
+}
+
+// writeBlocked replaces the response with a bare error status when the
+// verification scan failed in block mode.
+func (v *verifier) writeBlocked(rw *responseWriter) {
+	writeStatus(rw, v.status)
+}
+
+// writeStatus replaces the response buffered by rw with a bare error status.
+func writeStatus(rw *responseWriter, status int) {
+	h := rw.ResponseWriter.Header()
+	for k := range h {
+		h.Del(k)
+	}
+
+	h.Set("Content-Type", "text/plain; charset=utf-8")
+	h.Set("X-Content-Type-Options", "nosniff")
+	rw.ResponseWriter.WriteHeader(status)
+	_, _ = fmt.Fprintln(rw.ResponseWriter, http.StatusText(status))
+}
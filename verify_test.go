@@ -0,0 +1,102 @@
+package subfilter
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestVerifyAbsent(t *testing.T) {
+	tests := []struct {
+		desc        string
+		action      string
+		contentType string
+		resBody     string
+		expStatus   int
+		expResBody  string
+		expLog      string
+	}{
+		{
+			desc:       "should pass a clean body",
+			action:     "block",
+			resBody:    "card 1234-5678",
+			expStatus:  http.StatusOK,
+			expResBody: "card XXXX-XXXX",
+		},
+		{
+			desc:       "should block a violating body",
+			action:     "block",
+			resBody:    "card 1234-5678, secret 42",
+			expStatus:  http.StatusServiceUnavailable,
+			expResBody: "Service Unavailable\n",
+			expLog:     `verifyAbsent pattern "secret"`,
+		},
+		{
+			desc:       "should only log a violating body in log mode",
+			action:     "log",
+			resBody:    "card 1234-5678, secret 42",
+			expStatus:  http.StatusOK,
+			expResBody: "card XXXX-XXXX, secret 42",
+			expLog:     `verifyAbsent pattern "secret"`,
+		},
+		{
+			desc:        "should skip configured content types",
+			action:      "block",
+			contentType: "text/csv; charset=utf-8",
+			resBody:     "secret 42",
+			expStatus:   http.StatusOK,
+			expResBody:  "secret 42",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			var logs bytes.Buffer
+
+			log.SetOutput(&logs)
+			defer log.SetOutput(os.Stderr)
+
+			config := CreateConfig()
+			config.Filters = []Filter{{Regex: `[0-9]{4}`, Replacement: "XXXX"}}
+			config.VerifyAbsent = []string{`[0-9]{4}-[0-9]{4}`, "secret"}
+			config.VerifyAction = test.action
+			config.VerifyBlockStatus = http.StatusServiceUnavailable
+			config.VerifySkipContentTypes = []string{"text/csv"}
+
+			next := func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", test.contentType)
+				_, _ = w.Write([]byte(test.resBody))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if recorder.Code != test.expStatus {
+				t.Errorf("got status %d, want %d", recorder.Code, test.expStatus)
+			}
+
+			if got := recorder.Body.String(); got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+
+			if test.expLog == "" && logs.Len() > 0 {
+				t.Errorf("got unexpected log %q", logs.String())
+			}
+
+			if !strings.Contains(logs.String(), test.expLog) {
+				t.Errorf("got log %q, want it to contain %q", logs.String(), test.expLog)
+			}
+		})
+	}
+}
@@ -0,0 +1,17 @@
+package subfilter
+
+import (
+	"net/http"
+	"strings"
+)
+
+// addTransformationWarning adds to h the RFC 7234 warning telling caches and
+// clients that agent changed the response, keeping the Warning values already
+// there.
+func addTransformationWarning(h http.Header, agent string) {
+	if agent == "" || strings.ContainsAny(agent, " \t\",") {
+		agent = "-"
+	}
+
+	h.Add("Warning", `214 `+agent+` "Transformation Applied"`)
+}
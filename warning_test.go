@@ -0,0 +1,97 @@
+package subfilter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTransformationWarning(t *testing.T) {
+	tests := []struct {
+		desc       string
+		disabled   bool
+		stream     bool
+		filter     Filter
+		body       string
+		warning    string
+		expWarning []string
+	}{
+		{
+			desc:       "should warn of modified bodies",
+			filter:     Filter{Regex: "foo", Replacement: "bar"},
+			body:       "foo",
+			expWarning: []string{`214 subfilter "Transformation Applied"`},
+		},
+		{
+			desc:   "should not warn of unmodified bodies",
+			filter: Filter{Regex: "foo", Replacement: "bar"},
+			body:   "baz",
+		},
+		{
+			desc:       "should keep existing warnings",
+			filter:     Filter{Regex: "foo", Replacement: "bar"},
+			body:       "foo",
+			warning:    `110 cache "Response is Stale"`,
+			expWarning: []string{`110 cache "Response is Stale"`, `214 subfilter "Transformation Applied"`},
+		},
+		{
+			desc:       "should not touch existing warnings of unmodified bodies",
+			filter:     Filter{Regex: "foo", Replacement: "bar"},
+			body:       "baz",
+			warning:    `110 cache "Response is Stale"`,
+			expWarning: []string{`110 cache "Response is Stale"`},
+		},
+		{
+			desc:       "should warn of modified headers",
+			filter:     Filter{Regex: "internal", Replacement: "public", ApplyTo: []string{"headers"}},
+			body:       "internal",
+			expWarning: []string{`214 subfilter "Transformation Applied"`},
+		},
+		{
+			desc:       "should warn of modified bodies with streaming enabled",
+			stream:     true,
+			filter:     Filter{Regex: "foo", Replacement: "bar"},
+			body:       "foo",
+			expWarning: []string{`214 subfilter "Transformation Applied"`},
+		},
+		{
+			desc:     "should not warn unless enabled",
+			disabled: true,
+			filter:   Filter{Regex: "foo", Replacement: "bar"},
+			body:     "foo",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.Filters = []Filter{test.filter}
+			config.AddTransformationWarning = !test.disabled
+			config.Stream = test.stream
+
+			next := func(w http.ResponseWriter, _ *http.Request) {
+				if test.warning != "" {
+					w.Header().Set("Warning", test.warning)
+				}
+
+				w.Header().Set("Location", "https://internal/")
+				_, _ = w.Write([]byte(test.body))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if got := recorder.Header().Values("Warning"); strings.Join(got, "|") != strings.Join(test.expWarning, "|") {
+				t.Errorf("got Warning %q, want %q", got, test.expWarning)
+			}
+		})
+	}
+}
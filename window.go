@@ -0,0 +1,80 @@
+package subfilter
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// activeWindow is the time of day a filter is active, in minutes since
+// midnight in loc. Windows with from after to span midnight.
+type activeWindow struct {
+	from, to int
+	loc      *time.Location
+}
+
+// compileActiveWindow returns the active window of f, or nil when f is
+// always active.
+func compileActiveWindow(f Filter) (*activeWindow, error) {
+	switch {
+	case f.ActiveFrom == "" && f.ActiveTo == "":
+		if f.ActiveTimeZone != "" {
+			return nil, errors.New("activeTimeZone requires activeFrom and activeTo")
+		}
+
+		return nil, nil
+	case f.ActiveFrom == "" || f.ActiveTo == "":
+		return nil, errors.New("activeFrom and activeTo must be set together")
+	}
+
+	w := &activeWindow{loc: time.UTC}
+
+	var err error
+
+	if w.from, err = parseTimeOfDay(f.ActiveFrom); err != nil {
+		return nil, fmt.Errorf("invalid activeFrom: %w", err)
+	}
+
+	if w.to, err = parseTimeOfDay(f.ActiveTo); err != nil {
+		return nil, fmt.Errorf("invalid activeTo: %w", err)
+	}
+
+	if w.from == w.to {
+		return nil, errors.New("activeFrom and activeTo must differ")
+	}
+
+	if f.ActiveTimeZone != "" {
+		if w.loc, err = time.LoadLocation(f.ActiveTimeZone); err != nil {
+			return nil, fmt.Errorf("invalid activeTimeZone: %w", err)
+		}
+	}
+
+	return w, nil
+}
+
+// parseTimeOfDay returns the minutes since midnight of a "15:04" time.
+func parseTimeOfDay(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a time of day such as 09:30", s)
+	}
+
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// active reports whether the filtering of sc, as of the time it started,
+// falls within the window. A nil *activeWindow is always active.
+func (w *activeWindow) active(sc *scope) bool {
+	if w == nil {
+		return true
+	}
+
+	t := sc.time().In(w.loc)
+	now := t.Hour()*60 + t.Minute()
+
+	if w.from < w.to {
+		return now >= w.from && now < w.to
+	}
+
+	return now >= w.from || now < w.to
+}
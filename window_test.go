@@ -0,0 +1,125 @@
+package subfilter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestActiveWindow(t *testing.T) {
+	tests := []struct {
+		desc       string
+		from, to   string
+		timeZone   string
+		now        time.Time
+		expResBody string
+	}{
+		{
+			desc:       "should apply the filter inside the window",
+			from:       "09:00",
+			to:         "17:30",
+			now:        time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC),
+			expResBody: "<banner>sale</banner>",
+		},
+		{
+			desc:       "should include the start of the window",
+			from:       "09:00",
+			to:         "17:30",
+			now:        time.Date(2024, 5, 10, 9, 0, 0, 0, time.UTC),
+			expResBody: "<banner>sale</banner>",
+		},
+		{
+			desc:       "should not apply the filter before the window",
+			from:       "09:00",
+			to:         "17:30",
+			now:        time.Date(2024, 5, 10, 8, 59, 0, 0, time.UTC),
+			expResBody: "<banner></banner>",
+		},
+		{
+			desc:       "should exclude the end of the window",
+			from:       "09:00",
+			to:         "17:30",
+			now:        time.Date(2024, 5, 10, 17, 30, 0, 0, time.UTC),
+			expResBody: "<banner></banner>",
+		},
+		{
+			desc:       "should apply a window spanning midnight after midnight",
+			from:       "22:00",
+			to:         "02:00",
+			now:        time.Date(2024, 5, 10, 1, 0, 0, 0, time.UTC),
+			expResBody: "<banner>sale</banner>",
+		},
+		{
+			desc:       "should not apply a window spanning midnight at noon",
+			from:       "22:00",
+			to:         "02:00",
+			now:        time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC),
+			expResBody: "<banner></banner>",
+		},
+		{
+			desc:       "should read the time of day in the time zone",
+			from:       "09:00",
+			to:         "17:00",
+			timeZone:   "America/New_York",
+			now:        time.Date(2024, 5, 10, 14, 0, 0, 0, time.UTC),
+			expResBody: "<banner>sale</banner>",
+		},
+		{
+			desc:       "should not apply the filter outside the window of the time zone",
+			from:       "09:00",
+			to:         "17:00",
+			timeZone:   "America/New_York",
+			now:        time.Date(2024, 5, 10, 22, 0, 0, 0, time.UTC),
+			expResBody: "<banner></banner>",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.Filters = []Filter{{
+				Regex:          "<banner></banner>",
+				Replacement:    "<banner>sale</banner>",
+				ActiveFrom:     test.from,
+				ActiveTo:       test.to,
+				ActiveTimeZone: test.timeZone,
+			}}
+
+			next := func(w http.ResponseWriter, _ *http.Request) {
+				_, _ = w.Write([]byte("<banner></banner>"))
+			}
+
+			opts := Options{Now: func() time.Time { return test.now }}
+
+			handler, err := NewWithOptions(context.Background(), http.HandlerFunc(next), config, "subfilter", opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if got := recorder.Body.String(); got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+		})
+	}
+}
+
+func TestActiveWindowConfig(t *testing.T) {
+	for i, f := range []Filter{
+		{Regex: "x", ActiveFrom: "09:00"},
+		{Regex: "x", ActiveFrom: "9am", ActiveTo: "17:00"},
+		{Regex: "x", ActiveFrom: "09:00", ActiveTo: "25:00"},
+		{Regex: "x", ActiveFrom: "09:00", ActiveTo: "09:00"},
+		{Regex: "x", ActiveFrom: "09:00", ActiveTo: "17:00", ActiveTimeZone: "Mars/Olympus"},
+		{Regex: "x", ActiveTimeZone: "UTC"},
+	} {
+		if _, err := compileFilters([]Filter{f}); err == nil {
+			t.Errorf("filter %d: expected error", i)
+		}
+	}
+}
@@ -0,0 +1,455 @@
+package subfilter
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"strings"
+)
+
+// htmlVoidElements never have content nor end tags.
+var htmlVoidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true, "img": true,
+	"input": true, "link": true, "meta": true, "param": true, "source": true, "track": true, "wbr": true,
+}
+
+// htmlImpliedEnd are the elements a sibling of the same name closes when
+// their end tag is omitted, as in <li>a<li>b.
+var htmlImpliedEnd = map[string]bool{
+	"dd": true, "dt": true, "li": true, "option": true, "p": true, "td": true, "th": true, "tr": true,
+}
+
+const (
+	tokenizerFallbackPassthrough = "passthrough"
+	tokenizerFallbackRegex       = "regex"
+)
+
+var htmlAttrEscaper = strings.NewReplacer("&", "&amp;", `"`, "&quot;", "<", "&lt;", ">", "&gt;")
+
+// tagSelector matches elements by tag name, id and classes, any of which may
+// be omitted, as in "td.hostname", "#main" or "title".
+type tagSelector struct {
+	name, id string
+	classes  []string
+}
+
+// withinTags restricts a filter to the text, and optionally the attributes,
+// inside the HTML elements matching one of its selectors, or to all the text
+// of the document.
+type withinTags struct {
+	selectors  []tagSelector
+	all        bool
+	attributes bool
+	inner      *filter
+}
+
+// htmlElement is an element opened and not closed yet.
+type htmlElement struct {
+	name  string
+	match bool
+}
+
+func (s *SubFilter) setupTokenizerFallback(config *Config) error {
+	switch policy := strings.ToLower(config.TokenizerFallback); policy {
+	case "":
+		s.tokenizerFallback = tokenizerFallbackPassthrough
+	case tokenizerFallbackPassthrough, tokenizerFallbackRegex:
+		s.tokenizerFallback = policy
+	default:
+		return fmt.Errorf("invalid tokenizerFallback %q: must be %q or %q", config.TokenizerFallback,
+			tokenizerFallbackPassthrough, tokenizerFallbackRegex)
+	}
+
+	return nil
+}
+
+func parseTagSelector(s string) (tagSelector, error) {
+	var sel tagSelector
+
+	rest := strings.TrimSpace(s)
+	i := strings.IndexAny(rest, ".#")
+
+	if i < 0 {
+		i = len(rest)
+	}
+
+	sel.name, rest = strings.ToLower(rest[:i]), rest[i:]
+
+	for rest != "" {
+		kind := rest[0]
+		rest = rest[1:]
+
+		j := strings.IndexAny(rest, ".#")
+		if j < 0 {
+			j = len(rest)
+		}
+
+		part := rest[:j]
+		rest = rest[j:]
+
+		switch {
+		case part == "":
+			return tagSelector{}, fmt.Errorf("invalid tag selector %q", s)
+		case kind == '#':
+			sel.id = part
+		default:
+			sel.classes = append(sel.classes, part)
+		}
+	}
+
+	if sel.name == "" && sel.id == "" && len(sel.classes) == 0 || strings.ContainsAny(sel.name, " <>/=\"'") {
+		return tagSelector{}, fmt.Errorf("invalid tag selector %q", s)
+	}
+
+	return sel, nil
+}
+
+func compileWithinTags(f Filter, inner *filter) (*withinTags, error) {
+	wt := &withinTags{all: f.TextNodesOnly, attributes: f.WithinTagsAttributes, inner: inner}
+
+	for _, s := range f.WithinTags {
+		sel, err := parseTagSelector(s)
+		if err != nil {
+			return nil, err
+		}
+
+		wt.selectors = append(wt.selectors, sel)
+	}
+
+	return wt, nil
+}
+
+func (sel tagSelector) match(name string, attrs []htmlAttr, tag []byte) bool {
+	if sel.name != "" && sel.name != name {
+		return false
+	}
+
+	if sel.id == "" && len(sel.classes) == 0 {
+		return true
+	}
+
+	var id string
+
+	var classes []string
+
+	for _, a := range attrs {
+		switch strings.ToLower(string(tag[a.nameStart:a.nameEnd])) {
+		case "id":
+			id = html.UnescapeString(string(tag[a.valueStart:a.valueEnd]))
+		case "class":
+			classes = strings.Fields(html.UnescapeString(string(tag[a.valueStart:a.valueEnd])))
+		}
+	}
+
+	if sel.id != "" && sel.id != id {
+		return false
+	}
+
+	for _, want := range sel.classes {
+		found := false
+
+		for _, c := range classes {
+			if c == want {
+				found = true
+
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// apply runs the inner filter over the text inside matching elements of the
+// HTML body b. Bodies of other content types are left untouched, and so are
+// malformed ones unless the tokenizer fallback of sc is "regex", in which
+// case the inner filter runs over the whole body.
+func (wt *withinTags) apply(b []byte, sc *scope) []byte {
+	if sc != nil && sc.contentType != "" && !isHTMLContentType(sc.contentType) {
+		return b
+	}
+
+	if htmlMalformed(b) {
+		if sc != nil && sc.tokenizerFallback == tokenizerFallbackRegex {
+			return wt.inner.apply(b, sc)
+		}
+
+		return b
+	}
+
+	var stack []htmlElement
+
+	// inside counts the matching elements in stack.
+	inside := 0
+	out := make([]byte, 0, len(b))
+
+	for len(b) > 0 {
+		i := bytes.IndexByte(b, '<')
+		if i < 0 {
+			i = len(b)
+		}
+
+		if inside > 0 || wt.all {
+			out = append(out, filterTextNode(b[:i], html.UnescapeString, func(text []byte) []byte { return wt.inner.apply(text, sc) })...)
+		} else {
+			out = append(out, b[:i]...)
+		}
+
+		b = b[i:]
+		if len(b) == 0 {
+			break
+		}
+
+		n := xmlMarkupLen(b)
+		tag := b[:n]
+		b = b[n:]
+
+		name, closing := htmlTagName(tag)
+
+		switch {
+		case name == "":
+			out = append(out, tag...)
+		case closing:
+			out = append(out, tag...)
+
+			for j := len(stack) - 1; j >= 0; j-- {
+				if stack[j].name != name {
+					continue
+				}
+
+				for _, e := range stack[j:] {
+					if e.match {
+						inside--
+					}
+				}
+
+				stack = stack[:j]
+
+				break
+			}
+		default:
+			if n := len(stack); n > 0 && htmlImpliedEnd[name] && stack[n-1].name == name {
+				if stack[n-1].match {
+					inside--
+				}
+
+				stack = stack[:n-1]
+			}
+
+			attrs := htmlAttrs(tag, len(name)+1)
+
+			match := false
+
+			for _, sel := range wt.selectors {
+				if sel.match(name, attrs, tag) {
+					match = true
+
+					break
+				}
+			}
+
+			if wt.attributes && (inside > 0 || match) {
+				tag = wt.filterAttrs(tag, attrs, sc)
+			}
+
+			out = append(out, tag...)
+
+			if name == "script" || name == "style" {
+				// Raw text: copy it through up to the end tag.
+				end := htmlRawTextEnd(b, name)
+				out = append(out, b[:end]...)
+				b = b[end:]
+
+				continue
+			}
+
+			if htmlVoidElements[name] || bytes.HasSuffix(tag, []byte("/>")) {
+				continue
+			}
+
+			stack = append(stack, htmlElement{name: name, match: match})
+
+			if match {
+				inside++
+			}
+		}
+	}
+
+	return out
+}
+
+// htmlMalformed reports whether b holds markup the tokenizer cannot tell the
+// end of: an unterminated tag, comment or attribute value, which would swallow
+// the rest of the document, or a script or style element never closed.
+func htmlMalformed(b []byte) bool {
+	for {
+		i := bytes.IndexByte(b, '<')
+		if i < 0 {
+			return false
+		}
+
+		n, ok := xmlMarkupEnd(b[i:])
+		if !ok {
+			return true
+		}
+
+		name, closing := htmlTagName(b[i : i+n])
+		b = b[i+n:]
+
+		if !closing && (name == "script" || name == "style") {
+			end := htmlRawTextEnd(b, name)
+			if end == len(b) {
+				return true
+			}
+
+			b = b[end:]
+		}
+	}
+}
+
+// htmlTagName returns the lowercased name of the tag, and whether it is an
+// end tag, or "" for comments, doctypes and other markup.
+func htmlTagName(tag []byte) (string, bool) {
+	i := 1
+	closing := len(tag) > 1 && tag[1] == '/'
+
+	if closing {
+		i++
+	}
+
+	j := i
+	for j < len(tag) && (tag[j] >= 'a' && tag[j] <= 'z' || tag[j] >= 'A' && tag[j] <= 'Z' ||
+		j > i && (tag[j] >= '0' && tag[j] <= '9' || tag[j] == '-' || tag[j] == ':')) {
+		j++
+	}
+
+	return strings.ToLower(string(tag[i:j])), closing
+}
+
+// htmlRawTextEnd returns the offset of the end tag of the raw text element
+// name in b, or len(b).
+func htmlRawTextEnd(b []byte, name string) int {
+	lower := bytes.ToLower(b)
+
+	if i := bytes.Index(lower, []byte("</"+name)); i >= 0 {
+		return i
+	}
+
+	return len(b)
+}
+
+// htmlAttr is the span of an attribute of a start tag. Empty spans denote an
+// attribute without value; quote is the quote around its value, if any.
+type htmlAttr struct {
+	nameStart, nameEnd   int
+	valueStart, valueEnd int
+	quote                byte
+}
+
+// htmlAttrs returns the attributes of the start tag, from offset i.
+func htmlAttrs(tag []byte, i int) []htmlAttr {
+	var attrs []htmlAttr
+
+	isSpace := func(c byte) bool { return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' }
+
+	for i < len(tag) {
+		for i < len(tag) && (isSpace(tag[i]) || tag[i] == '/') {
+			i++
+		}
+
+		if i >= len(tag) || tag[i] == '>' {
+			break
+		}
+
+		a := htmlAttr{nameStart: i}
+		for i < len(tag) && !isSpace(tag[i]) && tag[i] != '=' && tag[i] != '>' && tag[i] != '/' {
+			i++
+		}
+
+		a.nameEnd = i
+
+		for i < len(tag) && isSpace(tag[i]) {
+			i++
+		}
+
+		if i >= len(tag) || tag[i] != '=' {
+			a.valueStart, a.valueEnd = a.nameEnd, a.nameEnd
+			attrs = append(attrs, a)
+
+			continue
+		}
+
+		i++
+
+		for i < len(tag) && isSpace(tag[i]) {
+			i++
+		}
+
+		if i < len(tag) && (tag[i] == '"' || tag[i] == '\'') {
+			a.quote = tag[i]
+			a.valueStart = i + 1
+
+			end := bytes.IndexByte(tag[i+1:], a.quote)
+			if end < 0 {
+				break
+			}
+
+			a.valueEnd = i + 1 + end
+			i = a.valueEnd + 1
+		} else {
+			a.valueStart = i
+			for i < len(tag) && !isSpace(tag[i]) && tag[i] != '>' {
+				i++
+			}
+
+			a.valueEnd = i
+		}
+
+		attrs = append(attrs, a)
+	}
+
+	return attrs
+}
+
+// filterAttrs returns the start tag with the inner filter applied to the
+// entity-decoded value of each of its attributes.
+func (wt *withinTags) filterAttrs(tag []byte, attrs []htmlAttr, sc *scope) []byte {
+	var out []byte
+
+	last := 0
+
+	for _, a := range attrs {
+		if a.valueStart == a.valueEnd {
+			continue
+		}
+
+		decoded := []byte(html.UnescapeString(string(tag[a.valueStart:a.valueEnd])))
+
+		filtered := wt.inner.apply(decoded, sc)
+		if bytes.Equal(filtered, decoded) {
+			continue
+		}
+
+		escaped := htmlAttrEscaper.Replace(string(filtered))
+		if a.quote == 0 {
+			// Unquoted values get quotes, which their new value may need.
+			escaped = `"` + escaped + `"`
+		} else if a.quote == '\'' {
+			escaped = strings.ReplaceAll(escaped, "'", "&#39;")
+		}
+
+		out = append(out, tag[last:a.valueStart]...)
+		out = append(out, escaped...)
+		last = a.valueEnd
+	}
+
+	if out == nil {
+		return tag
+	}
+
+	return append(out, tag[last:]...)
+}
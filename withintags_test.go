@@ -0,0 +1,209 @@
+package subfilter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWithinTags(t *testing.T) {
+	tests := []struct {
+		desc        string
+		filter      Filter
+		contentType string
+		resBody     string
+		expResBody  string
+	}{
+		{
+			desc:       "should only filter inside matching elements",
+			filter:     Filter{Regex: "internal", Replacement: "public", WithinTags: []string{"title"}},
+			resBody:    "<title>internal docs</title><p>internal</p>",
+			expResBody: "<title>public docs</title><p>internal</p>",
+		},
+		{
+			desc:   "should match class selectors",
+			filter: Filter{Regex: `\.corp\b`, Replacement: ".example", WithinTags: []string{"td.hostname"}},
+			resBody: `<table><tr><td class="name hostname">db.corp</td><td class="note">db.corp</td>` +
+				`<td>db.corp</td></tr></table>`,
+			expResBody: `<table><tr><td class="name hostname">db.example</td><td class="note">db.corp</td>` +
+				`<td>db.corp</td></tr></table>`,
+		},
+		{
+			desc:       "should match id selectors",
+			filter:     Filter{Regex: "foo", Replacement: "bar", WithinTags: []string{"#main"}},
+			resBody:    `<div id="main">foo</div><div id="side">foo</div>`,
+			expResBody: `<div id="main">bar</div><div id="side">foo</div>`,
+		},
+		{
+			desc:       "should filter nested elements and stop at the end tag",
+			filter:     Filter{Regex: "foo", Replacement: "bar", WithinTags: []string{"div.x"}},
+			resBody:    `<div class="x">foo<div class="x">foo<b>foo</b></div>foo<br>foo</div>foo`,
+			expResBody: `<div class="x">bar<div class="x">bar<b>bar</b></div>bar<br>bar</div>foo`,
+		},
+		{
+			desc:       "should close elements with omitted end tags",
+			filter:     Filter{Regex: "foo", Replacement: "bar", WithinTags: []string{"li.x"}},
+			resBody:    `<ul><li class="x">foo<li>foo</ul>`,
+			expResBody: `<ul><li class="x">bar<li>foo</ul>`,
+		},
+		{
+			desc:       "should leave attributes and scripts alone by default",
+			filter:     Filter{Regex: "foo", Replacement: "bar", WithinTags: []string{"p"}},
+			resBody:    `<p title="foo"><a href="/foo">foo</a><script>foo()</script></p>`,
+			expResBody: `<p title="foo"><a href="/foo">bar</a><script>foo()</script></p>`,
+		},
+		{
+			desc: "should filter attributes when enabled",
+			filter: Filter{
+				Regex: "foo", Replacement: `b"r`, WithinTags: []string{"p"}, WithinTagsAttributes: true,
+			},
+			resBody:    `<a href="/foo"></a><p title='foo'><a href=/foo>foo &amp; co</a></p>`,
+			expResBody: `<a href="/foo"></a><p title='b&quot;r'><a href="/b&quot;r">b"r &amp; co</a></p>`,
+		},
+		{
+			desc:        "should skip responses that are not HTML",
+			filter:      Filter{Regex: "foo", Replacement: "bar", WithinTags: []string{"title"}},
+			contentType: "application/xml",
+			resBody:     "<title>foo</title>",
+			expResBody:  "<title>foo</title>",
+		},
+		{
+			desc:       "should only filter text nodes",
+			filter:     Filter{Regex: "foo", Replacement: "bar", TextNodesOnly: true},
+			resBody:    `<p class="foo">foo</p><!-- foo --><foo-bar>x</foo-bar>foo`,
+			expResBody: `<p class="foo">bar</p><!-- foo --><foo-bar>x</foo-bar>bar`,
+		},
+		{
+			desc:       "should leave scripts and styles alone with textNodesOnly",
+			filter:     Filter{Regex: "foo", Replacement: "bar", TextNodesOnly: true},
+			resBody:    "<script>foo()</script><style>.foo{}</style><p>foo</p>",
+			expResBody: "<script>foo()</script><style>.foo{}</style><p>bar</p>",
+		},
+		{
+			desc:        "should skip responses that are not HTML with textNodesOnly",
+			filter:      Filter{Regex: "foo", Replacement: "bar", TextNodesOnly: true},
+			contentType: "application/json",
+			resBody:     `{"foo": "<p>foo</p>"}`,
+			expResBody:  `{"foo": "<p>foo</p>"}`,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.Filters = []Filter{test.filter}
+
+			next := func(w http.ResponseWriter, _ *http.Request) {
+				contentType := test.contentType
+				if contentType == "" {
+					contentType = "text/html; charset=utf-8"
+				}
+
+				w.Header().Set("Content-Type", contentType)
+				_, _ = w.Write([]byte(test.resBody))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if got := recorder.Body.String(); got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+		})
+	}
+}
+
+func TestWithinTagsConfig(t *testing.T) {
+	for _, f := range []Filter{
+		{Regex: "foo", WithinTags: []string{"td."}},
+		{Regex: "foo", WithinTags: []string{""}},
+		{Type: "bytes", Regex: "00", WithinTags: []string{"title"}},
+		{Type: "range", Start: "<a>", End: "</a>", TextNodesOnly: true},
+		{Regex: "foo", WithinTags: []string{"title"}, TextNodesOnly: true},
+	} {
+		if _, err := compileFilters([]Filter{f}); err == nil {
+			t.Errorf("expected an error for withinTags %q and textNodesOnly %v", f.WithinTags, f.TextNodesOnly)
+		}
+	}
+}
+
+func TestTokenizerFallback(t *testing.T) {
+	tests := []struct {
+		desc       string
+		policy     string
+		resBody    string
+		expResBody string
+	}{
+		{
+			desc:       "should filter well-formed markup",
+			resBody:    `<p class="foo">foo</p>`,
+			expResBody: `<p class="foo">bar</p>`,
+		},
+		{
+			desc:       "should pass an unterminated attribute value through by default",
+			resBody:    `<p class="foo>foo</p><p>foo</p>`,
+			expResBody: `<p class="foo>foo</p><p>foo</p>`,
+		},
+		{
+			desc:       "should pass an unterminated tag through",
+			policy:     "passthrough",
+			resBody:    `<p>foo</p><div foo`,
+			expResBody: `<p>foo</p><div foo`,
+		},
+		{
+			desc:       "should pass an unclosed script through",
+			policy:     "passthrough",
+			resBody:    `<p>foo</p><script>foo()`,
+			expResBody: `<p>foo</p><script>foo()`,
+		},
+		{
+			desc:       "should fall back to the flat regex",
+			policy:     "regex",
+			resBody:    `<p class="foo>foo</p><!-- foo`,
+			expResBody: `<p class="bar>bar</p><!-- bar`,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.Filters = []Filter{{Regex: "foo", Replacement: "bar", TextNodesOnly: true}}
+			config.TokenizerFallback = test.policy
+
+			next := func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Type", "text/html")
+				_, _ = w.Write([]byte(test.resBody))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if got := recorder.Body.String(); got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+		})
+	}
+}
+
+func TestTokenizerFallbackConfig(t *testing.T) {
+	config := CreateConfig()
+	config.Filters = []Filter{{Regex: "foo", TextNodesOnly: true}}
+	config.TokenizerFallback = "drop"
+
+	if _, err := New(context.Background(), nil, config, "subfilter"); err == nil {
+		t.Error("expected error for tokenizerFallback \"drop\"")
+	}
+}
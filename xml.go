@@ -0,0 +1,181 @@
+package subfilter
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+var xmlTextEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// xmlEntities are the entities predefined by XML. Any other, such as the HTML
+// &nbsp;, has to be declared by the document.
+var xmlEntities = map[string]string{"amp": "&", "lt": "<", "gt": ">", "quot": `"`, "apos": "'"}
+
+// isXMLContentType reports whether the given Content-Type header value
+// describes an XML document (including SVG and other +xml types).
+func isXMLContentType(contentType string) bool {
+	mt := mediaType(contentType)
+
+	return mt == "text/xml" || mt == "application/xml" || strings.HasSuffix(mt, "+xml")
+}
+
+// filterXMLText applies fn to the entity-decoded content of every text node in
+// b and re-encodes the result. Markup (tags, comments, CDATA sections,
+// processing instructions and doctypes) is copied through untouched, as are
+// text nodes that fn leaves unchanged.
+func filterXMLText(b []byte, fn func([]byte) []byte) []byte {
+	out := make([]byte, 0, len(b))
+
+	for len(b) > 0 {
+		i := bytes.IndexByte(b, '<')
+		if i < 0 {
+			i = len(b)
+		}
+
+		out = append(out, filterXMLTextNode(b[:i], fn)...)
+		b = b[i:]
+
+		if len(b) == 0 {
+			break
+		}
+
+		n := xmlMarkupLen(b)
+		out = append(out, b[:n]...)
+		b = b[n:]
+	}
+
+	return out
+}
+
+func filterXMLTextNode(text []byte, fn func([]byte) []byte) []byte {
+	return filterTextNode(text, xmlUnescape, fn)
+}
+
+// filterTextNode applies fn to text decoded with unescape, and re-encodes the
+// result if fn changed it.
+func filterTextNode(text []byte, unescape func(string) string, fn func([]byte) []byte) []byte {
+	if len(text) == 0 {
+		return text
+	}
+
+	decoded := []byte(unescape(string(text)))
+
+	filtered := fn(decoded)
+	if bytes.Equal(filtered, decoded) {
+		return text
+	}
+
+	return []byte(xmlTextEscaper.Replace(string(filtered)))
+}
+
+// xmlUnescape decodes the predefined XML entities and the character references
+// in s. References to other entities are left as they are.
+func xmlUnescape(s string) string {
+	if !strings.Contains(s, "&") {
+		return s
+	}
+
+	var sb strings.Builder
+
+	for {
+		i := strings.IndexByte(s, '&')
+		if i < 0 {
+			break
+		}
+
+		sb.WriteString(s[:i])
+		s = s[i:]
+
+		if end := strings.IndexByte(s, ';'); end > 0 {
+			if v, ok := xmlEntity(s[1:end]); ok {
+				sb.WriteString(v)
+				s = s[end+1:]
+
+				continue
+			}
+		}
+
+		sb.WriteByte('&')
+		s = s[1:]
+	}
+
+	sb.WriteString(s)
+
+	return sb.String()
+}
+
+// xmlEntity returns the text the entity or character reference name, as found
+// between "&" and ";", stands for in XML.
+func xmlEntity(name string) (string, bool) {
+	if v, ok := xmlEntities[name]; ok {
+		return v, true
+	}
+
+	if !strings.HasPrefix(name, "#") {
+		return "", false
+	}
+
+	digits, base := name[1:], 10
+	if strings.HasPrefix(digits, "x") {
+		digits, base = digits[1:], 16
+	}
+
+	n, err := strconv.ParseUint(digits, base, 32)
+	if err != nil || n == 0 || !utf8.ValidRune(rune(n)) {
+		return "", false
+	}
+
+	return string(rune(n)), true
+}
+
+// xmlMarkupLen returns the length of the markup construct starting at b[0],
+// which must be '<'. Unterminated markup extends to the end of b.
+func xmlMarkupLen(b []byte) int {
+	n, _ := xmlMarkupEnd(b)
+
+	return n
+}
+
+// xmlMarkupEnd is like xmlMarkupLen and also reports whether the markup is
+// terminated.
+func xmlMarkupEnd(b []byte) (int, bool) {
+	for _, delim := range [][2]string{{"<!--", "-->"}, {"<![CDATA[", "]]>"}, {"<?", "?>"}} {
+		if !bytes.HasPrefix(b, []byte(delim[0])) {
+			continue
+		}
+
+		end := bytes.Index(b[len(delim[0]):], []byte(delim[1]))
+		if end < 0 {
+			return len(b), false
+		}
+
+		return len(delim[0]) + end + len(delim[1]), true
+	}
+
+	var quote byte
+
+	depth := 0
+
+	for i := 1; i < len(b); i++ {
+		c := b[i]
+
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[':
+			depth++
+		case c == ']' && depth > 0:
+			depth--
+		case c == '>' && depth == 0:
+			return i + 1, true
+		}
+	}
+
+	return len(b), false
+}
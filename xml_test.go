@@ -0,0 +1,101 @@
+package subfilter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestXMLSafe(t *testing.T) {
+	tests := []struct {
+		desc        string
+		contentType string
+		xmlSafe     bool
+		filters     []Filter
+		resBody     string
+		expResBody  string
+	}{
+		{
+			desc:        "should match decoded text and re-encode it",
+			contentType: "image/svg+xml",
+			xmlSafe:     true,
+			filters:     []Filter{{Regex: "Tom & Jerry", Replacement: "Tom & Spike"}},
+			resBody:     `<svg><text x="1">Tom &amp; Jerry</text></svg>`,
+			expResBody:  `<svg><text x="1">Tom &amp; Spike</text></svg>`,
+		},
+		{
+			desc:        "should leave markup and untouched text nodes byte for byte",
+			contentType: "application/xml",
+			xmlSafe:     true,
+			filters:     []Filter{{Regex: "foo", Replacement: "bar"}},
+			resBody:     `<?xml version="1.0"?><!-- foo --><a href="foo">&#39;x&#39;</a><b>foo &lt; 2</b>`,
+			expResBody:  `<?xml version="1.0"?><!-- foo --><a href="foo">&#39;x&#39;</a><b>bar &lt; 2</b>`,
+		},
+		{
+			desc:        "should escape markup characters introduced by a replacement",
+			contentType: "application/atom+xml; charset=utf-8",
+			xmlSafe:     true,
+			filters:     []Filter{{Regex: "foo", Replacement: "<b>"}},
+			resBody:     `<title>foo</title>`,
+			expResBody:  `<title>&lt;b&gt;</title>`,
+		},
+		{
+			desc:        "should decode numeric character references and &apos;",
+			contentType: "application/xml",
+			xmlSafe:     true,
+			filters:     []Filter{{Regex: "© 'foo'", Replacement: "(c) 'bar'"}},
+			resBody:     `<a>&#169; &apos;foo&#x27;</a>`,
+			expResBody:  `<a>(c) 'bar'</a>`,
+		},
+		{
+			desc:        "should not decode HTML entities, which XML does not predefine",
+			contentType: "application/xml",
+			xmlSafe:     true,
+			filters:     []Filter{{Regex: "©", Replacement: "(c)"}},
+			resBody:     `<a>&copy; 2024</a>`,
+			expResBody:  `<a>&copy; 2024</a>`,
+		},
+		{
+			desc:        "should filter the raw body when the content type is not XML",
+			contentType: "text/html",
+			xmlSafe:     true,
+			filters:     []Filter{{Regex: "foo", Replacement: "bar"}},
+			resBody:     `<a href="foo">foo</a>`,
+			expResBody:  `<a href="bar">bar</a>`,
+		},
+		{
+			desc:        "should filter the raw body when disabled",
+			contentType: "image/svg+xml",
+			filters:     []Filter{{Regex: "Tom & Jerry", Replacement: "Tom & Spike"}},
+			resBody:     `<text>Tom &amp; Jerry</text>`,
+			expResBody:  `<text>Tom &amp; Jerry</text>`,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			config := CreateConfig()
+			config.XMLSafe = test.xmlSafe
+			config.Filters = test.filters
+
+			next := func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", test.contentType)
+				_, _ = w.Write([]byte(test.resBody))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "subfilter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if got := recorder.Body.String(); got != test.expResBody {
+				t.Errorf("got body %q, want %q", got, test.expResBody)
+			}
+		})
+	}
+}
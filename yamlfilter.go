@@ -0,0 +1,417 @@
+package subfilter
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// yamlFilter applies a filter to the scalar values found at a path of YAML
+// documents. Plugins cannot depend on a YAML library, so the documents are
+// scanned for their block structure only and the values are edited in place,
+// leaving the indentation, comments, anchors and every other value as they
+// were.
+type yamlFilter struct {
+	// path holds the keys leading to the values, "*" standing for any element
+	// of a sequence.
+	path  []string
+	inner *filter
+}
+
+// yamlFrame is a mapping entry or sequence element enclosing the line being
+// scanned.
+type yamlFrame struct {
+	indent int
+	key    string
+	seq    bool
+}
+
+// yamlScalar is the span of a scalar value of the body, without its quotes.
+// style is 0 for plain scalars, the quote of quoted ones, or '|' for a line of
+// a block scalar.
+type yamlScalar struct {
+	start, end int
+	style      byte
+}
+
+var errYAMLUnsupported = errors.New("unsupported YAML construct")
+
+func compileYAML(f Filter, inner *filter) (*yamlFilter, error) {
+	if f.Path == "" {
+		return nil, errors.New("yaml filters require path")
+	}
+
+	path := strings.Split(f.Path, ".")
+	for _, key := range path {
+		if key == "" {
+			return nil, fmt.Errorf("invalid path %q", f.Path)
+		}
+	}
+
+	return &yamlFilter{path: path, inner: inner}, nil
+}
+
+// apply filters the scalar values at the path in every document of b. Bodies
+// the scanner cannot make sense of are left untouched.
+func (yf *yamlFilter) apply(b []byte, sc *scope) []byte {
+	scalars, err := yf.scan(b)
+	if err != nil || len(scalars) == 0 {
+		return b
+	}
+
+	out := make([]byte, 0, len(b))
+	last := 0
+
+	for _, s := range scalars {
+		out = append(out, b[last:s.start]...)
+		out = append(out, yf.filterScalar(b[s.start:s.end], s.style, sc)...)
+		last = s.end
+	}
+
+	return append(out, b[last:]...)
+}
+
+// filterScalar returns the filtered version of the raw scalar v, quoted as
+// its new value requires. Values the filter leaves alone are kept as is.
+func (yf *yamlFilter) filterScalar(v []byte, style byte, sc *scope) []byte {
+	value := v
+
+	switch style {
+	case '"':
+		s, err := strconv.Unquote(`"` + string(v) + `"`)
+		if err != nil {
+			return v
+		}
+
+		value = []byte(s)
+	case '\'':
+		value = bytes.ReplaceAll(v, []byte("''"), []byte("'"))
+	}
+
+	filtered := yf.inner.apply(value, sc)
+	if bytes.Equal(filtered, value) {
+		return v
+	}
+
+	switch {
+	case style == '|':
+		return filtered
+	case style == '\'' && bytes.IndexByte(filtered, '\n') < 0:
+		return bytes.ReplaceAll(filtered, []byte("'"), []byte("''"))
+	case style == 0 && !yamlNeedsQuotes(filtered):
+		return filtered
+	}
+
+	quoted := strconv.Quote(string(filtered))
+	if style != '"' {
+		return []byte(quoted)
+	}
+
+	// The original quotes are kept around the span.
+	return []byte(quoted[1 : len(quoted)-1])
+}
+
+// yamlNeedsQuotes reports whether the plain scalar s would be read as
+// something else than the same string.
+func yamlNeedsQuotes(s []byte) bool {
+	if len(s) == 0 || strings.ContainsRune("-?:,[]{}#&*!|>'\"%@` \t", rune(s[0])) ||
+		s[len(s)-1] == ' ' || s[len(s)-1] == ':' {
+		return true
+	}
+
+	switch strings.ToLower(string(s)) {
+	case "~", "null", "true", "false", "yes", "no", "on", "off":
+		return true
+	}
+
+	return bytes.Contains(s, []byte(": ")) || bytes.Contains(s, []byte(" #")) || bytes.ContainsAny(s, "\r\n\t")
+}
+
+// scan returns the scalars of b at the path of the filter, in order.
+func (yf *yamlFilter) scan(b []byte) ([]yamlScalar, error) {
+	var (
+		stack   []yamlFrame
+		scalars []yamlScalar
+		// block is the indentation of the node holding the block scalar
+		// being read, or -1 outside of block scalars.
+		block      = -1
+		blockMatch bool
+	)
+
+	for pos := 0; pos < len(b); {
+		start := pos
+
+		end := bytes.IndexByte(b[pos:], '\n')
+		if end < 0 {
+			end = len(b)
+			pos = len(b)
+		} else {
+			end += pos
+			pos = end + 1
+		}
+
+		line := bytes.TrimSuffix(b[start:end], []byte("\r"))
+		indent := len(line) - len(bytes.TrimLeft(line, " "))
+		rest := line[indent:]
+
+		if block >= 0 {
+			if len(bytes.TrimSpace(rest)) == 0 {
+				continue
+			}
+
+			if indent > block {
+				if blockMatch {
+					scalars = append(scalars, yamlScalar{start: start + indent, end: start + len(line), style: '|'})
+				}
+
+				continue
+			}
+
+			block = -1
+		}
+
+		switch {
+		case len(bytes.TrimSpace(rest)) == 0 || rest[0] == '#':
+			continue
+		case rest[0] == '\t':
+			return nil, errYAMLUnsupported
+		case indent == 0 && (yamlMarker(line, "---") || yamlMarker(line, "...")):
+			// A new document starts.
+			stack = stack[:0]
+
+			if after := bytes.TrimSpace(line[3:]); len(after) > 0 && after[0] != '#' {
+				return nil, errYAMLUnsupported
+			}
+
+			continue
+		case indent == 0 && rest[0] == '%':
+			continue
+		}
+
+		col := indent
+		afterDash := false
+
+		for rest[0] == '-' && (len(rest) == 1 || rest[1] == ' ') {
+			for n := len(stack); n > 0 && (stack[n-1].indent > col || stack[n-1].indent == col && stack[n-1].seq); n-- {
+				stack = stack[:n-1]
+			}
+
+			stack = append(stack, yamlFrame{indent: col, seq: true})
+
+			skip := len(rest) - len(bytes.TrimLeft(rest[1:], " "))
+			col += skip
+			rest = rest[skip:]
+			afterDash = true
+
+			if len(rest) == 0 {
+				break
+			}
+		}
+
+		if len(rest) == 0 || rest[0] == '#' {
+			continue
+		}
+
+		if !afterDash {
+			for n := len(stack); n > 0 && stack[n-1].indent >= col; n-- {
+				stack = stack[:n-1]
+			}
+		}
+
+		key, valueAt, err := yamlKey(rest)
+		if err != nil {
+			return nil, err
+		}
+
+		if valueAt < 0 {
+			// Only sequence elements and whole documents are scalars on
+			// their own line; anything else continues a multi-line scalar.
+			if !afterDash && len(stack) > 0 {
+				return nil, errYAMLUnsupported
+			}
+
+			valueAt = 0
+		} else {
+			stack = append(stack, yamlFrame{indent: col, key: key})
+		}
+
+		value := rest[valueAt:]
+		valueStart := start + (len(line) - len(value))
+
+		s, isBlock, err := yamlValue(value, valueStart)
+		if err != nil {
+			return nil, err
+		}
+
+		match := yf.matchPath(stack)
+
+		switch {
+		case isBlock:
+			block, blockMatch = col, match
+			if valueAt == 0 && len(stack) > 0 {
+				block = stack[len(stack)-1].indent
+			}
+		case s != nil && match:
+			scalars = append(scalars, *s)
+		}
+	}
+
+	return scalars, nil
+}
+
+// yamlMarker reports whether line starts with the document marker m.
+func yamlMarker(line []byte, m string) bool {
+	return bytes.HasPrefix(line, []byte(m)) && (len(line) == len(m) || line[len(m)] == ' ' || line[len(m)] == '\t')
+}
+
+// matchPath reports whether the frames of stack lead to the path of the
+// filter.
+func (yf *yamlFilter) matchPath(stack []yamlFrame) bool {
+	if len(stack) != len(yf.path) {
+		return false
+	}
+
+	for i, frame := range stack {
+		if frame.seq != (yf.path[i] == "*") || !frame.seq && frame.key != yf.path[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// yamlKey parses the mapping key starting rest, returning the offset of its
+// value, or -1 when rest is not a mapping entry.
+func yamlKey(rest []byte) (string, int, error) {
+	switch rest[0] {
+	case '?':
+		return "", 0, errYAMLUnsupported
+	case '[', '{', '|', '>', '*':
+		return "", -1, nil
+	case '"', '\'':
+		end := yamlQuoteEnd(rest)
+		if end < 0 {
+			return "", 0, errYAMLUnsupported
+		}
+
+		after := rest[end+1:]
+		if len(after) == 0 || after[0] != ':' || len(after) > 1 && after[1] != ' ' {
+			return "", -1, nil
+		}
+
+		key := string(rest[1:end])
+		if rest[0] == '"' {
+			unquoted, err := strconv.Unquote(string(rest[:end+1]))
+			if err != nil {
+				return "", 0, errYAMLUnsupported
+			}
+
+			key = unquoted
+		} else {
+			key = strings.ReplaceAll(key, "''", "'")
+		}
+
+		return key, yamlSkipSpaces(rest, end+2), nil
+	}
+
+	for i := 0; i < len(rest); i++ {
+		switch {
+		case rest[i] == '#' && i > 0 && rest[i-1] == ' ':
+			return "", -1, nil
+		case rest[i] == ':' && (i+1 == len(rest) || rest[i+1] == ' '):
+			return string(bytes.TrimRight(rest[:i], " ")), yamlSkipSpaces(rest, i+1), nil
+		}
+	}
+
+	return "", -1, nil
+}
+
+func yamlSkipSpaces(b []byte, i int) int {
+	for i < len(b) && b[i] == ' ' {
+		i++
+	}
+
+	return i
+}
+
+// yamlQuoteEnd returns the offset of the quote closing the quoted scalar
+// starting b, or -1 when it does not close on the line.
+func yamlQuoteEnd(b []byte) int {
+	q := b[0]
+
+	for i := 1; i < len(b); i++ {
+		switch {
+		case q == '"' && b[i] == '\\':
+			i++
+		case b[i] == q && q == '\'' && i+1 < len(b) && b[i+1] == '\'':
+			i++
+		case b[i] == q:
+			return i
+		}
+	}
+
+	return -1
+}
+
+// yamlValue parses the value v of a node, found at offset start of the body.
+// It returns the scalar it holds, if any, or whether it opens a block scalar.
+func yamlValue(v []byte, start int) (*yamlScalar, bool, error) {
+	// Skip the anchor and tag properties.
+	for len(v) > 0 && (v[0] == '&' || v[0] == '!') {
+		n := bytes.IndexByte(v, ' ')
+		if n < 0 {
+			n = len(v)
+		}
+
+		n = yamlSkipSpaces(v, n)
+		v, start = v[n:], start+n
+	}
+
+	if len(v) == 0 || v[0] == '#' {
+		return nil, false, nil
+	}
+
+	switch v[0] {
+	case '|', '>':
+		header := v[1:]
+		if i := bytes.Index(header, []byte(" #")); i >= 0 {
+			header = header[:i]
+		}
+
+		if len(bytes.Trim(bytes.TrimSpace(header), "+-0123456789")) > 0 {
+			return nil, false, errYAMLUnsupported
+		}
+
+		return nil, true, nil
+	case '*':
+		return nil, false, nil
+	case '[', '{':
+		if bytes.Count(v, []byte("[")) != bytes.Count(v, []byte("]")) || bytes.Count(v, []byte("{")) != bytes.Count(v, []byte("}")) {
+			return nil, false, errYAMLUnsupported
+		}
+
+		return nil, false, nil
+	case '"', '\'':
+		end := yamlQuoteEnd(v)
+		if end < 0 {
+			return nil, false, errYAMLUnsupported
+		}
+
+		if after := bytes.TrimLeft(v[end+1:], " "); len(after) > 0 && after[0] != '#' {
+			return nil, false, errYAMLUnsupported
+		}
+
+		return &yamlScalar{start: start + 1, end: start + end, style: v[0]}, false, nil
+	}
+
+	end := len(v)
+	if i := bytes.Index(v, []byte(" #")); i >= 0 {
+		end = i
+	}
+
+	end = len(bytes.TrimRight(v[:end], " "))
+
+	return &yamlScalar{start: start, end: start + end}, false, nil
+}
@@ -0,0 +1,120 @@
+package subfilter
+
+import (
+	"testing"
+)
+
+func TestYAMLFilter(t *testing.T) {
+	images := Filter{Type: "yaml", Path: "spec.containers.*.image", Regex: `^docker\.io/`, Replacement: "mirror.corp/"}
+
+	tests := []struct {
+		desc       string
+		filter     Filter
+		resBody    string
+		expResBody string
+	}{
+		{
+			desc:   "should rewrite the values of a sequence wildcard path",
+			filter: images,
+			resBody: `spec:
+  containers:
+    - name: app # the app
+      image: docker.io/app:1.0
+    - image: "docker.io/sidecar:2.0"
+      name: docker.io/not-an-image
+  image: docker.io/not-in-a-container
+`,
+			expResBody: `spec:
+  containers:
+    - name: app # the app
+      image: mirror.corp/app:1.0
+    - image: "mirror.corp/sidecar:2.0"
+      name: docker.io/not-an-image
+  image: docker.io/not-in-a-container
+`,
+		},
+		{
+			desc:   "should process every document of a stream",
+			filter: images,
+			resBody: `---
+spec:
+  containers:
+  - image: docker.io/a
+---
+kind: Other
+spec:
+  containers:
+  - image: 'docker.io/b' # quoted
+...
+`,
+			expResBody: `---
+spec:
+  containers:
+  - image: mirror.corp/a
+---
+kind: Other
+spec:
+  containers:
+  - image: 'mirror.corp/b' # quoted
+...
+`,
+		},
+		{
+			desc:   "should rewrite block scalars and keep anchors",
+			filter: Filter{Type: "yaml", Path: "jobs.*.script", Regex: `http://`, Replacement: "https://"},
+			resBody: `defaults: &defaults
+  url: http://keep.example.com
+jobs:
+  - <<: *defaults
+    script: |-
+      curl http://a.example.com
+
+      curl http://b.example.com
+    after: http://keep.example.com
+`,
+			expResBody: `defaults: &defaults
+  url: http://keep.example.com
+jobs:
+  - <<: *defaults
+    script: |-
+      curl https://a.example.com
+
+      curl https://b.example.com
+    after: http://keep.example.com
+`,
+		},
+		{
+			desc:       "should quote values that need it",
+			filter:     Filter{Type: "yaml", Path: "a.b", Regex: `.+`, Replacement: "x: y # z"},
+			resBody:    "a:\n  b: c\n",
+			expResBody: "a:\n  b: \"x: y # z\"\n",
+		},
+		{
+			desc:       "should skip documents it cannot parse",
+			filter:     Filter{Type: "yaml", Path: "a", Regex: `foo`, Replacement: "bar"},
+			resBody:    "a: foo\nb: multi\n  line foo\n",
+			expResBody: "a: foo\nb: multi\n  line foo\n",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			if got := serveTransform(t, test.filter, test.resBody); got != test.expResBody {
+				t.Errorf("got body:\n%s\nwant:\n%s", got, test.expResBody)
+			}
+		})
+	}
+}
+
+func TestYAMLFilterConfig(t *testing.T) {
+	for _, f := range []Filter{
+		{Type: "yaml", Regex: "foo"},
+		{Type: "yaml", Path: "a..b", Regex: "foo"},
+		{Type: "yaml", Path: "a", Regex: "foo", Every: 2},
+	} {
+		if _, err := compileFilters([]Filter{f}); err == nil {
+			t.Errorf("expected an error for path %q", f.Path)
+		}
+	}
+}